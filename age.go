@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ageFilter restricts directory mode to recently modified files; the zero
+// value lets every file through.
+type ageFilter struct {
+	NewerThan     time.Duration // Only files modified within this long of the listing (0: off)
+	ModifiedAfter time.Time     // Only files modified at or after this time (zero: off)
+}
+
+// fileAge is the run-level age filter, from -newer-than and -modified-after.
+var fileAge ageFilter
+
+// modifiedAfterLayouts are the accepted -modified-after formats, in local
+// time unless they carry a zone.
+var modifiedAfterLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// parseNewerThan parses a -newer-than age such as "24h", "90m", or "7d".
+func parseNewerThan(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil && n > 0 {
+			return time.Duration(n * float64(24*time.Hour)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age '%s' (expected e.g. 24h, 90m, or 7d)", s)
+	}
+	return d, nil
+}
+
+// parseModifiedAfter parses a -modified-after date such as "2024-01-01" or
+// "2024-01-01T18:00".
+func parseModifiedAfter(s string) (time.Time, error) {
+	for _, layout := range modifiedAfterLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s' (expected e.g. 2024-01-01 or 2024-01-01T18:00)", s)
+}
+
+// cutoff returns the earliest modification time let through, taken from now
+// so each scheduled run counts its age afresh, or the zero time when the
+// filter is off.
+func (a ageFilter) cutoff(now time.Time) time.Time {
+	cut := a.ModifiedAfter
+	if a.NewerThan > 0 {
+		if since := now.Add(-a.NewerThan); since.After(cut) {
+			cut = since
+		}
+	}
+	return cut
+}
+
+// filterAge passes on the paths modified at or after cutoff, and reports how
+// many were skipped once the listing is done. Files that cannot be stat'ed
+// are passed on, so their error is reported when they are processed.
+func filterAge(in <-chan string, cutoff time.Time) <-chan string {
+	out := make(chan string, walkQueueSize)
+	go func() {
+		defer close(out)
+		skipped := 0
+		for path := range in {
+			if info, err := statLocation(path); err == nil && info.ModTime().Before(cutoff) {
+				if dryRun {
+					planSkip(path, fmt.Sprintf("modified %s, before %s", info.ModTime().Format(time.RFC3339), cutoff.Format(time.RFC3339)))
+				}
+				skipped++
+				continue
+			}
+			out <- path
+		}
+		if skipped > 0 {
+			fmt.Printf(tr("Skipped %d file(s) modified before %s\n"), skipped, cutoff.Format(time.RFC3339))
+		}
+	}()
+	return out
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// alphaMode is how inputs with transparency are handled: "preserve" keeps
+// the alpha channel, "flatten" composites the image over matteColor before
+// marking, and "warn" keeps it but reports the file.
+var alphaMode = "preserve"
+
+// defaultMatteColor is the -matte default, white.
+var defaultMatteColor = color.RGBA{255, 255, 255, 255}
+
+// matteColor is the background transparent inputs are flattened onto, and
+// fully transparent inputs in every mode.
+var matteColor = defaultMatteColor
+
+func init() {
+	// Flattened in the mark phase, after every color transform, so the matte
+	// color is exact
+	registerStage(phaseMark, "alpha", func(job *imageJob) error {
+		var warned bool
+		job.Image = applyAlphaMode(job.Image, job.InputPath, &warned)
+		return nil
+	})
+}
+
+// parseAlphaMode checks an -alpha value.
+func parseAlphaMode(mode string) (string, error) {
+	switch mode {
+	case "preserve", "flatten", "warn":
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid alpha mode '%s' (options: preserve, flatten, warn)", mode)
+}
+
+// applyAlphaMode returns img as it should be marked: flattened in flatten
+// mode, and in every mode when it is fully transparent, since it would show
+// as nothing but a viewer's checkerboard between the banners. Files are
+// reported once, through *warned, so every page or frame can be passed in.
+func applyAlphaMode(img image.Image, inputPath string, warned *bool) image.Image {
+	if isFullyTransparent(img) {
+		if !*warned {
+			fmt.Printf(tr("Warning: '%s' is fully transparent; flattening it onto the -matte color so the marked image is visible\n"), inputPath)
+			*warned = true
+		}
+		return flattenImage(img, matteColor)
+	}
+	if alphaMode == "preserve" || !hasTransparency(img) {
+		return img
+	}
+	if alphaMode == "flatten" {
+		return flattenImage(img, matteColor)
+	}
+	if !*warned {
+		fmt.Printf(tr("Warning: '%s' has transparent areas, which viewers may show differently beside the banners (use -alpha flatten to fill them)\n"), inputPath)
+		*warned = true
+	}
+	return img
+}
+
+// hasTransparency reports whether any pixel of img is not fully opaque.
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flattenImage composites img over an opaque matte.
+func flattenImage(img image.Image, matte color.RGBA) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, &image.Uniform{matte}, image.Point{}, draw.Src)
+	draw.Draw(out, b, img, b.Min, draw.Over)
+	return out
+}
+
+// isFullyTransparent reports whether every pixel of img has zero alpha.
+func isFullyTransparent(img image.Image) bool {
+	b := img.Bounds()
+	if b.Empty() {
+		return false
+	}
+	switch m := img.(type) {
+	case *image.RGBA:
+		return zeroAlpha(m.Pix, m.Stride, b.Dx(), b.Dy())
+	case *image.NRGBA:
+		return zeroAlpha(m.Pix, m.Stride, b.Dx(), b.Dy())
+	case interface{ Opaque() bool }:
+		if m.Opaque() {
+			return false
+		}
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// zeroAlpha reports whether every alpha byte of an RGBA or NRGBA pixel
+// buffer is zero.
+func zeroAlpha(pix []byte, stride, width, height int) bool {
+	for y := 0; y < height; y++ {
+		row := pix[y*stride:]
+		for x := 0; x < width; x++ {
+			if row[4*x+3] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
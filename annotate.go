@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"path/filepath"
+	"slices"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// annotationFormat selects the annotation file written next to each raster
+// output: "coco", "voc", or "" for none.
+var annotationFormat string
+
+// annotationCategories are the region kinds, in COCO category ID order.
+var annotationCategories = []string{"banner", "banner_row", "label"}
+
+// annotationRegion is one marked area of an output image.
+type annotationRegion struct {
+	Category string
+	Text     string // Label or row text; empty for whole banners
+	Box      image.Rectangle
+}
+
+func init() {
+	// Annotations describe the written output, so they follow the encode phase
+	registerStage(phaseDeliver, "annotate", func(job *imageJob) error {
+		if annotationFormat == "" {
+			return nil
+		}
+		return writeAnnotation(job.Image, job.OutputPath, job.Banner, job.BannerHeight, job.Loc, job.PHash)
+	})
+}
+
+// parseAnnotationFormat checks an -annotations value.
+func parseAnnotationFormat(format string) (string, error) {
+	switch format {
+	case "", "coco", "voc":
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid annotation format '%s' (options: coco, voc)", format)
+}
+
+// annotationPath returns the annotation file written for outputPath.
+func annotationPath(outputPath string) string {
+	if annotationFormat == "voc" {
+		return outputPath + ".xml"
+	}
+	return outputPath + ".json"
+}
+
+// annotationRegions lists the banners, rows, and labels of a layout, top
+// banner first. Left and right banners are listed as whole banners; the rows
+// and labels are those of the top and bottom banners.
+func annotationRegions(layout *classify.BannerLayout) []annotationRegion {
+	w, h := layout.Size.X, layout.Size.Y
+	var regions []annotationRegion
+	for _, b := range layout.Banners {
+		regions = append(regions, annotationRegion{Category: "banner", Box: b})
+	}
+	for _, side := range []string{"top", "bottom"} {
+		if !slices.Contains(layout.Sides, side) {
+			continue
+		}
+		place := func(r image.Rectangle) image.Rectangle {
+			if side == "bottom" {
+				return layout.Bottom(r)
+			}
+			return r
+		}
+		for _, row := range layout.Rows {
+			regions = append(regions, annotationRegion{Category: "banner_row", Text: row.Text, Box: place(row.Rect)})
+			if row.Text == "" {
+				continue
+			}
+			for _, label := range row.Labels {
+				regions = append(regions, annotationRegion{Category: "label", Text: row.Text, Box: place(label)})
+			}
+		}
+	}
+
+	// Labels that overflow their row are clipped to the image
+	bounds := image.Rect(0, 0, w, h)
+	kept := regions[:0]
+	for _, r := range regions {
+		if r.Box = r.Box.Intersect(bounds); !r.Box.Empty() {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// writeAnnotation writes the annotation for the output at outputPath, which
+// was marked from img. A non-empty phash is recorded with the image.
+func writeAnnotation(img image.Image, outputPath string, banner classify.BannerMode, bannerHeight int, loc, phash string) error {
+	layout, err := classify.LayoutImage(img, markOptions(banner, bannerHeight, loc))
+	if err != nil {
+		return err
+	}
+	regions := annotationRegions(layout)
+	name := filepath.Base(outputPath)
+
+	var data []byte
+	if annotationFormat == "voc" {
+		data, err = vocAnnotation(name, layout.Size, phash, regions)
+	} else {
+		data, err = cocoAnnotation(name, layout.Size, phash, regions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode annotation: %w", err)
+	}
+	return writeOutputFile(annotationPath(outputPath), data)
+}
+
+// COCO object detection format, with one image per file. Label text is
+// carried in the attributes of each annotation.
+type cocoFile struct {
+	Info        map[string]string `json:"info"`
+	Images      []cocoImage       `json:"images"`
+	Annotations []cocoObject      `json:"annotations"`
+	Categories  []cocoCategory    `json:"categories"`
+}
+
+type cocoImage struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	PHash    string `json:"phash,omitempty"` // Of the original region, with -phash
+}
+
+type cocoObject struct {
+	ID           int               `json:"id"`
+	ImageID      int               `json:"image_id"`
+	CategoryID   int               `json:"category_id"`
+	BBox         [4]int            `json:"bbox"` // x, y, width, height
+	Area         int               `json:"area"`
+	IsCrowd      int               `json:"iscrowd"`
+	Segmentation [][]int           `json:"segmentation"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+type cocoCategory struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Supercategory string `json:"supercategory"`
+}
+
+func cocoAnnotation(name string, size image.Point, phash string, regions []annotationRegion) ([]byte, error) {
+	f := cocoFile{
+		Info:   map[string]string{"description": "goclassifyit marking regions"},
+		Images: []cocoImage{{ID: 1, FileName: name, Width: size.X, Height: size.Y, PHash: phash}},
+	}
+	ids := map[string]int{}
+	for i, c := range annotationCategories {
+		ids[c] = i + 1
+		f.Categories = append(f.Categories, cocoCategory{ID: i + 1, Name: c, Supercategory: "marking"})
+	}
+	f.Annotations = []cocoObject{}
+	for i, r := range regions {
+		b := r.Box
+		obj := cocoObject{
+			ID:         i + 1,
+			ImageID:    1,
+			CategoryID: ids[r.Category],
+			BBox:       [4]int{b.Min.X, b.Min.Y, b.Dx(), b.Dy()},
+			Area:       b.Dx() * b.Dy(),
+			// The polygon lets mask-based tools use the region directly
+			Segmentation: [][]int{{b.Min.X, b.Min.Y, b.Max.X, b.Min.Y, b.Max.X, b.Max.Y, b.Min.X, b.Max.Y}},
+		}
+		if r.Text != "" {
+			obj.Attributes = map[string]string{"text": r.Text}
+		}
+		f.Annotations = append(f.Annotations, obj)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	return append(data, '\n'), err
+}
+
+// PASCAL VOC annotation format. Box coordinates are 1-based and inclusive.
+type vocFile struct {
+	XMLName   xml.Name    `xml:"annotation"`
+	Filename  string      `xml:"filename"`
+	Size      vocSize     `xml:"size"`
+	Segmented int         `xml:"segmented"`
+	PHash     string      `xml:"phash,omitempty"`
+	Objects   []vocObject `xml:"object"`
+}
+
+type vocSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+	Depth  int `xml:"depth"`
+}
+
+type vocObject struct {
+	Name      string `xml:"name"`
+	Pose      string `xml:"pose"`
+	Truncated int    `xml:"truncated"`
+	Difficult int    `xml:"difficult"`
+	Text      string `xml:"text,omitempty"`
+	BndBox    struct {
+		XMin int `xml:"xmin"`
+		YMin int `xml:"ymin"`
+		XMax int `xml:"xmax"`
+		YMax int `xml:"ymax"`
+	} `xml:"bndbox"`
+}
+
+func vocAnnotation(name string, size image.Point, phash string, regions []annotationRegion) ([]byte, error) {
+	f := vocFile{Filename: name, Size: vocSize{Width: size.X, Height: size.Y, Depth: 3}, PHash: phash}
+	for _, r := range regions {
+		obj := vocObject{Name: r.Category, Pose: "Unspecified", Text: r.Text}
+		obj.BndBox.XMin, obj.BndBox.YMin = r.Box.Min.X+1, r.Box.Min.Y+1
+		obj.BndBox.XMax, obj.BndBox.YMax = r.Box.Max.X, r.Box.Max.Y
+		f.Objects = append(f.Objects, obj)
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// bundleDocExts are the documents of a bundle whose image references are
+// rewritten.
+var bundleDocExts = map[string]bool{".html": true, ".htm": true, ".md": true, ".markdown": true}
+
+// bundleImageExts are the asset types treated as images.
+var bundleImageExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".ico": true, ".dcm": true,
+	".hdr": true, ".exr": true, ".psd": true, ".tif": true, ".tiff": true, ".gif": true, ".webp": true, ".avif": true,
+}
+
+// Image references: HTML src attributes, Markdown inline images, and
+// Markdown reference definitions. The reference itself is the last group.
+var bundleRefRes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(<(?:img|source)\b[^>]*?\bsrc\s*=\s*["'])([^"']+)`),
+	regexp.MustCompile(`(!\[[^\]]*\]\(\s*<?)([^)\s>]+)`),
+	regexp.MustCompile(`(?m)(^[ \t]{0,3}\[[^\]]+\]:[ \t]*<?)([^\s>]+)`),
+}
+
+// bundleRef is one local image reference in a document.
+type bundleRef struct {
+	raw    string // Reference as written, e.g. "img/a%20b.png?v=2"
+	target string // Bundle-relative slash path of the referenced file
+}
+
+// localRef resolves ref in the document at docRel to a bundle-relative path.
+// It reports false for URLs, data URIs, fragments, and paths outside the
+// bundle.
+func localRef(docRel, ref string) (string, bool) {
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "//") || strings.Contains(strings.SplitN(ref, "/", 2)[0], ":") {
+		return "", false
+	}
+	p := ref
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	if strings.HasPrefix(p, "/") {
+		p = strings.TrimPrefix(p, "/")
+	} else {
+		p = path.Join(path.Dir(docRel), p)
+	}
+	p = path.Clean(p)
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+// documentRefs returns the local image references in a document.
+func documentRefs(docRel string, content string) []bundleRef {
+	var refs []bundleRef
+	for _, re := range bundleRefRes {
+		for _, m := range re.FindAllStringSubmatch(content, -1) {
+			raw := m[len(m)-1]
+			target, ok := localRef(docRel, raw)
+			if ok && bundleImageExts[strings.ToLower(path.Ext(target))] {
+				refs = append(refs, bundleRef{raw: raw, target: target})
+			}
+		}
+	}
+	return refs
+}
+
+// rewriteRef returns raw pointing at the classified file named newBase
+// instead of the original, keeping its directory part, query, and fragment.
+func rewriteRef(raw, newBase string) string {
+	p, suffix := raw, ""
+	if i := strings.IndexAny(raw, "?#"); i >= 0 {
+		p, suffix = raw[:i], raw[i:]
+	}
+	dir, base := "", p
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		dir, base = p[:i+1], p[i+1:]
+	}
+	if unescaped, err := url.PathUnescape(base); err == nil && unescaped == newBase {
+		return raw
+	}
+	return dir + url.PathEscape(newBase) + suffix
+}
+
+// processBundle copies an HTML/Markdown bundle from bundleDir to outputDir,
+// classifying every image its documents reference and rewriting the
+// references to the classified copies. Referenced originals are not copied,
+// so the output holds no unmarked version of them; other assets are copied
+// unchanged.
+func processBundle(bundleDir string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	root, err := filepath.Abs(bundleDir)
+	if err != nil {
+		return err
+	}
+	if out, err := filepath.Abs(outputDir); err == nil && (out == root || strings.HasPrefix(out, root+string(filepath.Separator))) {
+		return fmt.Errorf("output directory '%s' must not be inside the bundle", outputDir)
+	}
+
+	// Collect the documents and every file of the bundle
+	var docs, files []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isSidecar(p) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if bundleDocExts[strings.ToLower(path.Ext(rel))] {
+			docs = append(docs, rel)
+		} else {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	// Classify each referenced image once, mapping it to its output name
+	contents := map[string]string{}
+	classified := map[string]string{} // Bundle-relative image -> output base name ("" if it failed)
+	skipped := map[string]bool{}      // Images below -min-dimension, copied unmarked
+	var failed int
+	for _, doc := range docs {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(doc)))
+		if err != nil {
+			return fmt.Errorf("failed to read document: %w", err)
+		}
+		contents[doc] = string(data)
+		for _, ref := range documentRefs(doc, contents[doc]) {
+			if _, done := classified[ref.target]; done || skipped[ref.target] {
+				continue
+			}
+			src := filepath.Join(root, filepath.FromSlash(ref.target))
+			if _, err := os.Stat(src); err != nil {
+				fmt.Printf(tr("Warning: %s references missing image '%s'\n"), doc, ref.raw)
+				continue
+			}
+			outPath, err := classifyFile(src, banner, filepath.Join(outputDir, filepath.FromSlash(path.Dir(ref.target))), bannerHeight, loc)
+			if isSkipped(err) {
+				reportSkip(ref.target, err)
+				skipped[ref.target] = true
+				continue
+			}
+			if err != nil {
+				fmt.Printf(tr("Error processing %s: %v\n"), ref.target, err)
+				events.Error(fmt.Sprintf("Error processing '%s' (operator: %s, run: %s): %v", src, operator, runID, err))
+				recordResult(src, err)
+				classified[ref.target] = ""
+				failed++
+				continue
+			}
+			fmt.Println(tr("Classified:"), ref.target)
+			events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", src, banner.Text, operator, runID))
+			recordResult(src, nil)
+			classified[ref.target] = filepath.Base(outPath)
+		}
+	}
+
+	// Write the documents with their references pointing at the classified copies
+	for _, doc := range docs {
+		content := contents[doc]
+		for _, re := range bundleRefRes {
+			content = re.ReplaceAllStringFunc(content, func(m string) string {
+				sub := re.FindStringSubmatch(m)
+				raw := sub[len(sub)-1]
+				target, ok := localRef(doc, raw)
+				if !ok || classified[target] == "" {
+					return m
+				}
+				return strings.TrimSuffix(m, raw) + rewriteRef(raw, classified[target])
+			})
+		}
+		dst := filepath.Join(outputDir, filepath.FromSlash(doc))
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := os.WriteFile(dst, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+	}
+
+	// Copy the remaining assets, leaving out the originals of classified images
+	for _, rel := range files {
+		if _, referenced := classified[rel]; referenced {
+			continue
+		}
+		if bundleImageExts[strings.ToLower(path.Ext(rel))] && !skipped[rel] {
+			fmt.Printf(tr("Warning: '%s' is not referenced by any document; copied unmarked\n"), rel)
+		}
+		dst := filepath.Join(outputDir, filepath.FromSlash(rel))
+		if err := copyLocation(filepath.Join(root, filepath.FromSlash(rel)), dst); err != nil {
+			return fmt.Errorf("failed to copy '%s': %w", rel, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d referenced image(s) failed to process", failed)
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// cacheVersion is part of every cache key; bump it when rendering changes so
+// entries written by older builds are not reused.
+const cacheVersion = 3
+
+// redisCachePrefix namespaces cache keys stored in Redis.
+const redisCachePrefix = "goclassifyit:"
+
+// outputCache maps a cache key to a stored output. Implementations must be
+// safe for concurrent use.
+type outputCache interface {
+	Get(key string) ([]byte, bool, error)
+	Put(key string, value []byte) error
+}
+
+// resultCache holds classified outputs keyed by (input SHA-256, options
+// hash), so repeated runs over mostly unchanged archives skip re-encoding.
+// nil disables caching. Outputs are reused byte for byte, so without
+// -deterministic a cached DICOM keeps the SOP Instance UID of its first run.
+var resultCache outputCache
+
+// openCache returns the cache named by spec: a redis:// URL or a directory.
+func openCache(spec string) (outputCache, error) {
+	if strings.HasPrefix(spec, "redis://") {
+		return newRedisCache(spec)
+	}
+	if err := os.MkdirAll(spec, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return dirCache(spec), nil
+}
+
+// cacheKey returns the key for input classified with the given settings and
+// the run-level options that affect output.
+func cacheKey(input []byte, banner classify.BannerMode, bannerHeight int, loc string) string {
+	in := sha256.Sum256(input)
+	opts := sha256.New()
+	fmt.Fprintf(opts, "%d|%+v|%d|%s|%+v|%s|%t|%s|%s",
+		cacheVersion, banner, bannerHeight, loc, toneMapping, colorSpace, deterministic, rawConverter, fallbackFontSpec)
+	// Added only when set, so enabling these options leaves other entries valid
+	if annotationFormat != "" {
+		fmt.Fprintf(opts, "|annotations=%s", annotationFormat)
+	}
+	if perceptualHash {
+		fmt.Fprint(opts, "|phash")
+	}
+	if convertFormat != "" {
+		fmt.Fprintf(opts, "|convert=%s", convertFormat)
+	}
+	if alphaMode == "flatten" || matteColor != defaultMatteColor {
+		fmt.Fprintf(opts, "|matte=%v", matteColor)
+	}
+	if bannerFontSpec != "" {
+		fmt.Fprintf(opts, "|font=%s", bannerFontSpec)
+	}
+	if pageNumberCorner != "" {
+		fmt.Fprintf(opts, "|pages=%s", pageNumberCorner)
+	}
+	if embedMetadata {
+		fmt.Fprint(opts, "|metadata")
+	}
+	if preserveMetadata {
+		fmt.Fprint(opts, "|preserve-metadata")
+	}
+	if strictLayout {
+		fmt.Fprint(opts, "|strict-layout")
+	}
+	if rotation != 0 {
+		fmt.Fprintf(opts, "|rotate=%d", rotation)
+	}
+	if flipAxis != "" {
+		fmt.Fprintf(opts, "|flip=%s", flipAxis)
+	}
+	if autoCrop {
+		fmt.Fprintf(opts, "|crop=%d", cropTolerance)
+	}
+	if jpegQuality != defaultJPEGQuality {
+		fmt.Fprintf(opts, "|quality=%d", jpegQuality)
+	}
+	if pngCompression != png.DefaultCompression {
+		fmt.Fprintf(opts, "|png=%d", pngCompression)
+	}
+	return hex.EncodeToString(in[:]) + "-" + hex.EncodeToString(opts.Sum(nil))
+}
+
+// encodeCacheEntry stores the output file name ahead of its contents. Outputs
+// are stored under cacheKeptName when they keep the input's name, or under
+// their extension, so identical inputs of other names get their own name.
+func encodeCacheEntry(name string, data []byte) []byte {
+	return append(append([]byte(name), 0), data...)
+}
+
+// decodeCacheEntry splits an entry into its output file name and contents.
+func decodeCacheEntry(entry []byte) (string, []byte, bool) {
+	i := bytes.IndexByte(entry, 0)
+	if i <= 0 {
+		return "", nil, false
+	}
+	return filepath.Base(string(entry[:i])), entry[i+1:], true
+}
+
+// cacheKeptName is the name stored for outputs that keep the input's name.
+const cacheKeptName = "."
+
+// cachedOutputName returns the output file name for imagePath from the name
+// stored in its cache entry.
+func cachedOutputName(imagePath, name string) string {
+	base := filepath.Base(imagePath)
+	if name == cacheKeptName {
+		return base
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)) + name
+}
+
+// processCached writes the cached output for imagePath when there is one,
+// and otherwise classifies it and stores the result, returning the path of
+// the output. Cache failures are reported but never fail the image.
+func processCached(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	data, err := readFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	key := cacheKey(data, banner, bannerHeight, loc)
+
+	entry, ok, err := resultCache.Get(key)
+	if err != nil {
+		fmt.Println(tr("Warning: result cache lookup failed:"), err)
+	}
+	if name, out, valid := decodeCacheEntry(entry); ok && valid {
+		outputPath := joinLocation(outputDir, cachedOutputName(imagePath, name))
+		if err := claimOutput(outputPath, imagePath); err != nil {
+			return "", err
+		}
+		// The marking time, operator, and run are those of this run
+		if embedMetadata && !deterministic {
+			if out, err = restampClassification(out, newClassificationMetadata(banner, operatorFor(imagePath))); err != nil {
+				return "", err
+			}
+		}
+		if err := writeOutputFile(outputPath, out); err != nil {
+			return "", err
+		}
+		if verifyOutput {
+			written, err := readFile(outputPath)
+			if err != nil || !bytes.Equal(written, out) {
+				return "", fmt.Errorf("verify '%s': output differs from the cached result", outputPath)
+			}
+		}
+		if err := restoreCachedAnnotation(key, outputPath); err != nil {
+			return "", err
+		}
+		restoreCachedHash(key, imagePath)
+		return outputPath, nil
+	}
+
+	outputPath, err := classifyImage(imagePath, banner, outputDir, bannerHeight, loc)
+	if err != nil {
+		return "", err
+	}
+	out, err := readFile(outputPath)
+	if err == nil {
+		name := cacheKeptName
+		if filepath.Base(outputPath) != filepath.Base(imagePath) {
+			name = filepath.Ext(outputPath)
+		}
+		err = resultCache.Put(key, encodeCacheEntry(name, out))
+	}
+	if err == nil {
+		err = storeCachedAnnotation(key, outputPath)
+	}
+	if err == nil {
+		err = storeCachedHash(key, imagePath)
+	}
+	if err != nil {
+		fmt.Println(tr("Warning: failed to store result in cache:"), err)
+	}
+	return outputPath, nil
+}
+
+// annotationCacheKey is the key of the annotation stored alongside the
+// output cached under key.
+func annotationCacheKey(key string) string {
+	return key + "-annotation"
+}
+
+// storeCachedAnnotation caches the annotation written for outputPath, if
+// there is one (only raster outputs are annotated).
+func storeCachedAnnotation(key, outputPath string) error {
+	if annotationFormat == "" {
+		return nil
+	}
+	data, err := readFile(annotationPath(outputPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resultCache.Put(annotationCacheKey(key), encodeCacheEntry(filepath.Base(annotationPath(outputPath)), data))
+}
+
+// restoreCachedAnnotation writes the cached annotation of a reused output.
+func restoreCachedAnnotation(key, outputPath string) error {
+	if annotationFormat == "" {
+		return nil
+	}
+	entry, ok, err := resultCache.Get(annotationCacheKey(key))
+	if err != nil {
+		fmt.Println(tr("Warning: result cache lookup failed:"), err)
+	}
+	if _, data, valid := decodeCacheEntry(entry); ok && valid {
+		return writeOutputFile(annotationPath(outputPath), data)
+	}
+	return nil
+}
+
+// hashCacheKey is the key of the perceptual hash stored alongside the output
+// cached under key.
+func hashCacheKey(key string) string {
+	return key + "-phash"
+}
+
+// storeCachedHash caches the perceptual hash computed for imagePath, if there
+// is one (only raster inputs are hashed).
+func storeCachedHash(key, imagePath string) error {
+	hash, ok := cachedHashes.LoadAndDelete(imagePath)
+	if !ok {
+		return nil
+	}
+	return resultCache.Put(hashCacheKey(key), []byte(hash.(string)))
+}
+
+// restoreCachedHash reports the cached perceptual hash of a reused output.
+func restoreCachedHash(key, imagePath string) {
+	if !perceptualHash {
+		return
+	}
+	hash, ok, err := resultCache.Get(hashCacheKey(key))
+	if err != nil {
+		fmt.Println(tr("Warning: result cache lookup failed:"), err)
+	}
+	if ok {
+		reportHash(imagePath, string(hash))
+	}
+}
+
+// dirCache stores entries as files under a directory, fanned out by the
+// first two characters of the key.
+type dirCache string
+
+func (c dirCache) path(key string) string {
+	return filepath.Join(string(c), key[:2], key)
+}
+
+func (c dirCache) Get(key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Put writes the entry to a temporary file and renames it into place, so
+// concurrent readers never see a partial entry.
+func (c dirCache) Put(key string, value []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// redisCache stores entries in Redis over a single RESP connection, which is
+// re-established after any error.
+type redisCache struct {
+	mu       sync.Mutex
+	addr     string
+	password string
+	db       int
+	ttl      time.Duration
+	conn     net.Conn
+	rd       *bufio.Reader
+}
+
+// newRedisCache parses redis://[:password@]host[:port][/db][?ttl=duration].
+func newRedisCache(rawURL string) (*redisCache, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	c := &redisCache{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database '%s'", db)
+		}
+	}
+	if ttl := u.Query().Get("ttl"); ttl != "" {
+		if c.ttl, err = time.ParseDuration(ttl); err != nil {
+			return nil, fmt.Errorf("invalid Redis ttl '%s': %w", ttl, err)
+		}
+	}
+
+	// Connect now so a bad address is reported at startup
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *redisCache) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis at %s: %w", c.addr, err)
+	}
+	c.conn, c.rd = conn, bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := c.do("AUTH", c.password); err != nil {
+			c.close()
+			return fmt.Errorf("Redis AUTH failed: %w", err)
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			c.close()
+			return fmt.Errorf("Redis SELECT failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// command runs one command, reconnecting first if needed and dropping the
+// connection after any failure.
+func (c *redisCache) command(args ...string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.do(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.close()
+	}
+	return reply, err
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool, error) {
+	reply, err := c.command("GET", redisCachePrefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	return reply, reply != nil, nil
+}
+
+func (c *redisCache) Put(key string, value []byte) error {
+	args := []string{"SET", redisCachePrefix + key, string(value)}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+	}
+	_, err := c.command(args...)
+	return err
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// do sends a RESP command and reads its reply. Bulk replies are returned as
+// bytes (nil for a missing key); status and integer replies as their text.
+func (c *redisCache) do(args ...string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty Redis reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed Redis reply '%s'", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+	return nil, fmt.Errorf("unexpected Redis reply '%s'", line)
+}
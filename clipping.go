@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// strictLayout fails images whose labels would be clipped (the
+// -strict-layout flag) instead of warning about them.
+var strictLayout bool
+
+func init() {
+	// Layouts are checked before the banners are drawn, so a strict run
+	// stops without rendering
+	registerStage(phaseMark, "layout", func(job *imageJob) error {
+		opts := markOptions(job.Banner, job.BannerHeight, job.Loc)
+		layout, err := classify.LayoutImage(job.Image, opts)
+		if err != nil {
+			return err
+		}
+		portions, err := classify.ClippedPortions(opts, job.Image.Bounds().Size())
+		if err != nil {
+			return err
+		}
+		return checkClipping(job.InputPath, append(layout.Clipped(), portions...))
+	})
+}
+
+// checkClipping warns about each clipped label of imagePath, or with
+// -strict-layout fails with all of them.
+func checkClipping(imagePath string, clipped []string) error {
+	if len(clipped) == 0 {
+		return nil
+	}
+	if strictLayout {
+		return fmt.Errorf("%w: %s", classify.ErrTextOverflow, strings.Join(clipped, "; "))
+	}
+	for _, c := range clipped {
+		fmt.Printf(tr("Warning: '%s': %s\n"), imagePath, c)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+)
+
+// adobeCMYKMarker is an APP14 "Adobe" segment with transform 0 (CMYK/RGB, no YCbCr conversion).
+var adobeCMYKMarker = []byte{
+	0xFF, 0xEE, 0x00, 0x0E,
+	'A', 'd', 'o', 'b', 'e',
+	0x00, 0x64, // version
+	0x00, 0x00, // flags0
+	0x00, 0x00, // flags1
+	0x00, // transform: unknown (CMYK)
+}
+
+// isUnmarkedCMYKError reports whether err is the decoder's rejection of a
+// 4-component JPEG that lacks the Adobe APP14 segment.
+func isUnmarkedCMYKError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "4-component JPEG doesn't have Adobe APP14 metadata")
+}
+
+// decodeUnmarkedCMYK decodes a 4-component JPEG written without an Adobe APP14
+// segment (common from print workflows) by supplying one. The decoder then
+// assumes Adobe's inverted ink values, so the channels are inverted back,
+// since non-Adobe writers store ink as-is.
+func decodeUnmarkedCMYK(data []byte) (image.Image, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG stream")
+	}
+	patched := make([]byte, 0, len(data)+len(adobeCMYKMarker))
+	patched = append(patched, data[:2]...)
+	patched = append(patched, adobeCMYKMarker...)
+	patched = append(patched, data[2:]...)
+
+	img, err := jpeg.Decode(bytes.NewReader(patched))
+	if err != nil {
+		return nil, err
+	}
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return img, nil
+	}
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = 255 - cmyk.Pix[i]
+	}
+	return cmyk, nil
+}
+
+// normalizeCMYK converts CMYK images to RGBA up front, so banners and the
+// re-encoded output use RGB colors rather than relying on lazy per-pixel
+// conversion.
+func normalizeCMYK(img image.Image) (image.Image, bool) {
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return img, false
+	}
+	b := cmyk.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, cmyk, b.Min, draw.Src)
+	return rgba, true
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// colorSpace selects how embedded color profiles are handled: "preserve"
+// leaves pixel values as decoded, "srgb" converts RGB inputs tagged with an
+// ICC profile (Adobe RGB, Display P3, ...) into sRGB.
+var colorSpace = "preserve"
+
+// srgbFromD50 converts D50 (ICC PCS) XYZ to linear sRGB, with Bradford
+// adaptation to the sRGB D65 white point.
+var srgbFromD50 = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccLinearSize and iccEncodeSize are the resolutions of the lookup tables
+// used to linearize 16-bit inputs and re-encode linear light as sRGB.
+const (
+	iccLinearSize = 4096
+	iccEncodeSize = 16384
+)
+
+// iccCurve maps an encoded channel value in [0,1] to linear light.
+type iccCurve func(float64) float64
+
+// iccProfile is an RGB matrix/TRC ICC profile: per-channel tone curves and
+// the matrix taking linear RGB to D50 XYZ.
+type iccProfile struct {
+	curves [3]iccCurve
+	matrix [3][3]float64
+}
+
+// validateColorSpace checks a -colorspace value.
+func validateColorSpace(name string) error {
+	switch name {
+	case "preserve", "srgb":
+		return nil
+	}
+	return fmt.Errorf("unknown color space '%s' (use preserve or srgb)", name)
+}
+
+// normalizeColorSpace converts img to sRGB using the ICC profile embedded in
+// imagePath. Images without a profile, or whose profile is not an RGB
+// matrix/TRC profile, are returned unchanged.
+func normalizeColorSpace(imagePath string, img image.Image) (image.Image, error) {
+	data, err := readFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	raw, err := embeddedICCProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read color profile of '%s': %w", imagePath, err)
+	}
+	if raw == nil {
+		return img, nil
+	}
+	profile, err := parseICCProfile(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse color profile of '%s': %w", imagePath, err)
+	}
+	if profile == nil {
+		return img, nil
+	}
+	return profile.toSRGB(img), nil
+}
+
+// embeddedICCProfile returns the ICC profile stored in a PNG iCCP chunk or in
+// JPEG APP2 ICC_PROFILE segments, or nil when there is none.
+func embeddedICCProfile(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngICCProfile(data)
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegICCProfile(data), nil
+	}
+	return nil, nil
+}
+
+// pngICCProfile reads the zlib-compressed profile in a PNG iCCP chunk.
+func pngICCProfile(data []byte) ([]byte, error) {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || kind == "IDAT" {
+			break
+		}
+		if kind == "iCCP" {
+			chunk := data[pos+8 : pos+8+length]
+			// Profile name, NUL, compression method, compressed profile
+			nul := bytes.IndexByte(chunk, 0)
+			if nul < 0 || nul+2 > len(chunk) {
+				return nil, fmt.Errorf("malformed iCCP chunk")
+			}
+			r, err := zlib.NewReader(bytes.NewReader(chunk[nul+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		}
+		pos += 12 + length
+	}
+	return nil, nil
+}
+
+// jpegICCProfile joins the ICC_PROFILE APP2 segments of a JPEG in sequence order.
+func jpegICCProfile(data []byte) []byte {
+	parts := map[int][]byte{}
+	count := 0
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan or end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+length]
+		if marker == 0xE2 && len(seg) > 14 && string(seg[:12]) == "ICC_PROFILE\x00" {
+			parts[int(seg[12])] = seg[14:]
+			count = int(seg[13])
+		}
+		pos += 2 + length
+	}
+	if len(parts) == 0 || len(parts) != count {
+		return nil
+	}
+	var profile []byte
+	for i := 1; i <= count; i++ {
+		profile = append(profile, parts[i]...)
+	}
+	return profile
+}
+
+// parseICCProfile extracts the matrix and tone curves of an RGB ICC profile.
+// It returns nil for profiles it cannot apply (non-RGB or LUT-based).
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, nil
+	}
+
+	tags := map[string][]byte{}
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < n && 132+12*i+12 <= len(data); i++ {
+		entry := data[132+12*i:]
+		sig := string(entry[:4])
+		off := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if off < 0 || size < 0 || off+size > len(data) {
+			return nil, fmt.Errorf("tag %s is out of range", sig)
+		}
+		tags[sig] = data[off : off+size]
+	}
+
+	p := &iccProfile{}
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, trc := tags[name+"XYZ"], tags[name+"TRC"]
+		if xyz == nil || trc == nil {
+			return nil, nil
+		}
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, fmt.Errorf("malformed %sXYZ tag", name)
+		}
+		for i := 0; i < 3; i++ {
+			p.matrix[i][c] = s15Fixed16(xyz[8+4*i:])
+		}
+		curve, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, fmt.Errorf("%sTRC: %w", name, err)
+		}
+		p.curves[c] = curve
+	}
+	return p, nil
+}
+
+// parseICCCurve decodes a curv or para tone curve tag.
+func parseICCCurve(tag []byte) (iccCurve, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("tag is too short")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, fmt.Errorf("curve is truncated")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			x := v * float64(n-1)
+			i := int(x)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(x-float64(i))
+		}, nil
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		counts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		count, ok := counts[kind]
+		if !ok || len(tag) < 12+4*count {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", kind)
+		}
+		// Parameters default to the identity segments of the full type 4 curve
+		g, a, b, c, d, e, f := 1.0, 1.0, 0.0, 0.0, 0.0, 0.0, 0.0
+		params := make([]float64, count)
+		for i := range params {
+			params[i] = s15Fixed16(tag[12+4*i:])
+		}
+		switch kind {
+		case 0:
+			g = params[0]
+		case 1:
+			g, a, b = params[0], params[1], params[2]
+			d = -b / a
+		case 2:
+			g, a, b, c = params[0], params[1], params[2], params[3]
+			d, e, f = -b/a, c, c
+		case 3:
+			g, a, b, c, d = params[0], params[1], params[2], params[3], params[4]
+		case 4:
+			g, a, b, c, d, e, f = params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+		}
+		return func(v float64) float64 {
+			if v >= d {
+				return math.Pow(math.Max(a*v+b, 0), g) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type '%s'", tag[:4])
+}
+
+// s15Fixed16 decodes an ICC signed 15.16 fixed-point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// toSRGB converts img from the profile's color space to 8-bit sRGB, keeping
+// alpha. Out-of-gamut colors are clipped.
+func (p *iccProfile) toSRGB(img image.Image) *image.NRGBA {
+	var linear [3][iccLinearSize]float64
+	for c := range linear {
+		for i := range linear[c] {
+			linear[c][i] = p.curves[c](float64(i) / (iccLinearSize - 1))
+		}
+	}
+	var encode [iccEncodeSize]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/(iccEncodeSize-1)) * 255))
+	}
+
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += srgbFromD50[i][k] * p.matrix[k][j]
+			}
+		}
+	}
+
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			in := [3]float64{
+				linear[0][int(c.R)*(iccLinearSize-1)/0xffff],
+				linear[1][int(c.G)*(iccLinearSize-1)/0xffff],
+				linear[2][int(c.B)*(iccLinearSize-1)/0xffff],
+			}
+			var px [3]uint8
+			for i := 0; i < 3; i++ {
+				v := m[i][0]*in[0] + m[i][1]*in[1] + m[i][2]*in[2]
+				v = math.Min(math.Max(v, 0), 1)
+				px[i] = encode[int(v*(iccEncodeSize-1)+0.5)]
+			}
+			out.SetNRGBA(x-b.Min.X, y-b.Min.Y, color.NRGBA{px[0], px[1], px[2], uint8(c.A >> 8)})
+		}
+	}
+	return out
+}
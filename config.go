@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is a -config file: named banner profiles, each selected with
+// -c NAME.
+type configFile struct {
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+// applyProfile sets flags from the profile named name in a YAML or JSON
+// config file, except those given on the command line (or by a job file),
+// which take precedence. Profile keys are flag names as in job files;
+// "classification" names the preset the profile builds on
+// (default custom). It returns that preset, or name itself when the file has
+// no such profile, so -c still accepts the presets.
+func applyProfile(path, name string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+	var cfg configFile
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return name, nil
+	}
+	source := fmt.Sprintf("config file '%s': profile '%s'", path, name)
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	base := "custom"
+	for key, value := range profile {
+		switch key {
+		case "classification":
+			base = fmt.Sprint(value)
+			continue
+		}
+		f := settingFlag(key)
+		if f == nil || f.Name == "c" || f.Name == "job" || f.Name == "config" {
+			return "", fmt.Errorf("%s: unknown option '%s'", source, key)
+		}
+		if explicit[f.Name] {
+			continue
+		}
+		if err := setFlagValue(f, value); err != nil {
+			return "", fmt.Errorf("%s: %s: %w", source, key, err)
+		}
+	}
+	return base, nil
+}
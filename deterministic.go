@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/big"
+)
+
+// deterministic guarantees byte-identical output for identical inputs and
+// options: encoder settings are pinned and values that would otherwise be
+// random or time-based are derived from the input instead.
+var deterministic bool
+
+// defaultJPEGQuality is the JPEG quality used without -quality (the
+// image/jpeg default).
+const defaultJPEGQuality = 75
+
+// jpegQuality is the -quality of JPEG and WebP outputs, from 1 to 100.
+var jpegQuality = defaultJPEGQuality
+
+// pngCompressionLevels are the -png-compression names.
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// pngCompression is the -png-compression level of PNG outputs.
+var pngCompression = png.DefaultCompression
+
+// parsePNGCompression checks a -png-compression value.
+func parsePNGCompression(name string) (png.CompressionLevel, error) {
+	level, ok := pngCompressionLevels[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid PNG compression '%s' (options: default, none, fast, best)", name)
+	}
+	return level, nil
+}
+
+// encodeImage writes img in the given format with the run's encoder
+// settings, which are fixed for the run so the same input always encodes
+// the same way.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "png":
+		enc := &png.Encoder{CompressionLevel: pngCompression}
+		return enc.Encode(w, img)
+	case "webp", "avif":
+		return encodeExternal(w, img, format)
+	}
+	return fmt.Errorf("unsupported output format '%s'", format)
+}
+
+// derivedUID returns a UID under the 2.25 root computed from the input bytes
+// and the marking options, so deterministic runs produce stable identifiers.
+func derivedUID(input []byte, options ...any) string {
+	h := sha256.New()
+	h.Write(input)
+	fmt.Fprintf(h, "%+v", options)
+	sum := h.Sum(nil)[:16]
+	sum[6] = sum[6]&0x0f | 0x80 // UUID version 8 (custom)
+	sum[8] = sum[8]&0x3f | 0x80
+	return "2.25." + new(big.Int).SetBytes(sum).String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// minDimension is the -min-dimension limit: images whose width or height is
+// below it are skipped rather than marked (0: off).
+var minDimension int
+
+// skipError is the reason an input was deliberately left unmarked. Skips
+// are reported, but are not failures.
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string { return e.reason }
+
+// isSkipped reports whether err is a skip rather than a failure.
+func isSkipped(err error) bool {
+	var se *skipError
+	return errors.As(err, &se)
+}
+
+// checkDimensions skips imagePath when it is smaller than minDimension. Only
+// images whose size can be read from their header (PNG, JPEG, GIF, TIFF,
+// WebP, PSD, HDR, EXR) are checked; documents, icons, and other formats are
+// not skipped.
+func checkDimensions(imagePath string) error {
+	if minDimension <= 0 {
+		return nil
+	}
+	f, err := openFile(imagePath)
+	if err != nil {
+		return nil // Reported when the image is opened to be marked
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil
+	}
+	if cfg.Width < minDimension || cfg.Height < minDimension {
+		return &skipError{fmt.Sprintf("%dx%d is smaller than -min-dimension %d", cfg.Width, cfg.Height, minDimension)}
+	}
+	return nil
+}
+
+// reportSkip prints and logs a skipped input.
+func reportSkip(path string, err error) {
+	fmt.Printf(tr("Skipped %s: %v\n"), path, err)
+	events.Info(fmt.Sprintf("Skipped '%s': %v (operator: %s, run: %s)", path, err, operatorFor(path), runID))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// dryRun reports what a run would do with each file (the -dry-run flag)
+// without writing any output.
+var dryRun bool
+
+// dryRunJSONPath is the -dry-run-json file the plan is written to, or empty
+// when off.
+var dryRunJSONPath string
+
+// plannedFile is what a dry run found a real run would do with one file.
+type plannedFile struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`           // process, skip, or fail
+	Format string `json:"format,omitempty"` // Output format, or the kind of document (tiff, gif, pdf, ...)
+	Output string `json:"output,omitempty"` // Output file of a raster image
+	Reason string `json:"reason,omitempty"` // Why the file would be skipped or fail
+	Stage  string `json:"stage,omitempty"`  // Stage a failure would come from; see failureRecord
+	Class  string `json:"class,omitempty"`  // Class of a failure; see errorClass
+}
+
+// dryRunReport is the -dry-run-json file.
+type dryRunReport struct {
+	RunID     string        `json:"run_id"`
+	Operator  string        `json:"operator"`
+	Time      time.Time     `json:"time"`
+	Processed int           `json:"processed"`
+	Skipped   int           `json:"skipped"`
+	Failed    int           `json:"failed"`
+	Files     []plannedFile `json:"files"`
+}
+
+// dryRunPlan collects the planned files of the run.
+var dryRunPlan struct {
+	mu    sync.Mutex
+	files []plannedFile
+}
+
+// planSkip records a file a dry run would skip, and prints it.
+func planSkip(path, reason string) {
+	addPlanned(plannedFile{Path: path, Action: "skip", Reason: reason})
+}
+
+// addPlanned records a planned file and prints it as it is found.
+func addPlanned(p plannedFile) {
+	dryRunPlan.mu.Lock()
+	defer dryRunPlan.mu.Unlock()
+	dryRunPlan.files = append(dryRunPlan.files, p)
+	switch p.Action {
+	case "process":
+		if p.Output != "" {
+			fmt.Printf(tr("Would classify: %s -> %s\n"), p.Path, p.Output)
+		} else {
+			fmt.Printf(tr("Would classify: %s (%s)\n"), p.Path, p.Format)
+		}
+	case "skip":
+		fmt.Printf(tr("Would skip: %s (%s)\n"), p.Path, p.Reason)
+	case "fail":
+		fmt.Printf(tr("Would fail: %s: %s\n"), p.Path, p.Reason)
+	}
+}
+
+// runDryRun plans every file of paths, which may mix files and directories,
+// as a real run would process them, then prints the summary and writes the
+// -dry-run-json report. It returns an error when any file would fail.
+func runDryRun(paths []string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	for _, path := range paths {
+		info, err := statLocation(path)
+		switch {
+		case err != nil:
+			addPlanned(failedPlan(path, err))
+		case info.IsDir():
+			if err := planDirectory(path, banner, outputDir, bannerHeight, loc); err != nil {
+				return err
+			}
+		default:
+			planFile(path, banner, outputDir, bannerHeight, loc)
+		}
+	}
+
+	report := dryRunReport{RunID: runID, Operator: operator, Time: time.Now().UTC()}
+	dryRunPlan.mu.Lock()
+	report.Files = dryRunPlan.files
+	dryRunPlan.mu.Unlock()
+	sort.SliceStable(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+	for _, p := range report.Files {
+		switch p.Action {
+		case "process":
+			report.Processed++
+		case "skip":
+			report.Skipped++
+		case "fail":
+			report.Failed++
+		}
+	}
+	if report.Files == nil {
+		report.Files = []plannedFile{}
+	}
+	fmt.Printf(tr("Dry run: %d to classify, %d to skip, %d would fail\n"), report.Processed, report.Skipped, report.Failed)
+	if err := writeDryRunReport(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d file(s) would fail", report.Failed)
+	}
+	return nil
+}
+
+// planDirectory plans the files of dirPath as processDirectory would list
+// them, with the same workers.
+func planDirectory(dirPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	if err := checkTreeOutput(dirPath, outputDir); err != nil {
+		return err
+	}
+	paths, errc := listFiles(dirPath)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				planFile(filePath, banner, treeOutputDir(dirPath, filePath, outputDir), bannerHeight, loc)
+			}
+		}()
+	}
+	wg.Wait()
+	return <-errc
+}
+
+// planFile records what classifyFile would do with imagePath. Raster images
+// go through every pipeline stage before the encode phase, so decoding,
+// format, and layout failures show up; documents and icons are only checked
+// up to their per-image settings.
+func planFile(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) {
+	banner, bannerHeight, loc, err := prepareFile(imagePath, banner, bannerHeight, loc)
+	if isSkipped(err) {
+		planSkip(imagePath, err.Error())
+		return
+	}
+	if err != nil {
+		addPlanned(failedPlan(imagePath, err))
+		return
+	}
+
+	if kind := formatStage(imagePath); kind != "classify" {
+		// Outlook messages fail as soon as they are handed over
+		if isOutlookMsg(imagePath) {
+			_, err = classifyImage(imagePath, banner, outputDir, bannerHeight, loc)
+			addPlanned(failedPlan(imagePath, atStage(kind, err)))
+			return
+		}
+		addPlanned(plannedFile{Path: imagePath, Action: "process", Format: kind})
+		return
+	}
+
+	job := &imageJob{InputPath: imagePath, OutputDir: outputDir, Banner: banner, BannerHeight: bannerHeight, Loc: loc}
+	for _, s := range pipelineStages {
+		if s.Phase >= phaseEncode {
+			break
+		}
+		if err := s.Run(job); err != nil {
+			addPlanned(failedPlan(imagePath, atStage(s.Name, err)))
+			return
+		}
+	}
+	output := joinLocation(outputDir, outputName(imagePath, job.Source, job.Format))
+	if err := claimOutput(output, imagePath); err != nil {
+		addPlanned(failedPlan(imagePath, atStage("write", err)))
+		return
+	}
+	addPlanned(plannedFile{
+		Path:   imagePath,
+		Action: "process",
+		Format: job.Format,
+		Output: output,
+	})
+}
+
+// failedPlan describes a file a dry run found would fail, with its stage and
+// class as in the -errors-json report.
+func failedPlan(path string, err error) plannedFile {
+	stage := failureStage(err)
+	return plannedFile{Path: path, Action: "fail", Reason: err.Error(), Stage: stage, Class: errorClass(stage, err)}
+}
+
+// writeDryRunReport writes the -dry-run-json report.
+func writeDryRunReport(report dryRunReport) error {
+	if dryRunJSONPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dry run report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dryRunJSONPath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to write dry run report: %w", err)
+	}
+	if err := os.WriteFile(dryRunJSONPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write dry run report: %w", err)
+	}
+	return nil
+}
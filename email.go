@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// htmlBodyRe finds the opening body tag of an HTML part.
+var htmlBodyRe = regexp.MustCompile(`(?i)<body\b[^>]*>`)
+
+// isEmail reports whether path is a MIME message (.eml).
+func isEmail(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".eml")
+}
+
+// isOutlookMsg reports whether path is an Outlook .msg file.
+func isOutlookMsg(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".msg")
+}
+
+// emailMarker rewrites one message: it classifies image attachments and
+// marks the subject and the first plain-text and HTML bodies.
+type emailMarker struct {
+	banner       classify.BannerMode
+	bannerHeight int
+	loc          string
+	tmp          string // Staging directory for attachments
+	attachments  int
+	markedPlain  bool
+	markedHTML   bool
+}
+
+// processEmail classifies the image attachments of an .eml message and
+// writes the message with the marked attachments and the classification
+// prepended to its subject and body. Header order and all other parts are
+// kept as they were.
+func processEmail(emailPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	raw, err := readFile(emailPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open message: %w", err)
+	}
+	header, body, nl := splitMessage(raw)
+	h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(append([]byte{}, header...), nl+nl...)))).ReadMIMEHeader()
+	if err != nil {
+		return "", badInput(fmt.Errorf("invalid message header in '%s': %w", emailPath, err))
+	}
+
+	tmp, err := os.MkdirTemp("", "goclassifyit-eml-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	m := &emailMarker{banner: banner, bannerHeight: bannerHeight, loc: loc, tmp: tmp}
+	_, body, err = m.rewrite(h, body, true)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	out.Write(markSubject(header, banner.Text, nl))
+	out.WriteString(nl + nl)
+	out.Write(body)
+
+	outputPath := joinLocation(outputDir, filepath.Base(emailPath))
+	if err := writeOutputFile(outputPath, out.Bytes()); err != nil {
+		return "", err
+	}
+	fmt.Printf(tr("Marked message with %d image attachment(s): %s\n"), m.attachments, emailPath)
+	return outputPath, nil
+}
+
+// splitMessage splits a raw message into its header block and body, and
+// reports the line ending it uses.
+func splitMessage(raw []byte) ([]byte, []byte, string) {
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		return raw[:i], raw[i+4:], "\r\n"
+	}
+	if i := bytes.Index(raw, []byte("\n\n")); i >= 0 {
+		return raw[:i], raw[i+2:], "\n"
+	}
+	return raw, nil, "\r\n"
+}
+
+// markSubject returns the header block with "[TEXT] " prepended to the
+// subject, adding a subject when there is none.
+func markSubject(header []byte, text, nl string) []byte {
+	prefix := "[" + text + "] "
+	lines := strings.Split(string(header), nl)
+	var out []string
+	found := false
+	for i := 0; i < len(lines); i++ {
+		name, value, ok := strings.Cut(lines[i], ":")
+		if !ok || found || !strings.EqualFold(strings.TrimSpace(name), "Subject") {
+			out = append(out, lines[i])
+			continue
+		}
+		// Unfold continuation lines
+		for i+1 < len(lines) && (strings.HasPrefix(lines[i+1], " ") || strings.HasPrefix(lines[i+1], "\t")) {
+			i++
+			value += lines[i]
+		}
+		found = true
+		subject, err := new(mime.WordDecoder).DecodeHeader(strings.TrimSpace(value))
+		if err != nil {
+			subject = strings.TrimSpace(value)
+		}
+		if !strings.HasPrefix(subject, prefix) {
+			subject = prefix + subject
+		}
+		out = append(out, "Subject: "+mime.QEncoding.Encode("utf-8", subject))
+	}
+	if !found {
+		out = append(out, "Subject: "+mime.QEncoding.Encode("utf-8", strings.TrimSpace(prefix)))
+	}
+	return []byte(strings.Join(out, nl))
+}
+
+// rewrite returns the entity with header h and body rewritten: multiparts
+// part by part, image attachments classified, and body text marked. The
+// header is only changed for classified attachments.
+func (m *emailMarker) rewrite(h textproto.MIMEHeader, body []byte, top bool) (textproto.MIMEHeader, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	disposition, dispParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		body, err := m.rewriteMultipart(body, params["boundary"])
+		return h, body, err
+	case !top && isImageAttachment(mediaType, params, dispParams):
+		return m.classifyAttachment(h, body, params, dispParams)
+	case disposition == "attachment":
+		return h, body, nil
+	case mediaType == "text/plain" && !m.markedPlain:
+		m.markedPlain = true
+		body, err := recode(h, body, func(text []byte) []byte {
+			return append([]byte(m.banner.Text+"\r\n\r\n"), text...)
+		})
+		return h, body, err
+	case mediaType == "text/html" && !m.markedHTML:
+		m.markedHTML = true
+		body, err := recode(h, body, func(text []byte) []byte {
+			line := "<p><strong>" + m.banner.Text + "</strong></p>"
+			if loc := htmlBodyRe.FindIndex(text); loc != nil {
+				return append(append(append([]byte{}, text[:loc[1]]...), line...), text[loc[1]:]...)
+			}
+			return append([]byte(line), text...)
+		})
+		return h, body, err
+	}
+	return h, body, nil
+}
+
+// rewriteMultipart rewrites each part of a multipart body, keeping its
+// boundary.
+func (m *emailMarker) rewriteMultipart(body []byte, boundary string) ([]byte, error) {
+	if boundary == "" {
+		return nil, badInput(fmt.Errorf("multipart message has no boundary"))
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	var out bytes.Buffer
+	mw := multipart.NewWriter(&out)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, badInput(fmt.Errorf("invalid multipart boundary: %w", err))
+	}
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, badInput(fmt.Errorf("invalid multipart message: %w", err))
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, badInput(fmt.Errorf("invalid multipart message: %w", err))
+		}
+		h, data, err := m.rewrite(part.Header, data, false)
+		if err != nil {
+			return nil, err
+		}
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// isImageAttachment reports whether a part holds an image the tool can mark.
+func isImageAttachment(mediaType string, params, dispParams map[string]string) bool {
+	switch mediaType {
+	case "image/png", "image/jpeg", "image/jpg", "image/pjpeg":
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(attachmentName(params, dispParams)))
+	return ext == ".png" || ext == ".jpg" || ext == ".jpeg"
+}
+
+// attachmentName returns the file name of a part from its disposition or
+// content type parameters.
+func attachmentName(params, dispParams map[string]string) string {
+	if name := dispParams["filename"]; name != "" {
+		return filepath.Base(name)
+	}
+	return filepath.Base(params["name"])
+}
+
+// classifyAttachment marks one image attachment and returns its part with
+// the marked image, base64 encoded.
+func (m *emailMarker) classifyAttachment(h textproto.MIMEHeader, body []byte, params, dispParams map[string]string) (textproto.MIMEHeader, []byte, error) {
+	data, err := decodeTransfer(h.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return nil, nil, badInput(fmt.Errorf("invalid attachment encoding: %w", err))
+	}
+	name := attachmentName(params, dispParams)
+	if name == "" || name == "." {
+		name = fmt.Sprintf("attachment%d.img", m.attachments+1)
+	}
+
+	// Each attachment is staged in its own directory so names cannot collide
+	dir := filepath.Join(m.tmp, fmt.Sprint(m.attachments))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	src := filepath.Join(dir, name)
+	if err := os.WriteFile(src, data, 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to stage attachment: %w", err)
+	}
+	outputPath, err := classifyImage(src, m.banner, filepath.Join(dir, "out"), m.bannerHeight, m.loc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("attachment '%s': %w", name, err)
+	}
+	marked, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.attachments++
+
+	// The marked copy may have a new name and type (e.g. a JPEG sent as .png)
+	newName := filepath.Base(outputPath)
+	contentType := "image/png"
+	if ext := strings.ToLower(filepath.Ext(newName)); ext == ".jpg" || ext == ".jpeg" {
+		contentType = "image/jpeg"
+	}
+	if params["name"] != "" {
+		params["name"] = newName
+	}
+	out := textproto.MIMEHeader{}
+	for k, v := range h {
+		out[k] = v
+	}
+	out.Set("Content-Type", mime.FormatMediaType(contentType, params))
+	if disposition, _, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		if dispParams["filename"] != "" {
+			dispParams["filename"] = newName
+		}
+		out.Set("Content-Disposition", mime.FormatMediaType(disposition, dispParams))
+	}
+	out.Set("Content-Transfer-Encoding", "base64")
+	return out, encodeBase64Lines(marked), nil
+}
+
+// recode decodes a text body, applies edit, and encodes it again with the
+// same transfer encoding.
+func recode(h textproto.MIMEHeader, body []byte, edit func([]byte) []byte) ([]byte, error) {
+	cte := h.Get("Content-Transfer-Encoding")
+	text, err := decodeTransfer(cte, body)
+	if err != nil {
+		return nil, badInput(fmt.Errorf("invalid body encoding: %w", err))
+	}
+	text = edit(text)
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return encodeBase64Lines(text), nil
+	case "quoted-printable":
+		var b bytes.Buffer
+		w := quotedprintable.NewWriter(&b)
+		w.Write(text)
+		w.Close()
+		return b.Bytes(), nil
+	}
+	return text, nil
+}
+
+// decodeTransfer undoes a Content-Transfer-Encoding.
+func decodeTransfer(cte string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(body)))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	}
+	return body, nil
+}
+
+// encodeBase64Lines base64-encodes data in 76-character lines.
+func encodeBase64Lines(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var b bytes.Buffer
+	for len(enc) > 76 {
+		b.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	b.WriteString(enc + "\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,18 @@
+package main
+
+// eventSink receives processing and error events for external monitoring.
+type eventSink interface {
+	Info(msg string)
+	Error(msg string)
+	Close() error
+}
+
+// nopEventSink discards all events; it is used when event logging is disabled.
+type nopEventSink struct{}
+
+func (nopEventSink) Info(string)  {}
+func (nopEventSink) Error(string) {}
+func (nopEventSink) Close() error { return nil }
+
+// events is the active event sink for the current run.
+var events eventSink = nopEventSink{}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// openEventSink is only available on Windows builds.
+func openEventSink(source string) (eventSink, error) {
+	return nil, fmt.Errorf("the Windows Event Log is not available on this platform")
+}
@@ -0,0 +1,46 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs written to the Windows Event Log so monitoring rules can match on them.
+const (
+	eventIDProcessed = 1
+	eventIDError     = 2
+)
+
+// windowsEventSink writes processing and error events under a dedicated Event Log source.
+type windowsEventSink struct {
+	log *eventlog.Log
+}
+
+// openEventSink registers the event source (if needed) and opens it for writing.
+func openEventSink(source string) (eventSink, error) {
+	// Registering a source requires administrator rights the first time; an
+	// already-registered source is fine, so the install error is ignored and
+	// Open below reports any real problem.
+	_ = eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log source '%s': %w", source, err)
+	}
+	return &windowsEventSink{log: l}, nil
+}
+
+func (s *windowsEventSink) Info(msg string) {
+	s.log.Info(eventIDProcessed, msg)
+}
+
+func (s *windowsEventSink) Error(msg string) {
+	s.log.Error(eventIDError, msg)
+}
+
+func (s *windowsEventSink) Close() error {
+	return s.log.Close()
+}
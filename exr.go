@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// OpenEXR compression methods supported by decodeEXR.
+const (
+	exrNone = 0
+	exrRLE  = 1
+	exrZIPS = 2
+	exrZIP  = 3
+)
+
+// exrChannel describes one channel from the EXR "channels" attribute.
+type exrChannel struct {
+	name      string
+	pixelType int32 // 0 = UINT, 1 = HALF, 2 = FLOAT
+}
+
+func (c exrChannel) size() int {
+	if c.pixelType == 1 {
+		return 2
+	}
+	return 4
+}
+
+// exrHeader holds the header attributes needed to decode scanline images.
+type exrHeader struct {
+	channels    []exrChannel
+	compression byte
+	window      image.Rectangle
+	dataStart   int // Offset of the chunk offset table
+}
+
+// readEXRHeader parses the magic number, version, and attributes of a single-part
+// scanline OpenEXR file.
+func readEXRHeader(data []byte) (*exrHeader, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != 20000630 {
+		return nil, fmt.Errorf("exr: invalid magic number")
+	}
+	version := binary.LittleEndian.Uint32(data[4:])
+	if version&0x200 != 0 {
+		return nil, fmt.Errorf("exr: tiled images are not supported")
+	}
+	if version&0x1800 != 0 {
+		return nil, fmt.Errorf("exr: deep and multi-part images are not supported")
+	}
+
+	h := &exrHeader{}
+	var haveWindow bool
+	pos := 8
+	readString := func() (string, error) {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return "", fmt.Errorf("exr: truncated header")
+		}
+		s := string(data[pos : pos+end])
+		pos += end + 1
+		return s, nil
+	}
+
+	for {
+		name, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			break
+		}
+		typ, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("exr: truncated header")
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			return nil, fmt.Errorf("exr: attribute '%s' overflows file", name)
+		}
+		value := data[pos : pos+size]
+		pos += size
+
+		switch {
+		case name == "channels" && typ == "chlist":
+			for p := 0; p < len(value) && value[p] != 0; {
+				end := bytes.IndexByte(value[p:], 0)
+				if end < 0 || p+end+17 > len(value) {
+					return nil, fmt.Errorf("exr: malformed channel list")
+				}
+				ch := exrChannel{name: string(value[p : p+end])}
+				p += end + 1
+				ch.pixelType = int32(binary.LittleEndian.Uint32(value[p:]))
+				xs := binary.LittleEndian.Uint32(value[p+8:])
+				ys := binary.LittleEndian.Uint32(value[p+12:])
+				if xs != 1 || ys != 1 {
+					return nil, fmt.Errorf("exr: subsampled channel '%s' is not supported", ch.name)
+				}
+				p += 16
+				h.channels = append(h.channels, ch)
+			}
+		case name == "compression" && len(value) == 1:
+			h.compression = value[0]
+		case name == "dataWindow" && typ == "box2i" && len(value) == 16:
+			x0 := int(int32(binary.LittleEndian.Uint32(value)))
+			y0 := int(int32(binary.LittleEndian.Uint32(value[4:])))
+			x1 := int(int32(binary.LittleEndian.Uint32(value[8:])))
+			y1 := int(int32(binary.LittleEndian.Uint32(value[12:])))
+			h.window = image.Rect(x0, y0, x1+1, y1+1)
+			haveWindow = true
+		}
+	}
+	if !haveWindow || h.window.Empty() {
+		return nil, fmt.Errorf("exr: missing or empty data window")
+	}
+	if len(h.channels) == 0 {
+		return nil, fmt.Errorf("exr: no channels")
+	}
+	h.dataStart = pos
+	return h, nil
+}
+
+func decodeEXRConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	h, err := readEXRHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBA64Model, Width: h.window.Dx(), Height: h.window.Dy()}, nil
+}
+
+// decodeEXR decodes a scanline OpenEXR image with NONE, RLE, ZIPS, or ZIP compression.
+// R, G, and B channels are used when present; a lone Y channel is treated as gray.
+func decodeEXR(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readEXRHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var linesPerChunk int
+	switch h.compression {
+	case exrNone, exrRLE, exrZIPS:
+		linesPerChunk = 1
+	case exrZIP:
+		linesPerChunk = 16
+	default:
+		return nil, fmt.Errorf("exr: compression method %d is not supported", h.compression)
+	}
+
+	width, height := h.window.Dx(), h.window.Dy()
+	lineBytes := 0
+	for _, ch := range h.channels {
+		lineBytes += ch.size() * width
+	}
+
+	// Map channels to output components
+	target := make([]int, len(h.channels))
+	for i, ch := range h.channels {
+		switch ch.name {
+		case "R":
+			target[i] = 0
+		case "G":
+			target[i] = 1
+		case "B":
+			target[i] = 2
+		case "Y":
+			target[i] = 3
+		default:
+			target[i] = -1
+		}
+	}
+
+	img := newFloatImage(image.Rect(0, 0, width, height))
+	chunks := (height + linesPerChunk - 1) / linesPerChunk
+	if h.dataStart+8*chunks > len(data) {
+		return nil, fmt.Errorf("exr: truncated offset table")
+	}
+
+	for c := 0; c < chunks; c++ {
+		off := int(binary.LittleEndian.Uint64(data[h.dataStart+8*c:]))
+		if off < 0 || off+8 > len(data) {
+			return nil, fmt.Errorf("exr: chunk %d offset out of range", c)
+		}
+		y0 := int(int32(binary.LittleEndian.Uint32(data[off:]))) - h.window.Min.Y
+		size := int(binary.LittleEndian.Uint32(data[off+4:]))
+		if size < 0 || off+8+size > len(data) || y0 < 0 || y0 >= height {
+			return nil, fmt.Errorf("exr: malformed chunk %d", c)
+		}
+		lines := min(linesPerChunk, height-y0)
+		block, err := exrDecompress(data[off+8:off+8+size], h.compression, lines*lineBytes)
+		if err != nil {
+			return nil, fmt.Errorf("exr: chunk %d: %w", c, err)
+		}
+
+		p := 0
+		for ly := 0; ly < lines; ly++ {
+			row := (y0 + ly) * width
+			for i, ch := range h.channels {
+				for x := 0; x < width; x++ {
+					v := exrSample(block[p:], ch.pixelType)
+					p += ch.size()
+					switch t := target[i]; {
+					case t >= 0 && t < 3:
+						img.Pix[3*(row+x)+t] = v
+					case t == 3:
+						img.Pix[3*(row+x)] = v
+						img.Pix[3*(row+x)+1] = v
+						img.Pix[3*(row+x)+2] = v
+					}
+				}
+			}
+		}
+	}
+	return img, nil
+}
+
+// exrDecompress returns the raw little-endian sample bytes of one chunk.
+func exrDecompress(src []byte, method byte, want int) ([]byte, error) {
+	// Chunks that would not shrink are stored uncompressed
+	if method == exrNone || len(src) == want {
+		if len(src) != want {
+			return nil, fmt.Errorf("unexpected chunk size")
+		}
+		return src, nil
+	}
+
+	var tmp []byte
+	switch method {
+	case exrRLE:
+		for i := 0; i < len(src); {
+			n := int(int8(src[i]))
+			i++
+			if n < 0 {
+				if i-n > len(src) {
+					return nil, fmt.Errorf("truncated RLE literal")
+				}
+				tmp = append(tmp, src[i:i-n]...)
+				i -= n
+			} else {
+				if i >= len(src) {
+					return nil, fmt.Errorf("truncated RLE run")
+				}
+				for k := 0; k <= n; k++ {
+					tmp = append(tmp, src[i])
+				}
+				i++
+			}
+		}
+	case exrZIPS, exrZIP:
+		zr, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		if tmp, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+	if len(tmp) != want {
+		return nil, fmt.Errorf("decompressed size %d, expected %d", len(tmp), want)
+	}
+
+	// Undo the delta predictor, then de-interleave the two byte halves
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+	out := make([]byte, len(tmp))
+	half := (len(tmp) + 1) / 2
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = tmp[i/2]
+		} else {
+			out[i] = tmp[half+i/2]
+		}
+	}
+	return out, nil
+}
+
+// exrSample converts one little-endian sample to float32.
+func exrSample(b []byte, pixelType int32) float32 {
+	switch pixelType {
+	case 0:
+		return float32(binary.LittleEndian.Uint32(b))
+	case 1:
+		return halfToFloat(binary.LittleEndian.Uint16(b))
+	default:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+}
+
+// halfToFloat converts an IEEE 754 half-precision value to float32.
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch {
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0:
+		// Subnormal: normalize the mantissa
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		exp++
+		mant &= 0x3ff
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+}
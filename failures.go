@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errorsJSONPath is the -errors-json file failures are written to at the end
+// of the run, or empty when off.
+var errorsJSONPath string
+
+// stageError records the processing stage an error came from; the message is
+// the wrapped error's.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// atStage tags err with the stage it came from, keeping the innermost stage
+// of an error that already has one.
+func atStage(stage string, err error) error {
+	var se *stageError
+	if err == nil || errors.As(err, &se) {
+		return err
+	}
+	return &stageError{stage, err}
+}
+
+// formatStage names the stage of an error from one of the formats
+// classifyImage hands to its own processor, which has no pipeline stages.
+func formatStage(path string) string {
+	switch {
+	case isICO(path):
+		return "ico"
+	case isDICOM(path):
+		return "dicom"
+	case isTIFF(path):
+		return "tiff"
+	case isGIF(path):
+		return "gif"
+	case isPDF(path):
+		return "pdf"
+	case isEmail(path), isOutlookMsg(path):
+		return "email"
+	}
+	return "classify"
+}
+
+// failureRecord is one failed input in the -errors-json file.
+type failureRecord struct {
+	Path    string `json:"path"`
+	Stage   string `json:"stage"` // The pipeline stage or step that failed, e.g. "decode", "policy", or "write"
+	Class   string `json:"class"` // See errorClass
+	Message string `json:"message"`
+}
+
+// failureReport is the -errors-json file.
+type failureReport struct {
+	RunID    string          `json:"run_id"`
+	Operator string          `json:"operator"`
+	Time     time.Time       `json:"time"`
+	Failures []failureRecord `json:"failures"`
+}
+
+// failureLog collects the failures of the run for -errors-json.
+var failureLog struct {
+	mu       sync.Mutex
+	failures []failureRecord
+}
+
+// logFailure adds a failed input to the -errors-json report.
+func logFailure(path string, err error) {
+	if errorsJSONPath == "" || err == nil {
+		return
+	}
+	stage := failureStage(err)
+	failureLog.mu.Lock()
+	failureLog.failures = append(failureLog.failures, failureRecord{Path: path, Stage: stage, Class: errorClass(stage, err), Message: err.Error()})
+	failureLog.mu.Unlock()
+}
+
+// resetFailures clears the report for a new run of -schedule.
+func resetFailures() {
+	failureLog.mu.Lock()
+	failureLog.failures = nil
+	failureLog.mu.Unlock()
+}
+
+// failureStage returns the stage err was tagged with by atStage, or "open"
+// for an input that does not exist.
+func failureStage(err error) string {
+	var se *stageError
+	switch {
+	case errors.As(err, &se):
+		return se.stage
+	case errors.Is(err, fs.ErrNotExist):
+		return "open"
+	}
+	return "classify"
+}
+
+// errorClass sorts a failure by what it takes to resolve:
+//
+//	input     the file cannot be classified as it is (missing, corrupt,
+//	          unsupported, or with a bad sidecar); retrying will not help
+//	policy    the marking is not allowed for the file by -policy
+//	io        a file system or network error, which may succeed on retry
+//	verify    the written output did not read back as rendered
+//	internal  anything else
+func errorClass(stage string, err error) string {
+	var ie *inputError
+	var pe *fs.PathError
+	var ne net.Error
+	var errno syscall.Errno
+	switch {
+	case stage == "policy":
+		return "policy"
+	case stage == "open" || stage == "sidecar" || errors.As(err, &ie):
+		return "input"
+	case stage == "verify":
+		return "verify"
+	case errors.As(err, &pe) || errors.As(err, &ne) || errors.As(err, &errno):
+		return "io"
+	}
+	return "internal"
+}
+
+// writeFailureReport writes the -errors-json file, listing no failures when
+// every input succeeded. The file is renamed into place so an orchestrator
+// polling for it never reads a partial report.
+func writeFailureReport() {
+	failureLog.mu.Lock()
+	report := failureReport{RunID: runID, Operator: operator, Time: time.Now().UTC(), Failures: failureLog.failures}
+	failureLog.mu.Unlock()
+	if report.Failures == nil {
+		report.Failures = []failureRecord{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Println(tr("Warning: failed to encode errors file:"), err)
+		return
+	}
+	tmp := errorsJSONPath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(errorsJSONPath), os.ModePerm); err != nil {
+		fmt.Println(tr("Warning: failed to write errors file:"), err)
+		return
+	}
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		fmt.Println(tr("Warning: failed to write errors file:"), err)
+		return
+	}
+	if err := os.Rename(tmp, errorsJSONPath); err != nil {
+		os.Remove(tmp)
+		fmt.Println(tr("Warning: failed to write errors file:"), err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// isGIF reports whether path names a GIF image.
+func isGIF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gif")
+}
+
+// processGIF marks every frame of a GIF and writes an animation with the
+// original frame delays, loop count, and per-frame colors.
+func processGIF(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	data, err := readFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return "", badInput(fmt.Errorf("failed to decode GIF '%s': %w", imagePath, err))
+	}
+
+	out := &gif.GIF{LoopCount: g.LoopCount}
+	warned := false
+	for i, frame := range composeGIFFrames(g) {
+		img := applyAlphaMode(orientImage(frame), imagePath, &warned)
+		marked, err := renderBanner(img, banner, bannerHeight, loc)
+		if err != nil {
+			return "", fmt.Errorf("frame %d: %w", i+1, err)
+		}
+		out.Image = append(out.Image, quantizeFrame(marked))
+		out.Delay = append(out.Delay, g.Delay[i])
+		// Every frame covers the canvas, so clearing it keeps transparency right
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, out); err != nil {
+		return "", fmt.Errorf("failed to encode GIF: %w", err)
+	}
+	outputPath := joinLocation(outputDir, filepath.Base(imagePath))
+	if err := writeOutputFile(outputPath, buf.Bytes()); err != nil {
+		return "", err
+	}
+	if len(out.Image) > 1 {
+		fmt.Printf(tr("Marked %d GIF frames: %s\n"), len(out.Image), imagePath)
+	}
+	if verifyOutput {
+		return outputPath, atStage("verify", verifyGIFOutput(outputPath, out))
+	}
+	return outputPath, nil
+}
+
+// composeGIFFrames renders each frame of g as it is displayed: drawn over
+// what the earlier frames left on the canvas after their disposal.
+func composeGIFFrames(g *gif.GIF) []*image.RGBA {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, f := range g.Image {
+			bounds = bounds.Union(f.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(bounds)
+	var frames []*image.RGBA
+	for i, f := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, f.Bounds(), f, f.Bounds().Min, draw.Over)
+		frames = append(frames, cloneRGBA(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, f.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Rect)
+	copy(c.Pix, img.Pix)
+	return c
+}
+
+// quantizeFrame converts a marked frame to a paletted image. Frames with at
+// most 256 colors (the original palette colors plus the banner's) are kept
+// exactly; otherwise the most frequent colors are kept and the rest mapped
+// to the nearest of them. Pixels less than half opaque become transparent.
+func quantizeFrame(img *image.RGBA) *image.Paletted {
+	counts := map[color.RGBA]int{}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			counts[gifColor(img.RGBAAt(x, y))]++
+		}
+	}
+	colors := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if counts[colors[i]] != counts[colors[j]] {
+			return counts[colors[i]] > counts[colors[j]]
+		}
+		ci, cj := colors[i], colors[j]
+		return uint32(ci.R)<<16|uint32(ci.G)<<8|uint32(ci.B) < uint32(cj.R)<<16|uint32(cj.G)<<8|uint32(cj.B)
+	})
+	if len(colors) > 256 {
+		colors = colors[:256]
+	}
+	palette := make(color.Palette, len(colors))
+	for i, c := range colors {
+		palette[i] = c
+	}
+
+	out := image.NewPaletted(b, palette)
+	index := map[color.RGBA]uint8{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := gifColor(img.RGBAAt(x, y))
+			i, ok := index[c]
+			if !ok {
+				i = uint8(palette.Index(c))
+				index[c] = i
+			}
+			out.SetColorIndex(x, y, i)
+		}
+	}
+	return out
+}
+
+// gifColor returns the color a GIF can store for c: fully transparent, or
+// opaque with c's straight (non-premultiplied) color.
+func gifColor(c color.RGBA) color.RGBA {
+	switch {
+	case c.A < 128:
+		return color.RGBA{}
+	case c.A == 255:
+		return c
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return color.RGBA{R: n.R, G: n.G, B: n.B, A: 255}
+}
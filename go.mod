@@ -1,7 +1,13 @@
-module goclassifyit
+module github.com/AmbitiousOkie/goclassifyit
 
 go 1.24.1
 
-require golang.org/x/image v0.25.0
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/image v0.25.0
+	golang.org/x/sys v0.31.0
+	golang.org/x/term v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/text v0.23.0 // indirect
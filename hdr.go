@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("hdr", "#?RADIANCE", decodeRadiance, decodeRadianceConfig)
+	image.RegisterFormat("hdr", "#?RGBE", decodeRadiance, decodeRadianceConfig)
+	image.RegisterFormat("exr", "\x76\x2f\x31\x01", decodeEXR, decodeEXRConfig)
+}
+
+// floatImage holds linear, scene-referred RGB values decoded from an HDR source.
+type floatImage struct {
+	Rect image.Rectangle
+	Pix  []float32 // R, G, B triples in row-major order
+}
+
+func newFloatImage(r image.Rectangle) *floatImage {
+	return &floatImage{Rect: r, Pix: make([]float32, 3*r.Dx()*r.Dy())}
+}
+
+func (f *floatImage) ColorModel() color.Model { return color.RGBA64Model }
+
+func (f *floatImage) Bounds() image.Rectangle { return f.Rect }
+
+// At clamps the linear values; use toneMap for a viewable rendering.
+func (f *floatImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return color.RGBA64{}
+	}
+	i := 3 * ((y-f.Rect.Min.Y)*f.Rect.Dx() + (x - f.Rect.Min.X))
+	c := func(v float32) uint16 { return uint16(math.Max(0, math.Min(1, float64(v))) * 0xffff) }
+	return color.RGBA64{c(f.Pix[i]), c(f.Pix[i+1]), c(f.Pix[i+2]), 0xffff}
+}
+
+// toneMapOptions controls how HDR inputs are rendered to 8-bit before marking.
+type toneMapOptions struct {
+	Operator string  // reinhard (default), aces, or clamp
+	Exposure float64 // Exposure adjustment in stops
+	Output   string  // Output format for HDR inputs: png (default) or jpeg
+}
+
+// toneMapping is the active tone mapping configuration for the current run.
+var toneMapping = toneMapOptions{Operator: "reinhard", Output: "png"}
+
+// validateToneMap checks the tone mapping options.
+func validateToneMap(opts toneMapOptions) error {
+	switch opts.Operator {
+	case "reinhard", "aces", "clamp":
+	default:
+		return fmt.Errorf("unknown tone mapping operator '%s' (options: reinhard, aces, clamp)", opts.Operator)
+	}
+	if opts.Output != "png" && opts.Output != "jpeg" {
+		return fmt.Errorf("unknown HDR output format '%s' (options: png, jpeg)", opts.Output)
+	}
+	return nil
+}
+
+// toneMap renders a linear HDR image to sRGB using the configured operator.
+func toneMap(src *floatImage, opts toneMapOptions) *image.RGBA {
+	dst := image.NewRGBA(src.Rect)
+	gain := math.Exp2(opts.Exposure)
+
+	curve := func(v float64) float64 {
+		switch opts.Operator {
+		case "aces":
+			// Narkowicz's fit of the ACES filmic curve
+			return (v * (2.51*v + 0.03)) / (v*(2.43*v+0.59) + 0.14)
+		case "clamp":
+			return v
+		default: // reinhard
+			return v / (1 + v)
+		}
+	}
+
+	for i, j := 0, 0; i < len(src.Pix); i, j = i+3, j+4 {
+		for c := 0; c < 3; c++ {
+			v := math.Max(0, float64(src.Pix[i+c])*gain)
+			dst.Pix[j+c] = uint8(math.Round(srgbEncode(math.Min(1, curve(v))) * 255))
+		}
+		dst.Pix[j+3] = 255
+	}
+	return dst
+}
+
+// srgbEncode applies the sRGB transfer function to a linear value in [0, 1].
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// readRadianceHeader parses the text header and resolution line of a Radiance .hdr file.
+func readRadianceHeader(br *bufio.Reader) (width, height int, err error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return 0, 0, fmt.Errorf("radiance: truncated header: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return 0, 0, fmt.Errorf("radiance: unsupported pixel format '%s'", strings.TrimPrefix(line, "FORMAT="))
+		}
+	}
+	res, err := br.ReadString('\n')
+	if err != nil {
+		return 0, 0, fmt.Errorf("radiance: missing resolution line: %w", err)
+	}
+	f := strings.Fields(res)
+	if len(f) != 4 || f[0] != "-Y" || f[2] != "+X" {
+		return 0, 0, fmt.Errorf("radiance: unsupported image orientation '%s'", strings.TrimSpace(res))
+	}
+	if height, err = strconv.Atoi(f[1]); err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("radiance: invalid height '%s'", f[1])
+	}
+	if width, err = strconv.Atoi(f[3]); err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("radiance: invalid width '%s'", f[3])
+	}
+	return width, height, nil
+}
+
+func decodeRadianceConfig(r io.Reader) (image.Config, error) {
+	w, h, err := readRadianceHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBA64Model, Width: w, Height: h}, nil
+}
+
+// decodeRadiance decodes a Radiance RGBE (.hdr) image, flat or run-length encoded.
+func decodeRadiance(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	w, h, err := readRadianceHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := newFloatImage(image.Rect(0, 0, w, h))
+	scan := make([]byte, 4*w)
+
+	for y := 0; y < h; y++ {
+		if err := readRadianceScanline(br, scan, w); err != nil {
+			return nil, fmt.Errorf("radiance: scanline %d: %w", y, err)
+		}
+		for x := 0; x < w; x++ {
+			e := scan[4*x+3]
+			if e == 0 {
+				continue
+			}
+			f := float32(math.Ldexp(1, int(e)-(128+8)))
+			i := 3 * (y*w + x)
+			img.Pix[i] = (float32(scan[4*x]) + 0.5) * f
+			img.Pix[i+1] = (float32(scan[4*x+1]) + 0.5) * f
+			img.Pix[i+2] = (float32(scan[4*x+2]) + 0.5) * f
+		}
+	}
+	return img, nil
+}
+
+// readRadianceScanline reads one scanline of RGBE pixels into scan.
+func readRadianceScanline(br *bufio.Reader, scan []byte, w int) error {
+	head, err := br.Peek(4)
+	if err != nil {
+		return err
+	}
+	// New-style RLE: each of the four components is run-length encoded separately
+	if w >= 8 && w < 0x8000 && head[0] == 2 && head[1] == 2 && head[2]&0x80 == 0 {
+		if int(head[2])<<8|int(head[3]) != w {
+			return fmt.Errorf("scanline width mismatch")
+		}
+		br.Discard(4)
+		for c := 0; c < 4; c++ {
+			for x := 0; x < w; {
+				n, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if n > 128 {
+					// Run of a single value
+					count := int(n) - 128
+					v, err := br.ReadByte()
+					if err != nil {
+						return err
+					}
+					if x+count > w {
+						return fmt.Errorf("run overflows scanline")
+					}
+					for ; count > 0; count-- {
+						scan[4*x+c] = v
+						x++
+					}
+				} else {
+					// Literal values
+					count := int(n)
+					if count == 0 || x+count > w {
+						return fmt.Errorf("invalid literal run")
+					}
+					for ; count > 0; count-- {
+						v, err := br.ReadByte()
+						if err != nil {
+							return err
+						}
+						scan[4*x+c] = v
+						x++
+					}
+				}
+			}
+		}
+		return nil
+	}
+	// Flat or old-style RLE, where (1,1,1,n) repeats the previous pixel
+	shift := 0
+	for x := 0; x < w; {
+		px := make([]byte, 4)
+		if _, err := io.ReadFull(br, px); err != nil {
+			return err
+		}
+		if px[0] == 1 && px[1] == 1 && px[2] == 1 {
+			if x == 0 {
+				return fmt.Errorf("repeat run at scanline start")
+			}
+			count := int(px[3]) << shift
+			if x+count > w {
+				return fmt.Errorf("run overflows scanline")
+			}
+			for ; count > 0; count-- {
+				copy(scan[4*x:4*x+4], scan[4*(x-1):4*x])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		copy(scan[4*x:4*x+4], px)
+		shift = 0
+		x++
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// localeFiles are the message catalogs, one JSON object per language mapping
+// each English message to its translation.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// localeEnv are the environment variables naming the operator's language,
+// in the order they take precedence.
+var localeEnv = []string{"GOCLASSIFYIT_LANG", "LC_ALL", "LC_MESSAGES", "LANG"}
+
+// catalog translates messages into the selected language; messages it does
+// not list are shown in English.
+var catalog map[string]string
+
+// tr returns msg in the operator's language.
+func tr(msg string) string {
+	if t, ok := catalog[msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// setLocale selects the language of CLI messages: lang when given (from
+// -lang), otherwise the first of localeEnv that is set. Tags such as "de",
+// "fr_FR.UTF-8", or "pt-BR" are accepted, falling back from a region to its
+// language. An unknown -lang is an error; an unknown environment language
+// leaves the messages in English.
+func setLocale(lang string) error {
+	explicit := lang != ""
+	for _, env := range localeEnv {
+		if lang != "" {
+			break
+		}
+		lang = os.Getenv(env)
+	}
+	tag, _, _ := strings.Cut(lang, ".") // Drop a POSIX codeset, e.g. ".UTF-8"
+	tag, _, _ = strings.Cut(tag, "@")
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	base, _, _ := strings.Cut(tag, "-")
+	if tag == "" || tag == "c" || tag == "posix" || base == "en" {
+		catalog = nil
+		return nil
+	}
+
+	for _, name := range []string{tag, base} {
+		data, err := localeFiles.ReadFile("locales/" + name + ".json")
+		if err != nil {
+			continue
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("invalid message catalog for '%s': %w", name, err)
+		}
+		catalog = messages
+		return nil
+	}
+	catalog = nil
+	if explicit {
+		return fmt.Errorf("unsupported language '%s' (options: en, %s)", lang, strings.Join(locales(), ", "))
+	}
+	return nil
+}
+
+// locales returns the languages with a message catalog.
+func locales() []string {
+	entries, _ := localeFiles.ReadDir("locales")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// formatVerb matches the fmt verbs a translation must keep, in order.
+var formatVerb = regexp.MustCompile(`%[-+#0-9.]*[a-zA-Z%]`)
+
+func TestCatalogs(t *testing.T) {
+	catalogs := map[string]map[string]string{}
+	for _, name := range locales() {
+		data, err := localeFiles.ReadFile("locales/" + name + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		catalogs[name] = messages
+	}
+	for name, messages := range catalogs {
+		for msg, translated := range messages {
+			if want, got := formatVerb.FindAllString(msg, -1), formatVerb.FindAllString(translated, -1); !slices.Equal(got, want) {
+				t.Errorf("%s: %q has verbs %v, want %v as in %q", name, translated, got, want, msg)
+			}
+			for other, otherMessages := range catalogs {
+				if _, ok := otherMessages[msg]; !ok {
+					t.Errorf("%s translates %q but %s does not", name, msg, other)
+				}
+			}
+		}
+	}
+}
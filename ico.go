@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	xdraw "golang.org/x/image/draw"
+)
+
+// iconReferenceHeight is the icon height at which markings use the full
+// -h banner height; smaller icons scale down so the default 60px banner
+// covers one eighth of each resolution.
+const iconReferenceHeight = 480
+
+// minIconFontSize is the smallest point size at which icon labels are drawn;
+// below it the banner is a plain color bar, since text would be illegible.
+const minIconFontSize = 6
+
+// icoEntry is one resolution decoded from an .ico file.
+type icoEntry struct {
+	img image.Image
+}
+
+// isICO reports whether path names a Windows icon file.
+func isICO(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".ico")
+}
+
+// processIcon marks every resolution of an .ico file and writes a new icon
+// whose entries keep their original dimensions.
+func processIcon(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	if err := requireTopAndBottom(banner, "icon"); err != nil {
+		return "", err
+	}
+	data, err := readFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	entries, err := decodeICO(data)
+	if err != nil {
+		return "", badInput(fmt.Errorf("failed to decode icon '%s': %w", imagePath, err))
+	}
+
+	var marked []image.Image
+	for _, e := range entries {
+		img, err := markIconEntry(orientImage(e.img), banner, bannerHeight, loc)
+		if err != nil {
+			return "", err
+		}
+		marked = append(marked, img)
+	}
+
+	out, err := encodeICO(marked)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode icon: %w", err)
+	}
+	outputPath := joinLocation(outputDir, filepath.Base(imagePath))
+	if err := writeOutputFile(outputPath, out); err != nil {
+		return "", err
+	}
+	if verifyOutput {
+		return outputPath, atStage("verify", verifyIconOutput(outputPath, marked))
+	}
+	return outputPath, nil
+}
+
+// markIconEntry shrinks one icon resolution to leave room for scaled banners,
+// so the marked result has the same dimensions as the original. Overlay
+// banners already keep the dimensions, so the entry is marked unshrunk.
+func markIconEntry(src image.Image, banner classify.BannerMode, bannerHeight int, loc string) (image.Image, error) {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	factor := float64(h) / iconReferenceHeight
+	scaled := scaleBanner(banner, factor)
+	scaled.Portions = nil // Regions are given for full-size images
+	bh := max(2, int(math.Round(float64(bannerHeight)*factor)))
+
+	// Geometry is resolved against the full entry, since the content is shrunk to fit
+	scaled.Geometry = scaled.Geometry.Absolute(image.Pt(w, h), bh)
+	total := bannerExtent(scaled, bh, image.Pt(w, h))
+	contentH := h - 2*total
+	if contentH < 1 {
+		return nil, fmt.Errorf("icon resolution %dx%d is too small for the banner", w, h)
+	}
+	if scaled.Overlay {
+		return renderBanner(src, scaled, bh, loc)
+	}
+
+	// Shrink uniformly and center horizontally on a transparent canvas
+	contentW := max(1, int(math.Round(float64(w)*float64(contentH)/float64(h))))
+	content := image.NewRGBA(image.Rect(0, 0, w, contentH))
+	off := (w - contentW) / 2
+	xdraw.CatmullRom.Scale(content, image.Rect(off, 0, off+contentW, contentH), src, src.Bounds(), xdraw.Src, nil)
+
+	return renderBanner(content, scaled, bh, loc)
+}
+
+// scaleBanner returns a copy of banner with font sizes, row heights, and line
+// widths multiplied by factor.
+func scaleBanner(banner classify.BannerMode, factor float64) classify.BannerMode {
+	size := banner.FontSize
+	if size <= 0 {
+		size = classify.DefaultFontSize
+	}
+	banner.FontSize = size * factor
+	if banner.FontSize < minIconFontSize {
+		banner.Text = ""
+	}
+	if banner.SeparatorWidth > 0 {
+		banner.SeparatorWidth = max(1, int(math.Round(float64(banner.SeparatorWidth)*factor)))
+	}
+	if banner.PatternWidth > 0 {
+		banner.PatternWidth = max(2, int(math.Round(float64(banner.PatternWidth)*factor)))
+	}
+	rows := make([]classify.BannerRow, len(banner.Rows))
+	for i, row := range banner.Rows {
+		row.FontSize *= factor
+		row.Height = max(1, int(math.Round(float64(row.Height)*factor)))
+		if row.FontSize < minIconFontSize {
+			row.Text = ""
+		}
+		rows[i] = row
+	}
+	banner.Rows = rows
+	banner.Geometry = banner.Geometry.Scale(factor)
+	return banner
+}
+
+// decodeICO parses the icon directory and decodes every PNG or BMP entry.
+func decodeICO(data []byte) ([]icoEntry, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint16(data) != 0 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, fmt.Errorf("not an icon file")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+16*count {
+		return nil, fmt.Errorf("truncated icon directory")
+	}
+
+	var entries []icoEntry
+	for i := 0; i < count; i++ {
+		d := data[6+16*i:]
+		size := int(binary.LittleEndian.Uint32(d[8:]))
+		off := int(binary.LittleEndian.Uint32(d[12:]))
+		if off < 0 || size <= 0 || off+size > len(data) {
+			return nil, fmt.Errorf("icon entry %d is out of range", i)
+		}
+		blob := data[off : off+size]
+
+		var img image.Image
+		var err error
+		if bytes.HasPrefix(blob, []byte("\x89PNG")) {
+			img, err = png.Decode(bytes.NewReader(blob))
+		} else {
+			img, err = decodeDIB(blob)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("icon entry %d: %w", i, err)
+		}
+		entries = append(entries, icoEntry{img: img})
+	}
+	return entries, nil
+}
+
+// decodeDIB decodes an icon's BMP payload: a BITMAPINFOHEADER, optional
+// palette, XOR color bitmap, and 1-bit AND transparency mask.
+func decodeDIB(b []byte) (image.Image, error) {
+	if len(b) < 40 {
+		return nil, fmt.Errorf("truncated bitmap header")
+	}
+	hdrSize := int(binary.LittleEndian.Uint32(b))
+	w := int(int32(binary.LittleEndian.Uint32(b[4:])))
+	h := int(int32(binary.LittleEndian.Uint32(b[8:]))) / 2 // Height covers XOR and AND bitmaps
+	bpp := int(binary.LittleEndian.Uint16(b[14:]))
+	compression := binary.LittleEndian.Uint32(b[16:])
+	colors := int(binary.LittleEndian.Uint32(b[32:]))
+	if w <= 0 || h <= 0 || compression != 0 {
+		return nil, fmt.Errorf("unsupported bitmap (%dx%d, compression %d)", w, h, compression)
+	}
+
+	pos := hdrSize
+	var palette []color.RGBA
+	if bpp <= 8 {
+		if colors == 0 {
+			colors = 1 << bpp
+		}
+		if pos+4*colors > len(b) {
+			return nil, fmt.Errorf("truncated palette")
+		}
+		for i := 0; i < colors; i++ {
+			p := b[pos+4*i:]
+			palette = append(palette, color.RGBA{p[2], p[1], p[0], 255})
+		}
+		pos += 4 * colors
+	}
+
+	stride := ((w*bpp + 31) / 32) * 4
+	maskStride := ((w + 31) / 32) * 4
+	if pos+stride*h > len(b) {
+		return nil, fmt.Errorf("truncated bitmap data")
+	}
+	xor := b[pos : pos+stride*h]
+	var and []byte
+	if pos+stride*h+maskStride*h <= len(b) {
+		and = b[pos+stride*h : pos+stride*h+maskStride*h]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		row := xor[(h-1-y)*stride:] // Rows are stored bottom-up
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch bpp {
+			case 32:
+				p := row[4*x:]
+				c = color.NRGBA{p[2], p[1], p[0], p[3]}
+			case 24:
+				p := row[3*x:]
+				c = color.NRGBA{p[2], p[1], p[0], 255}
+			case 8, 4, 1:
+				idx := int(row[x*bpp/8]>>(8-bpp-(x*bpp)%8)) & (1<<bpp - 1)
+				if idx >= len(palette) {
+					return nil, fmt.Errorf("palette index out of range")
+				}
+				pc := palette[idx]
+				c = color.NRGBA{pc.R, pc.G, pc.B, 255}
+			default:
+				return nil, fmt.Errorf("unsupported bit depth %d", bpp)
+			}
+			// Without an alpha channel the AND mask marks transparent pixels
+			if bpp != 32 && and != nil {
+				m := and[(h-1-y)*maskStride+x/8]
+				if m&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// encodeICO writes images as an icon file with PNG-compressed entries.
+func encodeICO(imgs []image.Image) ([]byte, error) {
+	var blobs [][]byte
+	for _, img := range imgs {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, "png"); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, buf.Bytes())
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, []uint16{0, 1, uint16(len(imgs))})
+	off := 6 + 16*len(imgs)
+	for i, img := range imgs {
+		// Dimensions of 256 or more are stored as 0
+		dim := func(v int) byte {
+			if v >= 256 {
+				return 0
+			}
+			return byte(v)
+		}
+		b := img.Bounds()
+		out.Write([]byte{dim(b.Dx()), dim(b.Dy()), 0, 0})
+		binary.Write(&out, binary.LittleEndian, []uint16{1, 32})
+		binary.Write(&out, binary.LittleEndian, []uint32{uint32(len(blobs[i])), uint32(off)})
+		off += len(blobs[i])
+	}
+	for _, blob := range blobs {
+		out.Write(blob)
+	}
+	return out.Bytes(), nil
+}
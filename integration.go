@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// integration describes a desktop entry that runs the tool with a preset.
+type integration struct {
+	Label  string   // Menu label, e.g. "Classify as SECRET"
+	Exe    string   // Absolute path of this executable
+	Args   []string // Flags placed before the selected paths
+	Remove bool     // Uninstall instead of install
+}
+
+// runInstallIntegration parses the install-integration flags and installs
+// (or removes) the platform's file manager entry.
+func runInstallIntegration(args []string) error {
+	fs := flag.NewFlagSet("install-integration", flag.ExitOnError)
+	classFlag := fs.String("c", "", "Classification preset invoked by the entry: 'unclassed', 'cui', 'confidential', 'secret', or 'topsecret'")
+	outputFlag := fs.String("o", "", "Output directory for classified images (default: ~/goclassifyit_output)")
+	labelFlag := fs.String("label", "", "Menu label (default: \"Classify as <TEXT>\")")
+	extraFlag := fs.String("args", "", "Extra classification flags passed to the tool, e.g. \"-l corners -h 80\"")
+	removeFlag := fs.Bool("uninstall", false, "Remove the entry instead of installing it")
+	fs.Parse(args)
+
+	banner, ok := classify.Presets[*classFlag]
+	if !ok || *classFlag == "custom" {
+		return fmt.Errorf("install-integration needs -c with one of: unclassed, cui, confidential, secret, topsecret")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to locate executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("unable to locate executable: %w", err)
+	}
+
+	output := *outputFlag
+	if output == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("unable to determine home directory: %w", err)
+		}
+		output = filepath.Join(home, "goclassifyit_output")
+	}
+	if output, err = filepath.Abs(output); err != nil {
+		return err
+	}
+
+	in := integration{
+		Label:  *labelFlag,
+		Exe:    exe,
+		Args:   append([]string{"-c", *classFlag, "-o", output}, strings.Fields(*extraFlag)...),
+		Remove: *removeFlag,
+	}
+	if in.Label == "" {
+		in.Label = "Classify as " + banner.Text
+	}
+	if err := installIntegration(in); err != nil {
+		return err
+	}
+	if in.Remove {
+		fmt.Printf(tr("Removed \"%s\".\n"), in.Label)
+	} else {
+		fmt.Printf(tr("Installed \"%s\" (output: %s).\n"), in.Label, output)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package main
+
+import "fmt"
+
+// installIntegration is only implemented for Windows Explorer and macOS Finder.
+func installIntegration(in integration) error {
+	return fmt.Errorf("install-integration supports Windows and macOS only; pass paths as arguments instead")
+}
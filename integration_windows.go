@@ -0,0 +1,71 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// installIntegration adds a "Send To" entry (which receives every selected
+// item at once) and an Explorer context-menu verb for image files.
+func installIntegration(in integration) error {
+	appData := os.Getenv("APPDATA")
+	if appData == "" {
+		return fmt.Errorf("APPDATA is not set")
+	}
+	sendTo := filepath.Join(appData, "Microsoft", "Windows", "SendTo", in.Label+".cmd")
+	keyPath := `Software\Classes\SystemFileAssociations\image\shell\` + registryVerb(in.Label)
+
+	if in.Remove {
+		if err := os.Remove(sendTo); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove Send To entry: %w", err)
+		}
+		registry.DeleteKey(registry.CURRENT_USER, keyPath+`\command`)
+		registry.DeleteKey(registry.CURRENT_USER, keyPath)
+		return nil
+	}
+
+	// %* forwards every selected path, already quoted by Explorer
+	script := fmt.Sprintf("@echo off\r\n%s %%*\r\npause\r\n", windowsCommand(in))
+	if err := os.WriteFile(sendTo, []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write Send To entry: %w", err)
+	}
+
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, keyPath, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to create context menu entry: %w", err)
+	}
+	defer key.Close()
+	if err := key.SetStringValue("", in.Label); err != nil {
+		return fmt.Errorf("failed to create context menu entry: %w", err)
+	}
+	cmd, _, err := registry.CreateKey(key, "command", registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to create context menu command: %w", err)
+	}
+	defer cmd.Close()
+	// The context menu passes one file per invocation as %1
+	return cmd.SetStringValue("", windowsCommand(in)+` "%1"`)
+}
+
+// windowsCommand quotes the executable and its flags for cmd.exe.
+func windowsCommand(in integration) string {
+	parts := []string{`"` + in.Exe + `"`}
+	for _, a := range in.Args {
+		if strings.ContainsAny(a, " \t") {
+			a = `"` + a + `"`
+		}
+		parts = append(parts, a)
+	}
+	return strings.Join(parts, " ")
+}
+
+// registryVerb turns a label into a shell verb key name.
+func registryVerb(label string) string {
+	return "goclassifyit." + strings.NewReplacer(" ", "", `\`, "", "/", "").Replace(label)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables read by job mode. Each one overrides the matching
+// field of the mounted job spec.
+const (
+	jobSpecEnv     = "GOCLASSIFYIT_JOB_SPEC"       // Path of the mounted job spec
+	jobInputEnv    = "GOCLASSIFYIT_INPUT"          // Input file or directory
+	jobOutputEnv   = "GOCLASSIFYIT_OUTPUT"         // Output directory
+	jobClassEnv    = "GOCLASSIFYIT_CLASSIFICATION" // Classification preset
+	jobTextEnv     = "GOCLASSIFYIT_TEXT"           // Banner text
+	jobOperatorEnv = "GOCLASSIFYIT_OPERATOR"       // Operator identity
+	jobShardsEnv   = "GOCLASSIFYIT_SHARDS"         // Number of shards of an Indexed Job
+	jobWorkersEnv  = "GOCLASSIFYIT_WORKERS"        // Images processed in parallel
+	jobHealthEnv   = "GOCLASSIFYIT_HEALTH_ADDR"    // Address of the probe endpoints, or "off"
+	jobRunIDEnv    = "GOCLASSIFYIT_RUN_ID"         // Run ID shared by the pods of one Job
+
+	// jobIndexEnv is set by Kubernetes on the pods of an Indexed Job.
+	jobIndexEnv = "JOB_COMPLETION_INDEX"
+)
+
+// defaultJobSpec is where the job spec is read from when jobSpecEnv is unset.
+const defaultJobSpec = "/etc/goclassifyit/job.yaml"
+
+// defaultHealthAddr serves /healthz and /readyz for the kubelet probes.
+const defaultHealthAddr = ":8080"
+
+// jobSpec is the mounted job definition. Banner fields use the same names as
+// per-image sidecar files.
+type jobSpec struct {
+	Input         string `yaml:"input"`
+	Output        string `yaml:"output"`
+	Operator      string `yaml:"operator"`
+	Shards        int    `yaml:"shards"`
+	Workers       int    `yaml:"workers"`
+	sidecarConfig `yaml:",inline"`
+
+	// Notify lists -notify targets told when the batch finishes, or after
+	// NotifyFailures failures when that is set.
+	Notify         []string `yaml:"notify"`
+	NotifyFailures int      `yaml:"notify_failures"`
+}
+
+// runJob runs one batch configured entirely by environment variables and the
+// mounted job spec, for use as a Kubernetes Job or CronJob. It exits non-zero
+// when any image fails so the Job is retried or marked failed.
+func runJob() error {
+	var ready atomic.Bool
+	if addr := envOr(jobHealthEnv, defaultHealthAddr); addr != "off" {
+		serveProbes(addr, &ready)
+	}
+
+	spec, err := loadJobSpec()
+	if err != nil {
+		return err
+	}
+	if spec.Input == "" || spec.Output == "" || spec.Classification == "" {
+		return fmt.Errorf("job needs input, output, and classification (spec fields or %s, %s, %s)", jobInputEnv, jobOutputEnv, jobClassEnv)
+	}
+
+	banner, ok := classify.Presets[spec.Classification]
+	if !ok {
+		return fmt.Errorf("invalid classification mode '%s'", spec.Classification)
+	}
+	banner.Pattern, banner.PatternWidth, banner.Style, banner.TextAlign = "solid", 20, "strip", "center"
+	banner, bannerHeight, loc, err := spec.apply("job spec", banner, 60, "center")
+	if err != nil {
+		return err
+	}
+
+	operator = resolveOperator(spec.Operator)
+	runID = envOr(jobRunIDEnv, runID)
+	if err := loadPolicies(""); err != nil {
+		return err
+	}
+	if err := checkPolicy(banner); err != nil {
+		return fmt.Errorf("policy violation: %w", err)
+	}
+	toneMapping = toneMapOptions{Operator: "reinhard", Output: "png"}
+
+	// Pods usually have a CPU limit below the node's core count, so the
+	// worker count can be set to match it
+	if spec.Workers > 0 {
+		workers = spec.Workers
+	}
+
+	// Pods of an Indexed Job each take the shard matching their completion index
+	if spec.Shards > 1 {
+		index, err := strconv.Atoi(os.Getenv(jobIndexEnv))
+		if err != nil {
+			return fmt.Errorf("job has %d shards but %s is not set; run it as an Indexed Job", spec.Shards, jobIndexEnv)
+		}
+		if shard, err = parseShard(fmt.Sprintf("%d/%d", index, spec.Shards)); err != nil {
+			return err
+		}
+	}
+
+	if !isRemote(spec.Output) {
+		if err := os.MkdirAll(spec.Output, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	ready.Store(true)
+
+	if err := startNotifiers(spec.Notify, spec.NotifyFailures, banner.Text); err != nil {
+		return err
+	}
+	defer notifyCompletion()
+
+	fmt.Printf(tr("Job: %s -> %s as %s (operator: %s, run: %s)\n"), spec.Input, spec.Output, banner.Text, operator, runID)
+	return processPaths([]string{spec.Input}, banner, spec.Output, bannerHeight, loc)
+}
+
+// loadJobSpec reads the mounted spec, if present, and applies the
+// environment overrides. A missing spec is only an error when its path was
+// given explicitly.
+func loadJobSpec() (jobSpec, error) {
+	var spec jobSpec
+	path, explicit := os.LookupEnv(jobSpecEnv)
+	if !explicit {
+		path = defaultJobSpec
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+	case err != nil:
+		return spec, fmt.Errorf("failed to read job spec '%s': %w", path, err)
+	default:
+		if err := yaml.Unmarshal(data, &spec); err != nil {
+			return spec, fmt.Errorf("failed to parse job spec '%s': %w", path, err)
+		}
+	}
+
+	spec.Input = envOr(jobInputEnv, spec.Input)
+	spec.Output = envOr(jobOutputEnv, spec.Output)
+	spec.Classification = envOr(jobClassEnv, spec.Classification)
+	spec.Text = envOr(jobTextEnv, spec.Text)
+	spec.Operator = envOr(jobOperatorEnv, spec.Operator)
+	if v := os.Getenv(jobShardsEnv); v != "" {
+		if spec.Shards, err = strconv.Atoi(v); err != nil {
+			return spec, fmt.Errorf("invalid %s '%s'", jobShardsEnv, v)
+		}
+	}
+	if v := os.Getenv(jobWorkersEnv); v != "" {
+		if spec.Workers, err = strconv.Atoi(v); err != nil || spec.Workers < 1 {
+			return spec, fmt.Errorf("invalid %s '%s'", jobWorkersEnv, v)
+		}
+	}
+	return spec, nil
+}
+
+// serveProbes starts the liveness (/healthz) and readiness (/readyz)
+// endpoints. Readiness turns on once the job is configured and its output is
+// writable.
+func serveProbes(addr string, ready *atomic.Bool) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Println(tr("Error serving health probes:"), err)
+		}
+	}()
+}
+
+// envOr returns the value of the environment variable name, or def when it
+// is unset or empty.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
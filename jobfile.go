@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jobFileAliases maps descriptive job file keys to the short flags they set.
+// Every other key is a flag name, with underscores accepted for dashes.
+var jobFileAliases = map[string]string{
+	"classification": "c",
+	"output":         "o",
+	"banner_height":  "h",
+	"location":       "l",
+	"file":           "f",
+	"directory":      "d",
+}
+
+// applyJobFile sets flags from a YAML job file describing a full run, so
+// complex invocations can be kept under version control. Flags given on the
+// command line take precedence. The "inputs" key lists files and directories
+// to process, and is returned.
+func applyJobFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job file '%s': %w", path, err)
+	}
+	var job map[string]any
+	if err := yaml.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse job file '%s': %w", path, err)
+	}
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var inputs []string
+	for key, value := range job {
+		if key == "inputs" {
+			if inputs, err = jobFileStrings(value); err != nil {
+				return nil, fmt.Errorf("job file '%s': inputs: %w", path, err)
+			}
+			continue
+		}
+
+		f := settingFlag(key)
+		if f == nil || f.Name == "job" {
+			return nil, fmt.Errorf("job file '%s': unknown option '%s'", path, key)
+		}
+		if explicit[f.Name] {
+			continue
+		}
+		if err := setFlagValue(f, value); err != nil {
+			return nil, fmt.Errorf("job file '%s': %s: %w", path, key, err)
+		}
+	}
+	return inputs, nil
+}
+
+// settingFlag returns the flag a job file or profile key sets, or nil.
+func settingFlag(key string) *flag.Flag {
+	name := strings.ReplaceAll(key, "_", "-")
+	if alias, ok := jobFileAliases[key]; ok {
+		name = alias
+	}
+	return flag.Lookup(name)
+}
+
+// setFlagValue sets f from a job file or profile value.
+func setFlagValue(f *flag.Flag, value any) error {
+	values, err := jobFileStrings(value)
+	if err != nil {
+		return err
+	}
+	// Repeatable flags take each list item; others take a comma-separated list
+	if _, repeatable := f.Value.(*rowFlag); !repeatable {
+		values = []string{strings.Join(values, ",")}
+	}
+	for _, v := range values {
+		if err := flag.Set(f.Name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobFileStrings converts a scalar or a list of scalars to strings.
+func jobFileStrings(value any) ([]string, error) {
+	switch v := value.(type) {
+	case []any:
+		var out []string
+		for _, item := range v {
+			s, err := jobFileStrings(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s...)
+		}
+		return out, nil
+	case map[string]any:
+		return nil, fmt.Errorf("expected a value or a list, not a mapping")
+	case nil:
+		return []string{""}, nil
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
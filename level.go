@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is a classification level. Levels are ordered by sensitivity, so they
+// compare with the usual operators: LevelUnclassified < LevelCUI <
+// LevelConfidential < LevelSecret < LevelTopSecret.
+type Level int
+
+const (
+	LevelUnclassified Level = iota
+	LevelCUI
+	LevelConfidential
+	LevelSecret
+	LevelTopSecret
+)
+
+// levelNames are the canonical marking text of each level.
+var levelNames = [...]string{
+	LevelUnclassified: "UNCLASSIFIED",
+	LevelCUI:          "CUI",
+	LevelConfidential: "CONFIDENTIAL",
+	LevelSecret:       "SECRET",
+	LevelTopSecret:    "TOP SECRET",
+}
+
+// levelAliases are accepted spellings besides the canonical names.
+var levelAliases = map[string]Level{
+	"U":         LevelUnclassified,
+	"UNCLASSED": LevelUnclassified,
+	"C":         LevelConfidential,
+	"S":         LevelSecret,
+	"TS":        LevelTopSecret,
+	"TOPSECRET": LevelTopSecret,
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Exceeds reports whether l is more sensitive than o.
+func (l Level) Exceeds(o Level) bool { return l > o }
+
+// Dominates reports whether l is at least as sensitive as o, i.e. a holder
+// cleared for l may see material marked o.
+func (l Level) Dominates(o Level) bool { return l >= o }
+
+// CompareLevels returns -1, 0, or +1 as a is less than, equal to, or more
+// sensitive than b.
+func CompareLevels(a, b Level) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// MaxLevel returns the most sensitive of levels, or LevelUnclassified when
+// there are none.
+func MaxLevel(levels ...Level) Level {
+	m := LevelUnclassified
+	for _, l := range levels {
+		m = max(m, l)
+	}
+	return m
+}
+
+// ParseLevel parses a level name such as "SECRET", "top secret", or "TS",
+// ignoring case and surrounding space.
+func ParseLevel(s string) (Level, error) {
+	name := strings.Join(strings.Fields(strings.ToUpper(s)), " ")
+	for l, n := range levelNames {
+		if name == n {
+			return Level(l), nil
+		}
+	}
+	if l, ok := levelAliases[name]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("unknown classification level '%s'", s)
+}
+
+// MarkingLevel returns the level of banner text such as
+// "SECRET//NOFORN", or false when the text does not start with a known level.
+func MarkingLevel(text string) (Level, bool) {
+	name, _ := splitMarking(text)
+	l, err := ParseLevel(name)
+	return l, err == nil
+}
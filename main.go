@@ -1,87 +1,332 @@
 package main
 
 import (
-	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
-	"image/draw"
-	"image/jpeg"
-	"image/png"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
-	"golang.org/x/image/font"
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
 	"golang.org/x/image/font/opentype"
-	"golang.org/x/image/math/fixed"
 )
 
-//go:embed fonts/DejaVuSans-Bold.ttf
-var fontData embed.FS
+// bannerFont is the -font banner font, or nil for the embedded one.
+var bannerFont *opentype.Font
 
-// BannerMode defines the banner properties: background color, text color, and text content.
-type BannerMode struct {
-	BgColor   color.RGBA // Background color of the banner
-	TextColor color.RGBA // Text color of the banner
-	Text      string     // Banner label text
-}
+// bannerFontSpec is the -font file or family the banner font was loaded from.
+var bannerFontSpec string
+
+// fallbackFonts are consulted in order for glyphs missing from the banner font.
+var fallbackFonts []*opentype.Font
+
+// fallbackFontSpec is the -font-fallback list the fonts were loaded from.
+var fallbackFontSpec string
+
+// rowFlag collects the values of a repeatable flag such as -row.
+type rowFlag []string
+
+func (r *rowFlag) String() string { return strings.Join(*r, ", ") }
 
-// Predefined classification banner modes with specific colors and text labels.
-var bannerModes = map[string]BannerMode{
-	"cui":       {BgColor: color.RGBA{0, 255, 0, 255}, TextColor: color.RGBA{0, 0, 0, 255}, Text: "CUI"},
-	"secret":    {BgColor: color.RGBA{255, 0, 0, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Text: "SECRET"},
-	"unclassed": {BgColor: color.RGBA{0, 0, 0, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Text: "UNCLASSIFIED"},
-	"custom":    {BgColor: color.RGBA{255, 255, 255, 255}, TextColor: color.RGBA{0, 0, 0, 255}, Text: "CUSTOM"},
+func (r *rowFlag) Set(v string) error {
+	*r = append(*r, v)
+	return nil
 }
 
 func main() {
+	// Messages follow the operator's language from the environment until -lang is read
+	setLocale("")
+
+	// Subcommands are handled before the classification flags
+	if len(os.Args) > 1 {
+		var run func() error
+		switch os.Args[1] {
+		case "install-integration":
+			run = func() error { return runInstallIntegration(os.Args[2:]) }
+		case "job":
+			run = runJob
+		case "review":
+			run = func() error { return runReview(os.Args[2:]) }
+		case "serve":
+			run = func() error { return runServe(os.Args[2:]) }
+		}
+		if run != nil {
+			if err := run(); err != nil {
+				fmt.Println(tr("Error:"), err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Define command-line flags
 	dirFlag := flag.String("d", "", "Directory containing images to classify")
 	fileFlag := flag.String("f", "", "Single image file to classify")
-	classFlag := flag.String("c", "", "Classification type: 'unclassed', 'cui', or 'secret'")
+	workersFlag := flag.Int("workers", runtime.NumCPU(), "Number of images processed in parallel in directory mode (default: number of CPUs)")
+	ioRateFlag := flag.String("io-rate", "", "Cap image reads and writes at this rate across all workers, e.g. '50MB/s' (default: unlimited)")
+	maxOpenFlag := flag.Int("max-open-files", 0, "Most image files open at once across all workers (default: 0, unlimited)")
+	lowPriorityFlag := flag.Bool("low-priority", false, "Run at lowered CPU priority with a quarter of the CPUs as workers, for background daemons")
+	recursiveFlag := flag.Bool("r", false, "Also classify images in subdirectories, recreating the directory tree under the output directory")
+	bundleFlag := flag.String("bundle", "", "HTML/Markdown bundle directory whose referenced images are classified")
+	classFlag := flag.String("c", "", "Classification type: 'unclassed', 'cui', 'confidential', 'secret', or 'topsecret'")
 	outputFlag := flag.String("o", "goclassifyit_output", "Output directory for classified images")
 	bannerHeightFlag := flag.Int("h", 60, "Banner height in pixels (default: 60)")
 	locFlag := flag.String("l", "center", "Location of banner text: 'center' (default) or 'corners'")
 	textFlag := flag.String("text", "", "Custom text for banner")
+	cuiCategoriesFlag := flag.String("cui-categories", "", "Comma-separated CUI category markings for -c cui, e.g. 'SP-PRVCY,CTI'")
+	cuiDissemFlag := flag.String("cui-dissem", "", "Slash-separated CUI limited dissemination controls for -c cui, e.g. 'NOFORN' or 'FEDCON/REL TO USA, GBR'")
+	sciFlag := flag.String("sci", "", "Comma-separated SCI control systems added to the banner line, e.g. 'SI-G,TK'")
+	sapFlag := flag.String("sap", "", "Comma-separated special access program identifiers added to the banner line, e.g. 'BP,GB'")
+	fgiFlag := flag.String("fgi", "", "Countries of foreign government information in the banner line, e.g. 'DEU GBR', or 'FGI' to not name them")
+	dissemFlag := flag.String("dissem", "", "Slash-separated dissemination controls added to the banner line, e.g. 'NOFORN' or 'ORCON/REL TO USA, FVEY'")
 	bgColorFlag := flag.String("background-color", "255,0,0", "Comma-separated R,G,B for background color (default: 255,0,0)")
-	txtColorFlag := flag.String("text-color", "255,255,255", "Comma-separated R,G,B for text color (default: 255,255,255)")
+	fontSizeFlag := flag.Float64("font-size", classify.DefaultFontSize, "Font size in points of the classification row (default: 36)")
+	autoFitFlag := flag.Float64("autofit", 0, "Scale banner text to fill this percentage of each row's height, shrinking it to fit the width (default: 0, off)")
+	overflowFlag := flag.String("overflow", "", "Banner text too wide for its row: 'wrap', 'shrink', 'truncate', or 'error' (default: drawn as is, or error with -autofit)")
+	txtColorFlag := flag.String("text-color", "255,255,255", "Comma-separated R,G,B for text color, or 'auto' for black or white by background luminance (default: 255,255,255)")
+	eventLogFlag := flag.Bool("eventlog", false, "Write processing and error events to the Windows Event Log (Windows only)")
+	eventSourceFlag := flag.String("eventlog-source", "goclassifyit", "Windows Event Log source name used with -eventlog")
+	pprofFlag := flag.String("pprof", "", "Serve pprof profiling endpoints on this address (e.g. :6060)")
+	cpuProfileFlag := flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfileFlag := flag.String("memprofile", "", "Write a heap profile to this file on exit")
+	deterministicFlag := flag.Bool("deterministic", false, "Produce byte-identical output for identical inputs and options")
+	operatorFlag := flag.String("operator", "", "Operator identity to record (default: current OS user)")
+	runIDFlag := flag.String("run-id", "", "Identifier recorded for this run (default: a random UUID)")
+	langFlag := flag.String("lang", "", "Language of messages, e.g. 'de' or 'fr' (default: from GOCLASSIFYIT_LANG, LC_ALL, LC_MESSAGES, or LANG)")
+	patternFlag := flag.String("pattern", "solid", "Banner background pattern: 'solid' (default), 'diagonal', 'stripes', or 'hatch'")
+	patternColorFlag := flag.String("pattern-color", "0,0,0", "Comma-separated R,G,B for the second pattern color (default: 0,0,0)")
+	patternWidthFlag := flag.Int("pattern-width", 20, "Stripe width in pixels for patterned banners (default: 20)")
+	var rowFlags rowFlag
+	flag.Var(&rowFlags, "row", "Extra stacked banner row as \"TEXT|R,G,B|R,G,B|SIZE|HEIGHT\" (repeatable)")
+	var portionFlags rowFlag
+	flag.Var(&portionFlags, "portion", "Image region marked with its own label as \"X,Y,W,H|LABEL|R,G,B|R,G,B\" (repeatable)")
+	rowsFileFlag := flag.String("rows-file", "", "JSON file defining extra stacked banner rows")
+	sepColorFlag := flag.String("separator-color", "0,0,0", "Comma-separated R,G,B for the banner/image separator line (default: 0,0,0)")
+	canvasColorFlag := flag.String("canvas-color", "255,255,255", "Comma-separated R,G,B for added area no banner covers, beside a placed -banner or around pills (default: 255,255,255)")
+	alignFlag := flag.String("text-align", "center", "Horizontal text alignment for center mode: 'left', 'center' (default), or 'right'")
+	sidesFlag := flag.String("sides", "top,bottom", "Comma-separated edges to draw banners on: 'top', 'bottom', 'left', 'right'; text on left and right banners runs vertically (default: top,bottom)")
+	overlayFlag := flag.Bool("overlay", false, "Draw semi-transparent banners over the image's edges instead of extending it")
+	alphaFlag := flag.String("alpha", "preserve", "Handling of transparent inputs: 'preserve' (default), 'flatten' onto -matte, or 'warn'")
+	matteFlag := flag.String("matte", "255,255,255", "Comma-separated R,G,B that transparent inputs are flattened onto with -alpha flatten, and fully transparent ones always (default: 255,255,255)")
+	pageNumbersFlag := flag.String("page-numbers", "", "Number each page of PDF and TIFF outputs 'Page X of Y' in a corner: 'top-left', 'top-right', 'bottom-left', or 'bottom-right'")
+	convertFlag := flag.String("convert", "", "Write WebP and AVIF inputs as 'png' or 'jpeg' instead of their own format (which needs cwebp or avifenc)")
+	phashFlag := flag.Bool("phash", false, "Report a perceptual hash of each image without its banners, for matching classified and unclassified copies")
+	annotationsFlag := flag.String("annotations", "", "Write a 'coco' or 'voc' annotation of the banner and label regions next to each image output")
+	opacityFlag := flag.Float64("overlay-opacity", classify.DefaultOverlayOpacity, "Banner fill opacity from 0 to 1 with -overlay (default: 0.8)")
+	watermarkFlag := flag.Bool("watermark", false, "Tile semi-transparent classification text diagonally across the image, as well as the banners")
+	watermarkOnlyFlag := flag.Bool("watermark-only", false, "Tile the watermark across the image instead of drawing banners")
+	watermarkAngleFlag := flag.Float64("watermark-angle", 45, "Degrees counterclockwise from horizontal that watermark text rises at (default: 45)")
+	watermarkOpacityFlag := flag.Float64("watermark-opacity", classify.DefaultWatermarkOpacity, "Watermark text opacity from 0 to 1 (default: 0.2)")
+	watermarkSpacingFlag := flag.Int("watermark-spacing", classify.DefaultWatermarkSpacing, "Gap in pixels between repeats of the watermark text (default: 120)")
+	toneMapFlag := flag.String("tonemap", "reinhard", "Tone mapping for HDR/EXR inputs: 'reinhard' (default), 'aces', or 'clamp'")
+	exposureFlag := flag.Float64("exposure", 0, "Exposure adjustment in stops applied to HDR/EXR inputs (default: 0)")
+	hdrOutputFlag := flag.String("hdr-output", "png", "Output format for HDR/EXR inputs: 'png' (default) or 'jpeg'")
+	colorSpaceFlag := flag.String("colorspace", "preserve", "Color handling for inputs with embedded ICC profiles: 'preserve' (default) or 'srgb'")
+	rotateFlag := flag.Int("rotate", 0, "Turn images clockwise by 90, 180, or 270 degrees before marking, e.g. for sideways scans")
+	flipFlag := flag.String("flip", "", "Mirror images before marking, after -rotate: 'h' (left to right) or 'v' (top to bottom)")
+	autoCropFlag := flag.Bool("auto-crop", false, "Trim uniform white or black scanner borders from images before marking")
+	cropToleranceFlag := flag.Int("crop-tolerance", defaultCropTolerance, "How far from 0 to 255 each channel of a border pixel may be from pure white or black with -auto-crop (default: 32)")
+	rawConverterFlag := flag.String("raw-converter", "", "Path to dcraw or dcraw_emu for camera RAW inputs (default: search PATH)")
+	fontFlag := flag.String("font", "", "TTF/OTF file or installed font family for banner text (default: embedded DejaVu Sans Bold)")
+	fontFallbackFlag := flag.String("font-fallback", "", "Comma-separated TTF/OTF fonts used, in order, for glyphs missing from the banner font")
+	styleFlag := flag.String("style", "strip", "Banner style: 'strip' (default, full-width) or 'pill' (rounded label)")
+	verifyFlag := flag.Bool("verify-output", false, "Re-read each output after writing and check its dimensions, banners, and markers")
+	strictLayoutFlag := flag.Bool("strict-layout", false, "Fail images whose banner or portion labels would be clipped, instead of warning")
+	embedMetadataFlag := flag.Bool("embed-metadata", false, "Record the classification in the Exif/XMP or PNG text metadata of PNG and JPEG outputs")
+	preserveMetadataFlag := flag.Bool("preserve-metadata", false, "Copy the Exif, XMP, ICC profile, and DPI of PNG and JPEG inputs into their outputs")
+	qualityFlag := flag.Int("quality", defaultJPEGQuality, "JPEG and WebP output quality from 1 to 100 (default: 75)")
+	pngCompressionFlag := flag.String("png-compression", "default", "PNG output compression: 'default', 'none', 'fast', or 'best'")
+	policyFlag := flag.String("policy", "", "Policy file restricting allowed markings (in addition to the system policy)")
+	quarantineFlag := flag.String("quarantine-dir", "", "Move undecodable inputs here with a JSON explanation; see the review subcommand")
+	jobFileFlag := flag.String("job", "", "YAML job file describing the run; command-line flags override it")
+	configFlag := flag.String("config", "", "YAML or JSON file of named banner profiles, selected with -c NAME; command-line flags override them")
+	outputModeFlag := flag.String("output-mode", "", "Octal mode and optional group for outputs, e.g. 0640 or 0640:share")
+	var levelModeFlags rowFlag
+	flag.Var(&levelModeFlags, "level-output-mode", "Per-level output mode as \"LEVEL=MODE[:GROUP]\", e.g. \"SECRET=0600:secret\" (repeatable)")
+	cacheFlag := flag.String("cache", "", "Reuse outputs of unchanged inputs from a cache directory or redis://host:port/db URL")
+	shardFlag := flag.String("shard", "", "Process only shard K of N (\"K/N\") of a directory, by a hash of each file's path")
+	newerThanFlag := flag.String("newer-than", "", "In directory mode, only classify files modified within this long, e.g. '24h' or '7d'")
+	modifiedAfterFlag := flag.String("modified-after", "", "In directory mode, only classify files modified at or after this date, e.g. '2024-01-01'")
+	minDimensionFlag := flag.Int("min-dimension", 0, "Skip images whose width or height is below this many pixels (0: off)")
+	tuiFlag := flag.Bool("tui", false, "Show a live status table for directory mode, with keys to pause, retry failures, or abort")
+	watchFlag := flag.Bool("watch", false, "With -d, keep running and classify files as they arrive in the directory")
+	watchSettleFlag := flag.Duration("watch-settle", 2*time.Second, "With -watch, how long a file must go unchanged before it is classified (default: 2s)")
+	watchAttemptsFlag := flag.Int("watch-attempts", 3, "With -watch, how many times a failing file is tried before it is moved to failed/ (default: 3)")
+	watchLogFlag := flag.String("watch-log", "", "With -watch, append a JSON line for each processed file to this file")
+	scheduleFlag := flag.String("schedule", "", "With -d, stay running and classify the directory on this cron schedule, e.g. '0 2 * * *'")
+	geometryFlag := flag.String("banner", "", "Banner geometry as an ImageMagick-style WxH+X+Y string; parts may be percentages (e.g. 50%x8%+25%+0)")
+	sepWidthFlag := flag.Int("separator-width", 0, "Thickness in pixels of the banner/image separator line (default: 0, disabled)")
+	var notifyFlags rowFlag
+	flag.Var(&notifyFlags, "notify", "Send the run summary to a webhook, Slack or Teams webhook URL, or smtp:// address (repeatable)")
+	notifyFailuresFlag := flag.Int("notify-failures", 0, "Also notify as soon as this many inputs have failed (default: 0, off)")
+	errorsJSONFlag := flag.String("errors-json", "", "Write every failed input, with its stage and error class, to this JSON file at the end of the run")
+	dryRunFlag := flag.Bool("dry-run", false, "List what would be classified, skipped, or fail, with reasons, without writing any output")
+	dryRunJSONFlag := flag.String("dry-run-json", "", "With -dry-run, also write the plan and its summary counts to this JSON file")
+	checkConfigFlag := flag.Bool("check-config", false, "Check the flags and the job, profile, rows, and policy files, then exit without classifying")
 
 	flag.Parse()
 
+	// A job file fills in every flag not given on the command line
+	args := flag.Args()
+	if *jobFileFlag != "" {
+		// Inputs named on the command line replace the job file's inputs
+		cliInputs := len(args) > 0 || *fileFlag != "" || *dirFlag != ""
+		inputs, err := applyJobFile(*jobFileFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		if !cliInputs {
+			args = inputs
+		}
+	}
+
+	// A profile named by -c fills in the flags still unset, building on a preset
+	class := *classFlag
+	if *configFlag != "" {
+		base, err := applyProfile(*configFlag, class)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		class = base
+	}
+
+	if *langFlag != "" {
+		if err := setLocale(*langFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+	}
+
+	// A lone "-" argument reads the image from standard input, as -f - does,
+	// and the image goes to standard output unless -o is given
+	if len(args) == 1 && args[0] == stdioName && *fileFlag == "" {
+		*fileFlag, args = stdioName, nil
+	}
+	if *fileFlag == stdioName {
+		outputSet := false
+		flag.Visit(func(f *flag.Flag) { outputSet = outputSet || f.Name == "o" })
+		if !outputSet {
+			*outputFlag = stdioName
+		}
+	}
+	if *outputFlag == stdioName && (*fileFlag == "" || *dirFlag != "" || *bundleFlag != "" || len(args) > 0) {
+		fmt.Println(tr("Error: -o - writes a single image to standard output; use it with -f."))
+		os.Exit(1)
+	}
+	// In a pipeline, standard output carries only the image (with -o -) and
+	// every message goes to standard error, even when -o names a directory
+	if *outputFlag == stdioName || *fileFlag == stdioName {
+		redirectStdout()
+	}
+
+	quarantineDir = *quarantineFlag
+	quarantineFlags = recordFlags()
+
+	operator = resolveOperator(*operatorFlag)
+	if *runIDFlag != "" {
+		runID = *runIDFlag
+	}
+	deterministic = *deterministicFlag
+	recursive = *recursiveFlag
+	if *workersFlag < 1 {
+		fmt.Println(tr("Error: -workers must be at least 1."))
+		os.Exit(1)
+	}
+	workers = *workersFlag
+	verifyOutput = *verifyFlag
+	strictLayout = *strictLayoutFlag
+	embedMetadata = *embedMetadataFlag
+	preserveMetadata = *preserveMetadataFlag
+
+	// Background runs yield the CPU to the workstation's interactive use
+	if *lowPriorityFlag {
+		if err := lowerPriority(); err != nil {
+			fmt.Println(tr("Warning:"), err)
+		}
+		workersSet := false
+		flag.Visit(func(f *flag.Flag) { workersSet = workersSet || f.Name == "workers" })
+		if !workersSet {
+			workers = max(1, runtime.NumCPU()/4)
+		}
+	}
+
+	// Batches on shared storage can be held to a share of its bandwidth and handles
+	if *ioRateFlag != "" {
+		rate, err := parseRate(*ioRateFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		ioLimiter = &rateLimiter{rate: rate}
+	}
+	if *maxOpenFlag < 0 {
+		fmt.Println(tr("Error: -max-open-files must not be negative."))
+		os.Exit(1)
+	}
+	if *maxOpenFlag > 0 {
+		fileSlots = make(chan struct{}, *maxOpenFlag)
+	}
+
+	if *eventLogFlag {
+		sink, err := openEventSink(*eventSourceFlag)
+		if err != nil {
+			fmt.Println(tr("Error enabling event log:"), err)
+			os.Exit(1)
+		}
+		events = sink
+		onShutdown(func() { events.Close() })
+	}
+	defer shutdown()
+
+	if err := startProfiling(*pprofFlag, *cpuProfileFlag, *memProfileFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+
 	// Validate required flags
-	if *classFlag == "" {
-		fmt.Println("Error: Classification type (-c) is required.")
+	if class == "" {
+		fmt.Println(tr("Error: Classification type (-c) is required."))
 		printUsageAndExit()
 	}
 
-	var banner BannerMode
+	var banner classify.BannerMode
 	var exists bool
 
-	// If classification is "custom", build a BannerMode from user-provided flags
-	if *classFlag == "custom" {
-		bgCol, err := parseRGB(*bgColorFlag)
+	// If classification is "custom", build a classify.BannerMode from user-provided flags
+	if class == "custom" {
+		bgCol, err := classify.ParseRGB(*bgColorFlag)
 		if err != nil {
-			fmt.Println("Error parsing background color:", err)
+			fmt.Println(tr("Error parsing background color:"), err)
 			os.Exit(1)
 		}
-		txtCol, err := parseRGB(*txtColorFlag)
+		txtCol, err := classify.ParseTextColor(*txtColorFlag, bgCol)
 		if err != nil {
-			fmt.Println("Error parsing text color:", err)
+			fmt.Println(tr("Error parsing text color:"), err)
 			os.Exit(1)
 		}
 		if *textFlag == "" {
-			fmt.Println("Error: You must provide -text for custom banner mode.")
+			fmt.Println(tr("Error: You must provide -text for custom banner mode."))
 			os.Exit(1)
 		}
 		if *bgColorFlag == "" {
-			fmt.Println("Error: You must provide -background-color for custom banner color")
+			fmt.Println(tr("Error: You must provide -background-color for custom banner color"))
 		}
 		if *txtColorFlag == "" {
-			fmt.Println("Error: You must provide -text-color for custom banner color")
+			fmt.Println(tr("Error: You must provide -text-color for custom banner color"))
 		}
 
-		banner = BannerMode{
+		banner = classify.BannerMode{
 			BgColor:   bgCol,
 			TextColor: txtCol,
 			Text:      *textFlag,
@@ -89,283 +334,974 @@ func main() {
 		exists = true // Because we created it ourselves
 	} else {
 		// Otherwise, look up the predefined mode
-		banner, exists = bannerModes[*classFlag]
+		banner, exists = classify.Presets[class]
 		if !exists {
-			fmt.Println("Error: Invalid classification mode. Options: unclassed, cui, secret.")
+			fmt.Println(tr("Error: Invalid classification mode. Options: unclassed, cui, confidential, secret, topsecret."))
 			printUsageAndExit()
 		}
 	}
 
+	// CUI categories and dissemination controls are composed into the cui banner
+	if *cuiCategoriesFlag != "" || *cuiDissemFlag != "" {
+		if class != "cui" {
+			fmt.Println(tr("Error: -cui-categories and -cui-dissem require -c cui."))
+			os.Exit(1)
+		}
+		text, err := cuiBannerText(*cuiCategoriesFlag, *cuiDissemFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		banner.Text = text
+	}
+
+	// SCI, SAP, FGI, and dissemination controls are composed into a US level's banner line
+	if *sciFlag != "" || *sapFlag != "" || *fgiFlag != "" || *dissemFlag != "" {
+		if class == "cui" || class == "custom" {
+			fmt.Println(tr("Error: -sci, -sap, -fgi, and -dissem require -c unclassed, confidential, secret, or topsecret."))
+			os.Exit(1)
+		}
+		text, err := bannerLineText(banner.Text, *sciFlag, *sapFlag, *fgiFlag, *dissemFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		banner.Text = text
+	}
+
 	// Validate classification mode
 	if !exists {
-		fmt.Println("Error: Invalid classification mode. Options: unclassed, cui, secret, custom.")
+		fmt.Println(tr("Error: Invalid classification mode. Options: unclassed, cui, confidential, secret, topsecret, custom."))
 		printUsageAndExit()
 	}
 
+	// Apply the optional background pattern on top of the selected mode
+	if err := classify.ValidatePattern(*patternFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	patCol, err := classify.ParseRGB(*patternColorFlag)
+	if err != nil {
+		fmt.Println(tr("Error parsing pattern color:"), err)
+		os.Exit(1)
+	}
+	banner.Pattern = *patternFlag
+	banner.PatternColor = patCol
+	banner.PatternWidth = *patternWidthFlag
+	if *fontSizeFlag <= 0 {
+		fmt.Println(tr("Error: -font-size must be a positive number of points."))
+		os.Exit(1)
+	}
+	banner.FontSize = *fontSizeFlag
+	if *autoFitFlag < 0 || *autoFitFlag > 100 {
+		fmt.Println(tr("Error: -autofit must be a percentage from 0 to 100."))
+		os.Exit(1)
+	}
+	banner.AutoFit = *autoFitFlag
+	if *overflowFlag != "" && !slices.Contains(classify.OverflowPolicies, *overflowFlag) {
+		fmt.Printf(tr("Error: invalid -overflow policy '%s' (options: %s)\n"), *overflowFlag, strings.Join(classify.OverflowPolicies, ", "))
+		os.Exit(1)
+	}
+	banner.Overflow = *overflowFlag
+
+	// Stacked rows from a preset file come first, then any -row flags
+	if *rowsFileFlag != "" {
+		rows, err := classify.LoadRowsFile(*rowsFileFlag, banner)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		banner.Rows = append(banner.Rows, rows...)
+	}
+	for _, spec := range rowFlags {
+		row, err := classify.ParseRowSpec(spec, banner)
+		if err != nil {
+			fmt.Println(tr("Error parsing row:"), err)
+			os.Exit(1)
+		}
+		banner.Rows = append(banner.Rows, row)
+	}
+
+	// Portion marks from flags; a portions file next to an image replaces them
+	for _, spec := range portionFlags {
+		p, err := classify.ParsePortionSpec(spec, banner)
+		if err != nil {
+			fmt.Println(tr("Error parsing portion:"), err)
+			os.Exit(1)
+		}
+		banner.Portions = append(banner.Portions, p)
+	}
+
+	rawConverter = *rawConverterFlag
+
+	if *cacheFlag != "" {
+		cache, err := openCache(*cacheFlag)
+		if err != nil {
+			fmt.Println(tr("Error opening cache:"), err)
+			os.Exit(1)
+		}
+		resultCache = cache
+	}
+
+	if *outputModeFlag != "" {
+		p, err := parseOutputPerm(*outputModeFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		outputPerms.Default = p
+	}
+	for _, spec := range levelModeFlags {
+		level, p, err := parseLevelOutputPerm(spec)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		if outputPerms.ByLevel == nil {
+			outputPerms.ByLevel = map[Level]outputPerm{}
+		}
+		outputPerms.ByLevel[level] = p
+	}
+
+	if *shardFlag != "" {
+		s, err := parseShard(*shardFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		shard = s
+	}
+
+	if *newerThanFlag != "" {
+		if fileAge.NewerThan, err = parseNewerThan(*newerThanFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+	}
+	if *modifiedAfterFlag != "" {
+		if fileAge.ModifiedAfter, err = parseModifiedAfter(*modifiedAfterFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+	}
+	if *minDimensionFlag < 0 {
+		fmt.Println(tr("Error: -min-dimension must not be negative"))
+		os.Exit(1)
+	}
+	minDimension = *minDimensionFlag
+
+	if *qualityFlag < 1 || *qualityFlag > 100 {
+		fmt.Println(tr("Error: -quality must be between 1 and 100"))
+		os.Exit(1)
+	}
+	jpegQuality = *qualityFlag
+	if pngCompression, err = parsePNGCompression(*pngCompressionFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+
+	if err := validateColorSpace(*colorSpaceFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	colorSpace = *colorSpaceFlag
+	if rotation, err = parseRotation(*rotateFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if flipAxis, err = parseFlip(*flipFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if *cropToleranceFlag < 0 || *cropToleranceFlag > 255 {
+		fmt.Println(tr("Error: -crop-tolerance must be between 0 and 255"))
+		os.Exit(1)
+	}
+	autoCrop = *autoCropFlag
+	cropTolerance = *cropToleranceFlag
+
+	if *fontFlag != "" {
+		f, err := classify.LoadFont(*fontFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		bannerFont = f
+		bannerFontSpec = *fontFlag
+	}
+	if *fontFallbackFlag != "" {
+		fonts, err := classify.LoadFallbackFonts(*fontFallbackFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		fallbackFonts = fonts
+		fallbackFontSpec = *fontFallbackFlag
+	}
+
+	toneMapping = toneMapOptions{Operator: *toneMapFlag, Exposure: *exposureFlag, Output: *hdrOutputFlag}
+	if err := validateToneMap(toneMapping); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+
+	if *geometryFlag != "" {
+		geometry, err := classify.ParseGeometry(*geometryFlag)
+		if err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+		banner.Geometry = geometry
+	}
+
+	// Optional separator line between the banner and the image
+	if *sepWidthFlag < 0 {
+		fmt.Println(tr("Error: -separator-width must not be negative."))
+		os.Exit(1)
+	}
+	sepCol, err := classify.ParseRGB(*sepColorFlag)
+	if err != nil {
+		fmt.Println(tr("Error parsing separator color:"), err)
+		os.Exit(1)
+	}
+	banner.SeparatorColor = sepCol
+	banner.SeparatorWidth = *sepWidthFlag
+
+	// Added pixels that no banner covers
+	if banner.CanvasColor, err = classify.ParseRGB(*canvasColorFlag); err != nil {
+		fmt.Println(tr("Error parsing canvas color:"), err)
+		os.Exit(1)
+	}
+
+	if *styleFlag != "strip" && *styleFlag != "pill" {
+		fmt.Println(tr("Error: Invalid banner style. Options: strip, pill."))
+		os.Exit(1)
+	}
+	banner.Style = *styleFlag
+
+	switch *alignFlag {
+	case "left", "center", "right":
+		banner.TextAlign = *alignFlag
+	default:
+		fmt.Println(tr("Error: Invalid text alignment. Options: left, center, right."))
+		os.Exit(1)
+	}
+
+	if banner.Sides, err = classify.ParseSides(*sidesFlag); err != nil {
+		fmt.Println(tr("Error parsing sides:"), err)
+		os.Exit(1)
+	}
+	if banner.TopAndBottom() {
+		banner.Sides = nil // Any spelling of the default shares its cache entries
+	}
+
+	if *opacityFlag <= 0 || *opacityFlag > 1 {
+		fmt.Println(tr("Error: -overlay-opacity must be greater than 0 and at most 1."))
+		os.Exit(1)
+	}
+	banner.Overlay = *overlayFlag
+	banner.Opacity = *opacityFlag
+
+	// The watermark repeats the classification line in the banner color
+	if *watermarkOpacityFlag <= 0 || *watermarkOpacityFlag > 1 {
+		fmt.Println(tr("Error: -watermark-opacity must be greater than 0 and at most 1."))
+		os.Exit(1)
+	}
+	if *watermarkSpacingFlag < 1 {
+		fmt.Println(tr("Error: -watermark-spacing must be at least 1."))
+		os.Exit(1)
+	}
+	banner.Watermark = classify.Watermark{
+		Set:     *watermarkFlag || *watermarkOnlyFlag,
+		Only:    *watermarkOnlyFlag,
+		Angle:   *watermarkAngleFlag,
+		Opacity: *watermarkOpacityFlag,
+		Spacing: *watermarkSpacingFlag,
+	}
+
+	if annotationFormat, err = parseAnnotationFormat(*annotationsFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	perceptualHash = *phashFlag
+	if pageNumberCorner, err = parsePageCorner(*pageNumbersFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if convertFormat, err = parseConvertFormat(*convertFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if alphaMode, err = parseAlphaMode(*alphaFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if matteColor, err = classify.ParseRGB(*matteFlag); err != nil {
+		fmt.Println(tr("Error: invalid -matte:"), err)
+		os.Exit(1)
+	}
+
+	// The marking must be allowed by every policy in force before anything is processed
+	if err := loadPolicies(*policyFlag); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	if err := checkPolicy(banner); err != nil {
+		fmt.Println(tr("Error: policy violation:"), err)
+		os.Exit(1)
+	}
+	if *checkConfigFlag {
+		fmt.Println(tr("Settings are valid."))
+		return
+	}
+
+	// A dry run only reports what would happen, so nothing is notified or written
+	if dryRun, dryRunJSONPath = *dryRunFlag, *dryRunJSONFlag; dryRun {
+		paths := args
+		switch {
+		case *bundleFlag != "" || *tuiFlag || *watchFlag || *scheduleFlag != "" || *fileFlag == stdioName:
+			fmt.Println(tr("Error: -dry-run cannot be combined with -bundle, -tui, -watch, -schedule, or standard input."))
+			printUsageAndExit()
+		case len(paths) == 0 && *fileFlag != "" && *dirFlag == "":
+			paths = []string{*fileFlag}
+		case len(paths) == 0 && *dirFlag != "" && *fileFlag == "":
+			paths = []string{*dirFlag}
+		case len(paths) == 0 || *fileFlag != "" || *dirFlag != "":
+			fmt.Println(tr("Error: You must specify either a file (-f) or a directory (-d)."))
+			printUsageAndExit()
+		}
+		if err := runDryRun(paths, banner, *outputFlag, *bannerHeightFlag, *locFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			shutdown()
+			os.Exit(1)
+		}
+		return
+	}
+	if *dryRunJSONFlag != "" {
+		fmt.Println(tr("Error: -dry-run-json requires -dry-run."))
+		os.Exit(1)
+	}
+
+	// Notifiers hear about the batch when it finishes, or early when failures pile up
+	if err := startNotifiers(notifyFlags, *notifyFailuresFlag, banner.Text); err != nil {
+		fmt.Println(tr("Error:"), err)
+		os.Exit(1)
+	}
+	// Scheduled runs report as each one finishes instead
+	if *scheduleFlag == "" {
+		onShutdown(notifyCompletion)
+	}
+	if *errorsJSONFlag != "" {
+		errorsJSONPath = *errorsJSONFlag
+		if *scheduleFlag == "" {
+			onShutdown(writeFailureReport)
+		}
+	}
+
+	// A document bundle is copied with its referenced images classified
+	if *bundleFlag != "" {
+		if *fileFlag != "" || *dirFlag != "" || len(args) > 0 {
+			fmt.Println(tr("Error: Do not combine -bundle with -f, -d, or path arguments."))
+			printUsageAndExit()
+		}
+		if err := processBundle(*bundleFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag); err != nil {
+			fmt.Printf(tr("Error processing bundle '%s': %v\n"), *bundleFlag, err)
+			events.Error(fmt.Sprintf("Error processing bundle '%s' (operator: %s, run: %s): %v", *bundleFlag, operator, runID, err))
+			shutdown()
+			os.Exit(1)
+		}
+		fmt.Println(tr("Bundle classified successfully:"), *outputFlag)
+		fmt.Println(tr("Operator:"), operator)
+		fmt.Println(tr("Run ID:"), runID)
+		return
+	}
+
+	// Paths given as arguments (e.g. from Send To or a Quick Action) may mix files and directories
+	if paths := args; len(paths) > 0 {
+		if *fileFlag != "" || *dirFlag != "" {
+			fmt.Println(tr("Error: Do not combine -f or -d with path arguments."))
+			printUsageAndExit()
+		}
+		if err := processPaths(paths, banner, *outputFlag, *bannerHeightFlag, *locFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			shutdown()
+			os.Exit(1)
+		}
+		fmt.Println(tr("All paths classified successfully."))
+		fmt.Println(tr("Operator:"), operator)
+		fmt.Println(tr("Run ID:"), runID)
+		return
+	}
+
 	if (*fileFlag == "" && *dirFlag == "") || (*fileFlag != "" && *dirFlag != "") {
-		fmt.Println("Error: You must specify either a file (-f) or a directory (-d).")
+		fmt.Println(tr("Error: You must specify either a file (-f) or a directory (-d)."))
 		printUsageAndExit()
 	}
+	if *tuiFlag && *dirFlag == "" {
+		fmt.Println(tr("Error: -tui requires a directory (-d)."))
+		printUsageAndExit()
+	}
+	if *watchFlag && (*dirFlag == "" || *tuiFlag) {
+		fmt.Println(tr("Error: -watch requires a directory (-d) and cannot be combined with -tui."))
+		printUsageAndExit()
+	}
+	var sched *cronSchedule
+	if *scheduleFlag != "" {
+		if *dirFlag == "" || *watchFlag || *tuiFlag {
+			fmt.Println(tr("Error: -schedule requires a directory (-d) and cannot be combined with -watch or -tui."))
+			printUsageAndExit()
+		}
+		var err error
+		if sched, err = parseCron(*scheduleFlag); err != nil {
+			fmt.Println(tr("Error:"), err)
+			os.Exit(1)
+		}
+	}
+	if *watchSettleFlag <= 0 {
+		fmt.Println(tr("Error: -watch-settle must be positive."))
+		os.Exit(1)
+	}
+	if *watchAttemptsFlag < 1 {
+		fmt.Println(tr("Error: -watch-attempts must be at least 1."))
+		os.Exit(1)
+	}
 
 	if *fileFlag != "" {
-		if _, err := os.Stat(*fileFlag); os.IsNotExist(err) {
-			fmt.Printf("Error: File '%s' does not exist.\n", *fileFlag)
+		// Standard input and output are staged through temporary files
+		inputPath, outputDir := *fileFlag, *outputFlag
+		if inputPath == stdioName {
+			var err error
+			if inputPath, err = stageStdin(); err != nil {
+				fmt.Println(tr("Error:"), err)
+				shutdown()
+				os.Exit(1)
+			}
+		} else if _, err := statLocation(inputPath); errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf(tr("Error: File '%s' does not exist.\n"), *fileFlag)
+			recordResult(*fileFlag, err)
+			shutdown()
 			os.Exit(1)
 		}
+		if outputDir == stdioName {
+			var err error
+			if outputDir, err = stdoutOutputDir(); err != nil {
+				fmt.Println(tr("Error:"), err)
+				shutdown()
+				os.Exit(1)
+			}
+		}
 
-		err := processImage(*fileFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag)
+		outputPath, err := classifyFile(inputPath, banner, outputDir, *bannerHeightFlag, *locFlag)
+		if isSkipped(err) {
+			reportSkip(*fileFlag, err)
+			return
+		}
+		if err == nil && *outputFlag == stdioName {
+			err = writeStdout(outputPath)
+		}
 		if err != nil {
-			fmt.Printf("Error processing file '%s': %v\n", *fileFlag, err)
+			fmt.Printf(tr("Error processing file '%s': %v\n"), *fileFlag, err)
+			events.Error(fmt.Sprintf("Error processing file '%s' (operator: %s, run: %s): %v", *fileFlag, operator, runID, err))
+			recordResult(*fileFlag, err)
+			shutdown()
 			os.Exit(1)
 		}
-		fmt.Println("File classified successfully:", *fileFlag)
+		if *outputFlag != stdioName {
+			fmt.Println(tr("File classified successfully:"), *fileFlag)
+			fmt.Println(tr("Operator:"), operator)
+			fmt.Println(tr("Run ID:"), runID)
+		}
+		events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", *fileFlag, banner.Text, operator, runID))
+		recordResult(*fileFlag, nil)
 	}
 
 	if *dirFlag != "" {
-		if _, err := os.Stat(*dirFlag); os.IsNotExist(err) {
-			fmt.Printf("Error: Directory '%s' does not exist.\n", *dirFlag)
+		if _, err := statLocation(*dirFlag); errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf(tr("Error: Directory '%s' does not exist.\n"), *dirFlag)
 			os.Exit(1)
 		}
 
-		err := processDirectory(*dirFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag)
+		var err error
+		switch {
+		case *tuiFlag:
+			err = runTUI(*dirFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag)
+		case *watchFlag:
+			err = runWatch(*dirFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag, *watchSettleFlag, *watchAttemptsFlag, *watchLogFlag)
+		case sched != nil:
+			err = runSchedule(sched, *scheduleFlag, *runIDFlag != "", func() error {
+				return processDirectory(*dirFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag)
+			})
+		default:
+			err = processDirectory(*dirFlag, banner, *outputFlag, *bannerHeightFlag, *locFlag)
+		}
+		// SIGHUP restarts -watch and -schedule with their settings files re-read
+		if errors.Is(err, errReload) {
+			shutdown()
+			err = restart()
+		}
 		if err != nil {
-			fmt.Printf("Error processing directory '%s': %v\n", *dirFlag, err)
+			fmt.Printf(tr("Error processing directory '%s': %v\n"), *dirFlag, err)
+			events.Error(fmt.Sprintf("Error processing directory '%s' (operator: %s, run: %s): %v", *dirFlag, operator, runID, err))
+			shutdown()
 			os.Exit(1)
 		}
-		fmt.Println("All images in directory classified successfully:", *dirFlag)
+		if sched != nil {
+			return
+		}
+		fmt.Println(tr("All images in directory classified successfully:"), *dirFlag)
+		fmt.Println(tr("Operator:"), operator)
+		fmt.Println(tr("Run ID:"), runID)
 	}
 }
 
 // printUsageAndExit prints usage information and exits the program.
 func printUsageAndExit() {
-	fmt.Println("Usage:")
-	fmt.Println("  -d \"directory\"      		Classify all images in a directory")
-	fmt.Println("  -f \"file\"             		Classify a specific image file")
-	fmt.Println("  -c \"classification\"   		Choose classification: unclassed, cui, secret, or custom")
-	fmt.Println("  -o \"output_directory\" 		Specify output directory (default: goclassifyit_output)")
-	fmt.Println("  -h \"height\"          		Banner height in pixels (default: 60)")
-	fmt.Println("  -l \"location\"         		Location of banner text: 'center' (default) or 'corners'")
+	fmt.Println(tr("Usage:"))
+	fmt.Println("  -d \"directory\"      		" + tr("Classify all images in a directory"))
+	fmt.Println("  -f \"file\"             		" + tr("Classify a specific image file (- reads standard input)"))
+	fmt.Println("  -r                      		" + tr("With -d, also classify subdirectories, recreating the tree under -o"))
+	fmt.Println("  -workers N              		" + tr("Images processed in parallel with -d (default: number of CPUs; -tui uses one)"))
+	fmt.Println("  -io-rate \"50MB/s\"     		" + tr("Cap image reads and writes across all workers (default: unlimited)"))
+	fmt.Println("  -max-open-files N       		" + tr("Most image files open at once across all workers (default: 0, unlimited)"))
+	fmt.Println("  -low-priority           		" + tr("Lower CPU priority and use a quarter of the CPUs as workers unless -workers is given"))
+	fmt.Println("  -bundle \"directory\" 		" + tr("Copy an HTML/Markdown bundle with its referenced images classified"))
+	fmt.Println("  -c \"classification\"   		" + tr("Choose classification: unclassed, cui, confidential, secret, topsecret, or custom"))
+	fmt.Println("  -cui-categories \"list\" 		" + tr("CUI category markings for -c cui, e.g. SP-PRVCY,CTI (checked against the CUI registry)"))
+	fmt.Println("  -cui-dissem \"list\"   		" + tr("CUI dissemination controls for -c cui, e.g. NOFORN or FEDCON/REL TO USA, GBR"))
+	fmt.Println("  -sci \"list\"          		" + tr("SCI control systems in the banner line, e.g. SI-G,TK"))
+	fmt.Println("  -sap \"list\"          		" + tr("Special access programs in the banner line, e.g. BP,GB (marked SAR-BP/GB)"))
+	fmt.Println("  -fgi \"countries\"     		" + tr("Foreign government information in the banner line, e.g. \"DEU GBR\", or FGI unnamed"))
+	fmt.Println("  -dissem \"list\"       		" + tr("Dissemination controls in the banner line, e.g. NOFORN or ORCON/REL TO USA, FVEY"))
+	fmt.Println("  -o \"output_directory\" 		" + tr("Specify output directory (default: goclassifyit_output; - writes the image to standard output)"))
+	fmt.Println("  -h \"height\"          		" + tr("Banner height in pixels (default: 60)"))
+	fmt.Println("  -l \"location\"         		" + tr("Location of banner text: 'center' (default) or 'corners'"))
+	fmt.Println("  -font-size N           		" + tr("Font size in points of the classification row (default: 36)"))
+	fmt.Println("  -autofit PCT           		" + tr("Scale banner text to fill PCT% of each row's height, shrinking it to fit the width (default: 0, off)"))
+	fmt.Println("  -overflow \"policy\"     		" + tr("Banner text too wide for its row: wrap, shrink, truncate (with an ellipsis), or error"))
+	fmt.Println("  -eventlog              		" + tr("Write processing and error events to the Windows Event Log (Windows only)"))
+	fmt.Println("  -eventlog-source \"name\"	" + tr("Event Log source name (default: goclassifyit)"))
+	fmt.Println("  -pprof \"addr\"          		" + tr("Serve pprof profiling endpoints (e.g. :6060)"))
+	fmt.Println("  -cpuprofile \"file\"     		" + tr("Write a CPU profile to file"))
+	fmt.Println("  -memprofile \"file\"     		" + tr("Write a heap profile to file on exit"))
+	fmt.Println("  -deterministic          		" + tr("Produce byte-identical output for identical inputs and options"))
+	fmt.Println("  -operator \"name\"      		" + tr("Operator identity to record (default: current OS user)"))
+	fmt.Println("  -run-id \"id\"          		" + tr("Identifier recorded for this run in events and records (default: a random UUID)"))
+	fmt.Println("  -lang \"code\"          		" + tr("Language of messages: en, de, es, or fr (default: from GOCLASSIFYIT_LANG, LC_ALL, LC_MESSAGES, or LANG)"))
+	fmt.Println("  -pattern \"pattern\"     		" + tr("Banner background pattern: solid (default), diagonal, stripes, or hatch"))
+	fmt.Println("  -pattern-color \"R,G,B\" 		" + tr("Second color for patterned banners (default: 0,0,0)"))
+	fmt.Println("  -pattern-width \"px\"    		" + tr("Stripe width for patterned banners (default: 20)"))
+	fmt.Println("  -row \"TEXT|BG|FG|SIZE|H\"	" + tr("Extra stacked banner row, e.g. \"NOFORN|255,0,0|255,255,255|24\" (repeatable)"))
+	fmt.Println("  -rows-file \"rows.json\" 		" + tr("JSON file defining extra stacked banner rows"))
+	fmt.Println("  -portion \"X,Y,W,H|LABEL\"		" + tr("Mark an image region with its own label, e.g. \"40,120,300,200|(S)\" (repeatable)"))
+	fmt.Println("  -separator-color \"R,G,B\"	" + tr("Color of the banner/image separator line (default: 0,0,0)"))
+	fmt.Println("  -canvas-color \"R,G,B\"	" + tr("Color of added area no banner covers, beside a placed -banner or around pills (default: 255,255,255)"))
+	fmt.Println("  -separator-width \"px\"  		" + tr("Thickness of the banner/image separator line (default: 0, disabled)"))
+	fmt.Println("  -font \"file\"          		" + tr("TTF/OTF file or installed font family (e.g. \"Liberation Sans\") for banner text"))
+	fmt.Println("  -font-fallback \"a.ttf,b.ttf\"	" + tr("Fallback fonts for glyphs missing from the banner font, in order"))
+	fmt.Println("  -banner \"WxH+X+Y\"      		" + tr("Banner geometry; W/H of 0 mean full width and -h height, parts may be percentages (e.g. 100x0+0+0)"))
+	fmt.Println("  -style \"style\"         		" + tr("Banner style: strip (default) or pill (rounded label)"))
+	fmt.Println("  -text-align \"align\"    		" + tr("Text alignment in center mode: left, center (default), or right"))
+	fmt.Println("  -sides \"top,bottom\"    		" + tr("Edges with a banner: any of top, bottom, left, right; left and right text runs vertically (default: top,bottom)"))
+	fmt.Println("  -overlay                		" + tr("Draw semi-transparent banners over the image edges, keeping its dimensions"))
+	fmt.Println("  -overlay-opacity \"0-1\"		" + tr("Banner fill opacity with -overlay; text stays opaque (default: 0.8)"))
+	fmt.Println("  -watermark              		" + tr("Tile semi-transparent classification text diagonally across the image, as well as the banners"))
+	fmt.Println("  -watermark-only         		" + tr("Tile the watermark across the image instead of drawing banners"))
+	fmt.Println("  -watermark-angle \"deg\" 		" + tr("Angle the watermark text rises at, counterclockwise from horizontal (default: 45)"))
+	fmt.Println("  -watermark-opacity \"0-1\"	" + tr("Watermark text opacity (default: 0.2)"))
+	fmt.Println("  -watermark-spacing \"px\"		" + tr("Gap between repeats of the watermark text (default: 120)"))
+	fmt.Println("  -convert \"format\"      		" + tr("Write WebP and AVIF inputs as png or jpeg (default: keep, which needs cwebp or avifenc)"))
+	fmt.Println("  -alpha \"mode\"         		" + tr("Transparent inputs: preserve (default), flatten onto -matte, or warn"))
+	fmt.Println("  -matte \"R,G,B\"        		" + tr("Color transparent inputs are flattened onto with -alpha flatten, and fully transparent ones always (default: 255,255,255)"))
+	fmt.Println("  -page-numbers \"corner\"		" + tr("Number PDF and TIFF pages \"Page X of Y\" in a corner: top-left, top-right, bottom-left, or bottom-right"))
+	fmt.Println("  -phash                  		" + tr("Report a perceptual hash of each image's original region (excluding banners)"))
+	fmt.Println("  -annotations \"format\"  		" + tr("Write a coco (<output>.json) or voc (<output>.xml) annotation of the marked regions"))
+	fmt.Println("  -tonemap \"operator\"    		" + tr("Tone mapping for HDR/EXR inputs: reinhard (default), aces, or clamp"))
+	fmt.Println("  -exposure \"stops\"      		" + tr("Exposure adjustment for HDR/EXR inputs (default: 0)"))
+	fmt.Println("  -hdr-output \"format\"   		" + tr("Output format for HDR/EXR inputs: png (default) or jpeg"))
+	fmt.Println("  -colorspace \"mode\"     		" + tr("Convert inputs with embedded ICC profiles: preserve (default) or srgb"))
+	fmt.Println("  -rotate \"degrees\"      		" + tr("Turn images clockwise by 90, 180, or 270 degrees before marking"))
+	fmt.Println("  -flip \"h|v\"            		" + tr("Mirror images left to right (h) or top to bottom (v) before marking, after -rotate"))
+	fmt.Println("  -auto-crop              		" + tr("Trim uniform white or black scanner borders from images before marking"))
+	fmt.Println("  -crop-tolerance \"0-255\"		" + tr("How far border pixels may be from pure white or black with -auto-crop (default: 32)"))
+	fmt.Println("  -raw-converter \"path\"  		" + tr("dcraw or dcraw_emu used to develop camera RAW files (default: search PATH)"))
+	fmt.Println("  -verify-output          		" + tr("Re-read each output after writing and check dimensions, banners, and markers"))
+	fmt.Println("  -strict-layout          		" + tr("Fail images whose banner or portion labels would be clipped, instead of warning"))
+	fmt.Println("  -embed-metadata         		" + tr("Record the level, caveats, marking time, operator, and run ID in Exif/XMP (JPEG) or tEXt/XMP (PNG) metadata"))
+	fmt.Println("  -preserve-metadata      		" + tr("Copy the Exif, XMP, ICC profile, and DPI of PNG and JPEG inputs into their outputs"))
+	fmt.Println("  -quality N             		" + tr("JPEG and WebP output quality from 1 to 100 (default: 75)"))
+	fmt.Println("  -png-compression \"level\"		" + tr("PNG output compression: default, none, fast, or best"))
+	fmt.Println("  -policy \"policy.yaml\"  		" + tr("Policy restricting allowed markings, enforced with the system policy"))
+	fmt.Println("  -quarantine-dir \"dir\"  		" + tr("Move undecodable inputs here with a JSON explanation (see review)"))
+	fmt.Println("  -job \"job.yaml\"        		" + tr("YAML job file describing the run; command-line flags override it"))
+	fmt.Println("  -config \"config.yaml\"  		" + tr("File of named banner profiles, selected with -c NAME; command-line flags override them"))
+	fmt.Println("  -output-mode \"MODE[:GROUP]\"	" + tr("Octal permissions and optional group for outputs, e.g. 0640 or 0640:share"))
+	fmt.Println("  -level-output-mode \"L=MODE[:GROUP]\" " + tr("Per-level override, e.g. \"SECRET=0600:secret\" (repeatable)"))
+	fmt.Println("  -cache \"dir|redis://...\"	" + tr("Reuse outputs of unchanged inputs from a cache directory or Redis (redis://[:pass@]host:port/db?ttl=24h)"))
+	fmt.Println("  -shard \"K/N\"            		" + tr("Process only files whose path hashes to K modulo N"))
+	fmt.Println("  -newer-than \"24h\"      		" + tr("With -d, only classify files modified within this long (units: s, m, h, d)"))
+	fmt.Println("  -modified-after \"date\" 		" + tr("With -d, only classify files modified at or after this date, e.g. 2024-01-01"))
+	fmt.Println("  -min-dimension 64       		" + tr("Skip images narrower or shorter than this many pixels, reporting them as skipped"))
+	fmt.Println("  -tui                    		" + tr("Live status table for -d; keys: p pause/resume, r retry failures, q abort"))
+	fmt.Println("  -watch                  		" + tr("With -d, keep running and classify files as they arrive (hot folder)"))
+	fmt.Println("  -watch-settle \"2s\"     		" + tr("With -watch, time a file must go unchanged before it is classified (default: 2s)"))
+	fmt.Println("  -watch-attempts 3       		" + tr("With -watch, tries of a failing file before it is moved to failed/ (default: 3)"))
+	fmt.Println("  -watch-log \"file\"      		" + tr("With -watch, append a JSON line for each processed file"))
+	fmt.Println("  -schedule \"cron\"      		" + tr("With -d, stay running and classify the directory on a cron schedule, e.g. \"0 2 * * *\""))
+	fmt.Println("  -notify \"URL\"          		" + tr("Send the run summary to a webhook, Slack/Teams webhook, or smtp:// address (repeatable)"))
+	fmt.Println("  -notify-failures N      		" + tr("Also notify as soon as N inputs have failed (default: 0, off)"))
+	fmt.Println("  -errors-json \"file\"    		" + tr("Write failed inputs with their stage and error class to a JSON file"))
+	fmt.Println("  -dry-run                		" + tr("List what would be classified, skipped, or fail, with reasons, writing nothing"))
+	fmt.Println("  -dry-run-json \"file\"   		" + tr("With -dry-run, also write the plan and summary counts to a JSON file"))
+	fmt.Println("  -check-config           		" + tr("Check the flags and job, profile, rows, and policy files, then exit, as SIGHUP does before -watch/-schedule restart"))
 	fmt.Println("")
-	fmt.Println("When using -c custom, you must also provide:")
-	fmt.Println("  -text \"some text\"      	The banner text to display")
-	fmt.Println("  -background-color \"R,G,B\"  Background color (default: 255,0,0)")
-	fmt.Println("  -tc \"R,G,B\"            	Text color (default: 255,255,255)")
+	fmt.Println(tr("When using -c custom, you must also provide:"))
+	fmt.Println("  -text \"some text\"      	" + tr("The banner text to display"))
+	fmt.Println("  -background-color \"R,G,B\"  " + tr("Background color (default: 255,0,0)"))
+	fmt.Println("  -text-color \"R,G,B\"    	" + tr("Text color, or auto for black or white by background luminance (default: 255,255,255)"))
+	fmt.Println()
+	fmt.Println(tr("Files and directories may also be passed as arguments after the flags."))
 	fmt.Println()
-	fmt.Println("Examples:")
-	fmt.Println("  FILE MODE:      bin/goclassifyit_linux_x64.bin -f test_images/gopher1.png -c cui -o my_output -h 80 -l corners")
-	fmt.Println("  DIRECTORY MODE: bin/goclassifyit_windows_x64.exe -d test_images/ -c secret -o classified_results -h 100 -l center")
-	fmt.Println("  CUSTOM MODE:	   bin/goclassifyit_linux_x64.bin -f test_images/gopher2.png -c custom -text SENSITIVE -background-color 255,255,0 -text-color 0,0,0")
+	fmt.Println(tr("Subcommands:"))
+	fmt.Println("  install-integration -c \"classification\"	" + tr("Add a Send To/context-menu entry (Windows) or Quick Action (macOS)"))
+	fmt.Println("  review -q \"dir\" [-retry]                	" + tr("List quarantined inputs, or retry them with their recorded options"))
+	fmt.Println("  job                                   	" + tr("Run one batch configured by GOCLASSIFYIT_* variables and a mounted job spec"))
+	fmt.Println("  serve -addr \":8080\"                   	" + tr("Serve POST /classify, returning each uploaded image classified, with GET /healthz and /readyz probes"))
+	fmt.Println()
+	fmt.Println(tr("Examples:"))
+	fmt.Printf("  %-18s bin/goclassifyit_linux_x64.bin -f test_images/gopher1.png -c cui -o my_output -h 80 -l corners\n", tr("FILE MODE:"))
+	fmt.Printf("  %-18s bin/goclassifyit_windows_x64.exe -d test_images/ -c secret -o classified_results -h 100 -l center\n", tr("DIRECTORY MODE:"))
+	fmt.Printf("  %-18s bin/goclassifyit_linux_x64.bin -f test_images/gopher2.png -c custom -text SENSITIVE -background-color 255,255,0 -text-color 0,0,0\n", tr("CUSTOM MODE:"))
 
 	os.Exit(1)
 }
 
-func processDirectory(dirPath string, banner BannerMode, outputDir string, bannerHeight int, loc string) error {
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
+func processDirectory(dirPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	if err := checkTreeOutput(dirPath, outputDir); err != nil {
+		return err
 	}
 
-	var hasErrors bool // Track if any images failed
+	// Entries are streamed from the directory rather than listed up front,
+	// and the workers take the next one as they become free
+	paths, errc := listFiles(dirPath)
 
-	for _, file := range files {
-		if !file.IsDir() {
-			filePath := filepath.Join(dirPath, file.Name())
-			err := processImage(filePath, banner, outputDir, bannerHeight, loc)
-			if err != nil {
-				fmt.Printf("Error processing %s: %v\n", filePath, err)
-				hasErrors = true
-			} else {
-				fmt.Println("Classified:", filePath)
+	var mu sync.Mutex
+	var failures []fileError // Every failed image, reported at the end
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range paths {
+				err := processImage(filePath, banner, treeOutputDir(dirPath, filePath, outputDir), bannerHeight, loc)
+				if isSkipped(err) {
+					reportSkip(filePath, err)
+					continue
+				}
+				recordResult(filePath, err)
+				if err != nil {
+					fmt.Printf(tr("Error processing %s: %v\n"), filePath, err)
+					events.Error(fmt.Sprintf("Error processing '%s' (operator: %s, run: %s): %v", filePath, operator, runID, err))
+					mu.Lock()
+					failures = append(failures, fileError{Path: filePath, Err: err})
+					mu.Unlock()
+				} else {
+					fmt.Println(tr("Classified:"), filePath)
+					events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", filePath, banner.Text, operator, runID))
+				}
 			}
-		}
+		}()
 	}
+	wg.Wait()
 
-	if hasErrors {
+	if len(failures) > 0 {
+		reportFailures(failures)
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if len(failures) > 0 {
 		return fmt.Errorf("some images failed to process")
 	}
 	return nil
 }
 
+// processPaths classifies each path, processing directories as in -d mode.
+func processPaths(paths []string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	var hasErrors bool
+	for _, path := range paths {
+		info, err := statLocation(path)
+		if err != nil {
+			fmt.Printf(tr("Error: '%s' does not exist.\n"), path)
+			recordResult(path, err)
+			hasErrors = true
+			continue
+		}
+		if info.IsDir() {
+			err = processDirectory(path, banner, outputDir, bannerHeight, loc)
+		} else {
+			err = processImage(path, banner, outputDir, bannerHeight, loc)
+			if isSkipped(err) {
+				reportSkip(path, err)
+				continue
+			}
+			recordResult(path, err)
+		}
+		if err != nil {
+			fmt.Printf(tr("Error processing %s: %v\n"), path, err)
+			events.Error(fmt.Sprintf("Error processing '%s' (operator: %s, run: %s): %v", path, operator, runID, err))
+			hasErrors = true
+		} else if !info.IsDir() {
+			fmt.Println(tr("Classified:"), path)
+			events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", path, banner.Text, operator, runID))
+		}
+	}
+	if hasErrors {
+		return fmt.Errorf("some paths failed to process")
+	}
+	return nil
+}
+
 // processImage loads an image, adds classification banners, and saves the result.
-func processImage(imagePath string, banner BannerMode, outputDir string, bannerHeight int, loc string) error {
+func processImage(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	_, err := classifyFile(imagePath, banner, outputDir, bannerHeight, loc)
+	return err
+}
+
+// classifyFile is processImage returning the location of the written
+// output. imagePath and outputDir may be local paths or storage locations.
+func classifyFile(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	outputPath, err := markFile(imagePath, banner, outputDir, bannerHeight, loc)
+	if err != nil {
+		// Inputs that cannot be classified are moved aside when a quarantine is set
+		err = quarantineInput(imagePath, err, 1)
+	}
+	return outputPath, err
+}
+
+// markFile is classifyFile leaving failed inputs in place, for callers that
+// try them again before quarantining them.
+func markFile(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (outputPath string, err error) {
+	if banner, bannerHeight, loc, err = prepareFile(imagePath, banner, bannerHeight, loc); err != nil {
+		return "", err
+	}
 
-	// Check if the output directory is writable (simple test by creating a temp file)
-	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
-		testFile := filepath.Join(outputDir, "test_write.tmp")
-		f, err := os.Create(testFile)
+	// Check if a local output directory is writable (simple test by creating a temp file)
+	if _, err := statLocation(outputDir); !isRemote(outputDir) && !os.IsNotExist(err) {
+		testFile := joinLocation(outputDir, "test_write.tmp")
+		f, err := createFile(testFile)
 		if err != nil {
-			return fmt.Errorf("output directory '%s' is not writable: %w", outputDir, err)
+			return "", atStage("output", fmt.Errorf("output directory '%s' is not writable: %w", outputDir, err))
 		}
 		f.Close()
 		os.Remove(testFile)
 	}
 
-	// Open the input image file
-	file, err := os.Open(imagePath)
+	// Reuse the output of an identical earlier run when a cache is configured
+	if resultCache != nil {
+		outputPath, err = processCached(imagePath, banner, outputDir, bannerHeight, loc)
+	} else {
+		outputPath, err = classifyImage(imagePath, banner, outputDir, bannerHeight, loc)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open image: %w", err)
+		return "", atStage(formatStage(imagePath), err)
 	}
-	defer file.Close()
+	if annotationFormat != "" {
+		if _, err := statLocation(annotationPath(outputPath)); err == nil {
+			if err := applyOutputPerms(annotationPath(outputPath), banner); err != nil {
+				return "", atStage("perms", err)
+			}
+		}
+	}
+	return outputPath, atStage("perms", applyOutputPerms(outputPath, banner))
+}
 
-	// Decode the image format (supports PNG & JPEG)
-	img, format, err := image.Decode(file)
+// prepareFile applies the per-image settings of imagePath and checks that it
+// should be marked: that the marking passes policy and the image is not
+// skipped.
+func prepareFile(imagePath string, banner classify.BannerMode, bannerHeight int, loc string) (classify.BannerMode, int, string, error) {
+	// Per-image sidecar settings override the run-level flags
+	banner, bannerHeight, loc, err := applySidecar(imagePath, banner, bannerHeight, loc)
 	if err != nil {
-		return fmt.Errorf("failed to decode image '%s'. Ensure the file is a valid JPEG or PNG: %w", imagePath, err)
+		return banner, bannerHeight, loc, atStage("sidecar", err)
 	}
-
-	// Validate supported formats
-	if format != "jpeg" && format != "png" {
-		return fmt.Errorf("unsupported image format '%s' for file: %s", format, imagePath)
+	if banner, err = applyPortions(imagePath, banner); err != nil {
+		return banner, bannerHeight, loc, atStage("sidecar", err)
 	}
+	if err := checkPolicy(banner); err != nil {
+		return banner, bannerHeight, loc, atStage("policy", badInput(fmt.Errorf("policy violation: %w", err)))
+	}
+	return banner, bannerHeight, loc, checkDimensions(imagePath)
+}
 
-	// Get image dimensions
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-	newHeight := height + 2*bannerHeight
-
-	// Create a new image with extra space for banners
-	newImg := image.NewRGBA(image.Rect(0, 0, width, newHeight))
-
-	// Define banner regions
-	topBanner := image.Rect(0, 0, width, bannerHeight)
-	bottomBanner := image.Rect(0, newHeight-bannerHeight, width, newHeight)
-
-	// Fill banner areas with the predefined background color
-	draw.Draw(newImg, topBanner, &image.Uniform{banner.BgColor}, image.Point{}, draw.Src)
-	draw.Draw(newImg, bottomBanner, &image.Uniform{banner.BgColor}, image.Point{}, draw.Src)
-
-	// Overlay the original image onto the new image
-	draw.Draw(newImg,
-		image.Rect(0, bannerHeight, width, bannerHeight+height),
-		img,
-		bounds.Min,
-		draw.Src,
-	)
-
-	// -- Load the font face once here --
-	face, err := loadFontFace(36) // 36pt is an example – feel free to adjust or parameterize
-	if err != nil {
-		return fmt.Errorf("failed to load font face: %w", err)
+// classifyImage marks imagePath with banners and writes the result to
+// outputDir, returning the path of the written file.
+func classifyImage(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	// Icons carry several resolutions, each marked separately
+	if isICO(imagePath) {
+		return processIcon(imagePath, banner, outputDir, bannerHeight, loc)
 	}
 
-	// Now draw the text in either "corners" or "center" mode
-	switch loc {
-	case "corners":
-		// 5% of width margin
-		marginX := int(0.05 * float64(width))
+	// DICOM images carry pixel data and tags that must be rewritten in place
+	if isDICOM(imagePath) {
+		return processDICOM(imagePath, banner, outputDir, bannerHeight, loc)
+	}
 
-		// Y positions for top and bottom text (vertical centering in each banner)
-		topY := bannerHeight/2 + 10
-		botY := (newHeight - bannerHeight/2) + 10
+	// Every page of a TIFF is marked, keeping multi-page files together
+	if isTIFF(imagePath) {
+		return processTIFF(imagePath, banner, outputDir, bannerHeight, loc)
+	}
 
-		// Measure the text width so we can align the right side properly
-		txtWidth := measureText(face, banner.Text)
+	// Animated GIFs are marked frame by frame
+	if isGIF(imagePath) {
+		return processGIF(imagePath, banner, outputDir, bannerHeight, loc)
+	}
 
-		// TOP-LEFT
-		addLabel(newImg, banner.Text, marginX, topY, banner.TextColor, face)
-		// TOP-RIGHT
-		addLabel(newImg, banner.Text, width-marginX-txtWidth, topY, banner.TextColor, face)
+	// PDF pages get banners stamped over their top and bottom margins
+	if isPDF(imagePath) {
+		return processPDF(imagePath, banner, outputDir, bannerHeight, loc)
+	}
 
-		// BOTTOM-LEFT
-		addLabel(newImg, banner.Text, marginX, botY, banner.TextColor, face)
-		// BOTTOM-RIGHT
-		addLabel(newImg, banner.Text, width-marginX-txtWidth, botY, banner.TextColor, face)
+	// Messages are rewritten with their image attachments marked
+	if isEmail(imagePath) {
+		return processEmail(imagePath, banner, outputDir, bannerHeight, loc)
+	}
+	if isOutlookMsg(imagePath) {
+		return "", badInput(fmt.Errorf("Outlook .msg files are not supported; save the message as .eml: %s", imagePath))
+	}
 
-	default: // "center" or anything else
-		// Center text horizontally
-		topY := bannerHeight/2 + 10
-		botY := (newHeight - bannerHeight/2) + 10
+	// Everything else runs through the raster pipeline stages
+	return runPipeline(&imageJob{
+		InputPath:    imagePath,
+		OutputDir:    outputDir,
+		Banner:       banner,
+		BannerHeight: bannerHeight,
+		Loc:          loc,
+	})
+}
 
-		// For center alignment, measure text and shift it half
-		txtWidth := measureText(face, banner.Text)
+// markOptions returns the library options for marking with banner in this run.
+func markOptions(banner classify.BannerMode, bannerHeight int, loc string) classify.Options {
+	return classify.Options{Banner: banner, BannerHeight: bannerHeight, Location: loc, Font: bannerFont, FallbackFonts: fallbackFonts}
+}
 
-		// X coordinate for center
-		centerX := width/2 - (txtWidth / 2)
+// renderBanner returns a copy of img extended with top and bottom classification banners.
+func renderBanner(img image.Image, banner classify.BannerMode, bannerHeight int, loc string) (*image.RGBA, error) {
+	return classify.Mark(img, markOptions(banner, bannerHeight, loc))
+}
 
-		// Center on top banner
-		addLabel(newImg, banner.Text, centerX, topY, banner.TextColor, face)
-		// Center on bottom banner
-		addLabel(newImg, banner.Text, centerX, botY, banner.TextColor, face)
+// requireTopAndBottom returns an error when banner has -sides other than top
+// and bottom or a -watermark, for documents of kind whose banners can only
+// go on the top and bottom.
+func requireTopAndBottom(banner classify.BannerMode, kind string) error {
+	if banner.Watermark.Set {
+		return fmt.Errorf("%s files cannot take a -watermark", kind)
 	}
-
-	// Create the output directory if it does not exist
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	if banner.TopAndBottom() {
+		return nil
 	}
+	return fmt.Errorf("%s files take banners on the top and bottom only, not -sides %s", kind, strings.Join(banner.Sides, ","))
+}
 
-	// Define the output file path
-	outputPath := filepath.Join(outputDir, filepath.Base(imagePath))
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+// requireUntransformed returns an error when -rotate, -flip, or -auto-crop
+// is set, for documents of kind whose pages are marked where they stand.
+func requireUntransformed(kind string) error {
+	if rotation == 0 && flipAxis == "" && !autoCrop {
+		return nil
 	}
-	defer outputFile.Close()
+	return fmt.Errorf("%s files cannot be turned or cropped with -rotate, -flip, or -auto-crop", kind)
+}
+
+// bannerExtent returns the height of one banner strip (top or bottom) for an
+// image of the given size.
+func bannerExtent(banner classify.BannerMode, bannerHeight int, size image.Point) int {
+	return classify.BannerExtent(markOptions(banner, bannerHeight, ""), size)
+}
 
-	// Encode and save the new image in the same format as the input
-	switch format {
-	case "jpeg":
-		err = jpeg.Encode(outputFile, newImg, nil)
-	case "png":
-		err = png.Encode(outputFile, newImg)
+// decodeInput decodes imagePath, handing camera raw files to the external
+// converter and retrying CMYK JPEGs that lack an Adobe marker.
+func decodeInput(imagePath string) (image.Image, string, error) {
+	// Camera raw containers are developed to RGB
+	if isCameraRaw(imagePath) {
+		path, cleanup, err := localCopy(imagePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open image: %w", err)
+		}
+		defer cleanup()
+		img, err := developRaw(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, "raw", nil
 	}
 
-	if err != nil {
-		return fmt.Errorf("failed to encode image: %w", err)
+	// AVIF has no Go decoder, so libavif converts it
+	if isAVIF(imagePath) {
+		path, cleanup, err := localCopy(imagePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open image: %w", err)
+		}
+		defer cleanup()
+		img, err := decodeAVIF(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, "avif", nil
 	}
-	return nil
-}
 
-// loadFontFace loads the embedded TTF font and returns a font.Face at a specified size.
-func loadFontFace(fontSize float64) (font.Face, error) {
-	fontBytes, err := fontData.ReadFile("fonts/DejaVuSans-Bold.ttf")
+	// Open the input image file
+	file, err := openFile(imagePath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read embedded font: %w", err)
+		return nil, "", fmt.Errorf("failed to open image: %w", err)
 	}
-	tt, err := opentype.Parse(fontBytes)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse font: %w", err)
+
+	// Decode the image format (supports PNG & JPEG). The file is closed
+	// first so a second read never waits on the -max-open-files slot it holds
+	img, format, err := image.Decode(file)
+	file.Close()
+	if isUnmarkedCMYKError(err) {
+		// CMYK JPEGs without an Adobe marker need a second, patched pass
+		var data []byte
+		if data, err = readFile(imagePath); err == nil {
+			img, err = decodeUnmarkedCMYK(data)
+			format = "jpeg"
+		}
 	}
-	face, err := opentype.NewFace(tt, &opentype.FaceOptions{
-		Size:    fontSize,
-		DPI:     72,
-		Hinting: font.HintingFull,
-	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to create font face: %w", err)
+		return nil, "", fmt.Errorf("failed to decode image '%s'. Ensure the file is a valid JPEG or PNG: %w", imagePath, err)
 	}
-	return face, nil
+	return img, format, nil
 }
 
-// measureText returns the width of the given text (in pixels) for the specified font face.
-func measureText(face font.Face, text string) int {
-	d := &font.Drawer{
-		Face: face,
+// outputName returns the output file name for imagePath, decoded from the
+// source container and encoded as format. The input's name is kept unless
+// the image was converted to another container, whatever its extension.
+func outputName(imagePath, source, format string) string {
+	base := filepath.Base(imagePath)
+	if source == format {
+		return base
+	}
+	if format == "jpeg" {
+		return strings.TrimSuffix(base, filepath.Ext(base)) + ".jpg"
 	}
-	return d.MeasureString(text).Round()
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "." + format
 }
 
-// addLabel draws the text at the exact (x, y) coordinate without additional horizontal shifting.
-func addLabel(img *image.RGBA, text string, x, y int, col color.RGBA, face font.Face) {
-	d := &font.Drawer{
-		Dst:  img,
-		Src:  &image.Uniform{col},
-		Face: face,
-		Dot: fixed.Point26_6{
-			X: fixed.I(x),
-			Y: fixed.I(y),
-		},
-	}
-	d.DrawString(text)
-}
+// outputClaims maps each output path written in this run to its input, so
+// two inputs converted to the same name fail instead of overwriting each
+// other from parallel workers.
+var outputClaims = struct {
+	sync.Mutex
+	inputs map[string]string
+}{inputs: map[string]string{}}
 
-func parseRGB(str string) (color.RGBA, error) {
-	var r, g, b int
-	_, err := fmt.Sscanf(str, "%d,%d,%d", &r, &g, &b)
-	if err != nil {
-		return color.RGBA{}, fmt.Errorf("invalid color format (expected \"R,G,B\"): %w", err)
+// claimOutput records outputPath as the output of imagePath, failing when
+// another input of this run already writes it.
+func claimOutput(outputPath, imagePath string) error {
+	outputClaims.Lock()
+	defer outputClaims.Unlock()
+	if other, ok := outputClaims.inputs[outputPath]; ok && other != imagePath {
+		return fmt.Errorf("output '%s' is also the output of '%s'; rename one of the inputs", outputPath, other)
 	}
-	if r < 0 || r > 255 || g < 0 || g > 255 || b < 0 || b > 255 {
-		return color.RGBA{}, fmt.Errorf("invalid color value, each must be between 0 and 255")
-	}
-	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}, nil
+	outputClaims.inputs[outputPath] = imagePath
+	return nil
 }
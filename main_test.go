@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		path, source, format string
+		want                 string
+	}{
+		{"in/gopher5.png", "jpeg", "jpeg", "gopher5.png"}, // JPEG data under a .png name
+		{"in/photo.JPEG", "jpeg", "jpeg", "photo.JPEG"},
+		{"in/shot.png", "png", "png", "shot.png"},
+		{"in/scene.exr", "exr", "png", "scene.png"},
+		{"in/scene.hdr", "hdr", "jpeg", "scene.jpg"},
+		{"in/layout.psd", "psd", "png", "layout.png"},
+		{"in/frame.webp", "webp", "png", "frame.png"},
+		{"in/frame.webp", "webp", "webp", "frame.webp"},
+	}
+	for _, tt := range tests {
+		if got := outputName(tt.path, tt.source, tt.format); got != tt.want {
+			t.Errorf("outputName(%q, %q, %q) = %q, want %q", tt.path, tt.source, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestClaimOutput(t *testing.T) {
+	if err := claimOutput("out/x.png", "in/x.png"); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if err := claimOutput("out/x.png", "in/x.png"); err != nil {
+		t.Errorf("input claiming its own output again: %v", err)
+	}
+	if err := claimOutput("out/x.png", "in/x.psd"); err == nil {
+		t.Error("second input claiming out/x.png succeeded, want a collision error")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// cuiBannerText composes the banner line of the cui preset from
+// -cui-categories and -cui-dissem, which list markings separated by commas
+// or slashes, e.g. "SP-PRVCY,CTI" and "FEDCON" or "REL TO USA, GBR".
+func cuiBannerText(categories, dissem string) (string, error) {
+	return classify.CUIMarking(splitMarkingList(categories), splitMarkingList(dissem))
+}
+
+// bannerLineText composes the banner line of a US level from -sci, -sap,
+// -fgi, and -dissem. FGI countries may also be separated by spaces.
+func bannerLineText(level, sci, sap, fgi, dissem string) (string, error) {
+	return classify.Marking{
+		Level:  level,
+		SCI:    splitMarkingList(sci),
+		SAP:    splitMarkingList(sap),
+		FGI:    strings.Fields(strings.Join(splitMarkingList(fgi), " ")),
+		Dissem: splitMarkingList(dissem),
+	}.BannerLine()
+}
+
+// splitMarkingList splits a comma- or slash-separated list of markings.
+func splitMarkingList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '/' })
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// embedMetadata records the marking in the metadata of PNG and JPEG outputs
+// (the -embed-metadata flag), so tools can read it without the banners.
+var embedMetadata bool
+
+// xmpNamespace is the XMP namespace of the classification properties.
+const xmpNamespace = "https://github.com/AmbitiousOkie/goclassifyit/ns/classification/1.0/"
+
+// classificationMetadata is the marking recorded in an output's metadata.
+type classificationMetadata struct {
+	Marking  string    // Full banner line, e.g. "SECRET//NOFORN"
+	Level    string    // Level of the marking, e.g. "SECRET"
+	Caveats  []string  // Controls of the banner line, then stacked row texts
+	MarkedAt time.Time // When the output was marked (zero with -deterministic)
+	Operator string    // Who ran the marking ("" with -deterministic)
+	RunID    string    // The run that marked the output ("" with -deterministic)
+}
+
+func init() {
+	// Metadata is added to the written file before it is verified
+	registerStage(phaseDeliver, "metadata", func(job *imageJob) error {
+		if !embedMetadata && !preserveMetadata || (job.Format != "png" && job.Format != "jpeg") {
+			return nil
+		}
+		data, err := readFile(job.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to read output: %w", err)
+		}
+		if preserveMetadata {
+			if data, err = preserveSourceMetadata(job, data); err != nil {
+				return err
+			}
+		}
+		if embedMetadata {
+			if data, err = embedClassification(data, job.Format, newClassificationMetadata(job.Banner, operatorFor(job.InputPath))); err != nil {
+				return err
+			}
+		}
+		return writeOutputFile(job.OutputPath, data)
+	})
+}
+
+// newClassificationMetadata describes the marking of banner by who.
+func newClassificationMetadata(banner classify.BannerMode, who string) classificationMetadata {
+	text := strings.TrimSpace(strings.ReplaceAll(banner.Text, classify.SrcHashToken, ""))
+	level, caveats := splitMarking(text)
+	if l, ok := MarkingLevel(text); ok {
+		level = l.String()
+	}
+	for _, row := range banner.Rows {
+		if t := strings.TrimSpace(strings.ReplaceAll(row.Text, classify.SrcHashToken, "")); t != "" {
+			caveats = append(caveats, t)
+		}
+	}
+	md := classificationMetadata{Marking: text, Level: level, Caveats: caveats}
+	if !deterministic {
+		md.MarkedAt = time.Now().UTC()
+		md.Operator, md.RunID = who, runID
+	}
+	return md
+}
+
+// embedClassification returns an encoded PNG or JPEG with md added: as tEXt
+// and XMP chunks after a PNG's header, or as Exif and XMP APP1 segments
+// after a JPEG's start marker and JFIF header.
+func embedClassification(data []byte, format string, md classificationMetadata) ([]byte, error) {
+	xmp := md.xmp()
+	switch format {
+	case "png":
+		// The IHDR chunk always comes first, after the 8-byte signature
+		const ihdrEnd = 8 + 8 + 13 + 4
+		if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+			return nil, fmt.Errorf("output is not a PNG file")
+		}
+		var chunks bytes.Buffer
+		writePNGText(&chunks, "Classification", md.Marking)
+		writePNGText(&chunks, "Classification Level", md.Level)
+		if len(md.Caveats) > 0 {
+			writePNGText(&chunks, "Classification Caveats", strings.Join(md.Caveats, "/"))
+		}
+		if !md.MarkedAt.IsZero() {
+			writePNGText(&chunks, "Classification Date", md.MarkedAt.Format(time.RFC3339))
+		}
+		if md.Operator != "" {
+			writePNGText(&chunks, "Classification Operator", md.Operator)
+		}
+		if md.RunID != "" {
+			writePNGText(&chunks, "Classification Run ID", md.RunID)
+		}
+		writePNGChunk(&chunks, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))
+		return slices.Concat(data[:ihdrEnd], chunks.Bytes(), data[ihdrEnd:]), nil
+	case "jpeg":
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return nil, fmt.Errorf("output is not a JPEG file")
+		}
+		var segments bytes.Buffer
+		for _, payload := range [][]byte{
+			append([]byte("Exif\x00\x00"), md.exif()...),
+			append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...),
+		} {
+			// A segment's length, including its own two bytes, must fit in 16 bits
+			if len(payload) > 0xFFFF-2 {
+				return nil, fmt.Errorf("classification metadata is too long for a JPEG segment")
+			}
+			writeJPEGSegment(&segments, 0xE1, payload)
+		}
+		// A JFIF header from -preserve-metadata must stay first
+		pos := 2
+		if len(data) >= 6 && data[2] == 0xFF && data[3] == 0xE0 {
+			pos += 2 + int(binary.BigEndian.Uint16(data[4:]))
+		}
+		return slices.Concat(data[:pos], segments.Bytes(), data[pos:]), nil
+	}
+	return data, nil
+}
+
+// restampClassification returns a cached PNG or JPEG output with the
+// classification metadata embedClassification added replaced by md, so a
+// reused output records the time, operator, and run of the run reusing it.
+// Other formats are returned unchanged.
+func restampClassification(data []byte, md classificationMetadata) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		var out []byte
+		pos := 8
+		for pos+12 <= len(data) {
+			length := int(binary.BigEndian.Uint32(data[pos:]))
+			kind := string(data[pos+4 : pos+8])
+			end := pos + 12 + length
+			if length < 0 || end > len(data) || kind == "IDAT" {
+				break
+			}
+			// The classification's text chunks and XMP packet are dropped
+			key, _, _ := bytes.Cut(data[pos+8:end-4], []byte{0})
+			if !(kind == "tEXt" || kind == "iTXt") || !bytes.HasPrefix(key, []byte("Classification")) && string(key) != "XML:com.adobe.xmp" {
+				out = append(out, data[pos:end]...)
+			}
+			pos = end
+		}
+		return embedClassification(slices.Concat(data[:8], out, data[pos:]), "png", md)
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		var out []byte
+		pos := 2
+		for pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA && data[pos+1] != 0xD9 {
+			length := int(binary.BigEndian.Uint16(data[pos+2:]))
+			end := pos + 2 + length
+			if length < 2 || end > len(data) {
+				break
+			}
+			// The classification's Exif and XMP segments are dropped
+			seg := data[pos+4 : end]
+			if data[pos+1] != 0xE1 || !bytes.HasPrefix(seg, []byte("Exif\x00\x00")) && !bytes.HasPrefix(seg, []byte(xmpSignature)) {
+				out = append(out, data[pos:end]...)
+			}
+			pos = end
+		}
+		return embedClassification(slices.Concat(data[:2], out, data[pos:]), "jpeg", md)
+	}
+	return data, nil
+}
+
+// xmp returns md as an XMP packet.
+func (md classificationMetadata) xmp() []byte {
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	fmt.Fprintf(&b, "  <rdf:Description rdf:about=\"\" xmlns:classification=\"%s\">\n", xmpNamespace)
+	fmt.Fprintf(&b, "   <classification:Marking>%s</classification:Marking>\n", esc(md.Marking))
+	fmt.Fprintf(&b, "   <classification:Level>%s</classification:Level>\n", esc(md.Level))
+	if len(md.Caveats) > 0 {
+		b.WriteString("   <classification:Caveats><rdf:Bag>")
+		for _, c := range md.Caveats {
+			fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", esc(c))
+		}
+		b.WriteString("</rdf:Bag></classification:Caveats>\n")
+	}
+	if !md.MarkedAt.IsZero() {
+		fmt.Fprintf(&b, "   <classification:MarkedAt>%s</classification:MarkedAt>\n", md.MarkedAt.Format(time.RFC3339))
+	}
+	if md.Operator != "" {
+		fmt.Fprintf(&b, "   <classification:Operator>%s</classification:Operator>\n", esc(md.Operator))
+	}
+	if md.RunID != "" {
+		fmt.Fprintf(&b, "   <classification:RunID>%s</classification:RunID>\n", esc(md.RunID))
+	}
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+// exif returns md as a little-endian TIFF structure holding IFD0 with the
+// marking as ImageDescription, the marking time as DateTime, and the
+// operator as Artist. Entries are in ascending tag order, as IFDs require.
+func (md classificationMetadata) exif() []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	entries := []entry{{0x010E, md.Marking}}
+	if !md.MarkedAt.IsZero() {
+		entries = append(entries, entry{0x0132, md.MarkedAt.Format("2006:01:02 15:04:05")})
+	}
+	if md.Operator != "" {
+		entries = append(entries, entry{0x013B, md.Operator})
+	}
+
+	le := binary.LittleEndian
+	ifdSize := 2 + 12*len(entries) + 4
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	out = le.AppendUint16(out, uint16(len(entries)))
+	var values []byte
+	for _, e := range entries {
+		v := append([]byte(e.value), 0) // ASCII values are NUL-terminated
+		out = le.AppendUint16(out, e.tag)
+		out = le.AppendUint16(out, 2) // ASCII
+		out = le.AppendUint32(out, uint32(len(v)))
+		if len(v) <= 4 {
+			out = append(out, append(v, make([]byte, 4-len(v))...)...)
+			continue
+		}
+		out = le.AppendUint32(out, uint32(8+ifdSize+len(values)))
+		values = append(values, v...)
+	}
+	out = le.AppendUint32(out, 0) // No further IFDs
+	return append(out, values...)
+}
+
+// writePNGText writes a tEXt chunk, or an iTXt chunk when value has
+// characters outside Latin-1.
+func writePNGText(w *bytes.Buffer, key, value string) {
+	latin1 := make([]byte, 0, len(value))
+	for _, r := range value {
+		if r > 0xFF {
+			writePNGChunk(w, "iTXt", []byte(key+"\x00\x00\x00\x00\x00"+value))
+			return
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	writePNGChunk(w, "tEXt", append([]byte(key+"\x00"), latin1...))
+}
+
+// writePNGChunk writes a PNG chunk with its length and CRC.
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	w.WriteString(kind)
+	w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
+
+// writeJPEGSegment writes a JPEG marker segment.
+func writeJPEGSegment(w *bytes.Buffer, marker byte, data []byte) {
+	w.Write([]byte{0xFF, marker})
+	binary.Write(w, binary.BigEndian, uint16(len(data)+2))
+	w.Write(data)
+}
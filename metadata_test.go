@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestRestampClassification(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	first := classificationMetadata{Marking: "SECRET", Level: "SECRET", MarkedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), Operator: "alice", RunID: "run-1"}
+	second := classificationMetadata{Marking: "SECRET", Level: "SECRET", MarkedAt: time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC), Operator: "bob", RunID: "run-2"}
+	for _, format := range []string{"png", "jpeg"} {
+		var buf bytes.Buffer
+		if format == "png" {
+			png.Encode(&buf, img)
+		} else {
+			jpeg.Encode(&buf, img, nil)
+		}
+		cached, err := embedClassification(buf.Bytes(), format, first)
+		if err != nil {
+			t.Fatalf("%s: embed: %v", format, err)
+		}
+		got, err := restampClassification(cached, second)
+		if err != nil {
+			t.Fatalf("%s: restamp: %v", format, err)
+		}
+		want, _ := embedClassification(buf.Bytes(), format, second)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: restamped output differs from one marked by the second run", format)
+		}
+		if bytes.Contains(got, []byte("alice")) || bytes.Contains(got, []byte("run-1")) {
+			t.Errorf("%s: restamped output still names the first run", format)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(got)); err != nil {
+			t.Errorf("%s: restamped output does not decode: %v", format, err)
+		}
+	}
+}
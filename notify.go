@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smtpPasswordEnv supplies the SMTP password when the notify URL has none.
+const smtpPasswordEnv = "GOCLASSIFYIT_SMTP_PASSWORD"
+
+// maxNotifyFailures caps the failures listed in one notification.
+const maxNotifyFailures = 20
+
+// notifyClient sends webhook notifications; a slow endpoint must not stall a batch.
+var notifyClient = &http.Client{Timeout: 15 * time.Second}
+
+// Notifier delivers a run notification to an external channel.
+type Notifier interface {
+	Notify(n notification) error
+}
+
+// notification describes a batch when it finishes or reaches the failure
+// threshold. It is the JSON body of webhook notifications.
+type notification struct {
+	Event          string          `json:"event"`  // "completed" or "failure_threshold"
+	Status         string          `json:"status"` // "succeeded", "failed", or "running"
+	RunID          string          `json:"run_id"`
+	Operator       string          `json:"operator"`
+	Classification string          `json:"classification"`
+	Classified     int             `json:"classified"`
+	Failed         int             `json:"failed"`
+	Failures       []notifyFailure `json:"failures,omitempty"` // The first failures, up to maxNotifyFailures
+	Started        time.Time       `json:"started"`
+	Time           time.Time       `json:"time"`
+}
+
+// notifyFailure is one failed input.
+type notifyFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// subject returns a one-line summary of the notification.
+func (n notification) subject() string {
+	if n.Event == "failure_threshold" {
+		return fmt.Sprintf("goclassifyit run %s reached %d failures (%s)", n.RunID, n.Failed, n.Classification)
+	}
+	return fmt.Sprintf("goclassifyit run %s %s: %d classified, %d failed (%s)", n.RunID, n.Status, n.Classified, n.Failed, n.Classification)
+}
+
+// text returns the summary followed by the operator and listed failures.
+func (n notification) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nOperator: %s\n", n.subject(), n.Operator)
+	for _, f := range n.Failures {
+		fmt.Fprintf(&b, "- %s: %s\n", f.Path, f.Error)
+	}
+	if n.Failed > len(n.Failures) {
+		fmt.Fprintf(&b, "(%d more)\n", n.Failed-len(n.Failures))
+	}
+	return b.String()
+}
+
+// batch tracks the outcomes of the current run for notifications.
+var batch struct {
+	mu             sync.Mutex
+	notifiers      []Notifier
+	threshold      int // Failures that trigger an early notification (0: off)
+	thresholdSent  bool
+	classification string
+	started        time.Time
+	classified     int
+	failed         int
+	failures       []notifyFailure
+}
+
+// parseNotifier returns the notifier for a -notify target:
+//
+//	https://example.com/hook              JSON webhook
+//	https://hooks.slack.com/services/...  Slack incoming webhook
+//	https://x.webhook.office.com/...      Microsoft Teams incoming webhook
+//	slack+URL, teams+URL, webhook+URL     force the kind for other hosts
+//	smtp://[user[:pass]@]host[:port]?from=ADDR&to=ADDR[,ADDR]
+func parseNotifier(target string) (Notifier, error) {
+	kind, rawURL, forced := strings.Cut(target, "+")
+	if !forced || strings.Contains(kind, ":") {
+		kind, rawURL = "", target
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid notify target '%s'", target)
+	}
+
+	switch u.Scheme {
+	case "smtp":
+		if kind != "" {
+			return nil, fmt.Errorf("invalid notify target '%s'", target)
+		}
+		return newSMTPNotifier(u)
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported notify target '%s' (use an http(s) webhook URL or smtp://)", target)
+	}
+
+	if kind == "" {
+		switch host := strings.ToLower(u.Hostname()); {
+		case host == "hooks.slack.com":
+			kind = "slack"
+		case strings.HasSuffix(host, ".webhook.office.com"):
+			kind = "teams"
+		default:
+			kind = "webhook"
+		}
+	}
+	switch kind {
+	case "webhook":
+		return webhookNotifier{url: rawURL}, nil
+	case "slack":
+		return slackNotifier{url: rawURL}, nil
+	case "teams":
+		return teamsNotifier{url: rawURL}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier '%s' in '%s' (options: webhook, slack, teams)", kind, target)
+}
+
+// startNotifiers configures the notifiers for a run classifying as
+// classification. Nothing is recorded when targets is empty.
+func startNotifiers(targets []string, threshold int, classification string) error {
+	if threshold < 0 {
+		return fmt.Errorf("invalid failure threshold %d", threshold)
+	}
+	var notifiers []Notifier
+	for _, target := range targets {
+		n, err := parseNotifier(target)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	batch.notifiers = notifiers
+	batch.threshold = threshold
+	batch.classification = classification
+	batch.started = time.Now()
+	return nil
+}
+
+// resetBatch starts counting a new batch, for each run of -schedule.
+func resetBatch() {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	batch.thresholdSent = false
+	batch.started = time.Now()
+	batch.classified, batch.failed, batch.failures = 0, 0, nil
+}
+
+// recordResult counts the outcome of one input, notifying once when the
+// failures reach the threshold, and logs failures for -errors-json.
+func recordResult(path string, err error) {
+	logFailure(path, err)
+	batch.mu.Lock()
+	if len(batch.notifiers) == 0 {
+		batch.mu.Unlock()
+		return
+	}
+	if err == nil {
+		batch.classified++
+		batch.mu.Unlock()
+		return
+	}
+	batch.failed++
+	if len(batch.failures) < maxNotifyFailures {
+		batch.failures = append(batch.failures, notifyFailure{Path: path, Error: err.Error()})
+	}
+	var n notification
+	send := batch.threshold > 0 && batch.failed >= batch.threshold && !batch.thresholdSent
+	if send {
+		batch.thresholdSent = true
+		n = batchNotification("failure_threshold", "running")
+	}
+	batch.mu.Unlock()
+
+	if send {
+		deliver(n)
+	}
+}
+
+// notifyCompletion sends the end-of-run notification.
+func notifyCompletion() {
+	batch.mu.Lock()
+	if len(batch.notifiers) == 0 {
+		batch.mu.Unlock()
+		return
+	}
+	status := "succeeded"
+	if batch.failed > 0 {
+		status = "failed"
+	}
+	n := batchNotification("completed", status)
+	batch.mu.Unlock()
+	deliver(n)
+}
+
+// batchNotification builds a notification from the batch; batch.mu is held.
+func batchNotification(event, status string) notification {
+	return notification{
+		Event:          event,
+		Status:         status,
+		RunID:          runID,
+		Operator:       operator,
+		Classification: batch.classification,
+		Classified:     batch.classified,
+		Failed:         batch.failed,
+		Failures:       append([]notifyFailure(nil), batch.failures...),
+		Started:        batch.started.UTC(),
+		Time:           time.Now().UTC(),
+	}
+}
+
+// deliver sends n to every notifier. A failed delivery is reported but does
+// not fail the run.
+func deliver(n notification) {
+	for _, notifier := range batch.notifiers {
+		if err := notifier.Notify(n); err != nil {
+			fmt.Println(tr("Warning: notification failed:"), err)
+		}
+	}
+}
+
+// postJSON posts v as JSON to rawURL and checks for a 2xx response.
+func postJSON(rawURL string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(rawURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL usually carries a secret token, so only the host is reported
+		if u, perr := url.Parse(rawURL); perr == nil {
+			return fmt.Errorf("POST %s: %v", u.Host, unwrapURLError(err))
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: %s %s", resp.Request.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// unwrapURLError drops the URL from an HTTP client error.
+func unwrapURLError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return ue.Err
+	}
+	return err
+}
+
+// webhookNotifier posts the notification as JSON.
+type webhookNotifier struct{ url string }
+
+func (w webhookNotifier) Notify(n notification) error {
+	return postJSON(w.url, n)
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct{ url string }
+
+func (s slackNotifier) Notify(n notification) error {
+	return postJSON(s.url, map[string]string{"text": n.text()})
+}
+
+// teamsNotifier posts a message card to a Microsoft Teams incoming webhook.
+type teamsNotifier struct{ url string }
+
+func (t teamsNotifier) Notify(n notification) error {
+	color := "2EB886"
+	if n.Failed > 0 {
+		color = "D00000"
+	}
+	return postJSON(t.url, map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    n.subject(),
+		"themeColor": color,
+		"title":      n.subject(),
+		"text":       strings.ReplaceAll(strings.TrimSpace(n.text()), "\n", "\n\n"),
+	})
+}
+
+// smtpNotifier emails the notification. The server's STARTTLS is used when
+// offered; credentials are only sent over TLS (or to localhost).
+type smtpNotifier struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func newSMTPNotifier(u *url.URL) (Notifier, error) {
+	q := u.Query()
+	s := &smtpNotifier{addr: u.Host, host: u.Hostname(), from: q.Get("from")}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "25")
+	}
+	for _, to := range strings.Split(q.Get("to"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			s.to = append(s.to, to)
+		}
+	}
+	if s.from == "" || len(s.to) == 0 {
+		return nil, fmt.Errorf("smtp://%s needs from= and to= addresses", u.Host)
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+		if s.password == "" {
+			s.password = os.Getenv(smtpPasswordEnv)
+		}
+	}
+	return s, nil
+}
+
+func (s *smtpNotifier) Notify(n notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.subject()))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.text(), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+	if err := smtp.SendMail(s.addr, auth, s.from, s.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("smtp %s: %w", s.addr, err)
+	}
+	return nil
+}
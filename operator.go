@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"sync"
+)
+
+// operator is the identity recorded for accountability in events and reports.
+var operator = "unknown"
+
+// inputOperators maps inputs classified on behalf of someone other than
+// operator, such as uploads to serve from an authenticated client, to that
+// identity.
+var inputOperators sync.Map
+
+// operatorFor returns the identity recorded for classifying imagePath.
+func operatorFor(imagePath string) string {
+	if who, ok := inputOperators.Load(imagePath); ok {
+		return who.(string)
+	}
+	return operator
+}
+
+// resolveOperator returns the identity of the person invoking the tool. An
+// explicit override (the -operator flag) wins; otherwise the OS account is
+// used, falling back to the USER/USERNAME environment variables when the
+// account database cannot be queried.
+func resolveOperator(override string) string {
+	if override != "" {
+		return override
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return "unknown"
+}
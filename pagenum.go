@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// pageNumberCorner is the corner in which the pages of PDF and TIFF outputs
+// are numbered "Page X of Y", or "" for none.
+var pageNumberCorner string
+
+// parsePageCorner checks a -page-numbers value.
+func parsePageCorner(corner string) (string, error) {
+	if corner == "" || slices.Contains(classify.Corners, corner) {
+		return corner, nil
+	}
+	return "", fmt.Errorf("invalid page number corner '%s' (options: %s)", corner, strings.Join(classify.Corners, ", "))
+}
+
+// pageBanner returns banner for page (1-based) of a document with pages
+// pages, numbered when page numbers are on.
+func pageBanner(banner classify.BannerMode, page, pages int) classify.BannerMode {
+	if pageNumberCorner == "" {
+		return banner
+	}
+	banner.CornerText = fmt.Sprintf("Page %d of %d", page, pages)
+	banner.Corner = pageNumberCorner
+	return banner
+}
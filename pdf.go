@@ -0,0 +1,1151 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"golang.org/x/image/font/sfnt"
+)
+
+// pdfBannerScale converts banner sizes from image pixels to PDF points, so
+// the default 60px banner is a 20pt strip with 12pt text on the page.
+const pdfBannerScale = 1.0 / 3
+
+// isPDF reports whether path names a PDF document.
+func isPDF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
+
+// PDF objects. Numbers, strings, booleans, and null are kept as written, so
+// objects that are copied into the update are not altered.
+type (
+	pdfName  string // Name without the leading slash, escapes as written
+	pdfRaw   string // Any other direct object, as written
+	pdfArray []any
+	pdfDict  map[pdfName]any
+	pdfRef   struct{ Num, Gen int }
+)
+
+// pdfStream is a stream object with its encoded data.
+type pdfStream struct {
+	Dict pdfDict
+	Data []byte
+}
+
+// pdfXrefEntry locates an object: at a file offset, or by index inside an
+// object stream. Free entries record objects deleted by a newer revision.
+type pdfXrefEntry struct {
+	Offset   int
+	Gen      int
+	InStream bool
+	Stream   int
+	Index    int
+	Free     bool
+	Revision int // Of the section that listed the entry, counting from the newest
+}
+
+// pdfFile is a parsed PDF document. Only the parts needed to find and update
+// pages are interpreted.
+type pdfFile struct {
+	data       []byte
+	xref       map[int]pdfXrefEntry
+	revision   int     // Of the cross-reference section being read
+	trailer    pdfDict // Trailer of the newest revision
+	xrefStream bool    // The newest revision uses a cross-reference stream
+	startxref  int
+	objects    map[int]any
+	objStms    map[int]*pdfObjStm
+}
+
+// pdfObjStm is a decoded object stream.
+type pdfObjStm struct {
+	data    []byte
+	offsets []int // Offset of each object in data
+}
+
+// pdfPage is a leaf of the page tree with its inherited attributes.
+type pdfPage struct {
+	Ref    pdfRef
+	Dict   pdfDict
+	Box    [4]float64 // Visible area (crop box, else media box): llx, lly, urx, ury
+	Rotate int        // Clockwise display rotation: 0, 90, 180, or 270
+}
+
+// processPDF stamps classification banners over the top and bottom margins
+// of every page. The original bytes are kept and the change is appended as
+// an incremental update, replacing only the page objects; banner text is
+// drawn as outlines of the banner font, so no fonts are added to the file.
+func processPDF(pdfPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	if err := requireTopAndBottom(banner, "PDF"); err != nil {
+		return "", err
+	}
+	if err := requireUntransformed("PDF"); err != nil {
+		return "", err
+	}
+	data, err := readFile(pdfPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open document: %w", err)
+	}
+	doc, err := parsePDF(data)
+	if err != nil {
+		return "", badInput(fmt.Errorf("failed to parse PDF '%s': %w", pdfPath, err))
+	}
+	pages, err := doc.pages()
+	if err != nil {
+		return "", badInput(fmt.Errorf("failed to read pages of '%s': %w", pdfPath, err))
+	}
+	if len(pages) == 0 {
+		return "", badInput(fmt.Errorf("PDF '%s' has no pages", pdfPath))
+	}
+
+	// A source hash in a PDF covers the document bytes, since there are no pixels
+	sum := sha256.Sum256(data)
+	banner = pdfExpandSrcHash(banner, hex.EncodeToString(sum[:])[:12])
+
+	size, _ := pdfInt(doc.trailer["Size"])
+	next := max(size, doc.maxObject()+1)
+	update := map[int]pdfUpdateObject{}
+	add := func(v any) pdfRef {
+		ref := pdfRef{Num: next}
+		update[next] = pdfUpdateObject{Value: v}
+		next++
+		return ref
+	}
+
+	// The page's own content is wrapped in q ... Q so any graphics state it
+	// leaves behind does not affect the banners drawn after it
+	saveRef := add(pdfStream{Dict: pdfDict{}, Data: []byte("q\n")})
+	bannerRefs := map[string]pdfRef{}
+	for i, page := range pages {
+		pb := pageBanner(banner, i+1, len(pages))
+		key := fmt.Sprint(page.Box, page.Rotate, pb.CornerText)
+		ref, ok := bannerRefs[key]
+		if !ok {
+			content, err := pdfBannerContent(page.Box, page.Rotate, pb, bannerHeight, loc)
+			if err != nil {
+				return "", fmt.Errorf("page %d: %w", i+1, err)
+			}
+			stream, err := pdfFlateStream(content)
+			if err != nil {
+				return "", err
+			}
+			ref = add(stream)
+			bannerRefs[key] = ref
+		}
+
+		contents := pdfArray{saveRef}
+		switch v := page.Dict["Contents"].(type) {
+		case pdfArray:
+			contents = append(contents, v...)
+		case pdfRef:
+			if arr, ok := doc.resolve(v).(pdfArray); ok {
+				contents = append(contents, arr...)
+			} else {
+				contents = append(contents, v)
+			}
+		}
+		dict := pdfDict{}
+		for k, v := range page.Dict {
+			dict[k] = v
+		}
+		dict["Contents"] = append(contents, ref)
+		update[page.Ref.Num] = pdfUpdateObject{Gen: page.Ref.Gen, Value: dict}
+	}
+
+	out, err := doc.appendUpdate(update, next)
+	if err != nil {
+		return "", err
+	}
+
+	outputPath := joinLocation(outputDir, filepath.Base(pdfPath))
+	if err := writeOutputFile(outputPath, out); err != nil {
+		return "", err
+	}
+	if verifyOutput {
+		refs := map[pdfRef]bool{}
+		for _, ref := range bannerRefs {
+			refs[ref] = true
+		}
+		return outputPath, atStage("verify", verifyPDFOutput(outputPath, len(pages), refs))
+	}
+	return outputPath, nil
+}
+
+// pdfExpandSrcHash replaces the source hash token in banner's texts with hash.
+func pdfExpandSrcHash(banner classify.BannerMode, hash string) classify.BannerMode {
+	banner.Text = strings.ReplaceAll(banner.Text, classify.SrcHashToken, hash)
+	rows := make([]classify.BannerRow, len(banner.Rows))
+	for i, row := range banner.Rows {
+		row.Text = strings.ReplaceAll(row.Text, classify.SrcHashToken, hash)
+		rows[i] = row
+	}
+	banner.Rows = rows
+	return banner
+}
+
+// pdfBannerContent returns the content stream drawing the banners on a page
+// with the given visible box and rotation. Banner sizes are scaled from
+// pixels to points; fills are solid and labels are filled glyph outlines.
+func pdfBannerContent(box [4]float64, rotate int, banner classify.BannerMode, bannerHeight int, loc string) ([]byte, error) {
+	w, h := box[2]-box[0], box[3]-box[1]
+	if rotate == 90 || rotate == 270 {
+		w, h = h, w
+	}
+	scaled := scaleBanner(banner, pdfBannerScale)
+	bh := max(2, int(math.Round(float64(bannerHeight)*pdfBannerScale)))
+	// Banners are stamped over the page's margins as plain strips
+	scaled.Style, scaled.Pattern, scaled.Overlay = "strip", "solid", true
+	opts := markOptions(scaled, bh, loc)
+
+	size := image.Pt(int(math.Ceil(w)), int(math.Ceil(h)))
+	layout, err := classify.LayoutBanner(opts, size)
+	if err != nil {
+		return nil, err
+	}
+	if 2*layout.Extent > size.Y {
+		return nil, fmt.Errorf("page of %dx%d pt is too small for the banner", size.X, size.Y)
+	}
+
+	// Drawing happens in the page's display orientation, with y pointing up
+	var c bytes.Buffer
+	c.WriteString("Q q\n")
+	switch rotate {
+	case 90:
+		fmt.Fprintf(&c, "0 1 -1 0 %s %s cm\n", pdfNum(box[2]), pdfNum(box[1]))
+	case 180:
+		fmt.Fprintf(&c, "-1 0 0 -1 %s %s cm\n", pdfNum(box[2]), pdfNum(box[3]))
+	case 270:
+		fmt.Fprintf(&c, "0 -1 1 0 %s %s cm\n", pdfNum(box[0]), pdfNum(box[3]))
+	default:
+		fmt.Fprintf(&c, "1 0 0 1 %s %s cm\n", pdfNum(box[0]), pdfNum(box[1]))
+	}
+	// The size is rounded up so the strips cover fractional page edges
+	top := h
+	fill := func(r image.Rectangle, col color.RGBA) {
+		pdfSetColor(&c, col)
+		fmt.Fprintf(&c, "%d %s %d %d re f\n", r.Min.X, pdfNum(top-float64(r.Max.Y)), r.Dx(), r.Dy())
+	}
+
+	for _, bottom := range []bool{false, true} {
+		for _, row := range layout.Rows {
+			rect, baseline := row.Rect, row.Baseline
+			if bottom {
+				rect = layout.Bottom(row.Rect)
+				baseline += rect.Min.Y - row.Rect.Min.Y
+			}
+			fill(rect, row.Row.BgColor)
+			if row.Text == "" {
+				continue
+			}
+			pdfSetColor(&c, row.Row.TextColor)
+			for _, line := range row.Lines {
+				outline, err := classify.TextOutline(line.Text, row.Row.FontSize, opts.Font, opts.FallbackFonts)
+				if err != nil {
+					return nil, err
+				}
+				lineBaseline := baseline + line.Baseline - row.Baseline
+				for _, label := range row.Labels {
+					pdfGlyphPath(&c, outline, float64(label.Min.X+line.X), top-float64(lineBaseline))
+				}
+			}
+		}
+		if sw := scaled.SeparatorWidth; sw > 0 {
+			r := image.Rect(0, layout.Extent-sw, size.X, layout.Extent)
+			if bottom {
+				r = layout.Bottom(r)
+			}
+			fill(r, scaled.SeparatorColor)
+		}
+	}
+	if corner := layout.Corner; corner != nil {
+		outline, err := classify.TextOutline(corner.Text, corner.Row.FontSize, opts.Font, opts.FallbackFonts)
+		if err != nil {
+			return nil, err
+		}
+		pdfSetColor(&c, corner.Row.TextColor)
+		pdfGlyphPath(&c, outline, float64(corner.Rect.Min.X), top-float64(corner.Baseline))
+	}
+	c.WriteString("Q\n")
+	return c.Bytes(), nil
+}
+
+// pdfSetColor sets the fill color.
+func pdfSetColor(c *bytes.Buffer, col color.RGBA) {
+	fmt.Fprintf(c, "%s %s %s rg\n", pdfNum(float64(col.R)/255), pdfNum(float64(col.G)/255), pdfNum(float64(col.B)/255))
+}
+
+// pdfGlyphPath fills a text outline whose baseline starts at (x, y) in page
+// space. Outline y points down; quadratic curves become cubic ones.
+func pdfGlyphPath(c *bytes.Buffer, outline sfnt.Segments, x, y float64) {
+	pt := func(i int, seg sfnt.Segment) (float64, float64) {
+		return x + float64(seg.Args[i].X)/64, y - float64(seg.Args[i].Y)/64
+	}
+	var cx, cy float64
+	open := false
+	for _, seg := range outline {
+		switch seg.Op {
+		case sfnt.SegmentOpMoveTo:
+			if open {
+				c.WriteString("h\n")
+			}
+			cx, cy = pt(0, seg)
+			fmt.Fprintf(c, "%s %s m\n", pdfNum(cx), pdfNum(cy))
+			open = true
+		case sfnt.SegmentOpLineTo:
+			cx, cy = pt(0, seg)
+			fmt.Fprintf(c, "%s %s l\n", pdfNum(cx), pdfNum(cy))
+		case sfnt.SegmentOpQuadTo:
+			qx, qy := pt(0, seg)
+			px, py := pt(1, seg)
+			fmt.Fprintf(c, "%s %s %s %s %s %s c\n",
+				pdfNum(cx+2*(qx-cx)/3), pdfNum(cy+2*(qy-cy)/3),
+				pdfNum(px+2*(qx-px)/3), pdfNum(py+2*(qy-py)/3),
+				pdfNum(px), pdfNum(py))
+			cx, cy = px, py
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := pt(0, seg)
+			x2, y2 := pt(1, seg)
+			cx, cy = pt(2, seg)
+			fmt.Fprintf(c, "%s %s %s %s %s %s c\n", pdfNum(x1), pdfNum(y1), pdfNum(x2), pdfNum(y2), pdfNum(cx), pdfNum(cy))
+		}
+	}
+	if open {
+		c.WriteString("h f\n")
+	}
+}
+
+// pdfNum formats a coordinate with at most three decimals.
+func pdfNum(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 3, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// pdfFlateStream returns a Flate-compressed stream holding data.
+func pdfFlateStream(data []byte) (pdfStream, error) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		return pdfStream{}, err
+	}
+	if err := w.Close(); err != nil {
+		return pdfStream{}, err
+	}
+	return pdfStream{Dict: pdfDict{"Filter": pdfName("FlateDecode")}, Data: b.Bytes()}, nil
+}
+
+// parsePDF reads the cross-reference sections of data, newest first, and the
+// newest trailer. Encrypted documents are rejected.
+func parsePDF(data []byte) (*pdfFile, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a PDF file")
+	}
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i < 0 {
+		return nil, fmt.Errorf("missing startxref")
+	}
+	l := &pdfLexer{data: data, pos: i + len("startxref")}
+	start, err := strconv.Atoi(string(l.token()))
+	if err != nil || start <= 0 || start >= len(data) {
+		return nil, fmt.Errorf("invalid startxref")
+	}
+
+	p := &pdfFile{data: data, xref: map[int]pdfXrefEntry{}, startxref: start, objects: map[int]any{}, objStms: map[int]*pdfObjStm{}}
+	seen := map[int]bool{}
+	for off, first := start, true; !seen[off]; first = false {
+		seen[off] = true
+		p.revision++
+		trailer, isStream, err := p.readXrefSection(off)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			p.trailer, p.xrefStream = trailer, isStream
+		}
+		// Hybrid files list some objects only in a cross-reference stream
+		if stm, ok := pdfInt(trailer["XRefStm"]); ok && !isStream && !seen[stm] {
+			seen[stm] = true
+			if _, _, err := p.readXrefSection(stm); err != nil {
+				return nil, err
+			}
+		}
+		prev, ok := pdfInt(trailer["Prev"])
+		if !ok {
+			break
+		}
+		off = prev
+	}
+	if p.trailer["Encrypt"] != nil {
+		return nil, fmt.Errorf("encrypted PDFs are not supported")
+	}
+	if _, ok := p.trailer["Root"].(pdfRef); !ok {
+		return nil, fmt.Errorf("trailer has no document catalog")
+	}
+	return p, nil
+}
+
+// setXref records an entry unless a newer section already did, so a free
+// entry hides the object from older revisions. Within a revision, the
+// entries of a hybrid file's cross-reference stream replace the free ones
+// its table lists for the same objects.
+func (p *pdfFile) setXref(num int, e pdfXrefEntry) {
+	e.Revision = p.revision
+	if old, ok := p.xref[num]; !ok || old.Free && !e.Free && old.Revision == e.Revision {
+		p.xref[num] = e
+	}
+}
+
+// readXrefSection reads a cross-reference table or stream at off and returns
+// its trailer dictionary.
+func (p *pdfFile) readXrefSection(off int) (pdfDict, bool, error) {
+	if off < 0 || off >= len(p.data) {
+		return nil, false, fmt.Errorf("cross-reference offset %d is outside the file", off)
+	}
+	l := &pdfLexer{data: p.data, pos: off}
+	l.skipSpace()
+	if bytes.HasPrefix(p.data[l.pos:], []byte("xref")) {
+		l.pos += len("xref")
+		for {
+			tok := string(l.token())
+			if tok == "trailer" {
+				v, err := l.value()
+				if err != nil {
+					return nil, false, err
+				}
+				dict, ok := v.(pdfDict)
+				if !ok {
+					return nil, false, fmt.Errorf("invalid trailer")
+				}
+				return dict, false, nil
+			}
+			first, err1 := strconv.Atoi(tok)
+			count, err2 := strconv.Atoi(string(l.token()))
+			if err1 != nil || err2 != nil || count < 0 {
+				return nil, false, fmt.Errorf("invalid cross-reference table at offset %d", off)
+			}
+			for i := 0; i < count; i++ {
+				offset, err1 := strconv.Atoi(string(l.token()))
+				gen, err2 := strconv.Atoi(string(l.token()))
+				kind := string(l.token())
+				if err1 != nil || err2 != nil || (kind != "n" && kind != "f") {
+					return nil, false, fmt.Errorf("invalid cross-reference entry for object %d", first+i)
+				}
+				p.setXref(first+i, pdfXrefEntry{Offset: offset, Gen: gen, Free: kind == "f"})
+			}
+		}
+	}
+
+	_, obj, err := p.parseObjectAt(off)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid cross-reference at offset %d: %w", off, err)
+	}
+	stream, ok := obj.(pdfStream)
+	if !ok || stream.Dict["Type"] != pdfName("XRef") {
+		return nil, false, fmt.Errorf("invalid cross-reference at offset %d", off)
+	}
+	data, err := p.decodeStream(stream)
+	if err != nil {
+		return nil, false, fmt.Errorf("cross-reference stream: %w", err)
+	}
+	widths, _ := stream.Dict["W"].(pdfArray)
+	if len(widths) != 3 {
+		return nil, false, fmt.Errorf("cross-reference stream has invalid /W")
+	}
+	var w [3]int
+	for i := range w {
+		if w[i], ok = pdfInt(widths[i]); !ok || w[i] < 0 || w[i] > 8 {
+			return nil, false, fmt.Errorf("cross-reference stream has invalid /W")
+		}
+	}
+	size, _ := pdfInt(stream.Dict["Size"])
+	index := pdfArray{pdfRaw("0"), pdfRaw(strconv.Itoa(size))}
+	if arr, ok := stream.Dict["Index"].(pdfArray); ok {
+		index = arr
+	}
+	field := func(b []byte) int {
+		v := 0
+		for _, c := range b {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+	rowLen := w[0] + w[1] + w[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		first, _ := pdfInt(index[i])
+		count, _ := pdfInt(index[i+1])
+		for n := first; n < first+count; n++ {
+			if pos+rowLen > len(data) {
+				return nil, false, fmt.Errorf("cross-reference stream is truncated")
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			kind := 1
+			if w[0] > 0 {
+				kind = field(row[:w[0]])
+			}
+			f2, f3 := field(row[w[0]:w[0]+w[1]]), field(row[w[0]+w[1]:])
+			switch kind {
+			case 0:
+				p.setXref(n, pdfXrefEntry{Gen: f3, Free: true})
+			case 1:
+				p.setXref(n, pdfXrefEntry{Offset: f2, Gen: f3})
+			case 2:
+				p.setXref(n, pdfXrefEntry{InStream: true, Stream: f2, Index: f3})
+			}
+		}
+	}
+	return stream.Dict, true, nil
+}
+
+// maxObject returns the highest object number in the cross-reference.
+func (p *pdfFile) maxObject() int {
+	n := 0
+	for num := range p.xref {
+		n = max(n, num)
+	}
+	return n
+}
+
+// parseObjectAt parses the indirect object "N G obj ... endobj" at off.
+func (p *pdfFile) parseObjectAt(off int) (pdfRef, any, error) {
+	l := &pdfLexer{data: p.data, pos: off}
+	num, err1 := strconv.Atoi(string(l.token()))
+	gen, err2 := strconv.Atoi(string(l.token()))
+	if err1 != nil || err2 != nil || string(l.token()) != "obj" {
+		return pdfRef{}, nil, fmt.Errorf("no object at offset %d", off)
+	}
+	ref := pdfRef{num, gen}
+	v, err := l.value()
+	if err != nil {
+		return ref, nil, err
+	}
+	dict, ok := v.(pdfDict)
+	if !ok {
+		return ref, v, nil
+	}
+	save := l.pos
+	if string(l.token()) != "stream" {
+		l.pos = save
+		return ref, v, nil
+	}
+
+	// Stream data starts after the end of line following the keyword
+	start := l.pos
+	if start < len(p.data) && p.data[start] == '\r' {
+		start++
+	}
+	if start < len(p.data) && p.data[start] == '\n' {
+		start++
+	}
+	length, ok := pdfInt(p.resolve(dict["Length"]))
+	end := start + length
+	if !ok || length < 0 || end > len(p.data) || !bytes.HasPrefix(bytes.TrimLeft(p.data[end:], "\r\n \t"), []byte("endstream")) {
+		// A wrong /Length is common; fall back to the endstream keyword
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i < 0 {
+			return ref, nil, fmt.Errorf("object %d has an unterminated stream", num)
+		}
+		end = start + i
+		if end > start && p.data[end-1] == '\n' {
+			end--
+		}
+		if end > start && p.data[end-1] == '\r' {
+			end--
+		}
+	}
+	return ref, pdfStream{Dict: dict, Data: p.data[start:end]}, nil
+}
+
+// object returns the object numbered num, or nil when it does not exist.
+func (p *pdfFile) object(num int) (any, error) {
+	if v, ok := p.objects[num]; ok {
+		return v, nil
+	}
+	e, ok := p.xref[num]
+	if !ok || e.Free {
+		return nil, nil
+	}
+	// Mark the object while it is parsed, so reference cycles end
+	p.objects[num] = nil
+	var v any
+	var err error
+	if e.InStream {
+		v, err = p.streamObject(e.Stream, e.Index)
+	} else {
+		var ref pdfRef
+		ref, v, err = p.parseObjectAt(e.Offset)
+		if err == nil && ref.Num != num {
+			err = fmt.Errorf("cross-reference for object %d points at object %d", num, ref.Num)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.objects[num] = v
+	return v, nil
+}
+
+// streamObject returns object index of the object stream numbered stm.
+func (p *pdfFile) streamObject(stm, index int) (any, error) {
+	objStm, ok := p.objStms[stm]
+	if !ok {
+		v, err := p.object(stm)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := v.(pdfStream)
+		if !ok {
+			return nil, fmt.Errorf("object stream %d is missing", stm)
+		}
+		data, err := p.decodeStream(stream)
+		if err != nil {
+			return nil, fmt.Errorf("object stream %d: %w", stm, err)
+		}
+		n, _ := pdfInt(stream.Dict["N"])
+		first, _ := pdfInt(stream.Dict["First"])
+		objStm = &pdfObjStm{data: data}
+		l := &pdfLexer{data: data}
+		for i := 0; i < n; i++ {
+			l.token()
+			off, err := strconv.Atoi(string(l.token()))
+			if err != nil {
+				return nil, fmt.Errorf("object stream %d has an invalid header", stm)
+			}
+			objStm.offsets = append(objStm.offsets, first+off)
+		}
+		p.objStms[stm] = objStm
+	}
+	if index < 0 || index >= len(objStm.offsets) || objStm.offsets[index] >= len(objStm.data) {
+		return nil, fmt.Errorf("object stream %d has no object %d", stm, index)
+	}
+	return (&pdfLexer{data: objStm.data, pos: objStm.offsets[index]}).value()
+}
+
+// resolve follows a reference to its object; other values are returned as is.
+// Unreadable objects resolve to nil.
+func (p *pdfFile) resolve(v any) any {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		var err error
+		if v, err = p.object(ref.Num); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// decodeStream returns the decoded data of a stream. Only Flate, with or
+// without PNG predictors, is supported, which covers the cross-reference and
+// object streams written by PDF producers.
+func (p *pdfFile) decodeStream(s pdfStream) ([]byte, error) {
+	filter := p.resolve(s.Dict["Filter"])
+	params := p.resolve(s.Dict["DecodeParms"])
+	if arr, ok := filter.(pdfArray); ok {
+		if len(arr) > 1 {
+			return nil, fmt.Errorf("chained filters are not supported")
+		}
+		filter = nil
+		if len(arr) == 1 {
+			filter = p.resolve(arr[0])
+		}
+	}
+	if arr, ok := params.(pdfArray); ok && len(arr) > 0 {
+		params = p.resolve(arr[0])
+	}
+	switch filter {
+	case nil:
+		return s.Data, nil
+	case pdfName("FlateDecode"), pdfName("Fl"):
+	default:
+		return nil, fmt.Errorf("unsupported stream filter %v", filter)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(s.Data))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+
+	dp, _ := params.(pdfDict)
+	predictor, _ := pdfInt(p.resolve(dp["Predictor"]))
+	if predictor < 10 {
+		if predictor == 2 {
+			return nil, fmt.Errorf("TIFF predictors are not supported")
+		}
+		return data, nil
+	}
+	columns, ok := pdfInt(p.resolve(dp["Columns"]))
+	if !ok {
+		columns = 1
+	}
+	colors, ok := pdfInt(p.resolve(dp["Colors"]))
+	if !ok {
+		colors = 1
+	}
+	bpc, ok := pdfInt(p.resolve(dp["BitsPerComponent"]))
+	if !ok {
+		bpc = 8
+	}
+	return pngUnpredict(data, columns*colors*bpc/8, max(1, colors*bpc/8))
+}
+
+// pngUnpredict reverses PNG row filters, each row prefixed by its filter type.
+func pngUnpredict(data []byte, rowLen, bpp int) ([]byte, error) {
+	if rowLen <= 0 {
+		return nil, fmt.Errorf("invalid predictor columns")
+	}
+	var out []byte
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		kind, row := data[0], append([]byte(nil), data[1:rowLen+1]...)
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// pages returns the leaves of the page tree in document order.
+func (p *pdfFile) pages() ([]pdfPage, error) {
+	root, ok := p.resolve(p.trailer["Root"]).(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("document catalog is missing")
+	}
+	ref, ok := root["Pages"].(pdfRef)
+	if !ok {
+		return nil, fmt.Errorf("document has no page tree")
+	}
+	var pages []pdfPage
+	visited := map[int]bool{}
+	var walk func(ref pdfRef, inherited pdfDict) error
+	walk = func(ref pdfRef, inherited pdfDict) error {
+		if visited[ref.Num] {
+			return fmt.Errorf("page tree has a cycle at object %d", ref.Num)
+		}
+		visited[ref.Num] = true
+		node, ok := p.resolve(ref).(pdfDict)
+		if !ok {
+			return fmt.Errorf("page tree node %d is missing", ref.Num)
+		}
+		attrs := pdfDict{}
+		for _, k := range []pdfName{"MediaBox", "CropBox", "Rotate"} {
+			if v, ok := node[k]; ok {
+				attrs[k] = v
+			} else if v, ok := inherited[k]; ok {
+				attrs[k] = v
+			}
+		}
+		if kids, ok := p.resolve(node["Kids"]).(pdfArray); ok && node["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				kidRef, ok := kid.(pdfRef)
+				if !ok {
+					return fmt.Errorf("page tree node %d has an invalid kid", ref.Num)
+				}
+				if err := walk(kidRef, attrs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		page := pdfPage{Ref: ref, Dict: node}
+		media, ok := p.box(attrs["MediaBox"])
+		if !ok {
+			// Letter size is the customary default for pages without one
+			media = [4]float64{0, 0, 612, 792}
+		}
+		page.Box = media
+		if crop, ok := p.box(attrs["CropBox"]); ok {
+			page.Box = [4]float64{
+				math.Max(crop[0], media[0]), math.Max(crop[1], media[1]),
+				math.Min(crop[2], media[2]), math.Min(crop[3], media[3]),
+			}
+			if page.Box[2] <= page.Box[0] || page.Box[3] <= page.Box[1] {
+				page.Box = media
+			}
+		}
+		rotate, _ := pdfInt(p.resolve(attrs["Rotate"]))
+		page.Rotate = (rotate%360 + 360) % 360
+		if page.Rotate%90 != 0 {
+			page.Rotate = 0
+		}
+		pages = append(pages, page)
+		return nil
+	}
+	if err := walk(ref, pdfDict{}); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// box returns a normalized rectangle array.
+func (p *pdfFile) box(v any) ([4]float64, bool) {
+	arr, ok := p.resolve(v).(pdfArray)
+	if !ok || len(arr) != 4 {
+		return [4]float64{}, false
+	}
+	var b [4]float64
+	for i, item := range arr {
+		raw, ok := p.resolve(item).(pdfRaw)
+		if !ok {
+			return b, false
+		}
+		f, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil {
+			return b, false
+		}
+		b[i] = f
+	}
+	b = [4]float64{math.Min(b[0], b[2]), math.Min(b[1], b[3]), math.Max(b[0], b[2]), math.Max(b[1], b[3])}
+	return b, b[2] > b[0] && b[3] > b[1]
+}
+
+// pdfInt returns the integer value of a direct number object.
+func pdfInt(v any) (int, bool) {
+	raw, ok := v.(pdfRaw)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(raw))
+	if err != nil {
+		f, ferr := strconv.ParseFloat(string(raw), 64)
+		if ferr != nil {
+			return 0, false
+		}
+		n = int(f)
+	}
+	return n, true
+}
+
+// pdfUpdateObject is an object written by an incremental update.
+type pdfUpdateObject struct {
+	Gen   int
+	Value any
+}
+
+// appendUpdate returns the document with objects appended as an incremental
+// update, in the same cross-reference form as the newest revision. size is
+// the first unused object number.
+func (p *pdfFile) appendUpdate(objects map[int]pdfUpdateObject, size int) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(p.data)
+	if !bytes.HasSuffix(p.data, []byte("\n")) {
+		b.WriteByte('\n')
+	}
+
+	nums := make([]int, 0, len(objects)+1)
+	for num := range objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	offsets := map[int]int{}
+	for _, num := range nums {
+		offsets[num] = b.Len()
+		fmt.Fprintf(&b, "%d %d obj\n", num, objects[num].Gen)
+		writePDFValue(&b, objects[num].Value)
+		b.WriteString("\nendobj\n")
+	}
+
+	trailer := pdfDict{"Prev": pdfRaw(strconv.Itoa(p.startxref))}
+	for _, k := range []pdfName{"Root", "Info", "ID"} {
+		if v, ok := p.trailer[k]; ok {
+			trailer[k] = v
+		}
+	}
+
+	if !p.xrefStream {
+		trailer["Size"] = pdfRaw(strconv.Itoa(size))
+		xref := b.Len()
+		b.WriteString("xref\n")
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			fmt.Fprintf(&b, "%d %d\n", nums[i], j-i+1)
+			for _, num := range nums[i : j+1] {
+				fmt.Fprintf(&b, "%010d %05d n\r\n", offsets[num], objects[num].Gen)
+			}
+			i = j + 1
+		}
+		b.WriteString("trailer\n")
+		writePDFValue(&b, trailer)
+		fmt.Fprintf(&b, "\nstartxref\n%d\n%%%%EOF\n", xref)
+		return b.Bytes(), nil
+	}
+
+	// The cross-reference stream is itself the next object and lists itself
+	xrefNum := size
+	xref := b.Len()
+	offsets[xrefNum] = xref
+	nums = append(nums, xrefNum)
+	var rows []byte
+	var index pdfArray
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		index = append(index, pdfRaw(strconv.Itoa(nums[i])), pdfRaw(strconv.Itoa(j-i+1)))
+		for _, num := range nums[i : j+1] {
+			row := []byte{1, 0, 0, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(row[1:], uint32(offsets[num]))
+			binary.BigEndian.PutUint16(row[5:], uint16(objects[num].Gen))
+			rows = append(rows, row...)
+		}
+		i = j + 1
+	}
+	trailer["Type"] = pdfName("XRef")
+	trailer["Size"] = pdfRaw(strconv.Itoa(size + 1))
+	trailer["W"] = pdfArray{pdfRaw("1"), pdfRaw("4"), pdfRaw("2")}
+	trailer["Index"] = index
+	fmt.Fprintf(&b, "%d 0 obj\n", xrefNum)
+	writePDFValue(&b, pdfStream{Dict: trailer, Data: rows})
+	fmt.Fprintf(&b, "\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes(), nil
+}
+
+// writePDFValue serializes v. Dictionary keys are sorted so output is stable.
+func writePDFValue(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case pdfName:
+		b.WriteString("/" + string(v))
+	case pdfRaw:
+		b.WriteString(string(v))
+	case pdfRef:
+		fmt.Fprintf(b, "%d %d R", v.Num, v.Gen)
+	case pdfArray:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			writePDFValue(b, item)
+		}
+		b.WriteByte(']')
+	case pdfDict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		b.WriteString("<<")
+		for _, k := range keys {
+			b.WriteString("/" + k + " ")
+			writePDFValue(b, v[pdfName(k)])
+		}
+		b.WriteString(">>")
+	case pdfStream:
+		dict := pdfDict{}
+		for k, val := range v.Dict {
+			dict[k] = val
+		}
+		dict["Length"] = pdfRaw(strconv.Itoa(len(v.Data)))
+		writePDFValue(b, dict)
+		b.WriteString("\nstream\n")
+		b.Write(v.Data)
+		b.WriteString("\nendstream")
+	default:
+		b.WriteString("null")
+	}
+}
+
+// pdfLexer tokenizes PDF object syntax.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace skips white space and comments.
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token returns the next token: a delimiter, a name, a complete string, or a
+// regular word. It returns nil at the end of the data.
+func (l *pdfLexer) token() []byte {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil
+	}
+	start := l.pos
+	switch c := l.data[l.pos]; {
+	case c == '<' || c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == c {
+			l.pos += 2
+			return l.data[start:l.pos]
+		}
+		if c == '>' {
+			l.pos++
+			return l.data[start:l.pos]
+		}
+		// Hex string
+		for l.pos < len(l.data) && l.data[l.pos] != '>' {
+			l.pos++
+		}
+		l.pos = min(l.pos+1, len(l.data))
+	case c == '(':
+		depth := 0
+		for l.pos < len(l.data) {
+			switch l.data[l.pos] {
+			case '\\':
+				l.pos++
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			l.pos++
+			if depth == 0 {
+				break
+			}
+		}
+		l.pos = min(l.pos, len(l.data))
+	case c == '[' || c == ']' || c == '{' || c == '}' || c == ')':
+		l.pos++
+	case c == '/':
+		l.pos++
+		for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+			l.pos++
+		}
+	default:
+		for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelim(l.data[l.pos]) {
+			l.pos++
+		}
+	}
+	return l.data[start:l.pos]
+}
+
+// value parses the next object, turning "N G R" into a reference.
+func (l *pdfLexer) value() (any, error) {
+	tok := l.token()
+	switch {
+	case tok == nil:
+		return nil, fmt.Errorf("unexpected end of data")
+	case string(tok) == "<<":
+		dict := pdfDict{}
+		for {
+			save := l.pos
+			key := l.token()
+			if string(key) == ">>" {
+				return dict, nil
+			}
+			if len(key) == 0 || key[0] != '/' {
+				l.pos = save
+				return nil, fmt.Errorf("invalid dictionary key at offset %d", save)
+			}
+			v, err := l.value()
+			if err != nil {
+				return nil, err
+			}
+			dict[pdfName(key[1:])] = v
+		}
+	case string(tok) == "[":
+		var arr pdfArray
+		for {
+			save := l.pos
+			if string(l.token()) == "]" {
+				return arr, nil
+			}
+			l.pos = save
+			v, err := l.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	case tok[0] == '/':
+		return pdfName(tok[1:]), nil
+	case string(tok) == ">>" || string(tok) == "]":
+		return nil, fmt.Errorf("unexpected '%s' at offset %d", tok, l.pos)
+	}
+
+	// "N G R" is a reference; anything else is kept as written
+	if num, err := strconv.Atoi(string(tok)); err == nil && num >= 0 {
+		save := l.pos
+		genTok := l.token()
+		if gen, err := strconv.Atoi(string(genTok)); err == nil && string(l.token()) == "R" {
+			return pdfRef{num, gen}, nil
+		}
+		l.pos = save
+	}
+	return pdfRaw(tok), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// testPDFObjects are a one-page document with a spare object 4.
+var testPDFObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+	"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] >>",
+	"<< /Note (spare) >>",
+}
+
+// buildPDF returns a PDF holding objs, numbered from 1, with a classic
+// cross-reference table.
+func buildPDF(objs ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, obj := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return b.Bytes()
+}
+
+// buildXrefStreamPDF returns a PDF holding objs, numbered from 1, with a
+// cross-reference stream as the last object.
+func buildXrefStreamPDF(objs ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n")
+	rows := []byte{0, 0, 0, 0, 0, 0xff, 0xff}
+	row := func(off int) {
+		r := []byte{1, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(r[1:], uint32(off))
+		rows = append(rows, r...)
+	}
+	for i, obj := range objs {
+		row(b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref, num := b.Len(), len(objs)+1
+	row(xref)
+	fmt.Fprintf(&b, "%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Root 1 0 R /Length %d >>\nstream\n", num, num+1, len(rows))
+	b.Write(rows)
+	fmt.Fprintf(&b, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xref)
+	return b.Bytes()
+}
+
+// appendFreeUpdate returns data with an incremental update appended that
+// deletes object num.
+func appendFreeUpdate(t *testing.T, data []byte, num, size int) []byte {
+	t.Helper()
+	i := bytes.LastIndex(data, []byte("startxref"))
+	prev, err := strconv.Atoi(string(bytes.Fields(data[i+len("startxref"):])[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBuffer(bytes.Clone(data))
+	xref := b.Len()
+	fmt.Fprintf(b, "xref\n0 1\n0000000000 65535 f \n%d 1\n0000000000 00001 f \n", num)
+	fmt.Fprintf(b, "trailer\n<< /Size %d /Root 1 0 R /Prev %d >>\nstartxref\n%d\n%%%%EOF\n", size, prev, xref)
+	return b.Bytes()
+}
+
+func TestPDFFreeEntryHidesOlderObject(t *testing.T) {
+	base := buildPDF(testPDFObjects...)
+	updated := appendFreeUpdate(t, base, 4, len(testPDFObjects)+1)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantNote bool
+	}{
+		{"original", base, true},
+		{"object deleted by an update", updated, false},
+	}
+	for _, tt := range tests {
+		doc, err := parsePDF(tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		obj, err := doc.object(4)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := obj != nil; got != tt.wantNote {
+			t.Errorf("%s: object 4 present = %t, want %t", tt.name, got, tt.wantNote)
+		}
+		pages, err := doc.pages()
+		if err != nil || len(pages) != 1 {
+			t.Errorf("%s: pages = %d, %v; want 1", tt.name, len(pages), err)
+		}
+	}
+}
+
+func TestPDFAppendUpdateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		xrefStream bool
+	}{
+		{"cross-reference table", buildPDF(testPDFObjects...), false},
+		{"cross-reference stream", buildXrefStreamPDF(testPDFObjects...), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parsePDF(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.xrefStream != tt.xrefStream {
+				t.Fatalf("xrefStream = %t, want %t", doc.xrefStream, tt.xrefStream)
+			}
+			size := doc.maxObject() + 1
+
+			// The first update replaces the page and adds a content stream
+			page, err := doc.object(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newPage := pdfDict{"Contents": pdfRef{Num: size}}
+			for k, v := range page.(pdfDict) {
+				newPage[k] = v
+			}
+			newPage["Rotate"] = pdfRaw("90")
+			content := []byte("0 0 1 rg 0 0 200 10 re f")
+			data, err := doc.appendUpdate(map[int]pdfUpdateObject{
+				3:    {Value: newPage},
+				size: {Value: pdfStream{Dict: pdfDict{}, Data: content}},
+			}, size+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, tt.data) {
+				t.Fatal("update rewrote the original bytes")
+			}
+
+			// The second update, on top of the first, replaces the spare object
+			doc, err = parsePDF(data)
+			if err != nil {
+				t.Fatalf("after one update: %v", err)
+			}
+			if doc.xrefStream != tt.xrefStream {
+				t.Errorf("update changed the cross-reference form")
+			}
+			data, err = doc.appendUpdate(map[int]pdfUpdateObject{4: {Value: pdfDict{"Note": pdfRaw("(updated)")}}}, doc.maxObject()+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc, err = parsePDF(data)
+			if err != nil {
+				t.Fatalf("after two updates: %v", err)
+			}
+			if _, ok := doc.trailer["Prev"]; !ok {
+				t.Error("newest trailer has no /Prev")
+			}
+			pages, err := doc.pages()
+			if err != nil || len(pages) != 1 {
+				t.Fatalf("pages = %d, %v; want 1", len(pages), err)
+			}
+			if pages[0].Rotate != 90 || pages[0].Box != [4]float64{0, 0, 200, 100} {
+				t.Errorf("page = rotate %d box %v, want the first update's page", pages[0].Rotate, pages[0].Box)
+			}
+			stream, err := doc.object(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, ok := stream.(pdfStream); !ok || !bytes.Equal(s.Data, content) {
+				t.Errorf("object %d = %v, want the content stream", size, stream)
+			}
+			spare, err := doc.object(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, ok := spare.(pdfDict); !ok || d["Note"] != pdfRaw("(updated)") {
+				t.Errorf("object 4 = %v, want the second update's", spare)
+			}
+			catalog, err := doc.object(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d, ok := catalog.(pdfDict); !ok || d["Type"] != pdfName("Catalog") {
+				t.Errorf("object 1 = %v, want the original catalog", catalog)
+			}
+		})
+	}
+}
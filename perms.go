@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// outputPerm is the mode and group given to a classified output.
+type outputPerm struct {
+	Mode    os.FileMode
+	HasMode bool
+	Group   string // Group name or ID as given (empty: leave the group alone)
+	gid     int
+}
+
+// outputPerms are the permissions applied to outputs: Default for every
+// output, overridden per classification level. The zero value leaves files
+// as created.
+var outputPerms struct {
+	Default outputPerm
+	ByLevel map[Level]outputPerm
+}
+
+// parseOutputPerm parses "MODE", "MODE:GROUP", or ":GROUP", e.g. "0640" or
+// "0600:secret-share". The mode is octal.
+func parseOutputPerm(spec string) (outputPerm, error) {
+	var p outputPerm
+	mode, group, _ := strings.Cut(spec, ":")
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return p, fmt.Errorf("invalid output mode '%s' (use octal permissions such as 0640)", mode)
+		}
+		p.Mode, p.HasMode = os.FileMode(m), true
+	}
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return p, err
+		}
+		p.Group, p.gid = group, gid
+	}
+	if !p.HasMode && p.Group == "" {
+		return p, fmt.Errorf("invalid output mode '%s' (use MODE, MODE:GROUP, or :GROUP)", spec)
+	}
+	return p, nil
+}
+
+// lookupGroup resolves a group name or numeric ID.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("unknown output group '%s': %w", group, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("output group '%s' has no numeric ID; groups are only supported on Unix-like systems", group)
+	}
+	return gid, nil
+}
+
+// parseLevelOutputPerm parses a per-level override of the form
+// "LEVEL=MODE[:GROUP]", e.g. "SECRET=0600:secret-share".
+func parseLevelOutputPerm(spec string) (Level, outputPerm, error) {
+	name, perm, ok := strings.Cut(spec, "=")
+	if !ok {
+		return 0, outputPerm{}, fmt.Errorf("invalid level output mode '%s' (use LEVEL=MODE[:GROUP])", spec)
+	}
+	level, err := ParseLevel(name)
+	if err != nil {
+		return 0, outputPerm{}, err
+	}
+	p, err := parseOutputPerm(perm)
+	return level, p, err
+}
+
+// applyOutputPerms sets the mode and group of the output at path for a file
+// marked with banner. Level overrides replace the default mode and group
+// they set, and inherit the rest. Remote outputs take the permissions of
+// their store.
+func applyOutputPerms(path string, banner classify.BannerMode) error {
+	if isRemote(path) {
+		return nil
+	}
+	p := outputPerms.Default
+	if level, ok := MarkingLevel(banner.Text); ok {
+		if o, ok := outputPerms.ByLevel[level]; ok {
+			if o.HasMode {
+				p.Mode, p.HasMode = o.Mode, true
+			}
+			if o.Group != "" {
+				p.Group, p.gid = o.Group, o.gid
+			}
+		}
+	}
+	if p.Group != "" {
+		if err := os.Chown(path, -1, p.gid); err != nil {
+			return fmt.Errorf("failed to set group '%s' on output: %w", p.Group, err)
+		}
+	}
+	if p.HasMode {
+		if err := os.Chmod(path, p.Mode); err != nil {
+			return fmt.Errorf("failed to set mode %04o on output: %w", p.Mode, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// perceptualHash reports the perceptual hash of each raster image's original
+// region, so downstream dedup systems can match classified copies with
+// unclassified ones.
+var perceptualHash bool
+
+// cachedHashes holds the hash of each input classified in this run until
+// processCached stores it alongside the output; only used with a cache.
+var cachedHashes sync.Map
+
+func init() {
+	// Hashed in the mark phase, ahead of the banners, once transforms are done
+	registerStage(phaseMark, "phash", func(job *imageJob) error {
+		if !perceptualHash {
+			return nil
+		}
+		job.PHash = fmt.Sprintf("%016x", classify.PerceptualHash(job.Image))
+		reportHash(job.InputPath, job.PHash)
+		if resultCache != nil {
+			cachedHashes.Store(job.InputPath, job.PHash)
+		}
+		return nil
+	})
+}
+
+// reportHash prints and logs the perceptual hash of an input.
+func reportHash(inputPath, hash string) {
+	fmt.Printf(tr("Perceptual hash %s: %s\n"), hash, inputPath)
+	events.Info(fmt.Sprintf("Perceptual hash of '%s' is %s (operator: %s, run: %s)", inputPath, hash, operatorFor(inputPath), runID))
+}
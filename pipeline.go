@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// stagePhase orders pipeline stages. Every image goes through the phases in
+// this order; within a phase, stages run in the order they were registered.
+type stagePhase int
+
+const (
+	phaseDecode    stagePhase = iota // Read the input into an image
+	phaseTransform                   // Adjust the decoded image (color, size, redaction)
+	phaseMark                        // Draw the classification markings
+	phaseEncode                      // Write the marked image to the output file
+	phaseDeliver                     // Check or hand off the written output
+)
+
+// imageJob is one image moving through the pipeline. Stages read and update
+// it in turn.
+type imageJob struct {
+	InputPath    string
+	OutputDir    string
+	Banner       classify.BannerMode
+	BannerHeight int
+	Loc          string
+
+	Image      image.Image // Decoded (and transformed) input
+	Source     string      // Container the input was decoded from, e.g. jpeg or exr
+	Format     string      // Output format: jpeg or png
+	Marked     *image.RGBA // Image with banners, set by the mark phase
+	PHash      string      // Perceptual hash of Image, set by the mark phase with -phash
+	OutputPath string      // Written file, set by the encode phase
+}
+
+// pipelineStage is a named step of the pipeline.
+type pipelineStage struct {
+	Name  string
+	Phase stagePhase
+	Run   func(job *imageJob) error
+}
+
+// pipelineStages are the registered stages, sorted by phase.
+var pipelineStages []pipelineStage
+
+// registerStage adds a stage to the pipeline. Features hook in by registering
+// a stage for their phase from an init function.
+func registerStage(phase stagePhase, name string, run func(job *imageJob) error) {
+	pipelineStages = append(pipelineStages, pipelineStage{Name: name, Phase: phase, Run: run})
+	sort.SliceStable(pipelineStages, func(i, j int) bool { return pipelineStages[i].Phase < pipelineStages[j].Phase })
+}
+
+// runPipeline classifies a raster image by running every stage in order,
+// returning the path of the written output. Errors carry the failed stage.
+func runPipeline(job *imageJob) (string, error) {
+	for _, s := range pipelineStages {
+		if err := s.Run(job); err != nil {
+			return "", atStage(s.Name, err)
+		}
+	}
+	return job.OutputPath, nil
+}
+
+func init() {
+	registerStage(phaseDecode, "decode", func(job *imageJob) error {
+		img, format, err := decodeInput(job.InputPath)
+		if err != nil {
+			return badInput(err)
+		}
+		job.Image, job.Source, job.Format = img, format, format
+		return nil
+	})
+
+	// Convert CMYK (print workflow) JPEGs to RGB before drawing banners
+	registerStage(phaseTransform, "cmyk", func(job *imageJob) error {
+		var converted bool
+		if job.Image, converted = normalizeCMYK(job.Image); converted {
+			fmt.Println(tr("Converted CMYK image to RGB:"), job.InputPath)
+		}
+		return nil
+	})
+
+	// HDR inputs are tone mapped to 8-bit and written in a standard format
+	registerStage(phaseTransform, "tonemap", func(job *imageJob) error {
+		if hdr, ok := job.Image.(*floatImage); ok {
+			job.Image = toneMap(hdr, toneMapping)
+			job.Format = toneMapping.Output
+		}
+		return nil
+	})
+
+	// Inputs tagged with a wide-gamut RGB profile are converted on request
+	registerStage(phaseTransform, "colorspace", func(job *imageJob) error {
+		if colorSpace != "srgb" {
+			return nil
+		}
+		img, err := normalizeColorSpace(job.InputPath, job.Image)
+		job.Image = img
+		return err
+	})
+
+	// Validate supported formats
+	registerStage(phaseTransform, "format", func(job *imageJob) error {
+		// Flattened Photoshop documents and developed raw files are written as PNG
+		if job.Format == "psd" || job.Format == "raw" {
+			job.Format = "png"
+		}
+		// WebP and AVIF are written back as they are unless transcoded with -convert
+		if (job.Format == "webp" || job.Format == "avif") && convertFormat != "" {
+			job.Format = convertFormat
+		}
+		if job.Format != "jpeg" && job.Format != "png" && job.Format != "webp" && job.Format != "avif" {
+			return badInput(fmt.Errorf("unsupported image format '%s' for file: %s", job.Format, job.InputPath))
+		}
+		if job.Format == "webp" || job.Format == "avif" {
+			_, err := externalEncoder(job.Format)
+			return err
+		}
+		return nil
+	})
+
+	// Add the classification banners
+	registerStage(phaseMark, "banner", func(job *imageJob) error {
+		marked, err := renderBanner(job.Image, job.Banner, job.BannerHeight, job.Loc)
+		job.Marked = marked
+		return err
+	})
+
+	registerStage(phaseEncode, "write", writeMarkedImage)
+
+	// The written file is read back and compared when verification is on
+	registerStage(phaseDeliver, "verify", func(job *imageJob) error {
+		if !verifyOutput {
+			return nil
+		}
+		layout, err := classify.LayoutImage(job.Image, markOptions(job.Banner, job.BannerHeight, job.Loc))
+		if err != nil {
+			return err
+		}
+		return verifyImageOutput(job.OutputPath, job.Marked, job.Format, layout.Banners)
+	})
+}
+
+// writeMarkedImage encodes the marked image into the output directory,
+// creating it if it does not exist, switching the extension when the image
+// was converted to another container.
+func writeMarkedImage(job *imageJob) error {
+	outputPath := joinLocation(job.OutputDir, outputName(job.InputPath, job.Source, job.Format))
+	if err := claimOutput(outputPath, job.InputPath); err != nil {
+		return err
+	}
+	outputFile, err := createFile(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer outputFile.Close()
+
+	// Encode and save the new image in the same format as the input
+	if err := encodeImage(outputFile, job.Marked, job.Format); err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	job.OutputPath = outputPath
+	if verifyOutput {
+		return syncAndClose(outputFile)
+	}
+	// Remote outputs are uploaded when closed
+	if err := outputFile.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	return nil
+}
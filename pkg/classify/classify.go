@@ -0,0 +1,220 @@
+// Package classify draws classification banners onto images. It is the
+// rendering core of the goclassifyit command, usable on its own:
+//
+//	img, err := classify.Apply(file, classify.Options{Banner: classify.Presets["secret"]})
+//
+// Banners are drawn above and below the image, so the result is taller than
+// the input by twice the banner extent. In overlay mode they are drawn over
+// the image's top and bottom edges instead, keeping its dimensions.
+// BannerMode.Sides can put them on any of the four edges, with the text of
+// left and right banners running vertically, and a BannerMode.Watermark
+// repeats the marking across the image as well or instead.
+package classify
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Registers the JPEG decoder for Apply
+	_ "image/png"  // Registers the PNG decoder for Apply
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font/opentype"
+)
+
+// DefaultBannerHeight is the classification row height used when
+// Options.BannerHeight is unset.
+const DefaultBannerHeight = 60
+
+// DefaultOverlayOpacity is the banner fill opacity in overlay mode when
+// BannerMode.Opacity is unset.
+const DefaultOverlayOpacity = 0.8
+
+// DefaultCanvasColor fills banner-strip area that no banner covers when
+// BannerMode.CanvasColor is unset.
+var DefaultCanvasColor = color.RGBA{255, 255, 255, 255}
+
+// BannerMode defines the banner properties: background color, text color, and text content.
+type BannerMode struct {
+	BgColor      color.RGBA  // Background color of the banner
+	TextColor    color.RGBA  // Text color of the banner
+	Text         string      // Banner label text
+	Pattern      string      // Background pattern: solid (default), diagonal, stripes, or hatch
+	PatternColor color.RGBA  // Second color used by non-solid patterns
+	PatternWidth int         // Stripe width in pixels for non-solid patterns
+	Rows         []BannerRow // Additional rows stacked beneath the classification row
+
+	SeparatorColor color.RGBA // Color of the line between banner and image
+	SeparatorWidth int        // Thickness of the separator line in pixels (0 disables it)
+
+	FontSize  float64 // Font size in points of the classification row (0 uses the default 36pt)
+	AutoFit   float64 // Percent of each row's height the text is scaled to fill, shrunk to fit the width (0 keeps the font sizes)
+	Overflow  string  // Text too wide for its row: wrap, shrink, truncate, or error, which also fails text too tall (default: error with AutoFit, otherwise drawn as is)
+	Style     string  // Banner style: strip (default, full-width fill) or pill (rounded label)
+	TextAlign string  // Horizontal text alignment in center mode: left, center (default), or right
+
+	Geometry    Geometry   // Optional WxH+X+Y placement of the banner within its strip
+	CanvasColor color.RGBA // Fill of strip area no banner covers, beside a Geometry or around pills (zero uses DefaultCanvasColor)
+
+	Sides   []string // Edges the banners are drawn on, from BannerSides (empty: top and bottom)
+	Overlay bool     // Draw the banners over the image's edges instead of extending it
+	Opacity float64  // Fill opacity from 0 to 1 in overlay mode (0 uses DefaultOverlayOpacity); text stays opaque
+
+	CornerText string // Extra text (e.g. a page number) drawn at half size in a corner of the classification row
+	Corner     string // Corner of CornerText, one of Corners (default bottom-right)
+
+	Portions  []Portion // Regions of the image marked with their own labels
+	Watermark Watermark // Text tiled across the image body, with or instead of the banners
+}
+
+// Presets are the predefined classification banner modes with specific colors and text labels.
+var Presets = map[string]BannerMode{
+	"cui":          {BgColor: color.RGBA{0, 255, 0, 255}, TextColor: color.RGBA{0, 0, 0, 255}, Text: "CUI"},
+	"confidential": {BgColor: color.RGBA{0, 51, 160, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Text: "CONFIDENTIAL"},
+	"secret":       {BgColor: color.RGBA{255, 0, 0, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Text: "SECRET"},
+	"topsecret":    {BgColor: color.RGBA{255, 140, 0, 255}, TextColor: color.RGBA{0, 0, 0, 255}, Text: "TOP SECRET"},
+	"unclassed":    {BgColor: color.RGBA{0, 0, 0, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Text: "UNCLASSIFIED"},
+	"custom":       {BgColor: color.RGBA{255, 255, 255, 255}, TextColor: color.RGBA{0, 0, 0, 255}, Text: "CUSTOM"},
+}
+
+// Preset returns the named entry of Presets, or an error wrapping
+// ErrUnknownPreset.
+func Preset(name string) (BannerMode, error) {
+	if banner, ok := Presets[name]; ok {
+		return banner, nil
+	}
+	names := make([]string, 0, len(Presets))
+	for n := range Presets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return BannerMode{}, fmt.Errorf("%w '%s' (options: %s)", ErrUnknownPreset, name, strings.Join(names, ", "))
+}
+
+// Options describes how an image is marked.
+type Options struct {
+	Banner        BannerMode       // Marking to draw, e.g. one of Presets
+	BannerHeight  int              // Classification row height in pixels (0 uses DefaultBannerHeight)
+	Location      string           // Label placement: center (default) or corners
+	Font          *opentype.Font   // Banner font, e.g. from LoadFont (nil uses the embedded DejaVu Sans Bold)
+	FallbackFonts []*opentype.Font // Fonts consulted, in order, for glyphs missing from the banner font
+}
+
+// withDefaults returns o with unset values filled in.
+func (o Options) withDefaults() Options {
+	if o.BannerHeight <= 0 {
+		o.BannerHeight = DefaultBannerHeight
+	}
+	if o.Banner.CanvasColor == (color.RGBA{}) {
+		o.Banner.CanvasColor = DefaultCanvasColor
+	}
+	if o.Banner.Overlay && o.Banner.Opacity <= 0 {
+		o.Banner.Opacity = DefaultOverlayOpacity
+	}
+	o.Banner.Opacity = min(o.Banner.Opacity, 1)
+	return o
+}
+
+// Apply decodes a PNG or JPEG image from r and returns it with banners.
+func Apply(r io.Reader, opts Options) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return Mark(img, opts)
+}
+
+// Mark returns a copy of img extended with classification banners on the
+// edges BannerMode.Sides lists (top and bottom by default), or with them
+// drawn over its edges in overlay mode, and with any watermark and portions
+// marked on the image. The strips are rendered once per banner and image width and reused
+// across calls.
+func Mark(img image.Image, opts Options) (*image.RGBA, error) {
+	opts = opts.withDefaults()
+	opts.Banner = expandSrcHash(opts.Banner, img)
+	if opts.Banner.Watermark.Set {
+		marked, err := drawWatermark(img, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = marked
+	}
+	if len(opts.Banner.Portions) > 0 {
+		marked, err := drawPortions(img, opts)
+		if err != nil {
+			return nil, err
+		}
+		img = marked
+		opts.Banner.Portions = nil // The strips do not depend on them
+	}
+	if !opts.Banner.TopAndBottom() {
+		return markSides(img, opts)
+	}
+	strip, err := cachedBannerStrip(opts, img.Bounds().Size())
+	if err != nil {
+		return nil, err
+	}
+	return strip.Apply(img)
+}
+
+// BannerExtent returns the height of one banner strip (top or bottom) for an
+// image of the given size: the geometry margin, every row, and the separator.
+// A left or right banner is as thick as the top banner of the image turned on
+// its side.
+func BannerExtent(opts Options, size image.Point) int {
+	opts = opts.withDefaults()
+	banner := opts.Banner
+	place := banner.Geometry.resolve(size, opts.BannerHeight)
+	total := place.Margin + banner.SeparatorWidth
+	for _, row := range bannerRows(banner, place.Height) {
+		total += row.Height
+	}
+	return total
+}
+
+// ParseRGB parses an "R,G,B" color with components from 0 to 255.
+func ParseRGB(str string) (color.RGBA, error) {
+	var r, g, b int
+	_, err := fmt.Sscanf(str, "%d,%d,%d", &r, &g, &b)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("%w format (expected \"R,G,B\"): %w", ErrInvalidColor, err)
+	}
+	if r < 0 || r > 255 || g < 0 || g > 255 || b < 0 || b > 255 {
+		return color.RGBA{}, fmt.Errorf("%w value, each must be between 0 and 255", ErrInvalidColor)
+	}
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}, nil
+}
+
+// AutoTextColor is the text color spec that ParseTextColor resolves to
+// black or white, whichever contrasts more with the background.
+const AutoTextColor = "auto"
+
+// ParseTextColor parses an "R,G,B" text color, or AutoTextColor for the
+// ContrastText of bg.
+func ParseTextColor(str string, bg color.RGBA) (color.RGBA, error) {
+	if strings.EqualFold(strings.TrimSpace(str), AutoTextColor) {
+		return ContrastText(bg), nil
+	}
+	return ParseRGB(str)
+}
+
+// ContrastText returns black or white, whichever has the higher WCAG
+// contrast ratio against bg.
+func ContrastText(bg color.RGBA) color.RGBA {
+	linear := func(c uint8) float64 {
+		v := float64(c) / 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	l := 0.2126*linear(bg.R) + 0.7152*linear(bg.G) + 0.0722*linear(bg.B)
+	// Black wins when (l+0.05)/0.05 exceeds 1.05/(l+0.05)
+	if (l+0.05)*(l+0.05) > 1.05*0.05 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{255, 255, 255, 255}
+}
@@ -0,0 +1,79 @@
+package classify
+
+import (
+	"image"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// cornerFontScale sizes corner text relative to the classification row's font.
+const cornerFontScale = 0.5
+
+// Corners are the positions accepted for BannerMode.Corner.
+var Corners = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// CornerLayout is the placement of BannerMode.CornerText. Rectangles are in
+// output-image coordinates, in the top or bottom banner.
+type CornerLayout struct {
+	Text     string
+	Row      BannerRow       // Colors and font size of the text
+	Bottom   bool            // Drawn in the bottom banner
+	Rect     image.Rectangle // Text box
+	Baseline int             // Y of the text baseline
+}
+
+// cornerRow returns the style of banner's corner text: the classification
+// row's colors at a smaller size.
+func cornerRow(banner BannerMode, rows []BannerRow) BannerRow {
+	row := rows[0]
+	row.Text = banner.CornerText
+	row.FontSize *= cornerFontScale
+	return row
+}
+
+// withCornerRow returns rows plus the corner text's row when there is one,
+// so a face is loaded for it.
+func withCornerRow(banner BannerMode, rows []BannerRow) []BannerRow {
+	if banner.CornerText == "" {
+		return rows
+	}
+	return append(slices.Clone(rows), cornerRow(banner, rows))
+}
+
+// layoutCorner places the corner text at the edge of the classification
+// row, inset like corner labels, and marks the layout as not fitting when it
+// runs into a label.
+func layoutCorner(banner BannerMode, layout *BannerLayout, face font.Face) *CornerLayout {
+	class := layout.Rows[0]
+	row := cornerRow(banner, []BannerRow{class.Row})
+	c := &CornerLayout{Text: row.Text, Row: row, Bottom: !strings.HasPrefix(banner.Corner, "top")}
+
+	rect, labels := class.Rect, class.Labels
+	if c.Bottom {
+		rect = layout.Bottom(rect)
+		labels = nil
+		for _, l := range class.Labels {
+			labels = append(labels, layout.Bottom(l))
+		}
+	}
+	width := measureText(face, row.Text)
+	x := labelPositions(rect, width, "corners", "")
+	if strings.HasSuffix(banner.Corner, "left") {
+		c.Rect = image.Rect(x[0], rect.Min.Y, x[0]+width, rect.Max.Y)
+	} else {
+		c.Rect = image.Rect(x[1], rect.Min.Y, x[1]+width, rect.Max.Y)
+	}
+	c.Baseline = rowBaseline(rect, row.FontSize)
+
+	if !c.Rect.In(rect) {
+		layout.Fits = false
+	}
+	for _, l := range labels {
+		if class.Text != "" && c.Rect.Overlaps(l) {
+			layout.Fits = false
+		}
+	}
+	return c
+}
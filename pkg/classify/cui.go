@@ -0,0 +1,140 @@
+package classify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CUICategories is the registry of CUI category banner markings (32 CFR
+// 2002), by abbreviation. A category is CUI Specified or Basic depending on
+// the authority that applies, so each may be marked with or without the
+// "SP-" prefix. Embedding applications may add categories registered since.
+var CUICategories = map[string]string{
+	"ASYL":      "Asylee",
+	"BUDG":      "Budget",
+	"CEII":      "Critical Energy Infrastructure Information",
+	"CENS":      "Census",
+	"CHRI":      "Criminal History Records Information",
+	"COMPT":     "Comptroller General",
+	"CRIT":      "General Critical Infrastructure Information",
+	"CTI":       "Controlled Technical Information",
+	"CVI":       "Chemical-terrorism Vulnerability Information",
+	"DCNI":      "Unclassified Controlled Nuclear Information - Defense",
+	"DCRIT":     "DoD Critical Infrastructure Security Information",
+	"DNA":       "DNA",
+	"EMGT":      "Emergency Management",
+	"EXPT":      "Export Controlled",
+	"EXPTR":     "Export Controlled Research",
+	"FGJ":       "Federal Grand Jury",
+	"FISA":      "Foreign Intelligence Surveillance Act",
+	"FNC":       "General Financial Information",
+	"GENETIC":   "Genetic Information",
+	"GEO":       "Geodetic Product Information",
+	"HLTH":      "Health Information",
+	"INF":       "Informant",
+	"INTEL":     "General Intelligence",
+	"INTL":      "International Agreement Information",
+	"INV":       "Investigation",
+	"INVENT":    "Inventions",
+	"ISVI":      "Information Systems Vulnerability Information",
+	"JUV":       "Juvenile",
+	"LEI":       "General Law Enforcement",
+	"MERG":      "Mergers",
+	"MFC":       "Proprietary Manufacturer",
+	"MIL":       "Military Personnel Records",
+	"NETW":      "Net Worth",
+	"NNPI":      "Naval Nuclear Propulsion Information",
+	"NUC":       "General Nuclear",
+	"OPSEC":     "Operations Security",
+	"PCII":      "Protected Critical Infrastructure Information",
+	"PERS":      "Personnel Records",
+	"PHYS":      "Physical Security",
+	"POSTAL":    "Proprietary Postal",
+	"PRIVILEGE": "Legal Privilege",
+	"PROCURE":   "General Procurement and Acquisition",
+	"PROPIN":    "General Proprietary Business Information",
+	"PRVCY":     "General Privacy",
+	"RWD":       "Reward",
+	"SAFE":      "SAFETY Act Information",
+	"SGI":       "Safeguards Information",
+	"SSEL":      "Source Selection",
+	"SSI":       "Sensitive Security Information",
+	"STUD":      "Student Records",
+	"TAX":       "Federal Taxpayer Information",
+	"UCNI":      "Unclassified Controlled Nuclear Information - Energy",
+	"VISA":      "Visas",
+	"WATER":     "Water Assessments",
+	"WHSTL":     "Whistleblower Identity",
+	"WIT":       "Witness Protection",
+}
+
+// CUIDissemControls are the CUI limited dissemination control markings, in
+// banner order. REL TO and DISPLAY ONLY take a list of country codes.
+var CUIDissemControls = []string{"NOFORN", "FED ONLY", "FEDCON", "NOCON", "DL ONLY", "REL TO", "DISPLAY ONLY"}
+
+// cuiConflicts are the pairs of dissemination controls that contradict
+// each other.
+var cuiConflicts = [][2]string{
+	{"NOFORN", "REL TO"},
+	{"NOFORN", "DISPLAY ONLY"},
+	{"FED ONLY", "FEDCON"},
+	{"FED ONLY", "NOCON"},
+	{"FEDCON", "NOCON"},
+}
+
+// CUIMarking composes a CUI banner line such as "CUI//SP-PRVCY//NOFORN" from
+// category and dissemination control markings, validating them against
+// CUICategories and CUIDissemControls. Specified categories come before Basic
+// ones, each alphabetically, and the controls follow in registry order, with
+// country lists read and ordered as for Marking.
+func CUIMarking(categories, dissem []string) (string, error) {
+	var specified, basic []string
+	seen := map[string]bool{}
+	for _, c := range categories {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		abbr, sp := strings.CutPrefix(c, "SP-")
+		if _, ok := CUICategories[abbr]; !ok {
+			return "", fmt.Errorf("unknown CUI category '%s' (not in the CUI registry)", c)
+		}
+		if seen[abbr] {
+			return "", fmt.Errorf("CUI category '%s' is listed more than once", abbr)
+		}
+		seen[abbr] = true
+		if sp {
+			specified = append(specified, c)
+		} else {
+			basic = append(basic, c)
+		}
+	}
+	sort.Strings(specified)
+	sort.Strings(basic)
+
+	controls, err := readControls(dissem, CUIDissemControls, nil, "CUI dissemination control")
+	if err != nil {
+		return "", err
+	}
+	for _, pair := range cuiConflicts {
+		_, a := controls[pair[0]]
+		_, b := controls[pair[1]]
+		if a && b {
+			return "", fmt.Errorf("CUI dissemination controls %s and %s cannot be combined", pair[0], pair[1])
+		}
+	}
+
+	parts := []string{"CUI"}
+	if cats := append(specified, basic...); len(cats) > 0 {
+		parts = append(parts, strings.Join(cats, "/"))
+	}
+	marks, err := formatControls(controls, CUIDissemControls)
+	if err != nil {
+		return "", err
+	}
+	if len(marks) > 0 {
+		parts = append(parts, strings.Join(marks, "/"))
+	}
+	return strings.Join(parts, "//"), nil
+}
@@ -0,0 +1,101 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// LoadFallbackFonts parses a comma-separated list of TTF/OTF (or TTC) paths,
+// for use as Options.FallbackFonts.
+func LoadFallbackFonts(list string) ([]*opentype.Font, error) {
+	var fonts []*opentype.Font
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read fallback font: %w", err)
+		}
+		// Font collections hold several faces; the first is used
+		f, err := parseFontData(data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse fallback font '%s': %w", path, err)
+		}
+		fonts = append(fonts, f)
+	}
+	return fonts, nil
+}
+
+// fallbackFace is a font.Face that renders each rune with the first font in
+// the chain that has a glyph for it, so mixed-script text renders completely.
+type fallbackFace struct {
+	fonts []*opentype.Font
+	faces []font.Face
+	buf   sfnt.Buffer
+}
+
+// newFallbackFace builds faces of the given size for primary followed by fallbacks.
+func newFallbackFace(primary *opentype.Font, fallbacks []*opentype.Font, opts *opentype.FaceOptions) (font.Face, error) {
+	ff := &fallbackFace{}
+	for _, f := range append([]*opentype.Font{primary}, fallbacks...) {
+		face, err := opentype.NewFace(f, opts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create font face: %w", err)
+		}
+		ff.fonts = append(ff.fonts, f)
+		ff.faces = append(ff.faces, face)
+	}
+	return ff, nil
+}
+
+// faceFor returns the index of the first face with a glyph for r, or 0 so the
+// primary font's missing-glyph box is drawn when no font covers it.
+func (f *fallbackFace) faceFor(r rune) int {
+	for i, fnt := range f.fonts {
+		if idx, err := fnt.GlyphIndex(&f.buf, r); err == nil && idx != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faces[f.faceFor(r)].Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.faces[f.faceFor(r)].GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faces[f.faceFor(r)].GlyphAdvance(r)
+}
+
+// Kern only applies between runes rendered by the same font.
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	i := f.faceFor(r0)
+	if i != f.faceFor(r1) {
+		return 0
+	}
+	return f.faces[i].Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
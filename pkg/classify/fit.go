@@ -0,0 +1,219 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// MinAutoFitFontSize is the smallest point size auto-fit and the shrink and
+// wrap overflow policies shrink text to. Auto-fitted text that still does
+// not fit fails with ErrTextOverflow unless BannerMode.Overflow says
+// otherwise; the shrink and wrap policies truncate it with an ellipsis.
+const MinAutoFitFontSize = 8
+
+// ellipsis marks truncated text.
+const ellipsis = "…"
+
+// OverflowPolicies are the values accepted for BannerMode.Overflow.
+var OverflowPolicies = []string{"wrap", "shrink", "truncate", "error"}
+
+// fitBanner returns opts with the text of every row sized by
+// BannerMode.AutoFit for an image of the given size: as large as fills
+// AutoFit percent of the row height, shrunk so each label spans at most
+// AutoFit percent of the width it may use. Text that is still too wide is
+// then handled by BannerMode.Overflow. Corner text follows the
+// classification row.
+func fitBanner(opts Options, size image.Point) (Options, error) {
+	banner := opts.Banner
+	if banner.AutoFit <= 0 && banner.Overflow == "" {
+		return opts, nil
+	}
+	place := banner.Geometry.resolve(size, opts.BannerHeight)
+	rows := bannerRows(banner, place.Height)
+	fitted := make([]BannerRow, len(rows))
+	for i, row := range rows {
+		var err error
+		if fitted[i], err = fitRow(row, place.Width, opts); err != nil {
+			return opts, err
+		}
+	}
+	banner.Text, banner.FontSize = fitted[0].Text, fitted[0].FontSize
+	banner.Rows = slices.Clone(fitted[1:])
+	opts.Banner = banner
+	return opts, nil
+}
+
+// fitRow sizes row's text for a row width pixels wide.
+func fitRow(row BannerRow, width int, opts Options) (BannerRow, error) {
+	banner := opts.Banner
+
+	// Labels keep the 5% margins; corner labels share the row
+	avail := float64(width) * 0.9
+	if opts.Location == "corners" {
+		avail /= 2
+	}
+	if banner.Style == "pill" {
+		avail -= float64(row.Height * 7 / 10)
+	}
+	if banner.AutoFit <= 0 {
+		return overflowRow(row, avail, opts)
+	}
+	share := banner.AutoFit / 100
+	avail *= share
+
+	// Text height and width grow in proportion to the size, so one
+	// measurement at the current size gives both limits
+	face, err := loadFontFace(row.FontSize, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return row, fmt.Errorf("failed to load font face: %w", err)
+	}
+	m := face.Metrics()
+	textHeight := float64(m.Ascent+m.Descent) / 64
+	textWidth := float64(measureText(face, row.Text))
+	face.Close()
+
+	size := row.FontSize * share * float64(row.Height) / textHeight
+	if textWidth > 0 {
+		size = math.Min(size, row.FontSize*avail/textWidth)
+	}
+	row.FontSize = math.Max(math.Floor(size*2)/2, MinAutoFitFontSize)
+
+	// Hinting rounds glyph advances, so the width is checked at the final size
+	row, err = shrinkRow(row, avail, opts)
+	if err != nil {
+		return row, err
+	}
+	return overflowRow(row, avail, opts)
+}
+
+// shrinkRow steps row's font size down until its text is at most avail
+// pixels wide or it reaches MinAutoFitFontSize.
+func shrinkRow(row BannerRow, avail float64, opts Options) (BannerRow, error) {
+	measured, err := textWidthAt(row.Text, row.FontSize, opts)
+	for err == nil && measured > avail && row.FontSize > MinAutoFitFontSize {
+		row.FontSize = math.Max(row.FontSize-0.5, MinAutoFitFontSize)
+		measured, err = textWidthAt(row.Text, row.FontSize, opts)
+	}
+	return row, err
+}
+
+// overflowRow applies BannerMode.Overflow to row when its text is wider than
+// avail pixels, or for the error policy also taller than the row. Without a
+// policy, auto-fitted text fails, so no part of a marking is dropped unasked,
+// and other text is left to run past its row.
+func overflowRow(row BannerRow, avail float64, opts Options) (BannerRow, error) {
+	policy := opts.Banner.Overflow
+	if policy == "" && opts.Banner.AutoFit > 0 {
+		policy = "error"
+	}
+	if policy == "error" {
+		return row, checkRowFits(row, avail, opts)
+	}
+	measured, err := textWidthAt(row.Text, row.FontSize, opts)
+	if err != nil || measured <= avail || policy == "" {
+		return row, err
+	}
+
+	switch policy {
+	case "wrap":
+		return wrapRow(row, avail, opts)
+	case "shrink":
+		if row, err = shrinkRow(row, avail, opts); err != nil {
+			return row, err
+		}
+	}
+
+	// Text that still does not fit is cut short, keeping whole characters
+	face, err := loadFontFace(row.FontSize, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return row, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+	row.Text = truncateText(face, row.Text, avail)
+	return row, nil
+}
+
+// checkRowFits returns an ErrTextOverflow error when row's text is wider
+// than avail pixels or its lines are taller than the row.
+func checkRowFits(row BannerRow, avail float64, opts Options) error {
+	measured, err := textWidthAt(row.Text, row.FontSize, opts)
+	if err != nil {
+		return err
+	}
+	if measured > avail {
+		return fmt.Errorf("%w: '%s' is %d pixels wide at %gpt, but its row leaves %d", ErrTextOverflow, row.Text, int(math.Ceil(measured)), row.FontSize, int(avail))
+	}
+	face, err := loadFontFace(row.FontSize, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return fmt.Errorf("failed to load font face: %w", err)
+	}
+	m := face.Metrics()
+	face.Close()
+	lines := strings.Count(row.Text, "\n") + 1
+	if height := (lines-1)*m.Height.Ceil() + (m.Ascent + m.Descent).Ceil(); height > row.Height {
+		return fmt.Errorf("%w: '%s' is %d pixels tall at %gpt, but its row is %d", ErrTextOverflow, row.Text, height, row.FontSize, row.Height)
+	}
+	return nil
+}
+
+// wrapRow breaks row's text into lines at most avail pixels wide, shrinking
+// the font until the lines also fit the row's height. At MinAutoFitFontSize
+// the lines that do not fit are cut short.
+func wrapRow(row BannerRow, avail float64, opts Options) (BannerRow, error) {
+	for {
+		face, err := loadFontFace(row.FontSize, opts.Font, opts.FallbackFonts)
+		if err != nil {
+			return row, fmt.Errorf("failed to load font face: %w", err)
+		}
+		// Words are only split once the font cannot shrink further
+		smallest := row.FontSize <= MinAutoFitFontSize
+		lines := wrapText(face, row.Text, avail, smallest)
+		m := face.Metrics()
+		step, height := m.Height.Ceil(), (m.Ascent + m.Descent).Ceil()
+		fits := (len(lines)-1)*step+height <= row.Height
+		for _, line := range lines {
+			fits = fits && float64(measureText(face, line)) <= avail
+		}
+
+		if !fits && smallest {
+			// Lines past the last that fits are joined into it and cut short
+			if n := max(1, 1+(row.Height-height)/step); len(lines) > n {
+				wrapped := wrapLines(face, row.Text, avail, true)
+				lines = append(lines[:n-1], joinLines(wrapped[n-1:]))
+			}
+			for i, line := range lines {
+				if float64(measureText(face, line)) > avail {
+					lines[i] = truncateText(face, line, avail)
+				}
+			}
+			fits = true
+		}
+		face.Close()
+		if fits {
+			row.Text = strings.Join(lines, "\n")
+			return row, nil
+		}
+		row.FontSize = math.Max(row.FontSize-0.5, MinAutoFitFontSize)
+	}
+}
+
+// truncateText cuts text short with an ellipsis so it is at most avail
+// pixels wide, keeping whole characters.
+func truncateText(face font.Face, text string, avail float64) string {
+	runes := []rune(text)
+	for len(runes) > 0 && float64(measureText(face, string(runes)+ellipsis)) > avail {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), " ") + ellipsis
+}
+
+// textWidthAt returns the width in pixels of text at fontSize points.
+func textWidthAt(text string, fontSize float64, opts Options) (float64, error) {
+	width, err := MeasureText(text, fontSize, opts.Font, opts.FallbackFonts)
+	return float64(width), err
+}
@@ -0,0 +1,51 @@
+package classify
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestAutoFitOverflow(t *testing.T) {
+	text := "SECRET//NOFORN//HANDLE VIA SPECIAL CHANNELS ONLY//ORCON"
+	tests := []struct {
+		overflow string
+		wantErr  bool
+	}{
+		{"", true}, // The marking is never cut short unasked
+		{"error", true},
+		{"truncate", false},
+	}
+	for _, tt := range tests {
+		banner := Presets["secret"]
+		banner.Text, banner.AutoFit, banner.Overflow = text, 70, tt.overflow
+		opts := Options{Banner: banner, BannerHeight: 20}
+		fitted, err := fitBanner(opts, image.Pt(80, 80))
+		if got := errors.Is(err, ErrTextOverflow); got != tt.wantErr {
+			t.Errorf("overflow %q: err = %v, want ErrTextOverflow %t", tt.overflow, err, tt.wantErr)
+		}
+		if err == nil && fitted.Banner.Text == text {
+			t.Errorf("overflow %q: text was not truncated", tt.overflow)
+		}
+	}
+}
+
+func TestErrorOverflowHeight(t *testing.T) {
+	banner := Presets["secret"]
+	banner.Overflow = "error"
+	banner.FontSize = 36
+	tests := []struct {
+		rowHeight int
+		wantErr   bool
+	}{
+		{60, false},
+		{20, true}, // 36pt text fits the width of a wide image, but not a 20-pixel row
+	}
+	for _, tt := range tests {
+		opts := Options{Banner: banner, BannerHeight: tt.rowHeight}
+		_, err := fitBanner(opts, image.Pt(2000, 200))
+		if got := errors.Is(err, ErrTextOverflow); got != tt.wantErr {
+			t.Errorf("row height %d: err = %v, want ErrTextOverflow %t", tt.rowHeight, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package classify
+
+import (
+	"embed"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+//go:embed fonts/DejaVuSans-Bold.ttf
+var fontData embed.FS
+
+// parseBannerFont parses the embedded TTF font.
+func parseBannerFont() (*opentype.Font, error) {
+	fontBytes, err := fontData.ReadFile("fonts/DejaVuSans-Bold.ttf")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read embedded font: %w", err)
+	}
+	tt, err := opentype.Parse(fontBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font: %w", err)
+	}
+	return tt, nil
+}
+
+// LoadFont returns the font named by spec, for use as Options.Font: a
+// TTF/OTF (or TTC) file path, or else the family name of an installed font
+// such as "Arial" or "Liberation Sans", whose bold face is preferred.
+func LoadFont(spec string) (*opentype.Font, error) {
+	path := spec
+	if _, err := os.Stat(spec); err != nil {
+		if path, err = FindSystemFont(spec); err != nil {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read font: %w", err)
+	}
+	f, err := parseFontData(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse font '%s': %w", path, err)
+	}
+	return f, nil
+}
+
+// parseFontData parses a font file, taking the first face of a collection.
+func parseFontData(data []byte) (*opentype.Font, error) {
+	f, err := opentype.Parse(data)
+	if err == nil {
+		return f, nil
+	}
+	coll, cerr := opentype.ParseCollection(data)
+	if cerr != nil {
+		return nil, err
+	}
+	return coll.Font(0)
+}
+
+// loadFontFace returns a font.Face at a specified size of primary, or of the
+// embedded font when primary is nil.
+func loadFontFace(fontSize float64, primary *opentype.Font, fallbacks []*opentype.Font) (font.Face, error) {
+	tt := primary
+	if tt == nil {
+		var err error
+		if tt, err = parseBannerFont(); err != nil {
+			return nil, err
+		}
+	}
+	opts := &opentype.FaceOptions{
+		Size:    fontSize,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	}
+	if len(fallbacks) > 0 {
+		return newFallbackFace(tt, fallbacks, opts)
+	}
+	face, err := opentype.NewFace(tt, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create font face: %w", err)
+	}
+	return face, nil
+}
+
+// drawRowText draws a row's text inside rect in either "corners" or "center" mode,
+// using align for the single label of center mode. When pill is non-nil, each
+// label is drawn on a rounded pill filled with it.
+func drawRowText(img *image.RGBA, rect image.Rectangle, row BannerRow, face font.Face, loc, align string, pill image.Image) {
+	// Measure the text width so we can align it horizontally
+	lines, txtWidth := labelLines(face, row.Text)
+	baselines := lineBaselines(rect, row.FontSize, face, len(lines))
+
+	for _, x := range labelPositions(rect, txtWidth, loc, align) {
+		if pill != nil {
+			drawPill(img, pillRect(rect, x, txtWidth), pill)
+		}
+		for i, line := range lines {
+			addLabel(img, line.Text, x+lineOffset(txtWidth, line.Width, loc, align), baselines[i], row.TextColor, face)
+		}
+	}
+}
+
+// textLine is one line of a label; wrapped labels have several, separated
+// by newlines in the row text.
+type textLine struct {
+	Text  string
+	Width int
+}
+
+// labelLines splits text into its lines and measures each, returning them
+// with the width of the widest.
+func labelLines(face font.Face, text string) ([]textLine, int) {
+	var lines []textLine
+	widest := 0
+	for _, s := range strings.Split(text, "\n") {
+		w := measureText(face, s)
+		lines = append(lines, textLine{s, w})
+		widest = max(widest, w)
+	}
+	return lines, widest
+}
+
+// lineBaselines returns the baseline Y of each of n lines set in face,
+// centered as a block in rect. A single line sits where rowBaseline puts it.
+func lineBaselines(rect image.Rectangle, fontSize float64, face font.Face, n int) []int {
+	if n <= 1 {
+		return []int{rowBaseline(rect, fontSize)}
+	}
+	m := face.Metrics()
+	step := m.Height.Ceil()
+	block := (n-1)*step + (m.Ascent + m.Descent).Ceil()
+	first := rect.Min.Y + (rect.Dy()-block)/2 + m.Ascent.Ceil()
+	baselines := make([]int, n)
+	for i := range baselines {
+		baselines[i] = first + i*step
+	}
+	return baselines
+}
+
+// lineOffset returns the X offset of a line of lineWidth within a label of
+// labelWidth: lines are centered unless center mode aligns the label left
+// or right.
+func lineOffset(labelWidth, lineWidth int, loc, align string) int {
+	switch {
+	case loc == "corners":
+	case align == "left":
+		return 0
+	case align == "right":
+		return labelWidth - lineWidth
+	}
+	return (labelWidth - lineWidth) / 2
+}
+
+// rowBaseline returns the baseline Y of a label of fontSize centered
+// vertically in rect.
+func rowBaseline(rect image.Rectangle, fontSize float64) int {
+	// The offset was tuned for 36pt and scales with size
+	return rect.Min.Y + rect.Dy()/2 + int(fontSize*10/36)
+}
+
+// labelPositions returns the X coordinates at which a label of txtWidth is drawn
+// inside rect for the given location mode and alignment.
+func labelPositions(rect image.Rectangle, txtWidth int, loc, align string) []int {
+	width := rect.Dx()
+
+	// 5% of width margin
+	marginX := int(0.05 * float64(width))
+
+	switch loc {
+	case "corners":
+		// LEFT and RIGHT
+		return []int{rect.Min.X + marginX, rect.Max.X - marginX - txtWidth}
+
+	default: // "center" or anything else
+		switch align {
+		case "left":
+			return []int{rect.Min.X + marginX}
+		case "right":
+			return []int{rect.Max.X - marginX - txtWidth}
+		}
+		// X coordinate for center
+		return []int{rect.Min.X + width/2 - (txtWidth / 2)}
+	}
+}
+
+// measureText returns the width of the given text (in pixels) for the specified font face.
+func measureText(face font.Face, text string) int {
+	d := &font.Drawer{
+		Face: face,
+	}
+	return d.MeasureString(text).Round()
+}
+
+// addLabel draws the text at the exact (x, y) coordinate without additional horizontal shifting.
+func addLabel(img *image.RGBA, text string, x, y int, col color.RGBA, face font.Face) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{col},
+		Face: face,
+		Dot: fixed.Point26_6{
+			X: fixed.I(x),
+			Y: fixed.I(y),
+		},
+	}
+	d.DrawString(text)
+}
@@ -0,0 +1,148 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// geometryRe matches ImageMagick-style WxH+X+Y strings, where every part is
+// optional and each may be a percentage.
+var geometryRe = regexp.MustCompile(`^(\d+(?:\.\d+)?%?)?(?:x(\d+(?:\.\d+)?%?))?([+-]\d+(?:\.\d+)?%?)?([+-]\d+(?:\.\d+)?%?)?$`)
+
+// GeometryValue is one component of a geometry string, in pixels or as a
+// percentage of the image width or height.
+type GeometryValue struct {
+	Value   float64
+	Percent bool
+}
+
+// Geometry places the banner inside its strip: Width and Height size
+// the banner (0 means full width and the -h height), X offsets it from the
+// left edge (from the right when FromRight is set), and Y insets it from the
+// outer edge of the image. The zero value is the full-width default.
+type Geometry struct {
+	Set       bool
+	Width     GeometryValue
+	Height    GeometryValue
+	X         GeometryValue
+	Y         GeometryValue
+	FromRight bool
+}
+
+// bannerPlacement is a geometry resolved to pixels for one image.
+type bannerPlacement struct {
+	X, Width int // Horizontal extent of the banner rows
+	Height   int // Height of the classification row
+	Margin   int // Gap between the outer image edge and the banner
+}
+
+// ParseGeometry parses a WxH+X+Y geometry string such as "100x0+0+0" or
+// "50%x8%+25%+2%".
+func ParseGeometry(spec string) (Geometry, error) {
+	m := geometryRe.FindStringSubmatch(spec)
+	if spec == "" || m == nil {
+		return Geometry{}, fmt.Errorf("invalid geometry '%s' (use WxH+X+Y, e.g. 100x0+0+0 or 50%%x8%%+25%%+0)", spec)
+	}
+	g := Geometry{Set: true}
+	parts := []*GeometryValue{&g.Width, &g.Height, &g.X, &g.Y}
+	for i, part := range m[1:] {
+		if part == "" {
+			continue
+		}
+		v, err := parseGeometryValue(part)
+		if err != nil {
+			return Geometry{}, fmt.Errorf("invalid geometry '%s': %w", spec, err)
+		}
+		*parts[i] = v
+	}
+	if g.X.Value < 0 || m[3] == "-0" {
+		g.FromRight = true
+		g.X.Value = -g.X.Value
+	}
+	if g.Y.Value < 0 {
+		return Geometry{}, fmt.Errorf("invalid geometry '%s': the Y offset must not be negative", spec)
+	}
+	return g, nil
+}
+
+// parseGeometryValue parses a number with an optional trailing percent sign.
+func parseGeometryValue(s string) (GeometryValue, error) {
+	var v GeometryValue
+	if n := len(s); s[n-1] == '%' {
+		v.Percent = true
+		s = s[:n-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return v, err
+	}
+	v.Value = f
+	return v, nil
+}
+
+// pixels resolves v against a dimension of size pixels.
+func (v GeometryValue) pixels(size int) int {
+	if v.Percent {
+		return int(math.Round(v.Value * float64(size) / 100))
+	}
+	return int(math.Round(v.Value))
+}
+
+// Scale returns g with its pixel components multiplied by factor;
+// percentages already follow the image size.
+func (g Geometry) Scale(factor float64) Geometry {
+	for _, v := range []*GeometryValue{&g.Width, &g.Height, &g.X, &g.Y} {
+		if !v.Percent {
+			v.Value *= factor
+		}
+	}
+	return g
+}
+
+// HasPercent reports whether any component of g is relative to the image size.
+func (g Geometry) HasPercent() bool {
+	return g.Width.Percent || g.Height.Percent || g.X.Percent || g.Y.Percent
+}
+
+// Absolute returns g resolved against an image of the given size, with every
+// component in pixels.
+func (g Geometry) Absolute(size image.Point, bannerHeight int) Geometry {
+	if !g.Set {
+		return g
+	}
+	p := g.resolve(size, bannerHeight)
+	return Geometry{
+		Set:    true,
+		Width:  GeometryValue{Value: float64(p.Width)},
+		Height: GeometryValue{Value: float64(p.Height)},
+		X:      GeometryValue{Value: float64(p.X)},
+		Y:      GeometryValue{Value: float64(p.Margin)},
+	}
+}
+
+// resolve places the banner for an image of the given size. Widths and X
+// offsets are relative to the image width, heights and Y to its height.
+func (g Geometry) resolve(size image.Point, bannerHeight int) bannerPlacement {
+	if !g.Set {
+		return bannerPlacement{Width: size.X, Height: bannerHeight}
+	}
+	p := bannerPlacement{
+		X:      min(max(g.X.pixels(size.X), 0), size.X),
+		Width:  g.Width.pixels(size.X),
+		Height: g.Height.pixels(size.Y),
+		Margin: g.Y.pixels(size.Y),
+	}
+	if p.Width <= 0 || p.Width > size.X-p.X {
+		p.Width = size.X - p.X
+	}
+	if p.Height <= 0 {
+		p.Height = bannerHeight
+	}
+	if g.FromRight {
+		p.X = size.X - p.X - p.Width
+	}
+	return p
+}
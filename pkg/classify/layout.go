@@ -0,0 +1,204 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+// BannerLayout is where the banners of an image would be drawn, computed
+// without rendering anything. Rectangles are in output-image coordinates.
+type BannerLayout struct {
+	Size    image.Point       // Size of the classified output
+	Offset  image.Point       // Position of the image's top-left corner on the output
+	Sides   []string          // Edges with a banner, in BannerSides order
+	Banners []image.Rectangle // Area of the banner on each of Sides
+	Extent  int               // Height of the top and bottom strips, including margin and separator (0 without them)
+	Rows    []RowLayout       // Rows of the top banner, outermost first; the bottom banner mirrors them
+	Fits    bool              // Every label fits inside its row
+	Corner  *CornerLayout     // Placement of the corner text; nil without one
+	Side    *BannerLayout     // Left and right banners, laid out as top banners of the image turned on its side; nil without them
+}
+
+// RowLayout is the placement of one banner row and its labels.
+type RowLayout struct {
+	Text       string            // Row label text
+	Row        BannerRow         // The row's colors, font size, and height
+	Rect       image.Rectangle   // Row area in the top banner
+	Baseline   int               // Y of the label baseline in the top banner
+	TextWidth  int               // Width of the label (its widest line) in pixels
+	TextHeight int               // Height of the label's lines (ascent plus descent for one) in pixels
+	Lines      []LineLayout      // Lines of the label, one unless it was wrapped
+	Labels     []image.Rectangle // Label boxes (pills in pill style), one per label drawn
+	Fits       bool              // Each label lies within the row and labels do not overlap
+}
+
+// LineLayout is the placement of one line of a row's label.
+type LineLayout struct {
+	Text     string
+	X        int // Offset from the left edge of the label's text
+	Width    int // Width of the line in pixels
+	Baseline int // Y of the line's baseline in the top banner
+}
+
+// MeasureText returns the width in pixels of text set at fontSize points in
+// primary (nil for the embedded banner font), using fallbacks for glyphs it
+// lacks.
+func MeasureText(text string, fontSize float64, primary *opentype.Font, fallbacks []*opentype.Font) (int, error) {
+	face, err := loadFontFace(fontSize, primary, fallbacks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+	return measureText(face, text), nil
+}
+
+// LayoutBanner computes the banner layout for an image of the given size, so
+// callers can check whether a marking fits before submitting the image.
+func LayoutBanner(opts Options, size image.Point) (*BannerLayout, error) {
+	opts, err := fitBanner(opts.withDefaults(), size)
+	if err != nil {
+		return nil, err
+	}
+	place := opts.Banner.Geometry.resolve(size, opts.BannerHeight)
+	faces, err := loadRowFaces(withCornerRow(opts.Banner, bannerRows(opts.Banner, place.Height)), opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return nil, err
+	}
+	return layoutSides(opts, size, layoutBanner(opts, size, faces))
+}
+
+// LayoutImage computes the layout Mark uses for img, with source hash
+// tokens expanded, so the labels match what is drawn.
+func LayoutImage(img image.Image, opts Options) (*BannerLayout, error) {
+	opts = opts.withDefaults()
+	opts.Banner = expandSrcHash(opts.Banner, img)
+	return LayoutBanner(opts, img.Bounds().Size())
+}
+
+// Bottom returns the bottom-banner counterpart of a rectangle in the top banner.
+func (l *BannerLayout) Bottom(r image.Rectangle) image.Rectangle {
+	return image.Rect(r.Min.X, l.Size.Y-r.Max.Y, r.Max.X, l.Size.Y-r.Min.Y)
+}
+
+// Clipped describes each label that would be cut off when drawn: one wider
+// or taller than its row, one reaching past the image edge, or corner text
+// running out of the classification row. It is empty when every label is
+// drawn whole.
+func (l *BannerLayout) Clipped() []string {
+	var clipped []string
+	bounds := image.Rect(0, 0, l.Size.X, l.Size.Y)
+	for i, r := range l.Rows {
+		if r.Text == "" {
+			continue
+		}
+		text := strings.ReplaceAll(r.Text, "\n", " ")
+		switch {
+		case r.TextWidth > r.Rect.Dx():
+			clipped = append(clipped, fmt.Sprintf("row %d label '%s' is %d pixels wide, but its row is %d", i+1, text, r.TextWidth, r.Rect.Dx()))
+		case r.TextHeight > r.Rect.Dy():
+			clipped = append(clipped, fmt.Sprintf("row %d label '%s' is %d pixels tall, but its row is %d", i+1, text, r.TextHeight, r.Rect.Dy()))
+		default:
+			for _, box := range r.Labels {
+				if !box.In(r.Rect) {
+					clipped = append(clipped, fmt.Sprintf("row %d label '%s' runs past the edge of its row", i+1, text))
+					break
+				}
+				if !box.In(bounds) {
+					clipped = append(clipped, fmt.Sprintf("row %d label '%s' runs past the edge of the %dx%d image", i+1, text, l.Size.X, l.Size.Y))
+					break
+				}
+			}
+		}
+	}
+	if c := l.Corner; c != nil && len(l.Rows) > 0 {
+		rect := l.Rows[0].Rect
+		if c.Bottom {
+			rect = l.Bottom(rect)
+		}
+		if !c.Rect.In(rect) || !c.Rect.In(bounds) {
+			clipped = append(clipped, fmt.Sprintf("corner text '%s' runs past the edge of its row", c.Text))
+		}
+	}
+	if l.Side != nil {
+		for _, c := range l.Side.Clipped() {
+			clipped = append(clipped, "side banner "+c)
+		}
+	}
+	return clipped
+}
+
+// loadRowFaces loads one font face per distinct row font size.
+func loadRowFaces(rows []BannerRow, primary *opentype.Font, fallbacks []*opentype.Font) (map[float64]font.Face, error) {
+	faces := map[float64]font.Face{}
+	for _, row := range rows {
+		if _, ok := faces[row.FontSize]; ok {
+			continue
+		}
+		face, err := loadFontFace(row.FontSize, primary, fallbacks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load font face: %w", err)
+		}
+		faces[row.FontSize] = face
+	}
+	return faces, nil
+}
+
+// layoutBanner lays out the banner rows using the faces loaded for them.
+func layoutBanner(opts Options, size image.Point, faces map[float64]font.Face) *BannerLayout {
+	banner := opts.Banner
+	place := banner.Geometry.resolve(size, opts.BannerHeight)
+	extent := BannerExtent(opts, size)
+	layout := &BannerLayout{Size: image.Pt(size.X, size.Y+2*extent), Extent: extent, Fits: true}
+	if banner.Overlay {
+		layout.Size = size
+	}
+
+	// Rows stack downward from the top edge, after any geometry margin
+	y := place.Margin
+	for _, row := range bannerRows(banner, place.Height) {
+		rect := image.Rect(place.X, y, place.X+place.Width, y+row.Height)
+		y += row.Height
+
+		face := faces[row.FontSize]
+		m := face.Metrics()
+		lines, width := labelLines(face, row.Text)
+		baselines := lineBaselines(rect, row.FontSize, face, len(lines))
+		r := RowLayout{
+			Text:       row.Text,
+			Row:        row,
+			Rect:       rect,
+			Baseline:   baselines[0],
+			TextWidth:  width,
+			TextHeight: (len(lines)-1)*m.Height.Ceil() + (m.Ascent + m.Descent).Ceil(),
+		}
+		for i, line := range lines {
+			r.Lines = append(r.Lines, LineLayout{Text: line.Text, X: lineOffset(width, line.Width, opts.Location, banner.TextAlign), Width: line.Width, Baseline: baselines[i]})
+		}
+		r.Fits = r.TextHeight <= rect.Dy()
+		for _, x := range labelPositions(rect, r.TextWidth, opts.Location, banner.TextAlign) {
+			box := image.Rect(x, rect.Min.Y, x+r.TextWidth, rect.Max.Y)
+			if banner.Style == "pill" {
+				box = pillRect(rect, x, r.TextWidth)
+			}
+			if !box.In(rect) {
+				r.Fits = false
+			}
+			for _, prev := range r.Labels {
+				if box.Overlaps(prev) {
+					r.Fits = false
+				}
+			}
+			r.Labels = append(r.Labels, box)
+		}
+		layout.Fits = layout.Fits && r.Fits
+		layout.Rows = append(layout.Rows, r)
+	}
+	if banner.CornerText != "" {
+		layout.Corner = layoutCorner(banner, layout, faces[cornerRow(banner, bannerRows(banner, place.Height)).FontSize])
+	}
+	return layout
+}
@@ -0,0 +1,182 @@
+package classify
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+)
+
+// noBreakBefore are characters a line must not start with: closing
+// brackets and quotes, trailing punctuation (including French punctuation
+// set after a space), and the CJK marks and small kana that kinsoku rules
+// keep with the character before them.
+const noBreakBefore = ")]}»›”’!?:;,.%" +
+	"、。，．・：；？！」』）】〕〉》ー々ゝゞヽヾ" +
+	"ぁぃぅぇぉっゃゅょゎァィゥェォッャュョヮヵヶ"
+
+// noBreakAfter are characters a line must not end with: opening brackets
+// and quotes.
+const noBreakAfter = "([{«‹“‘「『（【〔〈《"
+
+// breakUnits splits a paragraph into the units lines are built from. Each
+// unit ends where a line may break, keeping the spaces that follow it, so
+// the units join back into the paragraph and a line is whole units with its
+// trailing spaces trimmed. Lines may break:
+//
+//   - after spaces, but not before closing punctuation or after opening
+//     brackets, so French "« SECRET »" and "NOFORN !" stay together
+//   - after "/" and "//", the separators of marking lines
+//   - after a hyphen or dash between letters or digits
+//   - between Chinese and Japanese characters, which are written without
+//     spaces, except before a mark kinsoku rules keep with its predecessor
+func breakUnits(paragraph string) []string {
+	runes := []rune(paragraph)
+	var units []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if canBreak(runes, i) {
+			units = append(units, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		units = append(units, string(runes[start:]))
+	}
+	return units
+}
+
+// canBreak reports whether a line may break before runes[i].
+func canBreak(runes []rune, i int) bool {
+	prev, next := runes[i-1], runes[i]
+	if unicode.IsSpace(next) || strings.ContainsRune(noBreakBefore, next) {
+		return false
+	}
+	if unicode.IsSpace(prev) {
+		// The last character before the spaces decides
+		j := i - 1
+		for j > 0 && unicode.IsSpace(runes[j-1]) {
+			j--
+		}
+		return j == 0 || !strings.ContainsRune(noBreakAfter, runes[j-1])
+	}
+	if strings.ContainsRune(noBreakAfter, prev) {
+		return false
+	}
+	switch {
+	case prev == '/':
+		return next != '/'
+	case next == '/':
+		return false
+	case prev == '-' || prev == '‐' || prev == '–' || prev == '—':
+		return i >= 2 && isWordRune(runes[i-2]) && isWordRune(next)
+	}
+	return isIdeographic(prev) || isIdeographic(next)
+}
+
+// isWordRune reports whether r is a letter or digit.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isIdeographic reports whether r belongs to a script written without
+// spaces between words, whose lines may break between any two characters.
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// wrappedLine is one line of wrapped text.
+type wrappedLine struct {
+	Text string // The line as drawn
+	Raw  string // The text the line took, with its trailing spaces and no added hyphen
+	End  bool   // The line ends a paragraph
+}
+
+// wrapText breaks text into lines of at most avail pixels at the break
+// opportunities of breakUnits, keeping its explicit line breaks. A unit
+// wider than avail gets a line of its own, or with hyphenate is split into
+// pieces that fit, with a hyphen where it splits a word.
+func wrapText(face font.Face, text string, avail float64, hyphenate bool) []string {
+	var lines []string
+	for _, line := range wrapLines(face, text, avail, hyphenate) {
+		lines = append(lines, line.Text)
+	}
+	return lines
+}
+
+// wrapLines is wrapText returning what each line took from the text.
+func wrapLines(face font.Face, text string, avail float64, hyphenate bool) []wrappedLine {
+	fits := func(s string) bool {
+		return float64(measureText(face, strings.TrimRightFunc(s, unicode.IsSpace))) <= avail
+	}
+	var lines []wrappedLine
+	for _, paragraph := range strings.Split(text, "\n") {
+		var raw []string
+		line := ""
+		for _, unit := range breakUnits(strings.TrimSpace(paragraph)) {
+			if line != "" && !fits(line+unit) {
+				raw = append(raw, line)
+				line = ""
+			}
+			if line == "" && hyphenate && !fits(unit) {
+				pieces := splitUnit(face, unit, avail)
+				raw = append(raw, pieces[:len(pieces)-1]...)
+				unit = pieces[len(pieces)-1]
+			}
+			line += unit
+		}
+		raw = append(raw, line)
+		for i, r := range raw {
+			l := wrappedLine{Text: strings.TrimRightFunc(r, unicode.IsSpace), Raw: r, End: i == len(raw)-1}
+			if !l.End && splitsWord(r, raw[i+1]) {
+				l.Text += "-"
+			}
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// joinLines joins wrapped lines back into the text they took, on one line.
+func joinLines(lines []wrappedLine) string {
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l.Raw)
+		if l.End {
+			b.WriteString(" ")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// splitsWord reports whether a line break between line and next falls
+// inside a word, which only happens where splitUnit split one.
+func splitsWord(line, next string) bool {
+	last, _ := utf8.DecodeLastRuneInString(line)
+	first, _ := utf8.DecodeRuneInString(next)
+	return isWordRune(last) && isWordRune(first) && !isIdeographic(last) && !isIdeographic(first)
+}
+
+// splitUnit splits a unit too wide for avail pixels into pieces that fit,
+// each at least one character long and leaving room for the hyphen of a
+// piece that ends inside a word.
+func splitUnit(face font.Face, unit string, avail float64) []string {
+	runes := []rune(unit)
+	var pieces []string
+	for len(runes) > 0 {
+		n := len(runes)
+		for ; n > 1; n-- {
+			piece := strings.TrimRightFunc(string(runes[:n]), unicode.IsSpace)
+			if n < len(runes) && splitsWord(piece, string(runes[n:])) {
+				piece += "-"
+			}
+			if float64(measureText(face, piece)) <= avail {
+				break
+			}
+		}
+		pieces = append(pieces, string(runes[:n]))
+		runes = runes[n:]
+	}
+	return pieces
+}
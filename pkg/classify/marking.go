@@ -0,0 +1,306 @@
+package classify
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Levels are the US classification levels a Marking may carry, from least
+// to most sensitive.
+var Levels = []string{"UNCLASSIFIED", "CONFIDENTIAL", "SECRET", "TOP SECRET"}
+
+// SCIControlSystems are the SCI control systems a Marking may name.
+// Embedding applications may add systems registered since.
+var SCIControlSystems = []string{"HCS", "KDK", "RSV", "SI", "TK"}
+
+// DissemControls are the dissemination control markings of a banner line,
+// in banner order. REL TO and DISPLAY ONLY take a list of country codes.
+var DissemControls = []string{"RSEN", "FOUO", "ORCON", "ORCON-USGOV", "IMCON", "NOFORN", "PROPIN", "REL TO", "RELIDO", "DEA SENSITIVE", "FISA", "DISPLAY ONLY"}
+
+// NonICDissemControls are the dissemination controls of other agencies,
+// marked in their own group after DissemControls, in this order.
+var NonICDissemControls = []string{"LIMDIS", "EXDIS", "NODIS", "SBU", "SBU NOFORN", "LES", "LES NOFORN", "SSI"}
+
+// dissemAliases are the portion-mark abbreviations accepted for
+// dissemination controls.
+var dissemAliases = map[string]string{
+	"RS":     "RSEN",
+	"OC":     "ORCON",
+	"IMC":    "IMCON",
+	"NF":     "NOFORN",
+	"PR":     "PROPIN",
+	"DSEN":   "DEA SENSITIVE",
+	"DS":     "LIMDIS",
+	"XD":     "EXDIS",
+	"ND":     "NODIS",
+	"SBU-NF": "SBU NOFORN",
+	"LES-NF": "LES NOFORN",
+}
+
+// dissemConflicts are the pairs of dissemination controls that contradict
+// each other.
+var dissemConflicts = [][2]string{
+	{"NOFORN", "REL TO"},
+	{"NOFORN", "RELIDO"},
+	{"NOFORN", "DISPLAY ONLY"},
+	{"ORCON", "ORCON-USGOV"},
+}
+
+// classifiedOnly are the controls that only apply to classified material;
+// FOUO is the reverse, and is marked in the banner only when unclassified.
+var classifiedOnly = []string{"RSEN", "ORCON", "ORCON-USGOV", "IMCON"}
+
+// sciPattern matches an SCI control system with optional compartments and
+// sub-compartments, as in "SI-G ABCD".
+var sciPattern = regexp.MustCompile(`^[A-Z]+(-[A-Z0-9]+( [A-Z0-9]+)*)*$`)
+
+// Marking is a US classification banner line built from its parts, as laid
+// out by DoD Manual 5200.01 Volume 2.
+type Marking struct {
+	Level  string   // One of Levels
+	SCI    []string // SCI control systems with compartments, e.g. "SI-G", "TK"
+	SAP    []string // Special access program identifiers, e.g. "BP" (with or without "SAR-")
+	FGI    []string // Countries whose information is included, or just "FGI" when they are not named
+	Dissem []string // Dissemination controls, e.g. "NOFORN", or "REL TO USA" followed by "FVEY"
+}
+
+// BannerLine validates m and returns its banner line, such as
+// "SECRET//SI/TK//REL TO USA, FVEY". Each part is put in marking order: SCI
+// systems and SAPs alphabetically, FGI and REL TO countries as trigraphs then
+// tetragraphs (REL TO led by USA), and controls in DissemControls order.
+func (m Marking) BannerLine() (string, error) {
+	level := strings.Join(strings.Fields(strings.ToUpper(m.Level)), " ")
+	if !slices.Contains(Levels, level) {
+		return "", fmt.Errorf("unknown classification level '%s' (options: %s)", m.Level, strings.Join(Levels, ", "))
+	}
+	classified := level != "UNCLASSIFIED"
+	parts := []string{level}
+
+	var sci []string
+	for _, s := range m.SCI {
+		s = strings.Join(strings.Fields(strings.ToUpper(s)), " ")
+		if s == "" {
+			continue
+		}
+		system, _, _ := strings.Cut(s, "-")
+		if !sciPattern.MatchString(s) || !slices.Contains(SCIControlSystems, system) {
+			return "", fmt.Errorf("invalid SCI control system '%s' (systems: %s, with optional -COMPARTMENT)", s, strings.Join(SCIControlSystems, ", "))
+		}
+		if !slices.Contains(sci, s) {
+			sci = append(sci, s)
+		}
+	}
+	if len(sci) > 0 {
+		if !classified {
+			return "", fmt.Errorf("SCI markings require a classified level")
+		}
+		sort.Strings(sci)
+		parts = append(parts, strings.Join(sci, "/"))
+	}
+
+	var sap []string
+	for _, s := range m.SAP {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "SPECIAL ACCESS REQUIRED-"), "SAR-")
+		if s == "" {
+			continue
+		}
+		if strings.ContainsAny(s, "/ ") {
+			return "", fmt.Errorf("invalid special access program identifier '%s'", s)
+		}
+		if !slices.Contains(sap, s) {
+			sap = append(sap, s)
+		}
+	}
+	if len(sap) > 0 {
+		if !classified {
+			return "", fmt.Errorf("special access program markings require a classified level")
+		}
+		sort.Strings(sap)
+		parts = append(parts, "SAR-"+strings.Join(sap, "/"))
+	}
+
+	var fgi []string
+	named := true
+	for _, c := range m.FGI {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		switch {
+		case c == "":
+		case c == "FGI":
+			named = false
+		case isCountryCode(c):
+			if !slices.Contains(fgi, c) {
+				fgi = append(fgi, c)
+			}
+		default:
+			return "", fmt.Errorf("invalid FGI country code '%s' (expected a trigraph such as GBR or a tetragraph such as NATO)", c)
+		}
+	}
+	if len(fgi) > 0 || !named {
+		if !classified {
+			return "", fmt.Errorf("FGI markings require a classified level")
+		}
+		if !named && len(fgi) > 0 {
+			return "", fmt.Errorf("FGI is either marked with its countries or with FGI alone, not both")
+		}
+		parts = append(parts, strings.Join(append([]string{"FGI"}, countryOrder(fgi)...), " "))
+	}
+
+	controls, err := readControls(m.Dissem, append(slices.Clone(DissemControls), NonICDissemControls...), dissemAliases, "dissemination control")
+	if err != nil {
+		return "", err
+	}
+	for _, pair := range dissemConflicts {
+		_, a := controls[pair[0]]
+		_, b := controls[pair[1]]
+		if a && b {
+			return "", fmt.Errorf("dissemination controls %s and %s cannot be combined", pair[0], pair[1])
+		}
+	}
+	for _, c := range classifiedOnly {
+		if _, ok := controls[c]; ok && !classified {
+			return "", fmt.Errorf("%s requires a classified level", c)
+		}
+	}
+	if _, ok := controls["FOUO"]; ok && classified {
+		return "", fmt.Errorf("FOUO is not marked in the banner line of classified material")
+	}
+	for _, group := range [][]string{DissemControls, NonICDissemControls} {
+		marks, err := formatControls(controls, group)
+		if err != nil {
+			return "", err
+		}
+		if len(marks) > 0 {
+			parts = append(parts, strings.Join(marks, "/"))
+		}
+	}
+	return strings.Join(parts, "//"), nil
+}
+
+// readControls reads dissemination control markings, given as names from
+// known or alias abbreviations, into the country codes listed for each. A
+// REL TO or DISPLAY ONLY list may be split across items, as in "REL TO USA"
+// and "GBR"; kind names the markings in errors.
+func readControls(items, known []string, aliases map[string]string, kind string) (map[string][]string, error) {
+	controls := map[string][]string{}
+	var list string // The control whose country list is being read
+	for _, d := range items {
+		d = strings.Join(strings.Fields(strings.ToUpper(d)), " ")
+		if d == "" {
+			continue
+		}
+		name, codes := d, ""
+		if alias, ok := aliases[d]; ok {
+			name = alias
+		}
+		for _, c := range []string{"REL TO", "DISPLAY ONLY"} {
+			if d == c || strings.HasPrefix(d, c+" ") {
+				name, codes = c, strings.TrimPrefix(d, c)
+			}
+		}
+		if !slices.Contains(known, name) {
+			// A bare country code continues the open list
+			if list == "" || !isCountryCode(d) {
+				return nil, fmt.Errorf("unknown %s '%s' (options: %s)", kind, d, strings.Join(known, ", "))
+			}
+			name, codes = list, d
+		}
+		_, dup := controls[name]
+		if dup && name != list {
+			return nil, fmt.Errorf("%s '%s' is listed more than once", kind, name)
+		}
+		if !dup {
+			controls[name] = nil
+		}
+		list = ""
+		if name == "REL TO" || name == "DISPLAY ONLY" {
+			list = name
+			for _, code := range strings.Split(codes, ",") {
+				if code = strings.TrimSpace(code); code == "" {
+					continue
+				}
+				if !isCountryCode(code) {
+					return nil, fmt.Errorf("invalid country code '%s' in %s (expected a trigraph such as GBR or a tetragraph such as FVEY)", code, name)
+				}
+				if !slices.Contains(controls[name], code) {
+					controls[name] = append(controls[name], code)
+				}
+			}
+		}
+	}
+	return controls, nil
+}
+
+// formatControls returns the markings of the controls in order, with their
+// country lists.
+func formatControls(controls map[string][]string, order []string) ([]string, error) {
+	var marks []string
+	for _, name := range order {
+		codes, ok := controls[name]
+		if !ok {
+			continue
+		}
+		switch name {
+		case "REL TO":
+			codes = relToOrder(codes)
+			if len(codes) < 2 {
+				return nil, fmt.Errorf("REL TO needs a country besides USA, e.g. \"REL TO USA, GBR\"")
+			}
+		case "DISPLAY ONLY":
+			if len(codes) == 0 {
+				return nil, fmt.Errorf("DISPLAY ONLY needs a country, e.g. \"DISPLAY ONLY GBR\"")
+			}
+			codes = countryOrder(codes)
+		}
+		if len(codes) > 0 {
+			name += " " + strings.Join(codes, ", ")
+		}
+		marks = append(marks, name)
+	}
+	return marks, nil
+}
+
+// isCountryCode reports whether s looks like a country trigraph or a
+// tetragraph for a group of countries.
+func isCountryCode(s string) bool {
+	if len(s) < 3 || len(s) > 4 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// relToOrder returns a REL TO list in marking order: USA, then the rest in
+// countryOrder.
+func relToOrder(codes []string) []string {
+	var rest []string
+	for _, c := range codes {
+		if c != "USA" {
+			rest = append(rest, c)
+		}
+	}
+	return append([]string{"USA"}, countryOrder(rest)...)
+}
+
+// countryOrder returns country codes in marking order: trigraphs, then
+// tetragraphs, each alphabetically.
+func countryOrder(codes []string) []string {
+	var tri, tetra []string
+	for _, c := range codes {
+		if len(c) == 3 {
+			tri = append(tri, c)
+		} else {
+			tetra = append(tetra, c)
+		}
+	}
+	sort.Strings(tri)
+	sort.Strings(tetra)
+	return append(tri, tetra...)
+}
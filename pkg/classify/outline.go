@@ -0,0 +1,65 @@
+package classify
+
+import (
+	"fmt"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// TextOutline returns the glyph outlines of text set at fontSize points in
+// primary (nil for the embedded banner font), using fallbacks for glyphs it lacks, so labels can be
+// drawn as vector paths (e.g. in PDF pages). Coordinates are 26.6 fixed
+// point in points, with the origin at the start of the baseline and y
+// pointing down; advances match MeasureText.
+func TextOutline(text string, fontSize float64, primary *opentype.Font, fallbacks []*opentype.Font) (sfnt.Segments, error) {
+	if primary == nil {
+		var err error
+		if primary, err = parseBannerFont(); err != nil {
+			return nil, err
+		}
+	}
+	fonts := append([]*opentype.Font{primary}, fallbacks...)
+	ppem := fixed.Int26_6(0.5 + fontSize*64)
+
+	var buf sfnt.Buffer
+	var out sfnt.Segments
+	var x fixed.Int26_6
+	prevFont, prev := -1, sfnt.GlyphIndex(0)
+	for _, r := range text {
+		// The first font with a glyph for r draws it, as in fallbackFace
+		fi, idx := 0, sfnt.GlyphIndex(0)
+		for i, f := range fonts {
+			if g, err := f.GlyphIndex(&buf, r); err == nil && g != 0 {
+				fi, idx = i, g
+				break
+			}
+		}
+		f := fonts[fi]
+		if fi == prevFont {
+			if k, err := f.Kern(&buf, prev, idx, ppem, font.HintingFull); err == nil {
+				x += k
+			}
+		}
+
+		segs, err := f.LoadGlyph(&buf, idx, ppem, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load glyph for %q: %w", r, err)
+		}
+		for _, seg := range segs {
+			for i := range seg.Args {
+				seg.Args[i].X += x
+			}
+			out = append(out, seg)
+		}
+		adv, err := f.GlyphAdvance(&buf, idx, ppem, font.HintingFull)
+		if err != nil {
+			return nil, fmt.Errorf("unable to measure glyph for %q: %w", r, err)
+		}
+		x += adv
+		prevFont, prev = fi, idx
+	}
+	return out, nil
+}
@@ -0,0 +1,87 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Supported banner background patterns.
+var bannerPatterns = map[string]bool{
+	"solid":    true,
+	"diagonal": true,
+	"stripes":  true,
+	"hatch":    true,
+}
+
+// patternFill is an infinite image that paints a two-color pattern, used as the
+// draw source when filling banner regions.
+type patternFill struct {
+	kind  string     // One of the keys of bannerPatterns
+	a, b  color.RGBA // Base color and pattern color
+	width int        // Stripe width in pixels
+}
+
+func (p *patternFill) ColorModel() color.Model { return color.RGBAModel }
+
+func (p *patternFill) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+func (p *patternFill) At(x, y int) color.Color {
+	w := p.width
+	switch p.kind {
+	case "diagonal":
+		// 45° bands alternating between the two colors
+		if floorDiv(x+y, w)%2 == 0 {
+			return p.a
+		}
+		return p.b
+	case "stripes":
+		// Vertical bands alternating between the two colors
+		if floorDiv(x, w)%2 == 0 {
+			return p.a
+		}
+		return p.b
+	case "hatch":
+		// Thin lines along both diagonals over the base color
+		line := max(w/4, 1)
+		if floorMod(x+y, w) < line || floorMod(x-y, w) < line {
+			return p.b
+		}
+		return p.a
+	}
+	return p.a
+}
+
+// bannerFill returns the draw source used to paint the banner background.
+func bannerFill(banner BannerMode) image.Image {
+	if banner.Pattern == "" || banner.Pattern == "solid" {
+		return &image.Uniform{banner.BgColor}
+	}
+	width := banner.PatternWidth
+	if width <= 0 {
+		width = 20
+	}
+	return &patternFill{kind: banner.Pattern, a: banner.BgColor, b: banner.PatternColor, width: width}
+}
+
+// ValidatePattern checks that the named pattern is supported.
+func ValidatePattern(name string) error {
+	if !bannerPatterns[name] {
+		return fmt.Errorf("unknown banner pattern '%s' (options: solid, diagonal, stripes, hatch)", name)
+	}
+	return nil
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a, b int) int {
+	return a - floorDiv(a, b)*b
+}
@@ -0,0 +1,71 @@
+package classify
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// phashSize is the side of the grayscale thumbnail transformed by the DCT;
+// the hash keeps the lowest 8x8 frequencies.
+const phashSize = 32
+
+// PerceptualHash returns the 64-bit DCT perceptual hash (pHash) of img: the
+// image is reduced to a 32x32 grayscale thumbnail, and each of the 8x8
+// lowest DCT frequencies sets a bit when it is above their median, in row
+// order from the most significant bit. Copies of a picture that differ only
+// in size, compression, or small edits have hashes a few bits apart.
+//
+// Hash the image before Mark adds banners (or the region of a marked image
+// without them) so classified and unclassified copies match.
+func PerceptualHash(img image.Image) uint64 {
+	// Scale in color, then take luminance, so chroma does not alias
+	small := image.NewRGBA(image.Rect(0, 0, phashSize, phashSize))
+	xdraw.CatmullRom.Scale(small, small.Rect, img, img.Bounds(), xdraw.Src, nil)
+	var pixels [phashSize][phashSize]float64
+	for y := 0; y < phashSize; y++ {
+		for x := 0; x < phashSize; x++ {
+			c := small.RGBAAt(x, y)
+			pixels[y][x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+
+	// Separable 2D DCT-II, keeping only the low frequencies
+	var cosines [8][phashSize]float64
+	for u := range cosines {
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	var rows [phashSize][8]float64
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < phashSize; x++ {
+				rows[y][u] += pixels[y][x] * cosines[u][x]
+			}
+		}
+	}
+	var low []float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y][u] * cosines[v][y]
+			}
+			low = append(low, sum)
+		}
+	}
+
+	sorted := append([]float64(nil), low...)
+	sort.Float64s(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var hash uint64
+	for i, f := range low {
+		if f > median {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
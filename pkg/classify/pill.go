@@ -0,0 +1,47 @@
+package classify
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// pillRect returns the rounded label area around a label of txtWidth drawn at x
+// inside a banner row: 70% of the row height, padded by half its height per side.
+func pillRect(row image.Rectangle, x, txtWidth int) image.Rectangle {
+	h := row.Dy() * 7 / 10
+	pad := h / 2
+	top := row.Min.Y + (row.Dy()-h)/2
+	return image.Rect(x-pad, top, x+txtWidth+pad, top+h)
+}
+
+// drawPill paints fill inside an anti-aliased rounded rectangle whose end caps are semicircles.
+func drawPill(dst draw.Image, r image.Rectangle, fill image.Image) {
+	draw.DrawMask(dst, r, fill, r.Min, &pillMask{r: r}, r.Min, draw.Over)
+}
+
+// pillMask is an alpha mask for a rounded rectangle with radius of half its height.
+type pillMask struct {
+	r image.Rectangle
+}
+
+func (m *pillMask) ColorModel() color.Model { return color.AlphaModel }
+
+func (m *pillMask) Bounds() image.Rectangle { return m.r }
+
+func (m *pillMask) At(x, y int) color.Color {
+	radius := float64(m.r.Dy()) / 2
+	px, py := float64(x)+0.5, float64(y)+0.5
+
+	// Distance from the pixel center to the pill's central segment
+	cy := float64(m.r.Min.Y) + radius
+	left := float64(m.r.Min.X) + radius
+	right := float64(m.r.Max.X) - radius
+	cx := math.Max(left, math.Min(px, right))
+	d := math.Hypot(px-cx, py-cy)
+
+	// One pixel of linear falloff at the edge for anti-aliasing
+	a := math.Max(0, math.Min(1, radius-d+0.5))
+	return color.Alpha{uint8(a * 255)}
+}
@@ -0,0 +1,212 @@
+package classify
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// PortionFontSize is the point size of portion mark labels.
+const PortionFontSize = 16
+
+// PortionBorderWidth is the thickness in pixels of the border drawn around
+// each marked portion.
+const PortionBorderWidth = 3
+
+// portionPadding is the space in pixels between a portion label and the
+// edges of its tab.
+const portionPadding = 4
+
+// portionLevels map portion mark abbreviations to the presets whose colors
+// their labels take.
+var portionLevels = map[string]string{
+	"U":   "unclassed",
+	"CUI": "cui",
+	"C":   "confidential",
+	"S":   "secret",
+	"TS":  "topsecret",
+}
+
+// Portion is a region of an image marked with its own classification: a
+// border around Rect with Label on a tab in its top-left corner.
+type Portion struct {
+	Rect      image.Rectangle // Region in input-image coordinates
+	Label     string          // Portion mark, e.g. "(S)" or "(U//FOUO)"
+	BgColor   color.RGBA      // Border and tab color
+	TextColor color.RGBA      // Label color
+}
+
+// ParsePortionSpec parses a portion spec (the -portion flag) of the form
+// "X,Y,W,H|LABEL|R,G,B|R,G,B". The colors are optional, and the text color
+// may be AutoTextColor; a label that is a portion mark such as "(S)" or
+// "(TS//NF)" takes its level's banner colors, and any other label the
+// classification banner's colors.
+func ParsePortionSpec(spec string, banner BannerMode) (Portion, error) {
+	parts := strings.Split(spec, "|")
+	if len(parts) < 2 || len(parts) > 4 {
+		return Portion{}, fmt.Errorf("invalid portion '%s' (expected X,Y,W,H|LABEL|BG|FG)", spec)
+	}
+	parts = append(parts, make([]string, 4-len(parts))...)
+
+	var x, y, w, h int
+	if _, err := fmt.Sscanf(parts[0], "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+		return Portion{}, fmt.Errorf("portion '%s' has invalid rectangle '%s' (expected X,Y,W,H)", spec, parts[0])
+	}
+	return newPortion(x, y, w, h, parts[1], parts[2], parts[3], banner)
+}
+
+// newPortion builds a portion, taking unset colors from its label's level
+// or the classification banner.
+func newPortion(x, y, w, h int, label, bg, fg string, banner BannerMode) (Portion, error) {
+	if label == "" {
+		return Portion{}, fmt.Errorf("portion label must not be empty")
+	}
+	if x < 0 || y < 0 || w <= 0 || h <= 0 {
+		return Portion{}, fmt.Errorf("portion '%s' needs a non-negative position and a positive size, got %d,%d,%d,%d", label, x, y, w, h)
+	}
+	p := Portion{
+		Rect:      image.Rect(x, y, x+w, y+h),
+		Label:     label,
+		BgColor:   banner.BgColor,
+		TextColor: banner.TextColor,
+	}
+	if preset, ok := Presets[portionLevels[portionLevel(label)]]; ok {
+		p.BgColor, p.TextColor = preset.BgColor, preset.TextColor
+	}
+	var err error
+	if bg != "" {
+		if p.BgColor, err = ParseRGB(bg); err != nil {
+			return Portion{}, fmt.Errorf("portion '%s' background: %w", label, err)
+		}
+	}
+	if fg != "" {
+		if p.TextColor, err = ParseTextColor(fg, p.BgColor); err != nil {
+			return Portion{}, fmt.Errorf("portion '%s' text color: %w", label, err)
+		}
+	}
+	return p, nil
+}
+
+// portionLevel returns the level of a portion mark: "S" for "(S//NF)".
+func portionLevel(label string) string {
+	level := strings.Trim(strings.TrimSpace(label), "()")
+	level, _, _ = strings.Cut(level, "//")
+	return strings.ToUpper(strings.TrimSpace(level))
+}
+
+// portionFileEntry is one region in a portions file.
+type portionFileEntry struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Label     string `json:"label"`
+	BgColor   string `json:"background_color"`
+	TextColor string `json:"text_color"`
+}
+
+// ParsePortions reads portions from JSON, a list of regions with x, y,
+// width, height, label, and optional background_color and text_color.
+func ParsePortions(data []byte, banner BannerMode) ([]Portion, error) {
+	var entries []portionFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	var portions []Portion
+	for _, e := range entries {
+		p, err := newPortion(e.X, e.Y, e.Width, e.Height, e.Label, e.BgColor, e.TextColor, banner)
+		if err != nil {
+			return nil, err
+		}
+		portions = append(portions, p)
+	}
+	return portions, nil
+}
+
+// LoadPortionsFile reads portions from a JSON file; see ParsePortions.
+func LoadPortionsFile(path string, banner BannerMode) ([]Portion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read portions file: %w", err)
+	}
+	portions, err := ParsePortions(data, banner)
+	if err != nil {
+		return nil, fmt.Errorf("portions file '%s': %w", path, err)
+	}
+	return portions, nil
+}
+
+// drawPortions returns a copy of img with each portion's border and label
+// drawn over it. Portions are clipped to the image; one that lies wholly
+// outside it is an error.
+func drawPortions(img image.Image, opts Options) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Rect, img, bounds.Min, draw.Src)
+
+	face, err := loadFontFace(PortionFontSize, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+	m := face.Metrics()
+
+	for _, p := range opts.Banner.Portions {
+		r := p.Rect.Intersect(out.Rect)
+		if r.Empty() {
+			return nil, fmt.Errorf("portion '%s' at %d,%d,%d,%d lies outside the %dx%d image",
+				p.Label, p.Rect.Min.X, p.Rect.Min.Y, p.Rect.Dx(), p.Rect.Dy(), out.Rect.Dx(), out.Rect.Dy())
+		}
+		fill := &image.Uniform{p.BgColor}
+		bw := min(PortionBorderWidth, r.Dx(), r.Dy())
+		for _, edge := range []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+bw),
+			image.Rect(r.Min.X, r.Max.Y-bw, r.Max.X, r.Max.Y),
+			image.Rect(r.Min.X, r.Min.Y, r.Min.X+bw, r.Max.Y),
+			image.Rect(r.Max.X-bw, r.Min.Y, r.Max.X, r.Max.Y),
+		} {
+			draw.Draw(out, edge, fill, image.Point{}, draw.Src)
+		}
+
+		tab := portionTab(face, p, r.Min).Intersect(out.Rect)
+		draw.Draw(out, tab, fill, image.Point{}, draw.Src)
+		addLabel(out, p.Label, tab.Min.X+portionPadding, tab.Min.Y+portionPadding+m.Ascent.Ceil(), p.TextColor, face)
+	}
+	return out, nil
+}
+
+// portionTab returns the label tab of a portion whose visible region starts
+// at corner. The tab hangs from the top border inside the region, overhanging
+// it when the label is wider; it is cut off at the image edge when drawn.
+func portionTab(face font.Face, p Portion, corner image.Point) image.Rectangle {
+	m := face.Metrics()
+	return image.Rect(corner.X, corner.Y, corner.X+measureText(face, p.Label)+2*portionPadding, corner.Y+(m.Ascent+m.Descent).Ceil()+2*portionPadding)
+}
+
+// ClippedPortions describes each portion label of opts that would be cut
+// off at the edge of an image of the given size; see BannerLayout.Clipped.
+func ClippedPortions(opts Options, size image.Point) ([]string, error) {
+	if len(opts.Banner.Portions) == 0 {
+		return nil, nil
+	}
+	face, err := loadFontFace(PortionFontSize, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+	bounds := image.Rect(0, 0, size.X, size.Y)
+	var clipped []string
+	for _, p := range opts.Banner.Portions {
+		r := p.Rect.Intersect(bounds)
+		if !r.Empty() && !portionTab(face, p, r.Min).In(bounds) {
+			clipped = append(clipped, fmt.Sprintf("portion label '%s' runs past the edge of the %dx%d image", p.Label, size.X, size.Y))
+		}
+	}
+	return clipped, nil
+}
@@ -0,0 +1,135 @@
+package classify
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultFontSize is the point size used for the classification row.
+const DefaultFontSize = 36
+
+// BannerRow is one horizontal row of a stacked banner.
+type BannerRow struct {
+	Text      string     // Row label text
+	BgColor   color.RGBA // Background color of the row
+	TextColor color.RGBA // Text color of the row
+	FontSize  float64    // Font size in points
+	Height    int        // Row height in pixels
+}
+
+// bannerRows returns the rows drawn in each banner: the classification row
+// first, followed by any stacked caveat or handling rows.
+func bannerRows(banner BannerMode, bannerHeight int) []BannerRow {
+	size := banner.FontSize
+	if size <= 0 {
+		size = DefaultFontSize
+	}
+	rows := []BannerRow{{
+		Text:      banner.Text,
+		BgColor:   banner.BgColor,
+		TextColor: banner.TextColor,
+		FontSize:  size,
+		Height:    bannerHeight,
+	}}
+	return append(rows, banner.Rows...)
+}
+
+// ParseRowSpec parses a row spec (the -row flag) of the form "TEXT|R,G,B|R,G,B|SIZE|HEIGHT".
+// Everything after the text is optional; missing colors fall back to the
+// classification banner's colors, the text color may be AutoTextColor, the
+// size defaults to 24pt, and the height defaults to a value proportional to
+// the size.
+func ParseRowSpec(spec string, banner BannerMode) (BannerRow, error) {
+	parts := strings.Split(spec, "|")
+	if len(parts) > 5 {
+		return BannerRow{}, fmt.Errorf("too many fields in row '%s' (expected TEXT|BG|FG|SIZE|HEIGHT)", spec)
+	}
+	parts = append(parts, make([]string, 5-len(parts))...)
+
+	var size float64
+	if parts[3] != "" {
+		var err error
+		if size, err = strconv.ParseFloat(parts[3], 64); err != nil || size <= 0 {
+			return BannerRow{}, fmt.Errorf("row '%s' has invalid font size '%s'", parts[0], parts[3])
+		}
+	}
+	var height int
+	if parts[4] != "" {
+		var err error
+		if height, err = strconv.Atoi(parts[4]); err != nil || height <= 0 {
+			return BannerRow{}, fmt.Errorf("row '%s' has invalid height '%s'", parts[0], parts[4])
+		}
+	}
+	return newBannerRow(parts[0], parts[1], parts[2], size, height, banner)
+}
+
+// newBannerRow builds a row, filling unset values from the classification banner.
+func newBannerRow(text, bg, fg string, size float64, height int, banner BannerMode) (BannerRow, error) {
+	if text == "" {
+		return BannerRow{}, fmt.Errorf("row text must not be empty")
+	}
+	row := BannerRow{
+		Text:      text,
+		BgColor:   banner.BgColor,
+		TextColor: banner.TextColor,
+		FontSize:  size,
+		Height:    height,
+	}
+	var err error
+	if bg != "" {
+		if row.BgColor, err = ParseRGB(bg); err != nil {
+			return BannerRow{}, fmt.Errorf("row '%s' background: %w", text, err)
+		}
+	}
+	if fg != "" {
+		if row.TextColor, err = ParseTextColor(fg, row.BgColor); err != nil {
+			return BannerRow{}, fmt.Errorf("row '%s' text color: %w", text, err)
+		}
+	}
+	if row.FontSize <= 0 {
+		row.FontSize = 24
+	}
+	if row.Height <= 0 {
+		row.Height = defaultRowHeight(row.FontSize)
+	}
+	return row, nil
+}
+
+// rowFileEntry is one row in a rows preset file.
+type rowFileEntry struct {
+	Text      string  `json:"text"`
+	BgColor   string  `json:"background_color"`
+	TextColor string  `json:"text_color"`
+	FontSize  float64 `json:"font_size"`
+	Height    int     `json:"height"`
+}
+
+// LoadRowsFile reads stacked rows from a JSON preset file (the -rows-file flag).
+func LoadRowsFile(path string, banner BannerMode) ([]BannerRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rows file: %w", err)
+	}
+	var entries []rowFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse rows file '%s': %w", path, err)
+	}
+	var rows []BannerRow
+	for _, e := range entries {
+		row, err := newBannerRow(e.Text, e.BgColor, e.TextColor, e.FontSize, e.Height, banner)
+		if err != nil {
+			return nil, fmt.Errorf("rows file '%s': %w", path, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// defaultRowHeight sizes a row so that 36pt text gets the default 60px banner.
+func defaultRowHeight(fontSize float64) int {
+	return int(fontSize*60/36 + 0.5)
+}
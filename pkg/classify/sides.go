@@ -0,0 +1,235 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"slices"
+	"strings"
+)
+
+// BannerSides are the edges accepted for BannerMode.Sides.
+var BannerSides = []string{"top", "bottom", "left", "right"}
+
+// ParseSides parses a comma-separated list of BannerSides (the -sides flag),
+// such as "left,right". A side listed twice counts once.
+func ParseSides(spec string) ([]string, error) {
+	var sides []string
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(BannerSides, s) {
+			return nil, fmt.Errorf("invalid side '%s' (options: %s)", s, strings.Join(BannerSides, ", "))
+		}
+		if !slices.Contains(sides, s) {
+			sides = append(sides, s)
+		}
+	}
+	return sides, nil
+}
+
+// TopAndBottom reports whether b is drawn on the top and bottom edges only,
+// as it is when Sides is unset.
+func (b BannerMode) TopAndBottom() bool {
+	return b.edges() == bannerEdges{top: true, bottom: true}
+}
+
+// bannerEdges is which edges of an image carry a banner.
+type bannerEdges struct {
+	top, bottom, left, right bool
+}
+
+// edges returns the edges b is drawn on: none when a watermark replaces the
+// banners.
+func (b BannerMode) edges() bannerEdges {
+	if b.Watermark.Set && b.Watermark.Only {
+		return bannerEdges{}
+	}
+	if len(b.Sides) == 0 {
+		return bannerEdges{top: true, bottom: true}
+	}
+	return bannerEdges{
+		top:    slices.Contains(b.Sides, "top"),
+		bottom: slices.Contains(b.Sides, "bottom"),
+		left:   slices.Contains(b.Sides, "left"),
+		right:  slices.Contains(b.Sides, "right"),
+	}
+}
+
+// sidePlacement is where the banners of each edge go on the output.
+type sidePlacement struct {
+	edges                    bannerEdges
+	top, bottom, left, right int             // Thickness of the banner on each edge (0 without one)
+	image                    image.Rectangle // Area of the image itself
+	canvas                   image.Point     // Size of the output
+}
+
+// placeSides places the banners of opts around an image of the given size.
+// Left and right banners are top banners for the image turned on its side,
+// so their thickness follows its transposed size.
+func placeSides(opts Options, size image.Point) sidePlacement {
+	p := sidePlacement{edges: opts.Banner.edges()}
+	horizontal, vertical := BannerExtent(opts, size), BannerExtent(opts, image.Pt(size.Y, size.X))
+	if p.edges.top {
+		p.top = horizontal
+	}
+	if p.edges.bottom {
+		p.bottom = horizontal
+	}
+	if p.edges.left {
+		p.left = vertical
+	}
+	if p.edges.right {
+		p.right = vertical
+	}
+	p.image = image.Rectangle{Max: size}
+	if !opts.Banner.Overlay {
+		p.image = p.image.Add(image.Pt(p.left, p.top))
+	}
+	p.canvas = image.Pt(size.X+p.left+p.right, size.Y+p.top+p.bottom)
+	if opts.Banner.Overlay {
+		p.canvas = size
+	}
+	return p
+}
+
+// rects returns the area of each banner on the output. Top and bottom
+// banners span the image; left and right banners run between them.
+func (p sidePlacement) rects() (top, bottom, left, right image.Rectangle) {
+	w, h := p.canvas.X, p.canvas.Y
+	top = image.Rect(p.image.Min.X, 0, p.image.Max.X, p.top)
+	bottom = image.Rect(p.image.Min.X, h-p.bottom, p.image.Max.X, h)
+	left = image.Rect(0, p.top, p.left, h-p.bottom)
+	right = image.Rect(w-p.right, p.top, w, h-p.bottom)
+	return top, bottom, left, right
+}
+
+// banners returns the area of each banner drawn, in BannerSides order.
+func (p sidePlacement) banners() []image.Rectangle {
+	top, bottom, left, right := p.rects()
+	var rects []image.Rectangle
+	for i, on := range []bool{p.edges.top, p.edges.bottom, p.edges.left, p.edges.right} {
+		if on {
+			rects = append(rects, []image.Rectangle{top, bottom, left, right}[i])
+		}
+	}
+	return rects
+}
+
+// sideOptions returns the options of the left and right banners, which are
+// rendered as top banners. Corner text stays in the top or bottom banner.
+func sideOptions(opts Options) Options {
+	opts.Banner.Sides = nil
+	opts.Banner.CornerText = ""
+	return opts
+}
+
+// markSides returns a copy of img with banners on the edges opts.Banner.Sides
+// lists. The corners between two banners take the canvas color.
+func markSides(img image.Image, opts Options) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	size := bounds.Size()
+	p := placeSides(opts, size)
+	if opts.Banner.Overlay && (size.X < p.left+p.right || size.Y < p.top+p.bottom) {
+		return nil, fmt.Errorf("%w: image %dx%d is too small for its overlay banners", ErrBannerTooSmall, size.X, size.Y)
+	}
+
+	out := image.NewRGBA(image.Rectangle{Max: p.canvas})
+	op := draw.Over
+	if !opts.Banner.Overlay {
+		draw.Draw(out, out.Rect, &image.Uniform{opts.Banner.CanvasColor}, image.Point{}, draw.Src)
+		op = draw.Src
+	}
+	draw.Draw(out, p.image, img, bounds.Min, draw.Src)
+
+	top, bottom, left, right := p.rects()
+	if p.edges.top || p.edges.bottom {
+		strip, err := cachedBannerStrip(opts, size)
+		if err != nil {
+			return nil, err
+		}
+		if p.edges.top {
+			draw.Draw(out, top, strip.Top, strip.Top.Rect.Min, op)
+		}
+		if p.edges.bottom {
+			draw.Draw(out, bottom, strip.Bottom, strip.Bottom.Rect.Min, op)
+		}
+	}
+
+	// The top strip of the turned image is turned back, so its outer edge
+	// meets the left or right edge and the text reads up the left side and
+	// down the right
+	if p.edges.left || p.edges.right {
+		strip, err := cachedBannerStrip(sideOptions(opts), image.Pt(size.Y, size.X))
+		if err != nil {
+			return nil, err
+		}
+		from := image.Pt(0, left.Min.Y-p.image.Min.Y)
+		if p.edges.left {
+			draw.Draw(out, left, quarterTurn(strip.Top, false), from, op)
+		}
+		if p.edges.right {
+			draw.Draw(out, right, quarterTurn(strip.Top, true), from, op)
+		}
+	}
+	return out, nil
+}
+
+// quarterTurn returns src turned a quarter turn clockwise or counterclockwise.
+func quarterTurn(src *image.RGBA, clockwise bool) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, h, w))
+	for y := range h {
+		for x := range w {
+			c := src.RGBAAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+			if clockwise {
+				dst.SetRGBA(h-1-y, x, c)
+			} else {
+				dst.SetRGBA(y, w-1-x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// layoutSides places a layout computed for the top and bottom banners on the
+// output of opts.Banner.Sides, adding the layout of the left and right
+// banners.
+func layoutSides(opts Options, size image.Point, layout *BannerLayout) (*BannerLayout, error) {
+	p := placeSides(opts, size)
+	layout.Size = p.canvas
+	layout.Offset = p.image.Min
+	layout.Banners = p.banners()
+	layout.Sides = nil
+	for i, on := range []bool{p.edges.top, p.edges.bottom, p.edges.left, p.edges.right} {
+		if on {
+			layout.Sides = append(layout.Sides, BannerSides[i])
+		}
+	}
+
+	if !p.edges.top && !p.edges.bottom {
+		layout.Extent, layout.Rows, layout.Corner, layout.Fits = 0, nil, nil, true
+	}
+	// Rows move with the image when a left banner widens the output
+	if dx := p.image.Min.X; dx > 0 {
+		for i := range layout.Rows {
+			r := &layout.Rows[i]
+			r.Rect = r.Rect.Add(image.Pt(dx, 0))
+			for j := range r.Labels {
+				r.Labels[j] = r.Labels[j].Add(image.Pt(dx, 0))
+			}
+		}
+		if layout.Corner != nil {
+			layout.Corner.Rect = layout.Corner.Rect.Add(image.Pt(dx, 0))
+		}
+	}
+
+	if p.edges.left || p.edges.right {
+		side, err := LayoutBanner(sideOptions(opts), image.Pt(size.Y, size.X))
+		if err != nil {
+			return nil, err
+		}
+		layout.Side = side
+		layout.Fits = layout.Fits && side.Fits
+	}
+	return layout, nil
+}
@@ -0,0 +1,63 @@
+package classify
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// SrcHashToken in banner or row text is replaced with a short hash of the
+// image's original pixels, so a printed copy can be matched to its source.
+const SrcHashToken = "{{srchash}}"
+
+// srcHashLength is the number of hex digits of the SHA-256 shown.
+const srcHashLength = 12
+
+// hasSrcHash reports whether any text of banner uses the source hash token.
+func hasSrcHash(banner BannerMode) bool {
+	if strings.Contains(banner.Text, SrcHashToken) {
+		return true
+	}
+	for _, row := range banner.Rows {
+		if strings.Contains(row.Text, SrcHashToken) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSrcHash returns banner with the source hash token replaced by the
+// hash of img.
+func expandSrcHash(banner BannerMode, img image.Image) BannerMode {
+	if !hasSrcHash(banner) {
+		return banner
+	}
+	hash := pixelHash(img)[:srcHashLength]
+	banner.Text = strings.ReplaceAll(banner.Text, SrcHashToken, hash)
+	rows := make([]BannerRow, len(banner.Rows))
+	for i, row := range banner.Rows {
+		row.Text = strings.ReplaceAll(row.Text, SrcHashToken, hash)
+		rows[i] = row
+	}
+	banner.Rows = rows
+	return banner
+}
+
+// pixelHash returns the hex SHA-256 of img's dimensions and its pixels as
+// 8-bit RGBA, so the hash depends only on what the image shows and not on
+// how it was encoded.
+func pixelHash(img image.Image) string {
+	b := img.Bounds()
+	rgba, ok := img.(*image.RGBA)
+	if !ok || rgba.Stride != 4*b.Dx() {
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, [2]uint32{uint32(b.Dx()), uint32(b.Dy())})
+	h.Write(rgba.Pix[:4*b.Dx()*b.Dy()])
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package classify
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// stripCacheSize bounds how many distinct pre-rendered strips are kept; a
+// batch of uniformly sized images needs only one.
+const stripCacheSize = 32
+
+// BannerStrip is a pre-rendered pair of top and bottom banners for images of
+// one width. Rendering fills and text once and blitting the strips onto each
+// image is much cheaper than re-drawing them per file.
+type BannerStrip struct {
+	Width   int         // Image width the strips were rendered for
+	Extent  int         // Height of each strip in pixels
+	Top     *image.RGBA // Top strip, at y in [0, Extent)
+	Bottom  *image.RGBA // Bottom strip, positioned as for the image it was rendered for
+	Overlay bool        // Strips are translucent and drawn over the image's edges
+}
+
+// NewBannerStrip renders the banners for an image of the given size. The
+// height only matters for patterned fills, whose phase follows the canvas,
+// and for percentage geometries.
+func NewBannerStrip(opts Options, size image.Point) (*BannerStrip, error) {
+	opts, err := fitBanner(opts.withDefaults(), size)
+	if err != nil {
+		return nil, err
+	}
+	banner, loc := opts.Banner, opts.Location
+	width := size.X
+
+	// A geometry can narrow, offset, and inset the banner within its strip
+	place := banner.Geometry.resolve(size, opts.BannerHeight)
+
+	// Each banner is the classification row plus any stacked rows beneath it
+	rows := bannerRows(banner, place.Height)
+	totalBanner := BannerExtent(opts, size)
+	newHeight := size.Y + 2*totalBanner
+	if banner.Overlay {
+		newHeight = size.Y
+	}
+
+	// The strips use canvas coordinates so fills line up as on a full image
+	top := image.NewRGBA(image.Rect(0, 0, width, totalBanner))
+	bottom := image.NewRGBA(image.Rect(0, newHeight-totalBanner, width, newHeight))
+
+	// -- Load each font face once here, keyed by size --
+	faces, err := loadRowFaces(withCornerRow(banner, rows), opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return nil, err
+	}
+	layout := layoutBanner(opts, size, faces)
+
+	// In overlay mode every fill lets the image show through and blank areas
+	// stay transparent
+	blank := image.Image(&image.Uniform{banner.CanvasColor})
+	fade := func(fill image.Image) image.Image { return fill }
+	if banner.Overlay {
+		blank = image.Transparent
+		fade = func(fill image.Image) image.Image { return &translucent{fill, banner.Opacity} }
+	}
+
+	// Strip area left uncovered by a placed banner is blank
+	if banner.Geometry.Set {
+		draw.Draw(top, top.Rect, blank, image.Point{}, draw.Src)
+		draw.Draw(bottom, bottom.Rect, blank, image.Point{}, draw.Src)
+	}
+
+	// Stack rows downward from the top edge and upward from the bottom edge,
+	// so the classification row always sits on the outside of the image
+	topY, botY := place.Margin, newHeight-place.Margin
+	var classPill image.Image
+	for i, row := range rows {
+		topRect := layout.Rows[i].Rect
+		botRect := image.Rect(topRect.Min.X, newHeight-topRect.Max.Y, topRect.Max.X, newHeight-topRect.Min.Y)
+		topY += row.Height
+		botY -= row.Height
+
+		// The classification row uses the (possibly patterned) banner fill
+		var fill image.Image = &image.Uniform{row.BgColor}
+		if i == 0 {
+			fill = bannerFill(banner)
+		}
+		fill = fade(fill)
+
+		// In pill style the row is left blank and the fill goes behind each label
+		var pill image.Image
+		if banner.Style == "pill" {
+			pill = fill
+			fill = blank
+		}
+		if i == 0 {
+			classPill = pill
+		}
+		draw.Draw(top, topRect, fill, topRect.Min, draw.Src)
+		draw.Draw(bottom, botRect, fill, botRect.Min, draw.Src)
+
+		face := faces[row.FontSize]
+		drawRowText(top, topRect, row, face, loc, banner.TextAlign, pill)
+		drawRowText(bottom, botRect, row, face, loc, banner.TextAlign, pill)
+	}
+
+	// Separator lines sit between the innermost row and the image
+	if banner.SeparatorWidth > 0 {
+		sep := fade(&image.Uniform{banner.SeparatorColor})
+		draw.Draw(top, image.Rect(0, topY, width, topY+banner.SeparatorWidth), sep, image.Point{}, draw.Src)
+		draw.Draw(bottom, image.Rect(0, botY-banner.SeparatorWidth, width, botY), sep, image.Point{}, draw.Src)
+	}
+
+	// Corner text sits in the classification row, on a pill of its own in pill style
+	if c := layout.Corner; c != nil {
+		dst := top
+		if c.Bottom {
+			dst = bottom
+		}
+		if classPill != nil {
+			rowRect := layout.Rows[0].Rect
+			if c.Bottom {
+				rowRect = layout.Bottom(rowRect)
+			}
+			drawPill(dst, pillRect(rowRect, c.Rect.Min.X, c.Rect.Dx()), classPill)
+		}
+		addLabel(dst, c.Text, c.Rect.Min.X, c.Baseline, c.Row.TextColor, faces[c.Row.FontSize])
+	}
+
+	return &BannerStrip{Width: width, Extent: totalBanner, Top: top, Bottom: bottom, Overlay: banner.Overlay}, nil
+}
+
+// Apply returns a copy of img with the strips above and below it. img must
+// have the width the strips were rendered for.
+func (s *BannerStrip) Apply(img image.Image) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() != s.Width {
+		return nil, fmt.Errorf("banner strip is %d pixels wide but the image is %d", s.Width, bounds.Dx())
+	}
+	height := bounds.Dy()
+	if s.Overlay {
+		return s.applyOverlay(img)
+	}
+
+	// Create a new image with extra space for banners
+	newImg := image.NewRGBA(image.Rect(0, 0, s.Width, height+2*s.Extent))
+
+	// Overlay the original image onto the new image
+	draw.Draw(newImg, image.Rect(0, s.Extent, s.Width, s.Extent+height), img, bounds.Min, draw.Src)
+
+	draw.Draw(newImg, image.Rect(0, 0, s.Width, s.Extent), s.Top, s.Top.Rect.Min, draw.Src)
+	draw.Draw(newImg, image.Rect(0, s.Extent+height, s.Width, newImg.Rect.Max.Y), s.Bottom, s.Bottom.Rect.Min, draw.Src)
+	return newImg, nil
+}
+
+// applyOverlay returns a copy of img with the translucent strips drawn over
+// its top and bottom edges.
+func (s *BannerStrip) applyOverlay(img image.Image) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	height := bounds.Dy()
+	if height < 2*s.Extent {
+		return nil, fmt.Errorf("%w: image height %d is too small for two %d pixel overlay banners", ErrBannerTooSmall, height, s.Extent)
+	}
+
+	newImg := image.NewRGBA(image.Rect(0, 0, s.Width, height))
+	draw.Draw(newImg, newImg.Rect, img, bounds.Min, draw.Src)
+	draw.Draw(newImg, image.Rect(0, 0, s.Width, s.Extent), s.Top, s.Top.Rect.Min, draw.Over)
+	draw.Draw(newImg, image.Rect(0, height-s.Extent, s.Width, height), s.Bottom, s.Bottom.Rect.Min, draw.Over)
+	return newImg, nil
+}
+
+// translucent scales the alpha of an image by opacity, for overlay fills.
+type translucent struct {
+	image.Image
+	opacity float64
+}
+
+func (t *translucent) ColorModel() color.Model { return color.RGBA64Model }
+
+func (t *translucent) At(x, y int) color.Color {
+	r, g, b, a := t.Image.At(x, y).RGBA()
+	scale := func(v uint32) uint16 { return uint16(float64(v) * t.opacity) }
+	return color.RGBA64{scale(r), scale(g), scale(b), scale(a)}
+}
+
+// stripCache holds strips rendered by this process, keyed by the banner
+// settings and the image dimensions they depend on. order runs from the most
+// recently used strip to the least.
+var stripCache = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}{entries: map[string]*list.Element{}, order: list.New()}
+
+// stripEntry is one strip of stripCache. ready is closed once the strip is
+// rendered, so workers that ask for it meanwhile wait for that render rather
+// than starting their own.
+type stripEntry struct {
+	key   string
+	ready chan struct{}
+	strip *BannerStrip
+	err   error
+}
+
+// cachedBannerStrip returns a strip for an image of the given size, rendering
+// it only the first time those settings and dimensions are seen. Strips are
+// rendered outside the cache lock, so workers that need different strips
+// render them side by side, and the least recently used strip makes room for
+// a new one once stripCacheSize are kept.
+func cachedBannerStrip(opts Options, size image.Point) (*BannerStrip, error) {
+	// Solid fills with pixel geometry look the same at any image height
+	keyHeight := size.Y
+	if bannerFillIsSolid(opts.Banner) && !opts.Banner.Geometry.HasPercent() {
+		keyHeight = 0
+	}
+	key := fmt.Sprintf("%d|%d|%+v", size.X, keyHeight, opts)
+
+	stripCache.Lock()
+	if el, ok := stripCache.entries[key]; ok {
+		stripCache.order.MoveToFront(el)
+		stripCache.Unlock()
+		e := el.Value.(*stripEntry)
+		<-e.ready
+		return e.strip, e.err
+	}
+	e := &stripEntry{key: key, ready: make(chan struct{})}
+	stripCache.entries[key] = stripCache.order.PushFront(e)
+	for stripCache.order.Len() > stripCacheSize {
+		oldest := stripCache.order.Back()
+		stripCache.order.Remove(oldest)
+		delete(stripCache.entries, oldest.Value.(*stripEntry).key)
+	}
+	stripCache.Unlock()
+
+	e.strip, e.err = NewBannerStrip(opts, size)
+	close(e.ready)
+	if e.err != nil {
+		// A failed render is dropped, so the next image tries again
+		stripCache.Lock()
+		if el, ok := stripCache.entries[key]; ok && el.Value.(*stripEntry) == e {
+			stripCache.order.Remove(el)
+			delete(stripCache.entries, key)
+		}
+		stripCache.Unlock()
+	}
+	return e.strip, e.err
+}
+
+// bannerFillIsSolid reports whether every fill of banner is uniform, so its
+// rendering does not depend on where the strip sits on the canvas.
+func bannerFillIsSolid(banner BannerMode) bool {
+	return banner.Pattern == "" || banner.Pattern == "solid"
+}
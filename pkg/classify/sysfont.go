@@ -0,0 +1,87 @@
+package classify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// fontExtensions are the font files searched by FindSystemFont.
+var fontExtensions = map[string]bool{".ttf": true, ".otf": true, ".ttc": true, ".otc": true}
+
+// systemFontDirs returns the directories fonts are installed in on this
+// platform, system-wide and for the current user.
+func systemFontDirs() []string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		dirs := []string{filepath.Join(os.Getenv("WINDIR"), "Fonts")}
+		if local := os.Getenv("LOCALAPPDATA"); local != "" {
+			dirs = append(dirs, filepath.Join(local, "Microsoft", "Windows", "Fonts"))
+		}
+		return dirs
+	case "darwin":
+		return []string{"/System/Library/Fonts", "/Library/Fonts", filepath.Join(home, "Library", "Fonts")}
+	}
+	dirs := []string{"/usr/share/fonts", "/usr/local/share/fonts", filepath.Join(home, ".local", "share", "fonts"), filepath.Join(home, ".fonts")}
+	if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+		dirs = append(dirs, filepath.Join(data, "fonts"))
+	}
+	return dirs
+}
+
+// FindSystemFont returns the path of the installed font whose family (or
+// full name, e.g. "Arial Bold") is family, ignoring case. Of a family's
+// faces the bold one is preferred, as banners are set in bold, then the
+// regular one.
+func FindSystemFont(family string) (string, error) {
+	want := strings.ToLower(strings.TrimSpace(family))
+	best, bestRank := "", 0
+	var buf sfnt.Buffer
+	for _, dir := range systemFontDirs() {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !fontExtensions[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			f, err := parseFontData(data)
+			if err != nil {
+				return nil
+			}
+			name := func(id sfnt.NameID) string {
+				s, _ := f.Name(&buf, id)
+				return strings.ToLower(s)
+			}
+			// A regular face's full name is usually just the family name
+			rank := 0
+			switch style := name(sfnt.NameIDSubfamily); {
+			case name(sfnt.NameIDFamily) != want && name(sfnt.NameIDTypographicFamily) != want:
+				if name(sfnt.NameIDFull) == want {
+					rank = 4
+				}
+			case style == "bold":
+				rank = 3
+			case style == "regular" || style == "book":
+				rank = 2
+			default:
+				rank = 1
+			}
+			if rank > bestRank {
+				best, bestRank = path, rank
+			}
+			return nil
+		})
+	}
+	if best == "" {
+		return "", fmt.Errorf("font '%s' is neither a file nor an installed font family", family)
+	}
+	return best, nil
+}
@@ -0,0 +1,155 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"slices"
+	"strings"
+)
+
+// Errors reported by Options.Validate, Preset, ParseRGB, and marking, matched
+// with errors.Is so embedding applications need not parse messages.
+var (
+	ErrInvalidColor   = errors.New("invalid color")
+	ErrUnknownPreset  = errors.New("unknown preset")
+	ErrBannerTooSmall = errors.New("banner too small")
+	ErrInvalidOption  = errors.New("invalid option")
+	ErrTextOverflow   = errors.New("banner text does not fit") // With the error overflow policy
+)
+
+// OptionError is the error Options.Validate returns, naming the field at
+// fault. Err wraps ErrInvalidColor, ErrBannerTooSmall, or ErrInvalidOption.
+type OptionError struct {
+	Option string // Path of the field, e.g. "Banner.BgColor" or "Banner.Rows[1].Height"
+	Err    error
+}
+
+func (e *OptionError) Error() string { return e.Option + ": " + e.Err.Error() }
+
+func (e *OptionError) Unwrap() error { return e.Err }
+
+// Validate checks o before any image is marked, reporting the first problem
+// as an *OptionError: a color that is not opaque (ErrInvalidColor), a row
+// too short for its text or a negative height (ErrBannerTooSmall), or an
+// unknown or out-of-range setting (ErrInvalidOption). Unset values are valid
+// and take their defaults.
+func (o Options) Validate() error {
+	fail := func(option string, kind error, format string, args ...any) error {
+		return &OptionError{Option: option, Err: fmt.Errorf("%w: "+format, append([]any{kind}, args...)...)}
+	}
+	b := o.Banner
+
+	type namedColor struct {
+		name string
+		c    color.RGBA
+		used bool // Whether the color is drawn with these options
+	}
+	colors := []namedColor{
+		{"Banner.BgColor", b.BgColor, true},
+		{"Banner.TextColor", b.TextColor, true},
+		{"Banner.PatternColor", b.PatternColor, b.Pattern != "" && b.Pattern != "solid"},
+		{"Banner.SeparatorColor", b.SeparatorColor, b.SeparatorWidth > 0},
+		{"Banner.CanvasColor", b.CanvasColor, b.CanvasColor != (color.RGBA{}) && !b.Overlay},
+		{"Banner.Watermark.Color", b.Watermark.Color, b.Watermark.Set && b.Watermark.Color != (color.RGBA{})},
+	}
+	for i, row := range b.Rows {
+		colors = append(colors,
+			namedColor{fmt.Sprintf("Banner.Rows[%d].BgColor", i), row.BgColor, true},
+			namedColor{fmt.Sprintf("Banner.Rows[%d].TextColor", i), row.TextColor, true})
+	}
+	for i, p := range b.Portions {
+		colors = append(colors,
+			namedColor{fmt.Sprintf("Banner.Portions[%d].BgColor", i), p.BgColor, true},
+			namedColor{fmt.Sprintf("Banner.Portions[%d].TextColor", i), p.TextColor, true})
+	}
+	for _, c := range colors {
+		if c.used && c.c.A != 255 {
+			return fail(c.name, ErrInvalidColor, "alpha is %d, but banner colors must be opaque (overlay banners take Banner.Opacity)", c.c.A)
+		}
+	}
+
+	if o.BannerHeight < 0 {
+		return fail("BannerHeight", ErrBannerTooSmall, "%d pixels is negative", o.BannerHeight)
+	}
+	for i, row := range b.Rows {
+		if row.Height <= 0 {
+			return fail(fmt.Sprintf("Banner.Rows[%d].Height", i), ErrBannerTooSmall, "%d pixels leaves no room for the row", row.Height)
+		}
+		if row.FontSize <= 0 {
+			return fail(fmt.Sprintf("Banner.Rows[%d].FontSize", i), ErrInvalidOption, "font size %g must be positive", row.FontSize)
+		}
+	}
+	for i, p := range b.Portions {
+		if p.Rect.Empty() {
+			return fail(fmt.Sprintf("Banner.Portions[%d].Rect", i), ErrInvalidOption, "region %v is empty", p.Rect)
+		}
+		if p.Label == "" {
+			return fail(fmt.Sprintf("Banner.Portions[%d].Label", i), ErrInvalidOption, "label must not be empty")
+		}
+	}
+	switch {
+	case b.FontSize < 0:
+		return fail("Banner.FontSize", ErrInvalidOption, "font size %g is negative", b.FontSize)
+	case b.Pattern != "" && !bannerPatterns[b.Pattern]:
+		return fail("Banner.Pattern", ErrInvalidOption, "unknown banner pattern '%s' (options: solid, diagonal, stripes, hatch)", b.Pattern)
+	case b.PatternWidth < 0:
+		return fail("Banner.PatternWidth", ErrInvalidOption, "stripe width %d is negative", b.PatternWidth)
+	case b.SeparatorWidth < 0:
+		return fail("Banner.SeparatorWidth", ErrInvalidOption, "separator width %d is negative", b.SeparatorWidth)
+	case !slices.Contains([]string{"", "strip", "pill"}, b.Style):
+		return fail("Banner.Style", ErrInvalidOption, "unknown banner style '%s' (options: strip, pill)", b.Style)
+	case !slices.Contains([]string{"", "left", "center", "right"}, b.TextAlign):
+		return fail("Banner.TextAlign", ErrInvalidOption, "unknown text alignment '%s' (options: left, center, right)", b.TextAlign)
+	case !slices.Contains([]string{"", "center", "corners"}, o.Location):
+		return fail("Location", ErrInvalidOption, "unknown label location '%s' (options: center, corners)", o.Location)
+	case b.Corner != "" && !slices.Contains(Corners, b.Corner):
+		return fail("Banner.Corner", ErrInvalidOption, "unknown corner '%s'", b.Corner)
+	case slices.ContainsFunc(b.Sides, func(s string) bool { return !slices.Contains(BannerSides, s) }):
+		return fail("Banner.Sides", ErrInvalidOption, "unknown side in %v (options: %s)", b.Sides, strings.Join(BannerSides, ", "))
+	case b.Opacity < 0 || b.Opacity > 1:
+		return fail("Banner.Opacity", ErrInvalidOption, "opacity %g is outside 0 to 1", b.Opacity)
+	case b.Watermark.Opacity < 0 || b.Watermark.Opacity > 1:
+		return fail("Banner.Watermark.Opacity", ErrInvalidOption, "opacity %g is outside 0 to 1", b.Watermark.Opacity)
+	case b.Watermark.Spacing < 0:
+		return fail("Banner.Watermark.Spacing", ErrInvalidOption, "spacing %d is negative", b.Watermark.Spacing)
+	case b.Watermark.FontSize < 0:
+		return fail("Banner.Watermark.FontSize", ErrInvalidOption, "font size %g is negative", b.Watermark.FontSize)
+	case b.AutoFit < 0 || b.AutoFit > 100:
+		return fail("Banner.AutoFit", ErrInvalidOption, "auto-fit %g%% is outside 0 to 100", b.AutoFit)
+	case b.Overflow != "" && !slices.Contains(OverflowPolicies, b.Overflow):
+		return fail("Banner.Overflow", ErrInvalidOption, "unknown overflow policy '%s' (options: %s)", b.Overflow, strings.Join(OverflowPolicies, ", "))
+	}
+
+	// Auto-fit sizes the text to its rows
+	if b.AutoFit > 0 {
+		return nil
+	}
+
+	// Row heights are fixed except a percentage geometry height, which
+	// depends on the image
+	o = o.withDefaults()
+	height := o.BannerHeight
+	if h := b.Geometry.Height; b.Geometry.Set && !h.Percent && h.pixels(0) > 0 {
+		height = h.pixels(0)
+	}
+	for i, row := range bannerRows(b, height) {
+		if i == 0 && b.Geometry.Height.Percent {
+			continue
+		}
+		face, err := loadFontFace(row.FontSize, o.Font, o.FallbackFonts)
+		if err != nil {
+			return fmt.Errorf("failed to load font face: %w", err)
+		}
+		m := face.Metrics()
+		face.Close()
+		if text := (m.Ascent + m.Descent).Ceil(); text > row.Height {
+			option := "BannerHeight"
+			if i > 0 {
+				option = fmt.Sprintf("Banner.Rows[%d].Height", i-1)
+			}
+			return fail(option, ErrBannerTooSmall, "%d pixels is shorter than the %d pixel text at %gpt", row.Height, text, row.FontSize)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// DefaultWatermarkOpacity is the watermark text opacity when
+// Watermark.Opacity is unset.
+const DefaultWatermarkOpacity = 0.2
+
+// DefaultWatermarkSpacing is the gap in pixels between repeats of the
+// watermark text when Watermark.Spacing is unset.
+const DefaultWatermarkSpacing = 120
+
+// Watermark is classification text repeated across the whole image body, so
+// cropping the banners off does not remove the marking.
+type Watermark struct {
+	Set      bool       // Draw the watermark
+	Only     bool       // Leave out the edge banners, so the watermark alone marks the image
+	Text     string     // Text repeated (empty uses the banner text)
+	Angle    float64    // Degrees counterclockwise from horizontal that the text rises at
+	Opacity  float64    // Text opacity from 0 to 1 (0 uses DefaultWatermarkOpacity)
+	Spacing  int        // Gap in pixels between repeats, along and across the text (0 uses DefaultWatermarkSpacing)
+	FontSize float64    // Font size in points (0 uses the classification row's)
+	Color    color.RGBA // Text color (zero uses the banner background color)
+}
+
+// drawWatermark returns a copy of img with the watermark of opts tiled over
+// it. Lines of text follow the angle and each line is shifted by half a
+// repeat from the one before, so the text forms a brick pattern centered on
+// the image.
+func drawWatermark(img image.Image, opts Options) (*image.RGBA, error) {
+	banner := opts.Banner
+	wm := banner.Watermark
+	text := wm.Text
+	if text == "" {
+		text = banner.Text
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	size := wm.FontSize
+	if size <= 0 {
+		size = bannerRows(banner, opts.BannerHeight)[0].FontSize
+	}
+	opacity := wm.Opacity
+	if opacity <= 0 {
+		opacity = DefaultWatermarkOpacity
+	}
+	spacing := wm.Spacing
+	if spacing <= 0 {
+		spacing = DefaultWatermarkSpacing
+	}
+	col := wm.Color
+	if col == (color.RGBA{}) {
+		col = banner.BgColor
+	}
+
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Rect, img, bounds.Min, draw.Src)
+	if text == "" {
+		return out, nil
+	}
+
+	// One repeat of the text with its share of the gap on each side
+	face, err := loadFontFace(size, opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font face: %w", err)
+	}
+	defer face.Close()
+	m := face.Metrics()
+	tile := image.NewRGBA(image.Rect(0, 0, measureText(face, text)+spacing, (m.Ascent+m.Descent).Ceil()+spacing))
+	addLabel(tile, text, spacing/2, spacing/2+m.Ascent.Ceil(), color.RGBA{0, 0, 0, 255}, face)
+
+	// Each pixel is mapped back onto the unturned text plane, where the
+	// tile repeats, and the tile's coverage is sampled there
+	tw, th := float64(tile.Rect.Dx()), float64(tile.Rect.Dy())
+	sin, cos := math.Sincos(wm.Angle * math.Pi / 180)
+	cx, cy := float64(out.Rect.Dx())/2, float64(out.Rect.Dy())/2
+	mask := image.NewAlpha(out.Rect)
+	for y := range out.Rect.Dy() {
+		for x := range out.Rect.Dx() {
+			px, py := float64(x)+0.5-cx, float64(y)+0.5-cy
+			u := px*cos - py*sin + tw/2
+			v := px*sin + py*cos + th/2
+			u += math.Floor(v/th) * tw / 2
+			a := tileCoverage(tile, u-0.5, v-0.5)
+			mask.Pix[mask.PixOffset(x, y)] = uint8(a*opacity + 0.5)
+		}
+	}
+	draw.DrawMask(out, out.Rect, &image.Uniform{col}, image.Point{}, mask, image.Point{}, draw.Over)
+	return out, nil
+}
+
+// tileCoverage returns the alpha of tile at (u, v), repeating it in both
+// directions and interpolating between pixels.
+func tileCoverage(tile *image.RGBA, u, v float64) float64 {
+	w, h := tile.Rect.Dx(), tile.Rect.Dy()
+	x0, y0 := math.Floor(u), math.Floor(v)
+	fx, fy := u-x0, v-y0
+	at := func(x, y int) float64 {
+		x, y = ((x%w)+w)%w, ((y%h)+h)%h
+		return float64(tile.Pix[tile.PixOffset(x, y)+3])
+	}
+	ix, iy := int(x0), int(y0)
+	top := at(ix, iy)*(1-fx) + at(ix+1, iy)*fx
+	bottom := at(ix, iy+1)*(1-fx) + at(ix+1, iy+1)*fx
+	return top*(1-fy) + bottom*fy
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinMaxLevel caps the classification a build may apply. Set it at build
+// time, e.g. -ldflags "-X main.builtinMaxLevel=CUI", for workstations that
+// must never apply higher markings regardless of configuration.
+var builtinMaxLevel string
+
+// markingPolicy constrains the markings this host may apply.
+type markingPolicy struct {
+	MaxLevel       string   `yaml:"max_level"`       // Highest level allowed (empty: no cap)
+	AllowedCaveats []string `yaml:"allowed_caveats"` // Caveats allowed in banner text and rows (empty: any)
+	RequiredFields []string `yaml:"required_fields"` // Fields that must have a value, e.g. operator
+	AllowCustom    bool     `yaml:"allow_custom"`    // Allow banner text that is not a known level when capped
+
+	source   string
+	maxLevel Level // MaxLevel parsed by loadPolicies
+}
+
+// policies are every policy in force. They are all enforced, so a policy
+// given on the command line can narrow the system policy but never widen it.
+// policyMu guards them, since serve reloads them while requests are checked.
+var (
+	policies []markingPolicy
+	policyMu sync.RWMutex
+)
+
+// systemPolicyPath is the host-wide policy loaded automatically when present.
+func systemPolicyPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "goclassifyit", "policy.yaml")
+	}
+	return "/etc/goclassifyit/policy.yaml"
+}
+
+// loadPolicies loads the build-time cap, the system policy, and the policy at
+// path (if not empty), replacing those in force only when all of them load.
+func loadPolicies(path string) error {
+	var loaded []markingPolicy
+	if builtinMaxLevel != "" {
+		loaded = append(loaded, markingPolicy{MaxLevel: builtinMaxLevel, source: "build policy"})
+	}
+	if p, err := readPolicy(systemPolicyPath()); err == nil {
+		loaded = append(loaded, p)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if path != "" {
+		p, err := readPolicy(path)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, p)
+	}
+	for i, p := range loaded {
+		if p.MaxLevel != "" {
+			l, err := ParseLevel(p.MaxLevel)
+			if err != nil {
+				return fmt.Errorf("%s: invalid max_level: %w", p.source, err)
+			}
+			loaded[i].maxLevel = l
+		}
+		for _, field := range p.RequiredFields {
+			if _, ok := policyFields[field]; !ok {
+				return fmt.Errorf("%s: unknown required field '%s'", p.source, field)
+			}
+		}
+	}
+	policyMu.Lock()
+	policies = loaded
+	policyMu.Unlock()
+	return nil
+}
+
+func readPolicy(path string) (markingPolicy, error) {
+	p := markingPolicy{source: fmt.Sprintf("policy '%s'", path)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("failed to read policy: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("failed to parse policy '%s': %w", path, err)
+	}
+	return p, nil
+}
+
+// policyFields are the values a policy can require, by name.
+var policyFields = map[string]func(classify.BannerMode) string{
+	"operator": func(classify.BannerMode) string {
+		if operator == "unknown" {
+			return ""
+		}
+		return operator
+	},
+	"text": func(b classify.BannerMode) string { return b.Text },
+}
+
+// splitMarking splits banner text such as "SECRET//NOFORN/REL TO USA" into
+// its level and caveats.
+func splitMarking(text string) (string, []string) {
+	level, rest, _ := strings.Cut(strings.ToUpper(strings.TrimSpace(text)), "//")
+	var caveats []string
+	for _, c := range strings.FieldsFunc(rest, func(r rune) bool { return r == '/' || r == ',' }) {
+		if c = strings.TrimSpace(c); c != "" {
+			caveats = append(caveats, c)
+		}
+	}
+	return strings.TrimSpace(level), caveats
+}
+
+// checkPolicy reports the first way banner violates a policy in force.
+func checkPolicy(banner classify.BannerMode) error {
+	// The source hash is filled in per image and is not part of the marking
+	text := strings.ReplaceAll(banner.Text, classify.SrcHashToken, "")
+	level, known := MarkingLevel(text)
+	_, caveats := splitMarking(text)
+	// Stacked rows carry caveats and handling instructions
+	for _, row := range banner.Rows {
+		if t := strings.TrimSpace(strings.ReplaceAll(row.Text, classify.SrcHashToken, "")); t != "" {
+			caveats = append(caveats, strings.ToUpper(t))
+		}
+	}
+
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	for _, p := range policies {
+		if p.MaxLevel != "" {
+			switch {
+			case !known && !p.AllowCustom:
+				return fmt.Errorf("%s does not allow the marking '%s'", p.source, banner.Text)
+			case known && level.Exceeds(p.maxLevel):
+				return fmt.Errorf("%s caps markings at %s; '%s' is not allowed on this host", p.source, p.maxLevel, banner.Text)
+			}
+		}
+		if len(p.AllowedCaveats) > 0 {
+			for _, c := range caveats {
+				if !containsFold(p.AllowedCaveats, c) {
+					return fmt.Errorf("%s does not allow the caveat '%s'", p.source, c)
+				}
+			}
+		}
+		for _, field := range p.RequiredFields {
+			if policyFields[field](banner) == "" {
+				return fmt.Errorf("%s requires %s to be set", p.source, field)
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// preserveMetadata copies the Exif, XMP, ICC profile, and resolution of PNG
+// and JPEG inputs into their outputs (the -preserve-metadata flag), which
+// re-encoding would otherwise drop.
+var preserveMetadata bool
+
+// xmpSignature starts the payload of a JPEG XMP segment.
+const xmpSignature = "http://ns.adobe.com/xap/1.0/\x00"
+
+// iccSegmentSize is the most ICC profile data one JPEG APP2 segment holds.
+const iccSegmentSize = 0xFFFF - 2 - 14
+
+// sourceMetadata is the metadata carried over from an input.
+type sourceMetadata struct {
+	Exif       []byte  // TIFF structure of the Exif data
+	XMP        []byte  // XMP packet
+	ICC        []byte  // RGB ICC profile
+	DPIX, DPIY float64 // Resolution in dots per inch (0: unknown)
+}
+
+// preserveSourceMetadata returns the encoded output of job with the metadata
+// of its input added. The classification's own Exif and XMP replace the
+// input's with -embed-metadata, the input's color profile is dropped when
+// -colorspace srgb has converted the pixels, and the resolutions of the two
+// axes trade places when -rotate has turned the image on its side.
+func preserveSourceMetadata(job *imageJob, data []byte) ([]byte, error) {
+	src, err := readFile(job.InputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input metadata: %w", err)
+	}
+	md, err := readSourceMetadata(src)
+	if err != nil {
+		return nil, err
+	}
+	if embedMetadata {
+		md.Exif, md.XMP = nil, nil
+	}
+	if colorSpace == "srgb" {
+		md.ICC = nil
+	}
+	if turnsSideways() {
+		md.DPIX, md.DPIY = md.DPIY, md.DPIX
+	}
+	return md.insert(data, job.Format)
+}
+
+// readSourceMetadata reads the metadata of an encoded PNG or JPEG. Other
+// formats have none.
+func readSourceMetadata(data []byte) (sourceMetadata, error) {
+	var md sourceMetadata
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		if md.ICC, err = pngICCProfile(data); err != nil {
+			return md, fmt.Errorf("failed to read color profile: %w", err)
+		}
+		forEachPNGChunk(data, func(kind string, chunk []byte) {
+			switch kind {
+			case "eXIf":
+				md.Exif = chunk
+			case "pHYs":
+				// Pixels per unit on each axis, then the unit (1: meter)
+				if len(chunk) == 9 && chunk[8] == 1 {
+					md.DPIX = float64(binary.BigEndian.Uint32(chunk)) * 0.0254
+					md.DPIY = float64(binary.BigEndian.Uint32(chunk[4:])) * 0.0254
+				}
+			case "iTXt":
+				if xmp, ok := pngXMP(chunk); ok {
+					md.XMP = xmp
+				}
+			}
+		})
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		md.ICC = jpegICCProfile(data)
+		forEachJPEGSegment(data, func(marker byte, seg []byte) {
+			switch {
+			case marker == 0xE0 && len(seg) >= 12 && string(seg[:5]) == "JFIF\x00":
+				// Version, then the density unit (1: inch, 2: cm) and densities
+				scale := map[byte]float64{1: 1, 2: 2.54}[seg[7]]
+				md.DPIX = float64(binary.BigEndian.Uint16(seg[8:])) * scale
+				md.DPIY = float64(binary.BigEndian.Uint16(seg[10:])) * scale
+			case marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")):
+				md.Exif = seg[6:]
+			case marker == 0xE1 && bytes.HasPrefix(seg, []byte(xmpSignature)):
+				md.XMP = seg[len(xmpSignature):]
+			}
+		})
+	}
+	// Only RGB profiles describe the RGB pixels the encoders write; CMYK
+	// and gray profiles no longer apply
+	if len(md.ICC) < 20 || string(md.ICC[16:20]) != "RGB " {
+		md.ICC = nil
+	}
+	if md.Exif != nil {
+		md.Exif = sanitizeExif(md.Exif)
+	}
+	return md, nil
+}
+
+// forEachPNGChunk calls fn with each chunk of a PNG before its image data.
+func forEachPNGChunk(data []byte, fn func(kind string, chunk []byte)) {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) || kind == "IDAT" {
+			return
+		}
+		fn(kind, data[pos+8:pos+8+length])
+		pos += 12 + length
+	}
+}
+
+// forEachJPEGSegment calls fn with the payload of each marker segment of a
+// JPEG before its first scan.
+func forEachJPEGSegment(data []byte, fn func(marker byte, seg []byte)) {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan or end of image
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return
+		}
+		fn(marker, data[pos+4:pos+2+length])
+		pos += 2 + length
+	}
+}
+
+// pngXMP returns the XMP packet of an iTXt chunk, if it holds one.
+func pngXMP(chunk []byte) ([]byte, bool) {
+	// Keyword, NUL, compression flag and method, language, NUL, translated
+	// keyword, NUL, text
+	key, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || string(key) != "XML:com.adobe.xmp" || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	_, rest, _ = bytes.Cut(rest[2:], []byte{0})
+	_, text, ok := bytes.Cut(rest, []byte{0})
+	if !ok {
+		return nil, false
+	}
+	if !compressed {
+		return text, true
+	}
+	r, err := zlib.NewReader(bytes.NewReader(text))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	xmp, err := io.ReadAll(r)
+	return xmp, err == nil
+}
+
+// sanitizeExif returns a copy of an Exif TIFF structure fit for a marked
+// output: Orientation is reset to normal, since banners are drawn on the
+// stored pixels and must not be turned by viewers, and the thumbnail IFD is
+// unlinked and its image blanked, so no unmarked copy of the input remains.
+// When -rotate turns the image on its side, the horizontal and vertical
+// resolutions and pixel dimensions are swapped to match. Data that is not
+// valid TIFF is dropped.
+func sanitizeExif(exif []byte) []byte {
+	if len(exif) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	out := slices.Clone(exif)
+
+	// entries returns the position and entry count of the IFD at offset
+	entries := func(offset uint32) (int, int, bool) {
+		pos := int(offset)
+		if offset < 8 || pos+2 > len(out) {
+			return 0, 0, false
+		}
+		n := int(order.Uint16(out[pos:]))
+		if pos+2+12*n+4 > len(out) {
+			return 0, 0, false
+		}
+		return pos + 2, n, true
+	}
+
+	// swap exchanges the type, count, and value of two fields of an IFD
+	swap := func(ifd, n int, a, b uint16) {
+		var fa, fb []byte
+		for i := range n {
+			switch e := out[ifd+12*i : ifd+12*i+12]; order.Uint16(e) {
+			case a:
+				fa = e[2:]
+			case b:
+				fb = e[2:]
+			}
+		}
+		if fa != nil && fb != nil {
+			tmp := slices.Clone(fa)
+			copy(fa, fb)
+			copy(fb, tmp)
+		}
+	}
+
+	ifd0, n, ok := entries(order.Uint32(out[4:]))
+	if !ok {
+		return nil
+	}
+	var exifIFD uint32
+	for i := range n {
+		e := out[ifd0+12*i:]
+		switch order.Uint16(e) {
+		case 0x0112: // Orientation, a SHORT
+			if order.Uint16(e[2:]) == 3 {
+				order.PutUint16(e[8:], 1)
+			}
+		case 0x8769: // Exif IFD pointer
+			exifIFD = order.Uint32(e[8:])
+		}
+	}
+	if turnsSideways() {
+		swap(ifd0, n, 0x011A, 0x011B) // XResolution, YResolution
+		if pos, m, ok := entries(exifIFD); ok {
+			swap(pos, m, 0xA002, 0xA003) // PixelXDimension, PixelYDimension
+		}
+	}
+	next := ifd0 + 12*n
+	if ifd1, n, ok := entries(order.Uint32(out[next:])); ok {
+		var offset, length uint32
+		for i := range n {
+			e := out[ifd1+12*i:]
+			switch order.Uint16(e) {
+			case 0x0201: // JPEGInterchangeFormat
+				offset = order.Uint32(e[8:])
+			case 0x0202: // JPEGInterchangeFormatLength
+				length = order.Uint32(e[8:])
+			}
+		}
+		if end := uint64(offset) + uint64(length); offset > 0 && end <= uint64(len(out)) {
+			clear(out[offset:end])
+		}
+	}
+	order.PutUint32(out[next:], 0)
+	return out
+}
+
+// insert returns an encoded PNG or JPEG with md added after its header: as
+// iCCP, pHYs, eXIf, and iTXt chunks in a PNG, or as JFIF, Exif, XMP, and
+// ICC_PROFILE segments in a JPEG.
+func (md sourceMetadata) insert(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "png":
+		const ihdrEnd = 8 + 8 + 13 + 4
+		if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+			return nil, fmt.Errorf("output is not a PNG file")
+		}
+		var chunks bytes.Buffer
+		if md.ICC != nil {
+			var profile bytes.Buffer
+			zw := zlib.NewWriter(&profile)
+			zw.Write(md.ICC)
+			zw.Close()
+			writePNGChunk(&chunks, "iCCP", append([]byte("ICC Profile\x00\x00"), profile.Bytes()...))
+		}
+		if md.DPIX > 0 && md.DPIY > 0 {
+			phys := binary.BigEndian.AppendUint32(nil, uint32(math.Round(md.DPIX/0.0254)))
+			phys = binary.BigEndian.AppendUint32(phys, uint32(math.Round(md.DPIY/0.0254)))
+			writePNGChunk(&chunks, "pHYs", append(phys, 1))
+		}
+		if md.Exif != nil {
+			writePNGChunk(&chunks, "eXIf", md.Exif)
+		}
+		if md.XMP != nil {
+			writePNGChunk(&chunks, "iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), md.XMP...))
+		}
+		return slices.Concat(data[:ihdrEnd], chunks.Bytes(), data[ihdrEnd:]), nil
+	case "jpeg":
+		if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+			return nil, fmt.Errorf("output is not a JPEG file")
+		}
+		var segments bytes.Buffer
+		if md.DPIX > 0 && md.DPIY > 0 {
+			jfif := []byte("JFIF\x00\x01\x02\x01") // Version 1.02, densities in dots per inch
+			jfif = binary.BigEndian.AppendUint16(jfif, uint16(min(math.Round(md.DPIX), 0xFFFF)))
+			jfif = binary.BigEndian.AppendUint16(jfif, uint16(min(math.Round(md.DPIY), 0xFFFF)))
+			writeJPEGSegment(&segments, 0xE0, append(jfif, 0, 0)) // No thumbnail
+		}
+		for _, payload := range [][]byte{
+			nilIfEmpty(md.Exif, "Exif\x00\x00"),
+			nilIfEmpty(md.XMP, xmpSignature),
+		} {
+			if payload == nil {
+				continue
+			}
+			if len(payload) > 0xFFFF-2 {
+				return nil, fmt.Errorf("source metadata is too long for a JPEG segment")
+			}
+			writeJPEGSegment(&segments, 0xE1, payload)
+		}
+		// Profiles larger than a segment are split, with each part numbered
+		if count := (len(md.ICC) + iccSegmentSize - 1) / iccSegmentSize; count > 0 {
+			if count > 0xFF {
+				return nil, fmt.Errorf("color profile is too large for a JPEG file")
+			}
+			for i := range count {
+				part := md.ICC[i*iccSegmentSize : min((i+1)*iccSegmentSize, len(md.ICC))]
+				writeJPEGSegment(&segments, 0xE2, slices.Concat([]byte("ICC_PROFILE\x00"), []byte{byte(i + 1), byte(count)}, part))
+			}
+		}
+		return slices.Concat(data[:2], segments.Bytes(), data[2:]), nil
+	}
+	return data, nil
+}
+
+// nilIfEmpty returns value with prefix in front, or nil when value is empty.
+func nilIfEmpty(value []byte, prefix string) []byte {
+	if len(value) == 0 {
+		return nil
+	}
+	return append([]byte(prefix), value...)
+}
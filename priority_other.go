@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "fmt"
+
+// lowerPriority is only available on Unix and Windows builds.
+func lowerPriority() error {
+	return fmt.Errorf("-low-priority is not available on this platform")
+}
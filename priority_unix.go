@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// lowPriorityNice is the nice value -low-priority runs at.
+const lowPriorityNice = 10
+
+// lowerPriority renices the process. On Linux the nice value belongs to each
+// thread, so every thread running so far is reniced; later threads inherit it.
+func lowerPriority() error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, lowPriorityNice); err != nil {
+		return fmt.Errorf("failed to lower priority: %w", err)
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil
+	}
+	for _, t := range tasks {
+		if tid, err := strconv.Atoi(t.Name()); err == nil {
+			unix.Setpriority(unix.PRIO_PROCESS, tid, lowPriorityNice)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// lowerPriority moves the process to the below-normal priority class.
+func lowerPriority() error {
+	if err := windows.SetPriorityClass(windows.CurrentProcess(), windows.BELOW_NORMAL_PRIORITY_CLASS); err != nil {
+		return fmt.Errorf("failed to lower priority: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	_ "net/http/pprof" // Registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// shutdownHooks run before the program exits, in reverse registration order.
+var shutdownHooks []func()
+
+// onShutdown registers fn to run when the program exits.
+func onShutdown(fn func()) {
+	shutdownHooks = append(shutdownHooks, fn)
+}
+
+// shutdown runs the registered hooks once, flushing profiles and logs.
+func shutdown() {
+	for i := len(shutdownHooks) - 1; i >= 0; i-- {
+		shutdownHooks[i]()
+	}
+	shutdownHooks = nil
+}
+
+// startProfiling enables the pprof HTTP endpoint and CPU/heap profiles as requested.
+func startProfiling(pprofAddr, cpuProfile, memProfile string) error {
+	if pprofAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(pprofAddr, nil); err != nil {
+				fmt.Println(tr("Error serving pprof:"), err)
+			}
+		}()
+		fmt.Printf(tr("pprof listening on http://%s/debug/pprof/\n"), pprofAddr)
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		onShutdown(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+
+	if memProfile != "" {
+		onShutdown(func() {
+			f, err := os.Create(memProfile)
+			if err != nil {
+				fmt.Println(tr("Error creating memory profile:"), err)
+				return
+			}
+			defer f.Close()
+			runtime.GC() // Get up-to-date statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Println(tr("Error writing memory profile:"), err)
+			}
+		})
+	}
+	return nil
+}
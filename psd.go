@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("psd", "8BPS", decodePSD, decodePSDConfig)
+}
+
+// PSD color modes supported by decodePSD.
+const (
+	psdGrayscale = 1
+	psdIndexed   = 2
+	psdRGB       = 3
+	psdCMYK      = 4
+)
+
+// psdHeader is the fixed 26-byte PSD/PSB file header.
+type psdHeader struct {
+	Signature [4]byte
+	Version   uint16
+	Reserved  [6]byte
+	Channels  uint16
+	Height    uint32
+	Width     uint32
+	Depth     uint16
+	ColorMode uint16
+}
+
+func readPSDHeader(r io.Reader) (psdHeader, error) {
+	var h psdHeader
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return h, fmt.Errorf("psd: truncated header")
+	}
+	if string(h.Signature[:]) != "8BPS" || (h.Version != 1 && h.Version != 2) {
+		return h, fmt.Errorf("psd: invalid signature or version")
+	}
+	if h.Depth != 8 && h.Depth != 16 {
+		return h, fmt.Errorf("psd: %d-bit documents are not supported", h.Depth)
+	}
+	switch h.ColorMode {
+	case psdGrayscale, psdIndexed, psdRGB, psdCMYK:
+	default:
+		return h, fmt.Errorf("psd: color mode %d is not supported", h.ColorMode)
+	}
+	if h.Width == 0 || h.Height == 0 {
+		return h, fmt.Errorf("psd: empty document")
+	}
+	return h, nil
+}
+
+func decodePSDConfig(r io.Reader) (image.Config, error) {
+	h, err := readPSDHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBAModel, Width: int(h.Width), Height: int(h.Height)}, nil
+}
+
+// decodePSD decodes the flattened composite that Photoshop stores after the
+// layer data. The composite reflects all visible layers with their blend
+// modes applied, as long as the file was saved with "Maximize Compatibility".
+func decodePSD(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(data)
+	h, err := readPSDHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	psb := h.Version == 2
+
+	// Color mode data holds the palette for indexed documents
+	section := func(long bool) ([]byte, error) {
+		var n uint64
+		if long {
+			if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+				return nil, fmt.Errorf("psd: truncated section")
+			}
+		} else {
+			var n32 uint32
+			if err := binary.Read(br, binary.BigEndian, &n32); err != nil {
+				return nil, fmt.Errorf("psd: truncated section")
+			}
+			n = uint64(n32)
+		}
+		if n > uint64(br.Len()) {
+			return nil, fmt.Errorf("psd: section overflows file")
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(br, b)
+		return b, err
+	}
+	palette, err := section(false)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := section(false)
+	if err != nil {
+		return nil, err
+	}
+	if !psdHasMergedData(resources) {
+		return nil, fmt.Errorf("psd: file has no flattened composite; re-save with \"Maximize Compatibility\" enabled")
+	}
+	if _, err := section(psb); err != nil { // Layer and mask information
+		return nil, err
+	}
+
+	var compression uint16
+	if err := binary.Read(br, binary.BigEndian, &compression); err != nil {
+		return nil, fmt.Errorf("psd: missing image data")
+	}
+
+	w, ht := int(h.Width), int(h.Height)
+	bps := int(h.Depth) / 8
+	rowBytes := w * bps
+	channels := int(h.Channels)
+
+	// The composite is stored planar, one channel after another
+	planes := make([][]byte, channels)
+	switch compression {
+	case 0:
+		for c := range planes {
+			planes[c] = make([]byte, rowBytes*ht)
+			if _, err := io.ReadFull(br, planes[c]); err != nil {
+				return nil, fmt.Errorf("psd: truncated image data")
+			}
+		}
+	case 1:
+		counts := make([]int, channels*ht)
+		for i := range counts {
+			if psb {
+				var n uint32
+				if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+					return nil, fmt.Errorf("psd: truncated RLE counts")
+				}
+				counts[i] = int(n)
+			} else {
+				var n uint16
+				if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+					return nil, fmt.Errorf("psd: truncated RLE counts")
+				}
+				counts[i] = int(n)
+			}
+		}
+		for c := range planes {
+			planes[c] = make([]byte, 0, rowBytes*ht)
+			for y := 0; y < ht; y++ {
+				packed := make([]byte, counts[c*ht+y])
+				if _, err := io.ReadFull(br, packed); err != nil {
+					return nil, fmt.Errorf("psd: truncated RLE data")
+				}
+				row, err := unpackBits(packed, rowBytes)
+				if err != nil {
+					return nil, fmt.Errorf("psd: %w", err)
+				}
+				planes[c] = append(planes[c], row...)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("psd: image data compression %d is not supported", compression)
+	}
+
+	// sample returns channel c at pixel i as 8 bits
+	sample := func(c, i int) uint8 {
+		if c >= channels {
+			return 255
+		}
+		return planes[c][i*bps]
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, ht))
+	for i := 0; i < w*ht; i++ {
+		var px color.RGBA
+		switch h.ColorMode {
+		case psdGrayscale:
+			g := sample(0, i)
+			px = color.RGBA{g, g, g, 255}
+			if channels > 1 {
+				px.A = sample(1, i)
+			}
+		case psdIndexed:
+			idx := int(sample(0, i))
+			if len(palette) < 768 {
+				return nil, fmt.Errorf("psd: missing palette for indexed document")
+			}
+			px = color.RGBA{palette[idx], palette[256+idx], palette[512+idx], 255}
+		case psdRGB:
+			px = color.RGBA{sample(0, i), sample(1, i), sample(2, i), 255}
+			if channels > 3 {
+				px.A = sample(3, i)
+			}
+		case psdCMYK:
+			// Photoshop stores CMYK inverted (255 = no ink)
+			c := color.CMYK{255 - sample(0, i), 255 - sample(1, i), 255 - sample(2, i), 255 - sample(3, i)}
+			r, g, b, _ := c.RGBA()
+			px = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+		}
+		// Premultiply so the image.RGBA invariants hold for transparent composites
+		if px.A != 255 {
+			px.R = uint8(int(px.R) * int(px.A) / 255)
+			px.G = uint8(int(px.G) * int(px.A) / 255)
+			px.B = uint8(int(px.B) * int(px.A) / 255)
+		}
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = px.R, px.G, px.B, px.A
+	}
+	return img, nil
+}
+
+// psdHasMergedData checks the version info resource (0x0421), which records
+// whether a real composite was saved. Files without the resource are assumed
+// to contain one.
+func psdHasMergedData(res []byte) bool {
+	for p := 0; p+12 <= len(res); {
+		if string(res[p:p+4]) != "8BIM" {
+			return true
+		}
+		id := binary.BigEndian.Uint16(res[p+4:])
+		nameLen := int(res[p+6])
+		// Pascal string padded to an even total length
+		p += 6 + (1+nameLen+1)&^1
+		if p+4 > len(res) {
+			return true
+		}
+		size := int(binary.BigEndian.Uint32(res[p:]))
+		p += 4
+		if p+size > len(res) {
+			return true
+		}
+		if id == 0x0421 && size >= 5 {
+			return res[p+4] != 0
+		}
+		p += (size + 1) &^ 1
+	}
+	return true
+}
+
+// unpackBits decodes one PackBits-compressed row of the expected length.
+func unpackBits(src []byte, want int) ([]byte, error) {
+	out := make([]byte, 0, want)
+	for i := 0; i < len(src) && len(out) < want; {
+		n := int(int8(src[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(src) {
+				return nil, fmt.Errorf("truncated PackBits literal")
+			}
+			out = append(out, src[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(src) {
+				return nil, fmt.Errorf("truncated PackBits run")
+			}
+			for k := 0; k < 1-n; k++ {
+				out = append(out, src[i])
+			}
+			i++
+		}
+	}
+	if len(out) != want {
+		return nil, fmt.Errorf("PackBits row decoded to %d bytes, expected %d", len(out), want)
+	}
+	return out, nil
+}
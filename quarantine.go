@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quarantineSuffix is appended to a quarantined file's name for its record.
+const quarantineSuffix = ".quarantine.json"
+
+// quarantineDir receives inputs that cannot be classified; empty disables
+// quarantining.
+var quarantineDir string
+
+// quarantineFlags are the flags of this run, recorded so review can retry a
+// quarantined file with the same options.
+var quarantineFlags []string
+
+// quarantineMu serializes moves into the quarantine directory.
+var quarantineMu sync.Mutex
+
+// inputError marks a failure caused by the input itself (undecodable,
+// unsupported, or corrupt), as opposed to an output or environment problem.
+type inputError struct{ err error }
+
+func (e *inputError) Error() string { return e.err.Error() }
+func (e *inputError) Unwrap() error { return e.err }
+
+// badInput wraps err as an input problem.
+func badInput(err error) error {
+	return &inputError{err}
+}
+
+// quarantineRecord is the JSON explanation written next to a quarantined file.
+type quarantineRecord struct {
+	Source        string   `json:"source"`
+	Error         string   `json:"error"`
+	Operator      string   `json:"operator"`
+	RunID         string   `json:"run_id,omitempty"`
+	QuarantinedAt string   `json:"quarantined_at,omitempty"`
+	Attempts      int      `json:"attempts"`
+	Dir           string   `json:"dir"`
+	Flags         []string `json:"flags"`
+}
+
+// recordFlags returns the flags set for this run as -name=value arguments,
+// leaving out the input selection and run-mode flags.
+func recordFlags() []string {
+	skip := map[string]bool{"f": true, "d": true, "bundle": true, "job": true, "tui": true, "quarantine-dir": true, "run-id": true, "notify": true, "notify-failures": true, "watch": true, "watch-settle": true, "watch-log": true, "watch-attempts": true, "schedule": true}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if skip[f.Name] {
+			return
+		}
+		if rows, ok := f.Value.(*rowFlag); ok {
+			for _, row := range *rows {
+				args = append(args, "-"+f.Name+"="+row)
+			}
+			return
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return args
+}
+
+// quarantineInput moves imagePath (and its sidecar) into the quarantine
+// directory when cause is an input problem, writing a JSON record of it after
+// attempts tries beside it. Remote inputs are left where they are. It returns
+// the error to report for the image.
+func quarantineInput(imagePath string, cause error, attempts int) error {
+	if !quarantines(imagePath, cause) {
+		return cause
+	}
+	dest, err := quarantineFile(imagePath, quarantineDir, cause, attempts)
+	switch {
+	case dest == "":
+		return fmt.Errorf("%w (quarantine failed: %v)", cause, err)
+	case err != nil:
+		return fmt.Errorf("%w (quarantined to %s, but the record failed: %v)", cause, dest, err)
+	}
+	return fmt.Errorf("%w (quarantined to %s)", cause, dest)
+}
+
+// quarantines reports whether quarantineInput moves imagePath aside for cause.
+func quarantines(imagePath string, cause error) bool {
+	var ie *inputError
+	return quarantineDir != "" && !isRemote(imagePath) && errors.As(cause, &ie)
+}
+
+// quarantineFile moves imagePath (and its sidecar) into dir, writing the
+// record of cause after attempts tries beside it. It returns the new path,
+// or "" when the file could not be moved.
+func quarantineFile(imagePath, dir string, cause error, attempts int) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	// Never overwrite an earlier quarantined file of the same name; parallel
+	// workers pick names and move files one at a time
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	base := filepath.Base(imagePath)
+	dest := filepath.Join(dir, base)
+	for i := 1; fileExists(dest) || fileExists(dest+quarantineSuffix); i++ {
+		ext := filepath.Ext(base)
+		dest = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+
+	source, err := filepath.Abs(imagePath)
+	if err != nil {
+		source = imagePath
+	}
+	wd, _ := os.Getwd()
+	rec := quarantineRecord{Source: source, Error: cause.Error(), Operator: operatorFor(imagePath), Attempts: attempts, Dir: wd, Flags: quarantineFlags}
+	if !deterministic {
+		rec.RunID = runID
+		rec.QuarantinedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err := moveFile(imagePath, dest); err != nil {
+		return "", err
+	}
+	for _, suffix := range sidecarSuffixes {
+		if fileExists(imagePath + suffix) {
+			moveFile(imagePath+suffix, dest+suffix)
+		}
+	}
+	return dest, writeQuarantineRecord(dest, rec)
+}
+
+func writeQuarantineRecord(path string, rec quarantineRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+quarantineSuffix, append(data, '\n'), 0644)
+}
+
+// moveFile renames src to dst, copying across filesystems when needed.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// runReview lists the items in a quarantine directory, or with -retry
+// reprocesses each one with the flags of the run that quarantined it. A file
+// that succeeds is moved back to its original location and its record removed.
+func runReview(args []string) error {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	dirFlag := fs.String("q", "", "Quarantine directory to review")
+	retryFlag := fs.Bool("retry", false, "Retry every quarantined item with its recorded options")
+	fs.Parse(args)
+	if *dirFlag == "" {
+		return fmt.Errorf("review needs the quarantine directory (-q)")
+	}
+
+	records, err := filepath.Glob(filepath.Join(*dirFlag, "*"+quarantineSuffix))
+	if err != nil {
+		return err
+	}
+	sort.Strings(records)
+	if len(records) == 0 {
+		fmt.Println(tr("Quarantine is empty:"), *dirFlag)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to locate executable: %w", err)
+	}
+
+	var failed int
+	for _, recPath := range records {
+		path, err := filepath.Abs(strings.TrimSuffix(recPath, quarantineSuffix))
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(recPath)
+		var rec quarantineRecord
+		if err == nil {
+			err = json.Unmarshal(data, &rec)
+		}
+		if err != nil {
+			fmt.Printf(tr("Error reading %s: %v\n"), recPath, err)
+			failed++
+			continue
+		}
+		if !*retryFlag {
+			fmt.Printf(tr("%s\n  source:   %s\n  error:    %s\n  attempts: %d\n"), filepath.Base(path), rec.Source, rec.Error, rec.Attempts)
+			continue
+		}
+
+		// Retry in a child process so each item runs with its own recorded
+		// flags, from the directory the original run used
+		cmd := exec.Command(exe, append(append([]string{}, rec.Flags...), "-f", path)...)
+		cmd.Dir = rec.Dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			rec.Attempts++
+			rec.Error = lastErrorLine(string(out), err)
+			writeQuarantineRecord(path, rec)
+			fmt.Printf(tr("Still failing %s: %s\n"), filepath.Base(path), rec.Error)
+			failed++
+			continue
+		}
+
+		// Put the fixed input back where it came from, unless that path is taken
+		restored := rec.Source
+		if fileExists(restored) {
+			fmt.Printf(tr("Recovered %s (left in quarantine; %s already exists)\n"), filepath.Base(path), restored)
+		} else if err := moveFile(path, restored); err != nil {
+			fmt.Printf(tr("Recovered %s (left in quarantine: %v)\n"), filepath.Base(path), err)
+		} else {
+			for _, suffix := range sidecarSuffixes {
+				if fileExists(path + suffix) {
+					moveFile(path+suffix, restored+suffix)
+				}
+			}
+			fmt.Printf(tr("Recovered %s -> %s\n"), filepath.Base(path), restored)
+		}
+		os.Remove(recPath)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d quarantined item(s) still failing", failed)
+	}
+	return nil
+}
+
+// lastErrorLine picks the most specific error message from a child's output.
+func lastErrorLine(out string, err error) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); strings.HasPrefix(line, "Error") {
+			return line
+		}
+	}
+	return err.Error()
+}
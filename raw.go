@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cameraRawExtensions lists the raw containers handed to an external converter.
+var cameraRawExtensions = map[string]bool{
+	".cr2": true,
+	".cr3": true,
+	".nef": true,
+	".arw": true,
+	".dng": true,
+	".orf": true,
+	".raf": true,
+	".rw2": true,
+}
+
+// rawConverter is the external raw developer; empty means auto-detect dcraw or dcraw_emu.
+var rawConverter string
+
+// isCameraRaw reports whether path has a camera raw file extension.
+func isCameraRaw(path string) bool {
+	return cameraRawExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// developRaw runs the external converter on a camera raw file and decodes
+// the RGB image it writes to stdout.
+func developRaw(path string) (image.Image, error) {
+	converter := rawConverter
+	if converter == "" {
+		for _, name := range []string{"dcraw", "dcraw_emu"} {
+			if p, err := exec.LookPath(name); err == nil {
+				converter = p
+				break
+			}
+		}
+		if converter == "" {
+			return nil, fmt.Errorf("camera raw file '%s' requires dcraw or dcraw_emu (LibRaw) in PATH, or -raw-converter", path)
+		}
+	}
+
+	// dcraw writes a PPM to stdout with -c; LibRaw's dcraw_emu needs "-Z -"
+	args := []string{"-c", "-w", path}
+	if strings.HasPrefix(strings.ToLower(filepath.Base(converter)), "dcraw_emu") {
+		args = []string{"-w", "-Z", "-", path}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(converter, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("raw converter '%s' failed: %w: %s", converter, err, strings.TrimSpace(stderr.String()))
+	}
+
+	img, err := decodePPM(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read raw converter output: %w", err)
+	}
+	return img, nil
+}
+
+// decodePPM decodes a binary (P6) portable pixmap with 8- or 16-bit samples.
+func decodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	// The header is four whitespace-separated tokens; '#' starts a comment
+	var fields []string
+	for len(fields) < 4 {
+		var tok []byte
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("ppm: truncated header")
+			}
+			if c == '#' && len(tok) == 0 {
+				if _, err := br.ReadString('\n'); err != nil {
+					return nil, fmt.Errorf("ppm: truncated header")
+				}
+				continue
+			}
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				if len(tok) > 0 {
+					break
+				}
+				continue
+			}
+			tok = append(tok, c)
+		}
+		fields = append(fields, string(tok))
+	}
+	if fields[0] != "P6" {
+		return nil, fmt.Errorf("ppm: unsupported magic '%s'", fields[0])
+	}
+	w, err1 := strconv.Atoi(fields[1])
+	h, err2 := strconv.Atoi(fields[2])
+	maxVal, err3 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil || err3 != nil || w <= 0 || h <= 0 || maxVal <= 0 || maxVal > 65535 {
+		return nil, fmt.Errorf("ppm: invalid header")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	sample := 1
+	if maxVal > 255 {
+		sample = 2
+	}
+	row := make([]byte, w*3*sample)
+	for y := 0; y < h; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("ppm: truncated pixel data")
+		}
+		for x := 0; x < w; x++ {
+			for c := 0; c < 3; c++ {
+				var v int
+				if sample == 1 {
+					v = int(row[3*x+c])
+				} else {
+					v = int(row[2*(3*x+c)])<<8 | int(row[2*(3*x+c)+1])
+				}
+				img.Pix[y*img.Stride+4*x+c] = uint8(v * 255 / maxVal)
+			}
+			img.Pix[y*img.Stride+4*x+3] = 255
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// errReload is returned by -watch and -schedule when SIGHUP asks them to
+// restart with the settings files as they are now.
+var errReload = errors.New("reloading settings")
+
+// checkReloadSettings runs this command again with -check-config prepended,
+// so a job, profile, rows, or policy file broken by an edit is reported while
+// the running process keeps its settings, instead of stopping it on restart.
+func checkReloadSettings() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+	out, err := exec.Command(exe, append([]string{"-check-config"}, os.Args[1:]...)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// reloadAccepted reports whether the settings files check out for a restart,
+// warning and keeping the current settings when they do not.
+func reloadAccepted() bool {
+	if err := checkReloadSettings(); err != nil {
+		fmt.Println(tr("Warning: not reloading settings:"), err)
+		events.Error(fmt.Sprintf("Settings not reloaded (operator: %s, run: %s): %v", operator, runID, err))
+		return false
+	}
+	fmt.Println(tr("Reloading settings"))
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// restart is only available on Unix builds, the only ones sent SIGHUP.
+func restart() error {
+	return fmt.Errorf("reloading settings is not available on this platform")
+}
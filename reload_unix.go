@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// restart replaces the process with a new run of the same command, which
+// reads its job, profile, rows, and policy files again. It only returns on
+// failure.
+func restart() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to restart: %w", err)
+	}
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("failed to restart: %w", err)
+	}
+	return nil
+}
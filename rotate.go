@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// rotation turns images clockwise by 90, 180, or 270 degrees before they are
+// marked (the -rotate flag), for scans that arrive sideways; 0 leaves them
+// as they are.
+var rotation int
+
+// flipAxis mirrors images before they are marked, after any rotation (the
+// -flip flag): "h" swaps left and right, "v" top and bottom, and empty
+// leaves them as they are.
+var flipAxis string
+
+func init() {
+	// Images are turned after color conversion, so the marks go on the
+	// corrected image
+	registerStage(phaseTransform, "orient", func(job *imageJob) error {
+		job.Image = orientImage(job.Image)
+		return nil
+	})
+}
+
+// parseRotation checks a -rotate value.
+func parseRotation(degrees int) (int, error) {
+	switch degrees {
+	case 0, 90, 180, 270:
+		return degrees, nil
+	}
+	return 0, fmt.Errorf("invalid rotation %d (options: 90, 180, 270)", degrees)
+}
+
+// parseFlip checks a -flip value.
+func parseFlip(axis string) (string, error) {
+	switch axis {
+	case "", "h", "v":
+		return axis, nil
+	}
+	return "", fmt.Errorf("invalid flip '%s' (options: h, v)", axis)
+}
+
+// turnsSideways reports whether -rotate swaps the width and height of
+// images, and with them the horizontal and vertical resolution.
+func turnsSideways() bool {
+	return rotation == 90 || rotation == 270
+}
+
+// orientImage returns img turned by -rotate and then mirrored by -flip, or
+// img itself when neither is set.
+func orientImage(img image.Image) image.Image {
+	if rotation == 0 && flipAxis == "" {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if turnsSideways() {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			dx, dy := x, y
+			switch rotation {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			switch flipAxis {
+			case "h":
+				dx = dw - 1 - dx
+			case "v":
+				dy = dh - 1 - dy
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
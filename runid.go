@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// runID identifies this invocation in events, records, and metadata, so
+// artifacts from the same run can be correlated. It is a random UUID unless
+// set with -run-id (or GOCLASSIFYIT_RUN_ID in job mode).
+var runID = newRunID()
+
+// newRunID returns a random (version 4) UUID.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
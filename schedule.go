@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cronMacros are the shorthand -schedule expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronNames are the names accepted for the month and day-of-week fields.
+var cronNames = [][]string{
+	3: {"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"},
+	4: {"sun", "mon", "tue", "wed", "thu", "fri", "sat"},
+}
+
+// cronSchedule is a parsed five-field cron expression. Each field is a set
+// of allowed values, bit n set for value n.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // The day field was "*"
+}
+
+// parseCron parses a cron expression of five fields, "minute hour
+// day-of-month month day-of-week", each "*", a value, a range "a-b", or a
+// list of them, optionally stepped with "/n"; months and days may be given by
+// name, and Sunday is 0 or 7. The macros @hourly, @daily, @weekly, @monthly,
+// and @yearly are accepted too.
+func parseCron(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule '%s' (expected 5 fields: minute hour day-of-month month day-of-week)", expr)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1], cronNames[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday may be written as 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses one field of values from lo to hi.
+func parseCronField(field string, lo, hi int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if name != "" && strings.EqualFold(s, name) {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("'%s' is not a value from %d to %d", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+		}
+		first, last := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = value(a); err != nil {
+				return 0, err
+			}
+			last = first
+			if isRange {
+				if last, err = value(b); err != nil {
+					return 0, err
+				}
+			} else if stepped {
+				last = hi
+			}
+			if last < first {
+				return 0, fmt.Errorf("range '%s' is backwards", rng)
+			}
+		}
+		for v := first; v <= last; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first minute after t that the schedule matches, or the
+// zero time when none does within eight years (e.g. "0 0 30 2 *").
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether t's day is scheduled. As in cron, when both day
+// fields are restricted a day matching either one is.
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// runSchedule stays resident, calling run each time the schedule fires until
+// interrupted. Runs never overlap: times that pass while a run is still going
+// are skipped, and the next run starts at the first time after it finishes.
+// Each run gets a fresh run ID unless -run-id fixed one, and its completion
+// notification and -errors-json report are sent when it finishes. An
+// interrupt during a run stops once the run is done. SIGHUP returns errReload
+// the same way, once the new settings check out.
+func runSchedule(sched *cronSchedule, expr string, fixedRunID bool, run func() error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	for {
+		at := sched.next(time.Now())
+		if at.IsZero() {
+			return fmt.Errorf("schedule '%s' never fires", expr)
+		}
+		fmt.Printf(tr("Next scheduled run at %s (Ctrl+C to stop)\n"), at.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-stop:
+			timer.Stop()
+			fmt.Println(tr("Stopped scheduled runs"))
+			return nil
+		case <-reload:
+			timer.Stop()
+			if reloadAccepted() {
+				return errReload
+			}
+			continue
+		case <-timer.C:
+		}
+
+		if !fixedRunID {
+			runID = newRunID()
+		}
+		resetBatch()
+		resetFailures()
+		fmt.Printf(tr("Scheduled run %s started\n"), runID)
+		events.Info(fmt.Sprintf("Scheduled run started (operator: %s, run: %s)", operator, runID))
+		if err := run(); err != nil {
+			fmt.Printf(tr("Scheduled run %s failed: %v\n"), runID, err)
+			events.Error(fmt.Sprintf("Scheduled run failed (operator: %s, run: %s): %v", operator, runID, err))
+		} else {
+			fmt.Printf(tr("Scheduled run %s finished\n"), runID)
+		}
+		notifyCompletion()
+		if errorsJSONPath != "" {
+			writeFailureReport()
+		}
+
+		if missed := sched.missed(at, time.Now()); missed > 0 {
+			fmt.Printf(tr("Warning: skipped %d scheduled run(s) that fell during run %s\n"), missed, runID)
+		}
+		select {
+		case <-stop:
+			fmt.Println(tr("Stopped scheduled runs"))
+			return nil
+		case <-reload:
+			if reloadAccepted() {
+				return errReload
+			}
+		default:
+		}
+	}
+}
+
+// missed counts the scheduled times after start and up to end.
+func (s *cronSchedule) missed(start, end time.Time) int {
+	n := 0
+	for t := s.next(start); !t.IsZero() && !t.After(end); t = s.next(t) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// bits returns the set of values vs, as parseCronField builds it.
+func bits(vs ...int) uint64 {
+	var set uint64
+	for _, v := range vs {
+		set |= 1 << v
+	}
+	return set
+}
+
+// span returns the set of values from lo to hi, stepping by step.
+func span(lo, hi, step int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << v
+	}
+	return set
+}
+
+func TestParseCronField(t *testing.T) {
+	months, days := cronNames[3], cronNames[4]
+	tests := []struct {
+		field  string
+		lo, hi int
+		names  []string
+		want   uint64
+		err    bool
+	}{
+		{"*", 0, 59, nil, span(0, 59, 1), false},
+		{"5", 0, 59, nil, bits(5), false},
+		{"0,15,30,45", 0, 59, nil, bits(0, 15, 30, 45), false},
+		{"*/15", 0, 59, nil, bits(0, 15, 30, 45), false},
+		{"9-17", 0, 23, nil, span(9, 17, 1), false},
+		{"9-17/4", 0, 23, nil, bits(9, 13, 17), false},
+		{"20/2", 0, 23, nil, bits(20, 22), false}, // A stepped value runs to the end of the field
+		{"1-5,10", 1, 31, nil, bits(1, 2, 3, 4, 5, 10), false},
+		{"jan,JUL", 1, 12, months, bits(1, 7), false},
+		{"mar-may", 1, 12, months, bits(3, 4, 5), false},
+		{"mon-fri", 0, 7, days, span(1, 5, 1), false},
+		{"sun", 0, 7, days, bits(0), false},
+		{"60", 0, 59, nil, 0, true},
+		{"0", 1, 31, nil, 0, true},
+		{"17-9", 0, 23, nil, 0, true},
+		{"*/0", 0, 59, nil, 0, true},
+		{"*/x", 0, 59, nil, 0, true},
+		{"monday", 0, 7, days, 0, true},
+		{"", 0, 59, nil, 0, true},
+		{"1,", 0, 59, nil, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.lo, tt.hi, tt.names)
+		if (err != nil) != tt.err {
+			t.Errorf("parseCronField(%q): err = %v, want error %t", tt.field, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		want *cronSchedule // nil: the expression is invalid
+	}{
+		{"@daily", &cronSchedule{minute: bits(0), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), domAny: true, dowAny: true}},
+		{" @Hourly ", &cronSchedule{minute: bits(0), hour: span(0, 23, 1), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), domAny: true, dowAny: true}},
+		{"30 2 * * 7", &cronSchedule{minute: bits(30), hour: bits(2), dom: span(1, 31, 1), month: span(1, 12, 1), dow: bits(0, 7), domAny: true}}, // Sunday as 7
+		{"0 0 1,15 * mon", &cronSchedule{minute: bits(0), hour: bits(0), dom: bits(1, 15), month: span(1, 12, 1), dow: bits(1)}},
+		{"0 0 * *", nil},
+		{"0 0 * * * *", nil},
+		{"0 24 * * *", nil},
+		{"0 0 32 * *", nil},
+		{"0 0 * 13 *", nil},
+		{"0 0 * * 8", nil},
+		{"@reboot", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseCron(tt.expr)
+		switch {
+		case tt.want == nil && err == nil:
+			t.Errorf("parseCron(%q) succeeded, want an error", tt.expr)
+		case tt.want != nil && err != nil:
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+		case tt.want != nil && *got != *tt.want:
+			t.Errorf("parseCron(%q) = %+v, want %+v", tt.expr, *got, *tt.want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 10, 14, 12, 34, 56, 0, time.UTC) // A Wednesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 14, 12, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2026, 10, 15, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon", time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 9 13 * fri", time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}, // Either day field matches
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := sched.next(from); !got.Equal(tt.want) {
+			t.Errorf("next(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"gopkg.in/yaml.v3"
+)
+
+// serveTokenEnv holds the bearer token serve mode requires, when set.
+const serveTokenEnv = "GOCLASSIFYIT_API_TOKEN"
+
+// serveUploadField is the multipart field holding the image to classify.
+const serveUploadField = "image"
+
+// serveError is the JSON body of a failed request.
+type serveError struct {
+	Error string `json:"error"`
+	Stage string `json:"stage,omitempty"` // As in -errors-json
+	Class string `json:"class,omitempty"`
+}
+
+// imageServer handles classification requests, running at most slots at once.
+type imageServer struct {
+	maxUpload int64
+	tokens    map[string]string // Accepted bearer tokens, each to the identity recorded as the operator of its requests
+	tokensMu  sync.RWMutex      // Guards tokens, which SIGHUP reloads
+	slots     chan struct{}
+}
+
+// runServe serves the HTTP API until interrupted:
+//
+//	POST /classify  multipart upload of "image", with banner settings as form
+//	                fields named as in sidecar files; responds with the image
+//	GET  /healthz   liveness
+//	GET  /readyz    readiness: the banner font loads and uploads can be staged
+//
+// SIGHUP reloads the policy and tokens files without dropping requests.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addrFlag := fs.String("addr", ":8080", "Address to listen on")
+	maxUploadFlag := fs.Int64("max-upload", 64, "Largest accepted upload in MB")
+	workersFlag := fs.Int("workers", workers, "Requests classified at once")
+	operatorFlag := fs.String("operator", "", "Operator identity to record (default: current OS user)")
+	tokensFlag := fs.String("tokens", "", "YAML or JSON file mapping client identities to their bearer tokens")
+	policyFlag := fs.String("policy", "", "Policy file restricting allowed markings (in addition to the system policy)")
+	certFlag := fs.String("tls-cert", "", "TLS certificate file; serves HTTPS with -tls-key")
+	keyFlag := fs.String("tls-key", "", "TLS private key file")
+	fs.Parse(args)
+	if *workersFlag < 1 || *maxUploadFlag < 1 {
+		return fmt.Errorf("serve needs -workers and -max-upload of at least 1")
+	}
+	if (*certFlag == "") != (*keyFlag == "") {
+		return fmt.Errorf("serve needs both -tls-cert and -tls-key for HTTPS")
+	}
+
+	operator = resolveOperator(*operatorFlag)
+	if err := loadPolicies(*policyFlag); err != nil {
+		return err
+	}
+	s := &imageServer{
+		maxUpload: *maxUploadFlag << 20,
+		slots:     make(chan struct{}, *workersFlag),
+	}
+	if err := s.loadTokens(*tokensFlag); err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: *addrFlag, Handler: s.routes(), ReadHeaderTimeout: 10 * time.Second}
+
+	// Requests in flight are finished before exiting
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	done := make(chan error, 1)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	fmt.Printf(tr("Serving on %s (operator: %s, run: %s)\n"), *addrFlag, operator, runID)
+	if len(s.tokens) == 0 {
+		fmt.Printf(tr("Warning: %s is not set; requests are not authenticated\n"), serveTokenEnv)
+	}
+	// Policy and token changes apply without dropping requests
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			s.reload(*policyFlag, *tokensFlag)
+		}
+	}()
+	var err error
+	if *certFlag != "" {
+		err = srv.ListenAndServeTLS(*certFlag, *keyFlag)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-done
+}
+
+// loadTokens sets the accepted bearer tokens: those of the clients in the
+// tokens file at path (if not empty), and the one in serveTokenEnv, which
+// identifies as the process operator.
+func (s *imageServer) loadTokens(path string) error {
+	tokens, err := readTokens(path)
+	if err != nil {
+		return err
+	}
+	s.tokensMu.Lock()
+	s.tokens = tokens
+	s.tokensMu.Unlock()
+	return nil
+}
+
+func readTokens(path string) (map[string]string, error) {
+	tokens := map[string]string{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tokens file: %w", err)
+		}
+		var clients map[string]string
+		if err := yaml.Unmarshal(data, &clients); err != nil {
+			return nil, fmt.Errorf("failed to parse tokens file '%s': %w", path, err)
+		}
+		for who, token := range clients {
+			if token == "" {
+				return nil, fmt.Errorf("tokens file '%s' has no token for '%s'", path, who)
+			}
+			if other, dup := tokens[token]; dup {
+				return nil, fmt.Errorf("tokens file '%s' gives '%s' and '%s' the same token", path, other, who)
+			}
+			tokens[token] = who
+		}
+	}
+	if token := os.Getenv(serveTokenEnv); token != "" {
+		tokens[token] = operator
+	}
+	return tokens, nil
+}
+
+// reload re-reads the policy and tokens files for SIGHUP. When either fails
+// to load, both stay as they were.
+func (s *imageServer) reload(policyPath, tokensPath string) {
+	tokens, err := readTokens(tokensPath)
+	if err == nil {
+		err = loadPolicies(policyPath)
+	}
+	if err != nil {
+		fmt.Println(tr("Warning: not reloading settings:"), err)
+		events.Error(fmt.Sprintf("Settings not reloaded (operator: %s, run: %s): %v", operator, runID, err))
+		return
+	}
+	s.tokensMu.Lock()
+	s.tokens = tokens
+	s.tokensMu.Unlock()
+	fmt.Println(tr("Reloaded the policy and tokens files"))
+	events.Info(fmt.Sprintf("Reloaded the policy and tokens files (operator: %s, run: %s)", operator, runID))
+}
+
+// authenticate returns the identity of the request's bearer token, or the
+// process operator when no tokens are configured. Every token is compared,
+// so the time taken does not tell which one matched.
+func (s *imageServer) authenticate(r *http.Request) (string, bool) {
+	s.tokensMu.RLock()
+	defer s.tokensMu.RUnlock()
+	if len(s.tokens) == 0 {
+		return operator, true
+	}
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	who, ok := "", false
+	for token, name := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			who, ok = name, true
+		}
+	}
+	return who, ok
+}
+
+// routes returns the handler of the API's endpoints.
+func (s *imageServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /classify", s.handleClassify)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := readyCheck(); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	return mux
+}
+
+// readyCheck returns why uploads cannot be classified right now: the banner
+// font does not load, or the staging directory is not writable.
+func readyCheck() error {
+	if _, err := classify.MeasureText("SECRET", 12, bannerFont, fallbackFonts); err != nil {
+		return fmt.Errorf("banner font: %w", err)
+	}
+	f, err := os.CreateTemp("", "goclassifyit-ready-")
+	if err != nil {
+		return fmt.Errorf("staging directory: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// handleClassify marks the uploaded image and streams back the result.
+func (s *imageServer) handleClassify(w http.ResponseWriter, r *http.Request) {
+	who, ok := s.authenticate(r)
+	if !ok {
+		writeServeError(w, http.StatusUnauthorized, serveError{Error: "missing or invalid bearer token"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeServeError(w, http.StatusRequestEntityTooLarge, serveError{Error: fmt.Sprintf("upload exceeds %d MB", s.maxUpload>>20)})
+			return
+		}
+		writeServeError(w, http.StatusBadRequest, serveError{Error: "expected a multipart/form-data body: " + err.Error()})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	banner, bannerHeight, loc, err := requestBanner(r.MultipartForm.Value)
+	if err != nil {
+		writeServeError(w, http.StatusBadRequest, serveError{Error: err.Error()})
+		return
+	}
+	if err := checkPolicy(banner); err != nil {
+		writeServeError(w, http.StatusForbidden, serveError{Error: "policy violation: " + err.Error(), Stage: "policy", Class: "policy"})
+		return
+	}
+
+	upload, header, err := r.FormFile(serveUploadField)
+	if err != nil {
+		writeServeError(w, http.StatusBadRequest, serveError{Error: fmt.Sprintf("missing '%s' file field", serveUploadField)})
+		return
+	}
+	defer upload.Close()
+
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	// Each request is classified in its own staging directory, under the
+	// uploaded name so the format is recognized by its extension
+	tmp, err := os.MkdirTemp("", "goclassifyit-serve-")
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, serveError{Error: "failed to create staging directory"})
+		return
+	}
+	defer os.RemoveAll(tmp)
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(header.Filename, "\\", "/")))
+	if name == "/" || name == "." || isSidecar(name) {
+		name = "upload"
+	}
+	inputPath := filepath.Join(tmp, "in", name)
+	if err := os.MkdirAll(filepath.Dir(inputPath), os.ModePerm); err != nil {
+		writeServeError(w, http.StatusInternalServerError, serveError{Error: "failed to create staging directory"})
+		return
+	}
+	if err := saveUpload(upload, inputPath); err != nil {
+		writeServeError(w, http.StatusInternalServerError, serveError{Error: err.Error()})
+		return
+	}
+
+	// The upload is recorded as classified by the client that sent it
+	inputOperators.Store(inputPath, who)
+	defer inputOperators.Delete(inputPath)
+	outputPath, err := classifyFile(inputPath, banner, filepath.Join(tmp, "out"), bannerHeight, loc)
+	if err != nil {
+		fmt.Printf(tr("Error processing upload '%s': %v\n"), header.Filename, err)
+		events.Error(fmt.Sprintf("Error processing upload '%s' (operator: %s, run: %s): %v", header.Filename, who, runID, err))
+		stage := failureStage(err)
+		class := errorClass(stage, err)
+		status := http.StatusInternalServerError
+		switch class {
+		case "input":
+			status = http.StatusUnprocessableEntity
+		case "policy":
+			status = http.StatusForbidden
+		}
+		// Messages name the upload, not the staging directory
+		msg := strings.ReplaceAll(err.Error(), filepath.Dir(inputPath)+string(filepath.Separator), "")
+		writeServeError(w, status, serveError{Error: msg, Stage: stage, Class: class})
+		return
+	}
+
+	out, err := os.Open(outputPath)
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, serveError{Error: "failed to read output"})
+		return
+	}
+	defer out.Close()
+	info, err := out.Stat()
+	if err != nil {
+		writeServeError(w, http.StatusInternalServerError, serveError{Error: "failed to read output"})
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(outputPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(outputPath)}))
+	w.Header().Set("X-Goclassifyit-Run-Id", runID)
+	if _, err := io.Copy(w, out); err != nil {
+		fmt.Printf(tr("Error sending output for '%s': %v\n"), header.Filename, err)
+		return
+	}
+	fmt.Println(tr("Classified upload:"), header.Filename)
+	events.Info(fmt.Sprintf("Classified upload '%s' as %s (operator: %s, run: %s)", header.Filename, banner.Text, who, runID))
+}
+
+// requestBanner returns the banner settings of a request. The form fields
+// are named as in sidecar files, and classification is required.
+func requestBanner(form url.Values) (classify.BannerMode, int, string, error) {
+	sc := sidecarConfig{
+		Classification:  form.Get("classification"),
+		Text:            form.Get("text"),
+		BackgroundColor: form.Get("background_color"),
+		TextColor:       form.Get("text_color"),
+		Location:        form.Get("location"),
+		Pattern:         form.Get("pattern"),
+		Style:           form.Get("style"),
+		TextAlign:       form.Get("text_align"),
+	}
+	var err error
+	if v := form.Get("banner_height"); v != "" {
+		if sc.BannerHeight, err = strconv.Atoi(v); err != nil || sc.BannerHeight < 1 {
+			return classify.BannerMode{}, 0, "", fmt.Errorf("invalid banner_height '%s'", v)
+		}
+	}
+	if v := form.Get("overlay"); v != "" {
+		if sc.Overlay, err = strconv.ParseBool(v); err != nil {
+			return classify.BannerMode{}, 0, "", fmt.Errorf("invalid overlay '%s'", v)
+		}
+	}
+	if v := form.Get("overlay_opacity"); v != "" {
+		if sc.OverlayOpacity, err = strconv.ParseFloat(v, 64); err != nil {
+			return classify.BannerMode{}, 0, "", fmt.Errorf("invalid overlay_opacity '%s'", v)
+		}
+	}
+	if sc.Classification == "" {
+		return classify.BannerMode{}, 0, "", fmt.Errorf("classification is required")
+	}
+	if sc.Location != "" && sc.Location != "center" && sc.Location != "corners" {
+		return classify.BannerMode{}, 0, "", fmt.Errorf("invalid location '%s' (options: center, corners)", sc.Location)
+	}
+
+	// Custom banners start from the -c custom defaults
+	banner := classify.BannerMode{BgColor: color.RGBA{255, 0, 0, 255}, TextColor: color.RGBA{255, 255, 255, 255}, Opacity: classify.DefaultOverlayOpacity}
+	banner.Pattern, banner.PatternWidth, banner.Style, banner.TextAlign = "solid", 20, "strip", "center"
+	return sc.apply("request", banner, classify.DefaultBannerHeight, "center")
+}
+
+// saveUpload copies an uploaded file to path.
+func saveUpload(upload io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+	if _, err := io.Copy(f, upload); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+	return f.Close()
+}
+
+// writeServeError responds with a JSON error body.
+func writeServeError(w http.ResponseWriter, status int, body serveError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
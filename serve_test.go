@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	if err := os.WriteFile(path, []byte("portal: tok-portal\njdoe: tok-jdoe\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &imageServer{tokens: map[string]string{}}
+	if err := s.loadTokens(path); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		header string
+		who    string
+		ok     bool
+	}{
+		{"Bearer tok-jdoe", "jdoe", true},
+		{"Bearer tok-portal", "portal", true},
+		{"Bearer tok-other", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/classify", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if who, ok := s.authenticate(r); who != tt.who || ok != tt.ok {
+			t.Errorf("Authorization %q: got (%q, %t), want (%q, %t)", tt.header, who, ok, tt.who, tt.ok)
+		}
+	}
+}
+
+func TestServeProbes(t *testing.T) {
+	s := &imageServer{tokens: map[string]string{"secret": "jdoe"}}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s without a token: status %d, body %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("jdoe: tok-old\n")
+	s := &imageServer{}
+	if err := s.loadTokens(path); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		file     string
+		accepted string // Token accepted after the reload
+		rejected string
+	}{
+		{"rotated token", "jdoe: tok-new\n", "tok-new", "tok-old"},
+		{"broken file keeps the tokens", "jdoe: \"\"\n", "tok-new", "tok-old"},
+	}
+	for _, tt := range tests {
+		write(tt.file)
+		s.reload("", path)
+		for token, want := range map[string]bool{tt.accepted: true, tt.rejected: false} {
+			r := httptest.NewRequest("POST", "/classify", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			if _, ok := s.authenticate(r); ok != want {
+				t.Errorf("%s: token %q accepted = %t, want %t", tt.name, token, ok, want)
+			}
+		}
+	}
+}
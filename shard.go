@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shardSpec selects one slice of a directory for a worker: with Count > 1,
+// only files whose path hashes to Index modulo Count are processed. Every
+// worker hashes the same way, so machines can split an archive without a
+// coordinator or queue.
+type shardSpec struct {
+	Index int
+	Count int
+}
+
+// shard is the run-level shard; the zero value processes every file.
+var shard shardSpec
+
+// parseShard parses a "K/N" shard specification.
+func parseShard(spec string) (shardSpec, error) {
+	k, n, ok := strings.Cut(spec, "/")
+	index, err1 := strconv.Atoi(strings.TrimSpace(k))
+	count, err2 := strconv.Atoi(strings.TrimSpace(n))
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 0 || index >= count {
+		return shardSpec{}, fmt.Errorf("invalid shard '%s' (use K/N with 0 <= K < N, e.g. 3/8)", spec)
+	}
+	return shardSpec{Index: index, Count: count}, nil
+}
+
+// listFiles streams the files of dirPath, restricted to this worker's shard
+// when sharding is enabled and to recently modified files with fileAge.
+func listFiles(dirPath string) (<-chan string, <-chan error) {
+	var paths <-chan string
+	var errc <-chan error
+	if shard.Count <= 1 {
+		paths, errc = streamFiles(dirPath)
+	} else {
+		paths, errc = streamShard(dirPath, shard)
+	}
+	if cutoff := fileAge.cutoff(time.Now()); !cutoff.IsZero() {
+		paths = filterAge(paths, cutoff)
+	}
+	return paths, errc
+}
+
+// streamShard sends the files of dirPath belonging to s as they are listed,
+// so no worker holds or sorts the whole listing.
+func streamShard(dirPath string, s shardSpec) (<-chan string, <-chan error) {
+	paths := make(chan string, walkQueueSize)
+	all, errc := streamFiles(dirPath)
+
+	go func() {
+		defer close(paths)
+		for path := range all {
+			if shardOf(dirPath, path, s.Count) == s.Index {
+				paths <- path
+			}
+		}
+	}()
+	return paths, errc
+}
+
+// shardOf returns the shard of path, listed under dirPath, out of count: an
+// FNV-1a hash of its slash-separated path relative to dirPath. Workers agree
+// on it whatever order their listings come in and wherever they mount the
+// directory, and files added later do not move others between shards.
+func shardOf(dirPath, path string, count int) int {
+	rel := strings.TrimPrefix(path, strings.TrimSuffix(dirPath, "/")+"/")
+	if !isRemote(dirPath) {
+		if r, err := filepath.Rel(dirPath, path); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(rel))
+	return int(h.Sum64() % uint64(count))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		rel       string // Path relative to dir, whose shard path must share
+	}{
+		{"archive", filepath.Join("archive", "a.png"), "a.png"},
+		{"./archive/", filepath.Join("archive", "sub", "b.jpg"), "sub/b.jpg"},
+		{"/mnt/nas/archive", filepath.Join("/mnt/nas/archive", "sub", "b.jpg"), "sub/b.jpg"},
+		{"s3://scans/archive/", "s3://scans/archive/sub/b.jpg", "sub/b.jpg"},
+	}
+	for _, tt := range tests {
+		if got, want := shardOf(tt.dir, tt.path, 7), shardOf("other", "other/"+tt.rel, 7); got != want {
+			t.Errorf("shardOf(%q, %q) = %d, want %d as for %q under any directory", tt.dir, tt.path, got, want, tt.rel)
+		}
+	}
+}
+
+func TestStreamShard(t *testing.T) {
+	dir := t.TempDir()
+	const files, count = 60, 3
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("scan%02d.png", i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]int{}
+	for index := 0; index < count; index++ {
+		paths, errc := streamShard(dir, shardSpec{Index: index, Count: count})
+		n := 0
+		for path := range paths {
+			seen[path]++
+			n++
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Errorf("shard %d/%d is empty", index, count)
+		}
+	}
+	if len(seen) != files {
+		t.Errorf("shards cover %d files, want %d", len(seen), files)
+	}
+	for path, n := range seen {
+		if n != 1 {
+			t.Errorf("%s is in %d shards, want 1", path, n)
+		}
+	}
+}
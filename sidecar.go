@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"gopkg.in/yaml.v3"
+)
+
+// sidecarSuffix is appended to an input's file name to locate its override file.
+const sidecarSuffix = ".goclassifyit.yaml"
+
+// portionsSuffix is appended to an input's file name to locate its portions
+// file, a JSON list of regions marked with their own labels.
+const portionsSuffix = ".portions.json"
+
+// sidecarSuffixes are the suffixes of every file that travels with an input.
+var sidecarSuffixes = []string{sidecarSuffix, portionsSuffix}
+
+// sidecarConfig holds per-image overrides read from "<image>.goclassifyit.yaml".
+// Unset fields keep the run-level value.
+type sidecarConfig struct {
+	Classification  string  `yaml:"classification"`
+	Text            string  `yaml:"text"`
+	BackgroundColor string  `yaml:"background_color"`
+	TextColor       string  `yaml:"text_color"`
+	Location        string  `yaml:"location"`
+	BannerHeight    int     `yaml:"banner_height"`
+	Pattern         string  `yaml:"pattern"`
+	Style           string  `yaml:"style"`
+	TextAlign       string  `yaml:"text_align"`
+	Overlay         bool    `yaml:"overlay"`
+	OverlayOpacity  float64 `yaml:"overlay_opacity"`
+}
+
+// isSidecar reports whether path is a sidecar override or portions file
+// rather than an image.
+func isSidecar(path string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySidecar returns the banner settings for imagePath, applying the
+// overrides from its sidecar file when one exists next to it.
+func applySidecar(imagePath string, banner classify.BannerMode, bannerHeight int, loc string) (classify.BannerMode, int, string, error) {
+	path := imagePath + sidecarSuffix
+	data, err := readFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return banner, bannerHeight, loc, nil
+	}
+	if err != nil {
+		return banner, bannerHeight, loc, fmt.Errorf("failed to read sidecar '%s': %w", path, err)
+	}
+
+	var sc sidecarConfig
+	if err := yaml.Unmarshal(data, &sc); err != nil {
+		return banner, bannerHeight, loc, fmt.Errorf("failed to parse sidecar '%s': %w", path, err)
+	}
+	return sc.apply(fmt.Sprintf("sidecar '%s'", path), banner, bannerHeight, loc)
+}
+
+// applyPortions returns banner with the portions from imagePath's portions
+// file, which replace any -portion regions, when one exists next to it.
+func applyPortions(imagePath string, banner classify.BannerMode) (classify.BannerMode, error) {
+	path := imagePath + portionsSuffix
+	data, err := readFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return banner, nil
+	}
+	if err != nil {
+		return banner, fmt.Errorf("failed to read portions file '%s': %w", path, err)
+	}
+	if banner.Portions, err = classify.ParsePortions(data, banner); err != nil {
+		return banner, fmt.Errorf("failed to parse portions file '%s': %w", path, err)
+	}
+	return banner, nil
+}
+
+// apply returns the banner settings with the overrides in sc applied. source
+// names where they came from in error messages.
+func (sc sidecarConfig) apply(source string, banner classify.BannerMode, bannerHeight int, loc string) (classify.BannerMode, int, string, error) {
+	var err error
+	// A different classification replaces the colors and text, keeping styling
+	if sc.Classification != "" && sc.Classification != "custom" {
+		preset, ok := classify.Presets[sc.Classification]
+		if !ok {
+			return banner, bannerHeight, loc, fmt.Errorf("%s: invalid classification mode '%s'", source, sc.Classification)
+		}
+		banner.BgColor, banner.TextColor, banner.Text = preset.BgColor, preset.TextColor, preset.Text
+	}
+	if sc.Classification == "custom" && sc.Text == "" {
+		return banner, bannerHeight, loc, fmt.Errorf("%s: custom classification requires text", source)
+	}
+	if sc.Text != "" {
+		banner.Text = sc.Text
+	}
+	if sc.BackgroundColor != "" {
+		if banner.BgColor, err = classify.ParseRGB(sc.BackgroundColor); err != nil {
+			return banner, bannerHeight, loc, fmt.Errorf("%s background color: %w", source, err)
+		}
+	}
+	if sc.TextColor != "" {
+		if banner.TextColor, err = classify.ParseTextColor(sc.TextColor, banner.BgColor); err != nil {
+			return banner, bannerHeight, loc, fmt.Errorf("%s text color: %w", source, err)
+		}
+	}
+	if sc.Location != "" {
+		loc = sc.Location
+	}
+	if sc.BannerHeight > 0 {
+		bannerHeight = sc.BannerHeight
+	}
+	if sc.Pattern != "" {
+		if err := classify.ValidatePattern(sc.Pattern); err != nil {
+			return banner, bannerHeight, loc, fmt.Errorf("%s: %w", source, err)
+		}
+		banner.Pattern = sc.Pattern
+	}
+	switch sc.Style {
+	case "":
+	case "strip", "pill":
+		banner.Style = sc.Style
+	default:
+		return banner, bannerHeight, loc, fmt.Errorf("%s: invalid style '%s' (options: strip, pill)", source, sc.Style)
+	}
+	switch sc.TextAlign {
+	case "":
+	case "left", "center", "right":
+		banner.TextAlign = sc.TextAlign
+	default:
+		return banner, bannerHeight, loc, fmt.Errorf("%s: invalid text_align '%s' (options: left, center, right)", source, sc.TextAlign)
+	}
+	if sc.Overlay {
+		banner.Overlay = true
+	}
+	if sc.OverlayOpacity != 0 {
+		if sc.OverlayOpacity < 0 || sc.OverlayOpacity > 1 {
+			return banner, bannerHeight, loc, fmt.Errorf("%s: overlay_opacity must be greater than 0 and at most 1", source)
+		}
+		banner.Opacity = sc.OverlayOpacity
+	}
+	return banner, bannerHeight, loc, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// stdioName is the path given to -f and -o for standard input and output.
+const stdioName = "-"
+
+// imageStdout is the process's standard output once -f - or -o - has moved
+// informational messages to standard error.
+var imageStdout = os.Stdout
+
+// headerLine matches the first line of an RFC 5322 message.
+var headerLine = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*:[ \t]`)
+
+// stdinExtensions name the formats processed by extension, recognized by
+// their leading bytes. Formats not listed are recognized when decoded.
+var stdinExtensions = []struct {
+	magic []byte
+	ext   string
+}{
+	{[]byte("%PDF-"), ".pdf"},
+	{[]byte("GIF87a"), ".gif"},
+	{[]byte("GIF89a"), ".gif"},
+	{[]byte("II*\x00"), ".tif"},
+	{[]byte("MM\x00*"), ".tif"},
+	{[]byte("\x00\x00\x01\x00"), ".ico"},
+}
+
+// redirectStdout sends informational messages to standard error, keeping
+// standard output for the image bytes written by -o -, and clear of
+// messages when reading standard input.
+func redirectStdout() {
+	imageStdout = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// stageStdin copies standard input to a temporary file for -f -, named
+// after its detected format so it is processed like a file of that type, and
+// returns its path. The file is removed at shutdown.
+func stageStdin() (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read standard input: %w", err)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("standard input is empty")
+	}
+	tmp, err := os.MkdirTemp("", "goclassifyit-stdin-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	onShutdown(func() { os.RemoveAll(tmp) })
+
+	path := filepath.Join(tmp, "stdin"+stdinExtension(data))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to stage standard input: %w", err)
+	}
+	return path, nil
+}
+
+// stdinExtension returns the extension for data whose format is chosen by
+// extension, or "" for formats recognized when decoded.
+func stdinExtension(data []byte) string {
+	for _, f := range stdinExtensions {
+		if bytes.HasPrefix(data, f.magic) {
+			return f.ext
+		}
+	}
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return ".avif"
+	}
+	if headerLine.Match(data) {
+		return ".eml"
+	}
+	return ""
+}
+
+// stdoutOutputDir returns a temporary output directory for -o -, removed at
+// shutdown.
+func stdoutOutputDir() (string, error) {
+	tmp, err := os.MkdirTemp("", "goclassifyit-stdout-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	onShutdown(func() { os.RemoveAll(tmp) })
+	return tmp, nil
+}
+
+// writeStdout copies the output file to standard output.
+func writeStdout(outputPath string) error {
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read output: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(imageStdout, f); err != nil {
+		return fmt.Errorf("failed to write standard output: %w", err)
+	}
+	return nil
+}
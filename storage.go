@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage is a place inputs are read from and outputs written to. Every file
+// the pipeline reads or writes, local ones included, goes through the
+// backend of its location, so a new backend only needs a storageSchemes
+// entry. Names are slash-separated paths within remote backends and local
+// paths for localStorage; sidecar and output names are derived from them the
+// same way for both.
+type Storage interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error) // Creates missing parent directories, as object stores do
+	List(dir string) ([]string, error)          // Names of the regular files directly in dir
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// storageSchemes maps location URL schemes to their backends. Locations
+// without one of these schemes are local paths.
+var storageSchemes = map[string]func(host, name string) (Storage, string, error){
+	"s3":   newS3Storage,
+	"gs":   newGCSStorage,
+	"az":   newAzureStorage,
+	"sftp": newSFTPStorage,
+}
+
+// openStorage returns the backend for location and the name of location
+// within it, e.g. "s3://archive/scans/a.png" is "scans/a.png" in the S3
+// bucket "archive".
+func openStorage(location string) (Storage, string, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	newStorage, known := storageSchemes[scheme]
+	if !ok || !known {
+		return localStorage{}, location, nil
+	}
+	host, name, _ := strings.Cut(rest, "/")
+	if host == "" {
+		return nil, "", fmt.Errorf("storage location '%s' has no bucket or host", location)
+	}
+	return newStorage(host, name)
+}
+
+// isRemote reports whether location names a non-local storage backend.
+func isRemote(location string) bool {
+	scheme, _, ok := strings.Cut(location, "://")
+	_, known := storageSchemes[scheme]
+	return ok && known
+}
+
+// joinLocation returns the location of name inside the directory location dir.
+func joinLocation(dir, name string) string {
+	if isRemote(dir) {
+		return strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// statLocation stats a local path or remote location.
+func statLocation(location string) (fs.FileInfo, error) {
+	s, name, err := openStorage(location)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stat(name)
+}
+
+// treeStorage is a Storage with real subdirectories, which directory mode
+// reads a batch of entries at a time and descends into with -r. Backends
+// without it are listed flat.
+type treeStorage interface {
+	Storage
+	// streamDir sends the name of each file directly in dir to paths and
+	// returns the names of its subdirectories.
+	streamDir(dir string, paths chan<- string) ([]string, error)
+}
+
+// localStorage is the local filesystem.
+type localStorage struct{}
+
+func (localStorage) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+func (localStorage) Stat(name string) (fs.FileInfo, error)   { return os.Stat(name) }
+
+func (localStorage) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return os.Create(name)
+}
+
+func (localStorage) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// objectInfo describes a remote object, or a prefix standing in for a
+// directory.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (o objectInfo) Name() string       { return path.Base(o.name) }
+func (o objectInfo) Size() int64        { return o.size }
+func (o objectInfo) ModTime() time.Time { return o.modTime }
+func (o objectInfo) IsDir() bool        { return o.dir }
+func (o objectInfo) Sys() any           { return nil }
+
+func (o objectInfo) Mode() fs.FileMode {
+	if o.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// dirPrefix returns dir as an object-store listing prefix.
+func dirPrefix(dir string) string {
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		return dir + "/"
+	}
+	return ""
+}
+
+// uploadWriter buffers an object and uploads it on Close, since object
+// stores take whole objects.
+type uploadWriter struct {
+	bytes.Buffer
+	upload func(data []byte) error
+}
+
+func (w *uploadWriter) Close() error { return w.upload(w.Bytes()) }
+
+// localCopy returns a local path holding the file at location, for external
+// tools that take a path: location itself when it is local, and otherwise a
+// downloaded copy with the same name, which cleanup removes.
+func localCopy(location string) (path string, cleanup func(), err error) {
+	if !isRemote(location) {
+		return location, func() {}, nil
+	}
+	data, err := readFile(location)
+	if err != nil {
+		return "", nil, err
+	}
+	tmp, err := os.MkdirTemp("", "goclassifyit-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	path = filepath.Join(tmp, filepath.Base(location))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, fmt.Errorf("failed to stage '%s': %w", location, err)
+	}
+	return path, func() { os.RemoveAll(tmp) }, nil
+}
+
+// copyLocation copies the file at src to dst, either of which may be remote.
+func copyLocation(src, dst string) error {
+	from, srcName, err := openStorage(src)
+	if err != nil {
+		return err
+	}
+	to, dstName, err := openStorage(dst)
+	if err != nil {
+		return err
+	}
+	in, err := from.Open(srcName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := to.Create(dstName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
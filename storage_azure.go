@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// azureAPIVersion is the Blob service REST version requests are made with.
+const azureAPIVersion = "2021-08-06"
+
+// azureStorage is an Azure Blob Storage container, addressed as
+// az://account/container/path. Requests carry the shared access signature
+// in AZURE_STORAGE_SAS_TOKEN (or none, for public containers);
+// AZURE_STORAGE_ENDPOINT replaces the account endpoint, e.g. for Azurite.
+type azureStorage struct {
+	base      string // Endpoint URL including the container
+	container string
+	sas       url.Values
+}
+
+func newAzureStorage(account, name string) (Storage, string, error) {
+	container, name, _ := strings.Cut(name, "/")
+	if container == "" {
+		return nil, "", fmt.Errorf("az://%s needs a container (az://account/container/path)", account)
+	}
+	endpoint := envOr("AZURE_STORAGE_ENDPOINT", "https://"+account+".blob.core.windows.net")
+	sas, err := url.ParseQuery(strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+	}
+	s := &azureStorage{base: strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(container), container: container, sas: sas}
+	return s, name, nil
+}
+
+// blobURL returns the URL of the blob name (the container when name is
+// empty) with the SAS and any extra query parameters.
+func (s *azureStorage) blobURL(name string, query url.Values) string {
+	u := s.base
+	if name != "" {
+		u += "/" + (&url.URL{Path: name}).EscapedPath()
+	}
+	q := url.Values{}
+	for k, v := range s.sas {
+		q[k] = v
+	}
+	for k, v := range query {
+		q[k] = v
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+// do sends a request and returns the response when its status is 2xx.
+func (s *azureStorage) do(method, name string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.blobURL(name, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("az container %s: %s: %w", s.container, name, fs.ErrNotExist)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("azure %s %s/%s: %s %s", method, s.container, name, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (s *azureStorage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *azureStorage) Create(name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		resp, err := s.do(http.MethodPut, name, nil, data, http.Header{"X-Ms-Blob-Type": {"BlockBlob"}})
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// azureListResult is the part of a List Blobs response that is used.
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name string
+		}
+		BlobPrefix []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+func (s *azureStorage) list(prefix string, max int) ([]azureListResult, error) {
+	var pages []azureListResult
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}, "delimiter": {"/"}}
+	if max > 0 {
+		query.Set("maxresults", fmt.Sprint(max))
+	}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page azureListResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure listing: %w", err)
+		}
+		pages = append(pages, page)
+		if page.NextMarker == "" || max > 0 {
+			return pages, nil
+		}
+		query.Set("marker", page.NextMarker)
+	}
+}
+
+func (s *azureStorage) List(dir string) ([]string, error) {
+	prefix := dirPrefix(dir)
+	pages, err := s.list(prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, page := range pages {
+		for _, blob := range page.Blobs.Blob {
+			if name := strings.TrimPrefix(blob.Name, prefix); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// Stat reports a blob, or a directory when name is a prefix of blobs.
+func (s *azureStorage) Stat(name string) (fs.FileInfo, error) {
+	if strings.Trim(name, "/") == "" {
+		return objectInfo{name: s.container, dir: true}, nil
+	}
+	resp, err := s.do(http.MethodHead, name, nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return objectInfo{name: name, size: resp.ContentLength, modTime: modTime}, nil
+	}
+	pages, listErr := s.list(dirPrefix(name), 1)
+	if listErr == nil && (len(pages[0].Blobs.Blob) > 0 || len(pages[0].Blobs.BlobPrefix) > 0) {
+		return objectInfo{name: name, dir: true}, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsMetadataToken is where GCE, GKE, and Cloud Run serve an access token for
+// the attached service account.
+const gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcsStorage is a Google Cloud Storage bucket accessed through the JSON API.
+// It authenticates with GOOGLE_OAUTH_ACCESS_TOKEN when set and the metadata
+// server otherwise; STORAGE_EMULATOR_HOST points it at an emulator instead.
+type gcsStorage struct {
+	bucket   string
+	endpoint string
+	emulator bool
+	token    string // GOOGLE_OAUTH_ACCESS_TOKEN, used as is
+}
+
+// gcsTokenCache is the access token last fetched from the metadata
+// server. Locations are opened file by file, so it is shared by every
+// bucket rather than fetched again for each.
+var gcsTokenCache struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCSStorage(bucket, name string) (Storage, string, error) {
+	s := &gcsStorage{bucket: bucket, endpoint: "https://storage.googleapis.com", token: os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		s.endpoint, s.emulator = strings.TrimSuffix(host, "/"), true
+	}
+	return s, name, nil
+}
+
+// accessToken returns a bearer token, refreshing it from the metadata server
+// shortly before it expires.
+func (s *gcsStorage) accessToken() (string, error) {
+	if s.emulator || s.token != "" {
+		return s.token, nil
+	}
+	cached := &gcsTokenCache
+	cached.Lock()
+	defer cached.Unlock()
+	if time.Until(cached.expires) > time.Minute {
+		return cached.token, nil
+	}
+	req, _ := http.NewRequest(http.MethodGet, gcsMetadataToken, nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gs://%s needs GOOGLE_OAUTH_ACCESS_TOKEN or a metadata server: %w", s.bucket, err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a GCS access token from the metadata server (%s)", resp.Status)
+	}
+	cached.token, cached.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+	return cached.token, nil
+}
+
+// do sends an authenticated request and returns the response when its status
+// is 2xx.
+func (s *gcsStorage) do(method, rawURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("gs://%s: %w", s.bucket, fs.ErrNotExist)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("gcs %s gs://%s: %s %s", method, s.bucket, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (s *gcsStorage) objectURL(name string) string {
+	return s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(name)
+}
+
+func (s *gcsStorage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *gcsStorage) Create(name string) (io.WriteCloser, error) {
+	upload := s.endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + url.Values{"uploadType": {"media"}, "name": {name}}.Encode()
+	return &uploadWriter{upload: func(data []byte) error {
+		resp, err := s.do(http.MethodPost, upload, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// gcsObject is the part of an object resource that is used.
+type gcsObject struct {
+	Name    string    `json:"name"`
+	Size    string    `json:"size"` // int64 encoded as a string
+	Updated time.Time `json:"updated"`
+}
+
+func (o gcsObject) info() objectInfo {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	return objectInfo{name: o.Name, size: size, modTime: o.Updated}
+}
+
+type gcsListPage struct {
+	Items         []gcsObject `json:"items"`
+	Prefixes      []string    `json:"prefixes"`
+	NextPageToken string      `json:"nextPageToken"`
+}
+
+func (s *gcsStorage) list(prefix string, max int) ([]gcsListPage, error) {
+	var pages []gcsListPage
+	query := url.Values{"prefix": {prefix}, "delimiter": {"/"}}
+	if max > 0 {
+		query.Set("maxResults", strconv.Itoa(max))
+	}
+	for {
+		resp, err := s.do(http.MethodGet, s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page gcsListPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid GCS listing: %w", err)
+		}
+		pages = append(pages, page)
+		if page.NextPageToken == "" || max > 0 {
+			return pages, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (s *gcsStorage) List(dir string) ([]string, error) {
+	prefix := dirPrefix(dir)
+	pages, err := s.list(prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, page := range pages {
+		for _, obj := range page.Items {
+			if name := strings.TrimPrefix(obj.Name, prefix); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// Stat reports an object, or a directory when name is a prefix of objects.
+func (s *gcsStorage) Stat(name string) (fs.FileInfo, error) {
+	if strings.Trim(name, "/") == "" {
+		return objectInfo{name: s.bucket, dir: true}, nil
+	}
+	resp, err := s.do(http.MethodGet, s.objectURL(name), nil)
+	if err == nil {
+		defer resp.Body.Close()
+		var obj gcsObject
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			return nil, fmt.Errorf("invalid GCS object metadata: %w", err)
+		}
+		return obj.info(), nil
+	}
+	pages, listErr := s.list(dirPrefix(name), 1)
+	if listErr == nil && (len(pages[0].Items) > 0 || len(pages[0].Prefixes) > 0) {
+		return objectInfo{name: name, dir: true}, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Storage is an S3 bucket, or an S3-compatible service when
+// AWS_ENDPOINT_URL is set. Requests are signed with Signature Version 4
+// using the standard AWS_* credential variables.
+type s3Storage struct {
+	bucket   string
+	region   string
+	endpoint string // Scheme and host; path-style addressing when set
+	key      string
+	secret   string
+	token    string
+}
+
+func newS3Storage(bucket, name string) (Storage, string, error) {
+	s := &s3Storage{
+		bucket:   bucket,
+		region:   envOr("AWS_REGION", envOr("AWS_DEFAULT_REGION", "us-east-1")),
+		endpoint: strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		key:      os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.key == "" || s.secret == "" {
+		return nil, "", fmt.Errorf("s3://%s needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", bucket)
+	}
+	return s, name, nil
+}
+
+// objectURL returns the URL of key (the bucket itself when key is empty).
+func (s *s3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	if s.endpoint != "" {
+		base, _ := url.Parse(s.endpoint)
+		u.Scheme, u.Host, u.Path = base.Scheme, base.Host, "/"+s.bucket+"/"+key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	return u
+}
+
+// do sends a signed request and returns the response when its status is 2xx.
+func (s *s3Storage) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("s3://%s/%s: %w", s.bucket, key, fs.ErrNotExist)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("s3 %s s3://%s/%s: %s %s", method, s.bucket, key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// sign adds Signature Version 4 headers to req.
+func (s *s3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	payload := sha256.Sum256(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payload[:]))
+	if s.token != "" {
+		req.Header.Set("x-amz-security-token", s.token)
+	}
+
+	canonical, signedHeaders := canonicalRequest(req, hex.EncodeToString(payload[:]))
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.secret), date)
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.key, scope, signedHeaders, signature))
+}
+
+// canonicalRequest returns the canonical request of req, whose headers are
+// set, and the names of the headers it signs.
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	var names []string
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode percent-encodes everything but unreserved characters, keeping
+// slashes unless encodeSlash is set, as Signature Version 4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Create(name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		resp, err := s.do(http.MethodPut, name, nil, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}}, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response that is used.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Storage) list(prefix string, max int) ([]s3ListResult, error) {
+	var pages []s3ListResult
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	if max > 0 {
+		query.Set("max-keys", fmt.Sprint(max))
+	}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 listing: %w", err)
+		}
+		pages = append(pages, page)
+		if !page.IsTruncated || max > 0 {
+			return pages, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *s3Storage) List(dir string) ([]string, error) {
+	prefix := dirPrefix(dir)
+	pages, err := s.list(prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, page := range pages {
+		for _, obj := range page.Contents {
+			if name := strings.TrimPrefix(obj.Key, prefix); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// Stat reports an object, or a directory when name is a prefix of objects.
+func (s *s3Storage) Stat(name string) (fs.FileInfo, error) {
+	if strings.Trim(name, "/") == "" {
+		return objectInfo{name: s.bucket, dir: true}, nil
+	}
+	resp, err := s.do(http.MethodHead, name, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return objectInfo{name: name, size: resp.ContentLength, modTime: modTime}, nil
+	}
+	pages, listErr := s.list(dirPrefix(name), 1)
+	if listErr == nil && (len(pages[0].Contents) > 0 || len(pages[0].CommonPrefixes) > 0) {
+		return objectInfo{name: name, dir: true}, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sftpStorage is a directory tree on an SFTP server, addressed as
+// sftp://[user@]host[:port]/path. Transfers run the system OpenSSH sftp
+// client in batch mode, so authentication must not prompt (keys or an agent)
+// and host keys come from the usual known_hosts files.
+type sftpStorage struct {
+	target string // [user@]host
+	port   string
+}
+
+func newSFTPStorage(host, name string) (Storage, string, error) {
+	s := &sftpStorage{target: host}
+	if target, port, err := net.SplitHostPort(host); err == nil {
+		s.target, s.port = target, port
+	}
+	// Paths after the host are absolute on the server
+	return s, "/" + name, nil
+}
+
+// run executes sftp batch commands, failing if any of them fails.
+func (s *sftpStorage) run(commands ...string) (string, error) {
+	args := []string{"-q", "-b", "-", "-o", "BatchMode=yes"}
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	cmd := exec.Command("sftp", append(args, s.target)...)
+	cmd.Stdin = strings.NewReader(strings.Join(commands, "\n") + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("sftp %s: %v: %s", s.target, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// sftpQuote quotes an argument for an sftp batch command.
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// tempReader is a downloaded file that is removed when closed.
+type tempReader struct{ *os.File }
+
+func (t tempReader) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+func (s *sftpStorage) Open(name string) (io.ReadCloser, error) {
+	tmp, err := os.CreateTemp("", "goclassifyit-sftp-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	if _, err := s.run("get " + sftpQuote(name) + " " + sftpQuote(tmp.Name())); err != nil {
+		os.Remove(tmp.Name())
+		// A missing file is told apart, so absent sidecars are not errors
+		if _, serr := s.Stat(name); errors.Is(serr, fs.ErrNotExist) {
+			return nil, serr
+		}
+		return nil, err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tempReader{f}, nil
+}
+
+func (s *sftpStorage) Create(name string) (io.WriteCloser, error) {
+	return &uploadWriter{upload: func(data []byte) error {
+		tmp, err := os.CreateTemp("", "goclassifyit-sftp-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		_, err = s.run("put " + sftpQuote(tmp.Name()) + " " + sftpQuote(name))
+		return err
+	}}, nil
+}
+
+// entries lists dir with "ls -la", returning files and subdirectories.
+func (s *sftpStorage) entries(dir string) ([]objectInfo, error) {
+	out, err := s.run("ls -la " + sftpQuote(dir))
+	if err != nil {
+		return nil, err
+	}
+	var infos []objectInfo
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		// -rw-r--r--    1 user     group        1234 Jan  1 12:00 name
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 9 || (fields[0][0] != '-' && fields[0][0] != 'd') {
+			continue
+		}
+		name := path.Base(strings.Join(fields[8:], " "))
+		if name == "." || name == ".." {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[4], 10, 64)
+		infos = append(infos, objectInfo{name: path.Join(dir, name), size: size, dir: fields[0][0] == 'd'})
+	}
+	return infos, nil
+}
+
+func (s *sftpStorage) List(dir string) ([]string, error) {
+	infos, err := s.entries(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.dir {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// Stat finds name in a listing of its parent directory.
+func (s *sftpStorage) Stat(name string) (fs.FileInfo, error) {
+	name = path.Clean(name)
+	if name == "/" {
+		return objectInfo{name: name, dir: true}, nil
+	}
+	infos, err := s.entries(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name() == path.Base(name) {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("sftp://%s%s: %w", s.target, name, fs.ErrNotExist)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most bytes read or written between -io-rate waits,
+// keeping the transfer rate smooth.
+const throttleChunk = 256 << 10
+
+// ioLimiter paces image reads and writes to -io-rate, or is nil when
+// unlimited. It is shared by all workers.
+var ioLimiter *rateLimiter
+
+// fileSlots bounds the image files open at once to -max-open-files, or is
+// nil when unlimited.
+var fileSlots chan struct{}
+
+// rateLimiter spaces transfers so they average at most rate bytes per second.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time // When the next transfer may start
+}
+
+// wait blocks until n more bytes may be transferred.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// parseRate parses an -io-rate such as "50MB/s", "800KiB/s", or "1GB" into
+// bytes per second. KB, MB, and GB are powers of 1000; KiB, MiB, and GiB of
+// 1024.
+func parseRate(s string) (float64, error) {
+	v := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"B", 1},
+	}
+	scale := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(v), strings.ToUpper(u.suffix)) {
+			v, scale = strings.TrimSpace(v[:len(v)-len(u.suffix)]), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s' (expected e.g. 50MB/s or 800KiB/s)", s)
+	}
+	return n * scale, nil
+}
+
+// limitedFile is an image input or output, opened through the storage of its
+// location, whose reads and writes are paced by ioLimiter and which holds a
+// fileSlots slot until closed.
+type limitedFile struct {
+	r       io.Reader // Set for a file opened for reading
+	w       io.Writer // Set for a file created for writing
+	c       io.Closer
+	release sync.Once
+	err     error // Error of closing c
+}
+
+// openFile opens an image file for reading, waiting for a free slot.
+func openFile(location string) (*limitedFile, error) {
+	return acquireFile(func() (*limitedFile, error) {
+		s, name, err := openStorage(location)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := s.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &limitedFile{r: rc, c: rc}, nil
+	})
+}
+
+// createFile creates an image output file, and any missing directories
+// above it, waiting for a free slot.
+func createFile(location string) (*limitedFile, error) {
+	return acquireFile(func() (*limitedFile, error) {
+		s, name, err := openStorage(location)
+		if err != nil {
+			return nil, err
+		}
+		wc, err := s.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		return &limitedFile{w: wc, c: wc}, nil
+	})
+}
+
+func acquireFile(open func() (*limitedFile, error)) (*limitedFile, error) {
+	if fileSlots != nil {
+		fileSlots <- struct{}{}
+	}
+	f, err := open()
+	if err != nil {
+		if fileSlots != nil {
+			<-fileSlots
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// readFile reads a whole image file, as os.ReadFile within the limits.
+func readFile(location string) ([]byte, error) {
+	f, err := openFile(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk && ioLimiter != nil {
+		p = p[:throttleChunk]
+	}
+	n, err := f.r.Read(p)
+	ioLimiter.wait(n)
+	return n, err
+}
+
+func (f *limitedFile) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk && ioLimiter != nil {
+			chunk = chunk[:throttleChunk]
+		}
+		ioLimiter.wait(len(chunk))
+		n, err := f.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Sync flushes a local output to stable storage. Remote outputs are uploaded
+// whole when closed, so there is nothing to flush before then.
+func (f *limitedFile) Sync() error {
+	if s, ok := f.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Close closes the file and frees its slot. Only the first call closes it,
+// since closing a remote output uploads it; later calls report its error.
+func (f *limitedFile) Close() error {
+	f.release.Do(func() {
+		f.err = f.c.Close()
+		if fileSlots != nil {
+			<-fileSlots
+		}
+	})
+	return f.err
+}
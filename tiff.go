@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"golang.org/x/image/tiff"
+)
+
+// maxTIFFPages bounds the IFD chain walked in one file, so a looping or
+// corrupt chain cannot run away.
+const maxTIFFPages = 10000
+
+// TIFF field tags used when pages are split and joined.
+const (
+	tiffStripOffsets    = 273
+	tiffStripByteCounts = 279
+	tiffXResolution     = 282
+	tiffYResolution     = 283
+	tiffResolutionUnit  = 296
+)
+
+// tiffTypeSizes are the sizes in bytes of the TIFF field types.
+var tiffTypeSizes = map[uint16]uint64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffEntry is one IFD field, with its value converted to little endian.
+type tiffEntry struct {
+	Tag, Type uint16
+	Count     uint32
+	Value     []byte
+}
+
+// tiffPage is one IFD of a TIFF file.
+type tiffPage struct {
+	Offset  uint32
+	Entries []tiffEntry
+}
+
+// entry returns the field with the given tag.
+func (p tiffPage) entry(tag uint16) (tiffEntry, bool) {
+	for _, e := range p.Entries {
+		if e.Tag == tag {
+			return e, true
+		}
+	}
+	return tiffEntry{}, false
+}
+
+// isTIFF reports whether path names a TIFF image.
+func isTIFF(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// processTIFF marks every page of a TIFF file and writes a TIFF with the
+// pages in their original order, each keeping its resolution.
+func processTIFF(imagePath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) (string, error) {
+	data, err := readFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image: %w", err)
+	}
+	imgs, pages, err := decodeTIFF(data)
+	if err != nil {
+		return "", badInput(fmt.Errorf("failed to decode TIFF '%s': %w", imagePath, err))
+	}
+
+	var marked []*image.RGBA
+	warned := false
+	for i, img := range imgs {
+		img := applyAlphaMode(cropBorders(orientImage(img)), imagePath, &warned)
+		m, err := renderBanner(img, pageBanner(banner, i+1, len(imgs)), bannerHeight, loc)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i+1, err)
+		}
+		marked = append(marked, m)
+	}
+
+	out, err := encodeTIFF(marked, pages)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode TIFF: %w", err)
+	}
+	outputPath := joinLocation(outputDir, filepath.Base(imagePath))
+	if err := writeOutputFile(outputPath, out); err != nil {
+		return "", err
+	}
+	if len(marked) > 1 {
+		fmt.Printf(tr("Marked %d TIFF pages: %s\n"), len(marked), imagePath)
+	}
+	if verifyOutput {
+		return outputPath, atStage("verify", verifyTIFFOutput(outputPath, marked))
+	}
+	return outputPath, nil
+}
+
+// decodeTIFF decodes every page of a TIFF file, returning the images and
+// the IFDs they came from.
+func decodeTIFF(data []byte) ([]image.Image, []tiffPage, error) {
+	order, pages, err := readTIFFPages(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var imgs []image.Image
+	for i, p := range pages {
+		// The decoder reads the first IFD, so each page is decoded through a
+		// header pointing at it
+		r := &tiffPageReader{data: data}
+		copy(r.header[:4], data[:4])
+		order.PutUint32(r.header[4:], p.Offset)
+		img, err := tiff.Decode(io.NewSectionReader(r, 0, int64(len(data))))
+		if err != nil {
+			return nil, nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, pages, nil
+}
+
+// tiffPageReader reads a TIFF file with its header replaced.
+type tiffPageReader struct {
+	header [8]byte
+	data   []byte
+}
+
+func (r *tiffPageReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if off < int64(len(r.header)) {
+		copy(p, r.header[off:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readTIFFPages parses the header and IFD chain of a TIFF file. Fields of
+// unknown types are skipped.
+func readTIFFPages(data []byte) (binary.ByteOrder, []tiffPage, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("file is too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("missing TIFF byte order mark")
+	}
+	switch order.Uint16(data[2:]) {
+	case 42:
+	case 43:
+		return nil, nil, errors.New("BigTIFF files are not supported")
+	default:
+		return nil, nil, errors.New("missing TIFF version")
+	}
+
+	var pages []tiffPage
+	seen := map[uint32]bool{}
+	for off := order.Uint32(data[4:]); off != 0; {
+		if seen[off] || len(pages) == maxTIFFPages {
+			return nil, nil, errors.New("IFD chain loops or is too long")
+		}
+		seen[off] = true
+		if uint64(off)+2 > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("IFD offset %d is out of range", off)
+		}
+		n := uint64(order.Uint16(data[off:]))
+		end := uint64(off) + 2 + 12*n
+		if end+4 > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("IFD at %d is truncated", off)
+		}
+		page := tiffPage{Offset: off}
+		for i := uint64(0); i < n; i++ {
+			field := data[uint64(off)+2+12*i:]
+			e := tiffEntry{Tag: order.Uint16(field), Type: order.Uint16(field[2:]), Count: order.Uint32(field[4:])}
+			size, ok := tiffTypeSizes[e.Type]
+			if !ok {
+				continue
+			}
+			size *= uint64(e.Count)
+			value := field[8:12]
+			if size > 4 {
+				at := uint64(order.Uint32(field[8:]))
+				if at+size > uint64(len(data)) {
+					return nil, nil, fmt.Errorf("field %d of IFD at %d is out of range", e.Tag, off)
+				}
+				value = data[at : at+size]
+			}
+			e.Value = littleEndianValue(order, e.Type, value[:size])
+			page.Entries = append(page.Entries, e)
+		}
+		pages = append(pages, page)
+		off = order.Uint32(data[end:])
+	}
+	if len(pages) == 0 {
+		return nil, nil, errors.New("file has no pages")
+	}
+	return order, pages, nil
+}
+
+// littleEndianValue returns a copy of a field value converted from order to
+// little endian.
+func littleEndianValue(order binary.ByteOrder, typ uint16, value []byte) []byte {
+	out := append([]byte(nil), value...)
+	if order == binary.LittleEndian {
+		return out
+	}
+	// Rationals are pairs of 32-bit integers
+	width := int(tiffTypeSizes[typ])
+	if typ == 5 || typ == 10 {
+		width = 4
+	}
+	for i := 0; i+width <= len(out); i += width {
+		for a, b := i, i+width-1; a < b; a, b = a+1, b-1 {
+			out[a], out[b] = out[b], out[a]
+		}
+	}
+	return out
+}
+
+// uints returns the values of a SHORT or LONG field.
+func (e tiffEntry) uints() []uint32 {
+	var vals []uint32
+	switch e.Type {
+	case 3:
+		for i := 0; i+2 <= len(e.Value); i += 2 {
+			vals = append(vals, uint32(binary.LittleEndian.Uint16(e.Value[i:])))
+		}
+	case 4:
+		for i := 0; i+4 <= len(e.Value); i += 4 {
+			vals = append(vals, binary.LittleEndian.Uint32(e.Value[i:]))
+		}
+	}
+	return vals
+}
+
+// encodeTIFF writes the marked pages as one little endian TIFF, Deflate
+// compressed, carrying each source page's resolution.
+func encodeTIFF(imgs []*image.RGBA, sources []tiffPage) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("II*\x00\x00\x00\x00\x00")
+	link := 4 // Where the offset of the next IFD is stored
+	for i, img := range imgs {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, img, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+			return nil, err
+		}
+		_, encoded, err := readTIFFPages(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		page := encoded[0]
+
+		// The strips are copied ahead of the IFD and their offsets rewritten
+		offsets, _ := page.entry(tiffStripOffsets)
+		counts, _ := page.entry(tiffStripByteCounts)
+		starts, lengths := offsets.uints(), counts.uints()
+		if len(starts) != len(lengths) {
+			return nil, errors.New("encoded page has mismatched strips")
+		}
+		moved := tiffEntry{Tag: tiffStripOffsets, Type: 4, Count: uint32(len(starts))}
+		for j, start := range starts {
+			moved.Value = binary.LittleEndian.AppendUint32(moved.Value, uint32(out.Len()))
+			out.Write(buf.Bytes()[start : start+lengths[j]])
+		}
+		entries := []tiffEntry{moved}
+		for _, e := range page.Entries {
+			switch e.Tag {
+			case tiffStripOffsets:
+				continue
+			case tiffXResolution, tiffYResolution, tiffResolutionUnit:
+				// The encoder always writes 72 dpi; a page turned on its
+				// side takes the other axis's resolution
+				from := e.Tag
+				if turnsSideways() {
+					switch e.Tag {
+					case tiffXResolution:
+						from = tiffYResolution
+					case tiffYResolution:
+						from = tiffXResolution
+					}
+				}
+				if src, ok := sources[i].entry(from); ok {
+					src.Tag = e.Tag
+					e = src
+				}
+			}
+			entries = append(entries, e)
+		}
+
+		if out.Len()%2 == 1 {
+			out.WriteByte(0)
+		}
+		if uint64(out.Len()) > math.MaxUint32 {
+			return nil, errors.New("output exceeds 4 GiB")
+		}
+		binary.LittleEndian.PutUint32(out.Bytes()[link:], uint32(out.Len()))
+		link = writeTIFFIFD(&out, entries)
+	}
+	if uint64(out.Len()) > math.MaxUint32 {
+		return nil, errors.New("output exceeds 4 GiB")
+	}
+	return out.Bytes(), nil
+}
+
+// writeTIFFIFD appends an IFD with its out-of-line values, returning the
+// position of its next-IFD offset.
+func writeTIFFIFD(out *bytes.Buffer, entries []tiffEntry) int {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+	start := out.Len()
+	link := start + 2 + 12*len(entries)
+	var values []byte
+	out.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(entries))))
+	for _, e := range entries {
+		field := make([]byte, 12)
+		binary.LittleEndian.PutUint16(field, e.Tag)
+		binary.LittleEndian.PutUint16(field[2:], e.Type)
+		binary.LittleEndian.PutUint32(field[4:], e.Count)
+		if len(e.Value) <= 4 {
+			copy(field[8:], e.Value)
+		} else {
+			binary.LittleEndian.PutUint32(field[8:], uint32(link+4+len(values)))
+			values = append(values, e.Value...)
+			if len(values)%2 == 1 {
+				values = append(values, 0)
+			}
+		}
+		out.Write(field)
+	}
+	out.Write(make([]byte, 4))
+	out.Write(values)
+	return link
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// defaultCropTolerance is the -crop-tolerance used unless the flag sets one.
+const defaultCropTolerance = 32
+
+// autoCrop trims uniform white or black borders, as scanners leave around a
+// page, from images before they are marked (the -auto-crop flag), so the
+// banners sit against the content.
+var autoCrop bool
+
+// cropTolerance is how far each channel of a border pixel may be from pure
+// white or black (the -crop-tolerance flag), so paper tint and sensor noise
+// still count as border.
+var cropTolerance = defaultCropTolerance
+
+func init() {
+	// Borders are trimmed once the image is turned upright
+	registerStage(phaseTransform, "crop", func(job *imageJob) error {
+		before := job.Image.Bounds().Size()
+		job.Image = cropBorders(job.Image)
+		if after := job.Image.Bounds().Size(); after != before {
+			fmt.Printf(tr("Cropped scanner borders: %s (%dx%d to %dx%d)\n"), job.InputPath, before.X, before.Y, after.X, after.Y)
+		}
+		return nil
+	})
+}
+
+// cropBorders returns img without its uniform borders when -auto-crop is
+// set, or img itself when it is not or there is nothing to trim.
+func cropBorders(img image.Image) image.Image {
+	if !autoCrop {
+		return img
+	}
+	r := contentBounds(img)
+	if r == img.Bounds() {
+		return img
+	}
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Rect, img, r.Min, draw.Src)
+	return out
+}
+
+// contentBounds returns the part of img inside its uniform borders. Rows and
+// columns are trimmed from each edge while every pixel in them is opaque and
+// within -crop-tolerance of white, or every pixel of black, so a page of
+// black text on white keeps its text. Edges are trimmed again until none
+// changes, which clears white margins beyond a black lid shadow along one
+// side. An image that is border throughout is left whole.
+func contentBounds(img image.Image) image.Rectangle {
+	blank := func(r image.Rectangle) bool {
+		white, black := true, true
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A != 255 {
+					return false
+				}
+				lo, hi := int(min(c.R, c.G, c.B)), int(max(c.R, c.G, c.B))
+				white = white && lo >= 255-cropTolerance
+				black = black && hi <= cropTolerance
+				if !white && !black {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	r := img.Bounds()
+	for changed := true; changed && !r.Empty(); {
+		changed = false
+		for !r.Empty() && blank(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1)) {
+			r.Min.Y++
+			changed = true
+		}
+		for !r.Empty() && blank(image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y)) {
+			r.Max.Y--
+			changed = true
+		}
+		for !r.Empty() && blank(image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y)) {
+			r.Min.X++
+			changed = true
+		}
+		for !r.Empty() && blank(image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y)) {
+			r.Max.X--
+			changed = true
+		}
+	}
+	if r.Empty() {
+		return img.Bounds()
+	}
+	return r
+}
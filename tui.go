@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"golang.org/x/term"
+)
+
+// tuiRecentRows bounds how many per-file rows the batch table keeps, so
+// memory stays flat for very large directories.
+const tuiRecentRows = 500
+
+// tuiRefresh is how often the batch table is redrawn.
+const tuiRefresh = 250 * time.Millisecond
+
+// tuiRow is one line of the batch table.
+type tuiRow struct {
+	Path   string
+	Status string // "WORK", "OK", "SKIP", or "ERR"
+	Detail string
+}
+
+// batchTUI tracks a directory run shown as a live table. The worker and the
+// key reader share it under mu; cond wakes the worker on pause, retry, or abort.
+type batchTUI struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	dir      string
+	rows     []tuiRow
+	failed   []string // Paths that failed and have not been retried
+	pending  []string // Failed paths queued for retry
+	done     int
+	skipped  int
+	errors   int
+	paused   bool
+	aborted  bool
+	finished bool
+	listErr  error
+	start    time.Time
+}
+
+// runTUI classifies every file in dirPath like processDirectory, but shows a
+// live status table instead of scrolling output. Keys: p pauses or resumes,
+// r retries failures, q aborts (or quits once the run is done).
+func runTUI(dirPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) error {
+	if err := checkTreeOutput(dirPath, outputDir); err != nil {
+		return err
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return fmt.Errorf("-tui requires an interactive terminal")
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return fmt.Errorf("failed to set terminal mode: %w", err)
+	}
+
+	t := &batchTUI{dir: dirPath, start: time.Now()}
+	t.cond = sync.NewCond(&t.mu)
+
+	go t.readKeys()
+
+	exited := make(chan struct{})
+	go func() {
+		t.work(dirPath, banner, outputDir, bannerHeight, loc)
+		close(exited)
+	}()
+
+	// Clear the screen and hide the cursor while the table is live
+	fmt.Print("\x1b[2J\x1b[?25l")
+	ticker := time.NewTicker(tuiRefresh)
+	for running := true; running; {
+		select {
+		case <-exited:
+			running = false
+		case <-ticker.C:
+		}
+		t.draw(out)
+	}
+	ticker.Stop()
+	fmt.Print("\x1b[?25h\r\n")
+	term.Restore(in, state)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Printf(tr("Processed %d file(s), %d error(s) in %s\n"), t.done+t.errors, len(t.failed), time.Since(t.start).Round(time.Second))
+	for _, path := range t.failed {
+		fmt.Println(tr("Failed:"), path)
+	}
+	switch {
+	case t.listErr != nil:
+		return t.listErr
+	case t.aborted && !t.finished:
+		return fmt.Errorf("aborted by user")
+	case len(t.failed) > 0:
+		return fmt.Errorf("some images failed to process")
+	}
+	return nil
+}
+
+// work processes each listed file, then any retries, until the user quits.
+func (t *batchTUI) work(dirPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string) {
+	paths, errc := listFiles(dirPath)
+	for {
+		path, ok := t.next(&paths, errc)
+		if !ok {
+			return
+		}
+
+		row := t.addRow(path)
+		err := processImage(path, banner, treeOutputDir(dirPath, path, outputDir), bannerHeight, loc)
+		skipped := isSkipped(err)
+		if !skipped {
+			recordResult(path, err)
+		}
+
+		t.mu.Lock()
+		if skipped {
+			t.rows[row].Status, t.rows[row].Detail = "SKIP", err.Error()
+			t.skipped++
+		} else if err != nil {
+			events.Error(fmt.Sprintf("Error processing '%s' (operator: %s, run: %s): %v", path, operator, runID, err))
+			t.rows[row].Status, t.rows[row].Detail = "ERR", err.Error()
+			t.failed = append(t.failed, path)
+			t.errors++
+		} else {
+			events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", path, banner.Text, operator, runID))
+			t.rows[row].Status, t.rows[row].Detail = "OK", ""
+			t.done++
+		}
+		t.mu.Unlock()
+	}
+}
+
+// next returns the next path to process, blocking while paused and, once the
+// listing is exhausted, until a retry is requested or the user quits.
+func (t *batchTUI) next(paths *<-chan string, errc <-chan error) (string, bool) {
+	for {
+		t.mu.Lock()
+		for t.paused && !t.aborted {
+			t.cond.Wait()
+		}
+		if t.aborted {
+			t.mu.Unlock()
+			return "", false
+		}
+		if len(t.pending) > 0 {
+			path := t.pending[0]
+			t.pending = t.pending[1:]
+			t.mu.Unlock()
+			return path, true
+		}
+		if *paths == nil {
+			t.finished = true
+			for len(t.pending) == 0 && !t.aborted {
+				t.cond.Wait()
+			}
+			t.finished = len(t.pending) == 0
+			t.mu.Unlock()
+			continue
+		}
+		t.mu.Unlock()
+
+		if path, ok := <-*paths; ok {
+			return path, true
+		}
+		*paths = nil
+		if err := <-errc; err != nil {
+			t.mu.Lock()
+			t.listErr = err
+			t.mu.Unlock()
+		}
+	}
+}
+
+// addRow appends a row for path, dropping the oldest rows past the limit,
+// and returns its index.
+func (t *batchTUI) addRow(path string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.rows) >= tuiRecentRows {
+		t.rows = append(t.rows[:0], t.rows[len(t.rows)-tuiRecentRows+1:]...)
+	}
+	t.rows = append(t.rows, tuiRow{Path: path, Status: "WORK"})
+	return len(t.rows) - 1
+}
+
+// readKeys handles keypresses until the process exits.
+func (t *batchTUI) readKeys() {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+			return
+		}
+		t.mu.Lock()
+		switch buf[0] {
+		case 'p', 'P', ' ':
+			t.paused = !t.paused
+		case 'r', 'R':
+			t.pending = append(t.pending, t.failed...)
+			t.errors -= len(t.failed)
+			t.failed = nil
+		case 'q', 'Q', 3: // 3 is Ctrl-C, which raw mode delivers as a key
+			t.aborted = true
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
+
+// draw repaints the whole screen with the header, stats, and the most recent
+// rows that fit the terminal.
+func (t *batchTUI) draw(fd int) {
+	width, height, err := term.GetSize(fd)
+	if err != nil || width < 20 || height < 8 {
+		width, height = 80, 24
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := "running"
+	switch {
+	case t.aborted:
+		state = "aborting"
+	case t.paused:
+		state = "paused"
+	case t.finished:
+		state = "done"
+	}
+	elapsed := time.Since(t.start)
+	rate := float64(t.done+t.errors) / elapsed.Seconds()
+
+	var b strings.Builder
+	line := func(s string) {
+		if len(s) > width {
+			s = s[:width]
+		}
+		b.WriteString(s + "\x1b[K\r\n")
+	}
+
+	b.WriteString("\x1b[H")
+	line(fmt.Sprintf("goclassifyit  %s  [%s]", t.dir, tr(state)))
+	line(fmt.Sprintf(tr("Classified: %d  Skipped: %d  Errors: %d  Retry queue: %d  Throughput: %.1f files/s  Elapsed: %s"),
+		t.done, t.skipped, t.errors, len(t.pending), rate, elapsed.Round(time.Second)))
+	if t.listErr != nil {
+		line(tr("Listing error:") + " " + t.listErr.Error())
+	} else {
+		line("")
+	}
+	line(fmt.Sprintf("%-6s %s", tr("STATUS"), tr("FILE")))
+
+	visible := height - 6
+	rows := t.rows
+	if len(rows) > visible {
+		rows = rows[len(rows)-visible:]
+	}
+	for _, row := range rows {
+		text := fmt.Sprintf("%-6s %s", row.Status, row.Path)
+		if row.Detail != "" {
+			text += "  " + row.Detail
+		}
+		if len(text) > width {
+			text = text[:width]
+		}
+		b.WriteString(tuiStatusColor(row.Status) + text + "\x1b[0m\x1b[K\r\n")
+	}
+	// Clear anything left from a previous, longer frame
+	b.WriteString("\x1b[J")
+	b.WriteString(fmt.Sprintf("\x1b[%d;1H", height))
+	if t.finished {
+		b.WriteString(tr("r retry failures   q quit") + "\x1b[K")
+	} else {
+		b.WriteString(tr("p pause/resume   r retry failures   q abort") + "\x1b[K")
+	}
+	os.Stdout.WriteString(b.String())
+}
+
+// tuiStatusColor returns the ANSI color for a row status.
+func tuiStatusColor(status string) string {
+	switch status {
+	case "OK":
+		return "\x1b[32m"
+	case "ERR":
+		return "\x1b[31m"
+	default:
+		return "\x1b[33m"
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+)
+
+// verifyOutput re-reads every output after it is written and checks it
+// against what was rendered, catching truncated or corrupted writes (for
+// example on unreliable network storage) before the run reports success.
+var verifyOutput bool
+
+// verifyJPEGTolerance is the largest mean per-channel difference (0-255)
+// accepted between a rendered banner and its JPEG (or WebP or AVIF) decode.
+// Lossless formats must match exactly.
+const verifyJPEGTolerance = 12
+
+// syncAndClose flushes f to stable storage and closes it, so a read-back
+// sees what actually reached the disk or server.
+func syncAndClose(f *limitedFile) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to flush output file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	return nil
+}
+
+// writeOutputFile writes data to path, flushing it first when outputs are
+// verified.
+func writeOutputFile(path string, data []byte) error {
+	f, err := createFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if verifyOutput {
+		return syncAndClose(f)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	return nil
+}
+
+// verifyImageOutput decodes the written file at path and checks its format,
+// its dimensions, and that each banner region matches the rendered image.
+func verifyImageOutput(path string, want *image.RGBA, format string, banners []image.Rectangle) error {
+	got, gotFormat, err := decodeInput(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not decode: %w", path, err)
+	}
+	if gotFormat != format {
+		return fmt.Errorf("verify '%s': output decodes as %s, expected %s", path, gotFormat, format)
+	}
+	if got.Bounds().Size() != want.Bounds().Size() {
+		return fmt.Errorf("verify '%s': output is %v, expected %v", path, got.Bounds().Size(), want.Bounds().Size())
+	}
+
+	tolerance := 0.0
+	if format == "jpeg" || format == "webp" || format == "avif" {
+		tolerance = verifyJPEGTolerance
+	}
+	for _, r := range banners {
+		if diff := meanDifference(got, want, r); diff > tolerance {
+			return fmt.Errorf("verify '%s': banner region %v differs from the rendered banner (mean difference %.1f)", path, r, diff)
+		}
+	}
+	return nil
+}
+
+// meanDifference returns the mean absolute per-channel difference, on a
+// 0-255 scale, between a and b over r (in b's coordinates).
+func meanDifference(a image.Image, b *image.RGBA, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	offset := a.Bounds().Min.Sub(b.Bounds().Min)
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ca := color.RGBAModel.Convert(a.At(x+offset.X, y+offset.Y)).(color.RGBA)
+			cb := b.RGBAAt(x, y)
+			sum += absDiff(ca.R, cb.R) + absDiff(ca.G, cb.G) + absDiff(ca.B, cb.B) + absDiff(ca.A, cb.A)
+		}
+	}
+	return sum / float64(4*r.Dx()*r.Dy())
+}
+
+func absDiff(a, b uint8) float64 {
+	if a > b {
+		return float64(a - b)
+	}
+	return float64(b - a)
+}
+
+// verifyIconOutput checks that the written icon at path holds the marked
+// entries with their original dimensions.
+func verifyIconOutput(path string, want []image.Image) error {
+	data, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': %w", path, err)
+	}
+	entries, err := decodeICO(data)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not decode: %w", path, err)
+	}
+	if len(entries) != len(want) {
+		return fmt.Errorf("verify '%s': output has %d entries, expected %d", path, len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e.img.Bounds().Size() != want[i].Bounds().Size() {
+			return fmt.Errorf("verify '%s': entry %d is %v, expected %v", path, i, e.img.Bounds().Size(), want[i].Bounds().Size())
+		}
+	}
+	return nil
+}
+
+// verifyTIFFOutput checks that the written TIFF at path decodes to the
+// marked pages, pixel for pixel.
+func verifyTIFFOutput(path string, want []*image.RGBA) error {
+	data, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': %w", path, err)
+	}
+	pages, _, err := decodeTIFF(data)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not decode: %w", path, err)
+	}
+	if len(pages) != len(want) {
+		return fmt.Errorf("verify '%s': output has %d pages, expected %d", path, len(pages), len(want))
+	}
+	for i, p := range pages {
+		if p.Bounds().Size() != want[i].Bounds().Size() {
+			return fmt.Errorf("verify '%s': page %d is %v, expected %v", path, i+1, p.Bounds().Size(), want[i].Bounds().Size())
+		}
+		if diff := meanDifference(p, want[i], want[i].Bounds()); diff > 0 {
+			return fmt.Errorf("verify '%s': page %d differs from the rendered page (mean difference %.1f)", path, i+1, diff)
+		}
+	}
+	return nil
+}
+
+// verifyGIFOutput checks that the written GIF at path decodes to the marked
+// frames with their delays.
+func verifyGIFOutput(path string, want *gif.GIF) error {
+	f, err := openFile(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': %w", path, err)
+	}
+	defer f.Close()
+	got, err := gif.DecodeAll(f)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not decode: %w", path, err)
+	}
+	if len(got.Image) != len(want.Image) {
+		return fmt.Errorf("verify '%s': output has %d frames, expected %d", path, len(got.Image), len(want.Image))
+	}
+	for i, frame := range got.Image {
+		w := want.Image[i]
+		if frame.Bounds() != w.Bounds() || got.Delay[i] != want.Delay[i] {
+			return fmt.Errorf("verify '%s': frame %d does not match the rendered frame", path, i+1)
+		}
+		for j, idx := range frame.Pix {
+			if frame.Palette[idx] != w.Palette[w.Pix[j]] {
+				return fmt.Errorf("verify '%s': frame %d differs from the rendered frame", path, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyDicomOutput checks that the written DICOM file at path parses, has
+// the marked geometry and complete pixel data, and carries the burned-in
+// annotation markers.
+func verifyDicomOutput(path string, want []byte, rows int, text string) error {
+	data, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': %w", path, err)
+	}
+	df, err := parseDICOM(data)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not parse: %w", path, err)
+	}
+	if got := df.intValue(tagRows, 0); got != rows {
+		return fmt.Errorf("verify '%s': output has %d rows, expected %d", path, got, rows)
+	}
+	// Odd-length values are padded to an even length when written
+	if got := df.find(df.dataset, tagPixelData); !bytes.HasPrefix(got, want) || len(got)-len(want) > 1 {
+		return fmt.Errorf("verify '%s': pixel data differs from the marked frames", path)
+	}
+	if got := strings.TrimRight(string(df.find(df.dataset, tagBurnedInAnnotation)), " \x00"); got != "YES" {
+		return fmt.Errorf("verify '%s': Burned In Annotation is '%s', expected YES", path, got)
+	}
+	if !strings.Contains(string(df.find(df.dataset, tagImageComments)), "Classification: "+text) {
+		return fmt.Errorf("verify '%s': Image Comments lack the classification marker", path)
+	}
+	return nil
+}
+
+// verifyPDFOutput checks that the written PDF at path parses and that each
+// of its pages ends its content with one of the banner streams.
+func verifyPDFOutput(path string, pages int, banners map[pdfRef]bool) error {
+	data, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("verify '%s': %w", path, err)
+	}
+	doc, err := parsePDF(data)
+	if err != nil {
+		return fmt.Errorf("verify '%s': output does not parse: %w", path, err)
+	}
+	got, err := doc.pages()
+	if err != nil {
+		return fmt.Errorf("verify '%s': output pages do not parse: %w", path, err)
+	}
+	if len(got) != pages {
+		return fmt.Errorf("verify '%s': output has %d pages, expected %d", path, len(got), pages)
+	}
+	for i, page := range got {
+		contents, _ := page.Dict["Contents"].(pdfArray)
+		if len(contents) == 0 {
+			return fmt.Errorf("verify '%s': page %d has no banner", path, i+1)
+		}
+		ref, ok := contents[len(contents)-1].(pdfRef)
+		if _, isStream := doc.resolve(ref).(pdfStream); !ok || !banners[ref] || !isStream {
+			return fmt.Errorf("verify '%s': page %d has no banner", path, i+1)
+		}
+	}
+	return nil
+}
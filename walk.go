@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// walkBatchSize is how many directory entries are read at a time, bounding
+// memory use for directories with millions of files.
+const walkBatchSize = 256
+
+// walkQueueSize is the number of paths buffered between the directory reader
+// and the workers that process them.
+const walkQueueSize = 64
+
+// workers is the number of images directory mode processes at once.
+var workers = runtime.NumCPU()
+
+// fileError is the failure of one input, kept for the end-of-batch summary.
+type fileError struct {
+	Path string
+	Err  error
+}
+
+// reportFailures lists every failed input, sorted by path, after a batch.
+func reportFailures(failures []fileError) {
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Path < failures[j].Path })
+	fmt.Printf(tr("Failed images (%d):\n"), len(failures))
+	for _, f := range failures {
+		fmt.Printf("  %s: %v\n", f.Path, f.Err)
+	}
+}
+
+// recursive makes directory mode descend into subdirectories, writing each
+// output under the same relative path in the output directory.
+var recursive bool
+
+// streamFiles reads dirPath in batches and sends the location of every file
+// (skipping sidecar overrides) to the returned channel, which is closed when
+// the listing is done. With recursive set, subdirectories are read too.
+// Backends without subdirectories are listed flat. A listing error is
+// delivered on errc.
+func streamFiles(dirPath string) (<-chan string, <-chan error) {
+	paths := make(chan string, walkQueueSize)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(paths)
+		defer close(errc)
+
+		s, dir, err := openStorage(dirPath)
+		if err != nil {
+			errc <- err
+			return
+		}
+		tree, ok := s.(treeStorage)
+		if !ok {
+			names, err := s.List(dir)
+			if err != nil {
+				errc <- fmt.Errorf("failed to read directory: %w", err)
+				return
+			}
+			for _, name := range names {
+				if !isSidecar(name) {
+					paths <- joinLocation(dirPath, name)
+				}
+			}
+			return
+		}
+
+		// Subdirectories are read depth-first, one open directory at a time
+		pending := []string{dir}
+		for len(pending) > 0 {
+			next := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			subdirs, err := tree.streamDir(next, paths)
+			if err != nil {
+				errc <- fmt.Errorf("failed to read directory: %w", err)
+				return
+			}
+			if recursive {
+				for i := len(subdirs) - 1; i >= 0; i-- {
+					pending = append(pending, subdirs[i])
+				}
+			}
+		}
+	}()
+	return paths, errc
+}
+
+// streamDir sends the files directly in dirPath to paths and returns its
+// subdirectories.
+func (localStorage) streamDir(dirPath string, paths chan<- string) ([]string, error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	var subdirs []string
+	for {
+		entries, err := dir.ReadDir(walkBatchSize)
+		for _, entry := range entries {
+			switch {
+			case entry.IsDir():
+				subdirs = append(subdirs, filepath.Join(dirPath, entry.Name()))
+			case !isSidecar(entry.Name()):
+				paths <- filepath.Join(dirPath, entry.Name())
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return subdirs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkTreeOutput rejects an output directory inside dirPath when recursing,
+// since the outputs would be picked up as inputs. Remote listings are not
+// recursive, so -r is refused for them.
+func checkTreeOutput(dirPath, outputDir string) error {
+	if !recursive {
+		return nil
+	}
+	if s, _, err := openStorage(dirPath); err == nil {
+		if _, ok := s.(treeStorage); !ok {
+			return fmt.Errorf("-r is not supported for remote directories ('%s')", dirPath)
+		}
+	}
+	if isRemote(outputDir) {
+		return nil
+	}
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return err
+	}
+	if out, err := filepath.Abs(outputDir); err == nil && (out == root || strings.HasPrefix(out, root+string(filepath.Separator))) {
+		return fmt.Errorf("output directory '%s' must not be inside '%s' with -r", outputDir, dirPath)
+	}
+	return nil
+}
+
+// treeOutputDir returns the output directory for filePath, found under
+// dirPath: outputDir itself, or with recursive set, outputDir joined with the
+// file's directory relative to dirPath.
+func treeOutputDir(dirPath, filePath, outputDir string) string {
+	if !recursive {
+		return outputDir
+	}
+	rel, err := filepath.Rel(dirPath, filepath.Dir(filePath))
+	if err != nil || rel == "." {
+		return outputDir
+	}
+	return joinLocation(outputDir, filepath.ToSlash(rel))
+}
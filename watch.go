@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchPartialSuffixes mark files still being downloaded or copied, which are
+// classified only once renamed to their final name.
+var watchPartialSuffixes = []string{".tmp", ".part", ".partial", ".crdownload", ".download"}
+
+// watchRecord is one line of the -watch-log JSON Lines file.
+type watchRecord struct {
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+	Status   string    `json:"status"` // "classified", "skipped", "retrying", or "failed"
+	Output   string    `json:"output,omitempty"`
+	Stage    string    `json:"stage,omitempty"` // As in -errors-json, for failures
+	Class    string    `json:"class,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"duration_seconds"`
+	RunID    string    `json:"run_id"`
+	Operator string    `json:"operator"`
+}
+
+// pendingFile is a file waiting to settle before it is classified.
+type pendingFile struct {
+	timer   *time.Timer
+	size    int64
+	modTime time.Time
+}
+
+// watchFailedDir is the directory under the watched one that files failing
+// every attempt are moved to.
+const watchFailedDir = "failed"
+
+// hotFolder watches a directory and classifies each file once it has stopped
+// changing for the settle time.
+type hotFolder struct {
+	dirPath, outputDir string
+	banner             classify.BannerMode
+	bannerHeight       int
+	loc                string
+	settle             time.Duration
+	attempts           int
+	skip               []string // Absolute paths that are not inputs: the output, quarantine, and failed directories and the watch log
+	watcher            *fsnotify.Watcher
+	queue              chan string
+
+	mu       sync.Mutex
+	pending  map[string]*pendingFile
+	failures map[string]int // Failed attempts of files being retried
+	stopped  bool
+	sending  sync.WaitGroup // Settled files being queued
+	failed   int
+
+	logMu sync.Mutex
+	log   *os.File // The -watch-log file, or nil
+}
+
+// runWatch classifies the files already in dirPath, then each file created
+// or changed there (and in its subdirectories with -r) until interrupted. A
+// file is classified once it has gone settle without changing, so partially
+// written files are not picked up. Each outcome is appended to logPath as a
+// JSON line when it is set. A file that fails is tried again after a growing
+// delay, and after attempts failures it is quarantined as in directory mode
+// when it is unusable and -quarantine-dir is set, and otherwise moved to the
+// failed directory with a quarantine record. As in directory mode, it fails
+// when any file did. SIGHUP stops it as an interrupt does, once the new
+// settings check out, and it returns errReload.
+func runWatch(dirPath string, banner classify.BannerMode, outputDir string, bannerHeight int, loc string, settle time.Duration, attempts int, logPath string) error {
+	if isRemote(dirPath) {
+		return fmt.Errorf("-watch is not supported for remote directories ('%s')", dirPath)
+	}
+	if err := checkTreeOutput(dirPath, outputDir); err != nil {
+		return err
+	}
+	if !isRemote(outputDir) {
+		root, rootErr := filepath.Abs(dirPath)
+		out, outErr := filepath.Abs(outputDir)
+		if rootErr == nil && outErr == nil && root == out {
+			return fmt.Errorf("output directory '%s' must not be the watched directory", outputDir)
+		}
+	}
+
+	w := &hotFolder{
+		dirPath: dirPath, outputDir: outputDir, banner: banner, bannerHeight: bannerHeight, loc: loc,
+		settle: settle, attempts: attempts,
+		queue:    make(chan string, walkQueueSize),
+		pending:  map[string]*pendingFile{},
+		failures: map[string]int{},
+	}
+	for _, dir := range []string{outputDir, quarantineDir, filepath.Join(dirPath, watchFailedDir)} {
+		if dir == "" || isRemote(dir) {
+			continue
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			w.skip = append(w.skip, abs)
+		}
+	}
+	if logPath != "" {
+		f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open watch log: %w", err)
+		}
+		defer f.Close()
+		w.log = f
+		if abs, err := filepath.Abs(logPath); err == nil {
+			w.skip = append(w.skip, abs)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	defer watcher.Close()
+	w.watcher = watcher
+	if err := w.addTree(dirPath); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range w.queue {
+				w.classify(path)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	fmt.Printf(tr("Watching %s for new files (Ctrl+C to stop)\n"), dirPath)
+
+	// Files already waiting are classified first, after settling like new ones
+	w.scan(dirPath)
+
+	reloading := false
+loop:
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				break loop
+			}
+			w.handle(event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				break loop
+			}
+			// Missed events are made up for by rescanning the folder
+			fmt.Println(tr("Warning: watch error:"), err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.scan(dirPath)
+			}
+		case <-stop:
+			break loop
+		case <-reload:
+			if reloading = reloadAccepted(); reloading {
+				break loop
+			}
+		}
+	}
+
+	// Files still settling are left for the next run
+	w.mu.Lock()
+	w.stopped = true
+	for _, p := range w.pending {
+		p.timer.Stop()
+	}
+	w.mu.Unlock()
+	w.sending.Wait()
+	close(w.queue)
+	wg.Wait()
+	fmt.Printf(tr("Stopped watching %s\n"), dirPath)
+	if reloading {
+		return errReload
+	}
+	if w.failed > 0 {
+		return fmt.Errorf("some images failed to process")
+	}
+	return nil
+}
+
+// addTree watches dir, and with recursive set every directory under it.
+func (w *hotFolder) addTree(dir string) error {
+	if err := w.watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch '%s': %w", dir, err)
+	}
+	if !recursive {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, e := range entries {
+		if sub := filepath.Join(dir, e.Name()); e.IsDir() && !w.skipped(sub) {
+			if err := w.addTree(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan schedules every file in dir, as listed in directory mode.
+func (w *hotFolder) scan(dir string) {
+	paths, errc := streamFiles(dir)
+	for path := range paths {
+		w.schedule(path)
+	}
+	if err := <-errc; err != nil {
+		fmt.Println(tr("Warning:"), err)
+	}
+}
+
+// handle schedules the file an event is about, and starts watching new
+// subdirectories with -r.
+func (w *hotFolder) handle(event fsnotify.Event) {
+	path := event.Name
+	if w.skipped(path) {
+		return
+	}
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		w.cancel(path)
+		return
+	}
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		// A directory moved in whole produces no events for its files
+		if recursive && event.Has(fsnotify.Create) {
+			if err := w.addTree(path); err != nil {
+				fmt.Println(tr("Warning:"), err)
+			}
+			w.scan(path)
+		}
+		return
+	}
+	w.schedule(path)
+}
+
+// skipped reports whether path is not an input: an output, quarantined,
+// failed, or partial file, a sidecar, or the watch log.
+func (w *hotFolder) skipped(path string) bool {
+	name := filepath.Base(path)
+	if isSidecar(name) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~$") {
+		return true
+	}
+	for _, suffix := range watchPartialSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return true
+		}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range w.skip {
+		if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule (re)starts the settle timer of path. A changed file gets all its
+// attempts again.
+func (w *hotFolder) schedule(path string) {
+	if w.skipped(path) {
+		return
+	}
+	w.mu.Lock()
+	delete(w.failures, path)
+	w.mu.Unlock()
+	w.scheduleIn(path, w.settle)
+}
+
+// scheduleIn (re)starts the timer of path to settle after delay.
+func (w *hotFolder) scheduleIn(path string, delay time.Duration) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	if p, ok := w.pending[path]; ok {
+		p.size, p.modTime = info.Size(), info.ModTime()
+		p.timer.Reset(delay)
+		return
+	}
+	w.pending[path] = &pendingFile{
+		timer:   time.AfterFunc(delay, func() { w.settled(path) }),
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+}
+
+// cancel forgets a pending file that was removed or renamed away.
+func (w *hotFolder) cancel(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p, ok := w.pending[path]; ok {
+		p.timer.Stop()
+		delete(w.pending, path)
+	}
+	delete(w.failures, path)
+}
+
+// settled queues path for classification when it is unchanged since it was
+// scheduled; writers that produce no events are caught by the size and
+// modification time check.
+func (w *hotFolder) settled(path string) {
+	info, statErr := os.Stat(path)
+	w.mu.Lock()
+	p, ok := w.pending[path]
+	if !ok || w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	if statErr == nil && (info.Size() != p.size || !info.ModTime().Equal(p.modTime)) {
+		p.size, p.modTime = info.Size(), info.ModTime()
+		p.timer.Reset(w.settle)
+		w.mu.Unlock()
+		return
+	}
+	delete(w.pending, path)
+	if statErr != nil {
+		w.mu.Unlock()
+		return
+	}
+	w.sending.Add(1)
+	w.mu.Unlock()
+	w.queue <- path
+	w.sending.Done()
+}
+
+// classify marks one settled file and records the outcome. A failed file
+// still in place is retried until it has used its attempts, then moved to
+// the quarantine directory when it is set and the file is unusable, and to
+// the failed directory otherwise.
+func (w *hotFolder) classify(path string) {
+	start := time.Now()
+	outputPath, err := markFile(path, w.banner, treeOutputDir(w.dirPath, path, w.outputDir), w.bannerHeight, w.loc)
+	rec := watchRecord{Time: time.Now().UTC(), Path: path, Status: "classified", Output: outputPath, Duration: time.Since(start).Seconds(), RunID: runID, Operator: operator}
+	w.mu.Lock()
+	attempt := w.failures[path] + 1
+	if err == nil || isSkipped(err) || attempt >= w.attempts {
+		delete(w.failures, path)
+	} else {
+		w.failures[path] = attempt
+	}
+	w.mu.Unlock()
+	switch {
+	case isSkipped(err):
+		reportSkip(path, err)
+		rec.Status, rec.Error = "skipped", err.Error()
+	case err != nil && attempt < w.attempts && fileExists(path):
+		fmt.Printf(tr("Warning: %s failed (attempt %d of %d), retrying: %v\n"), path, attempt, w.attempts, err)
+		rec.Status, rec.Error = "retrying", err.Error()
+		rec.Stage = failureStage(err)
+		rec.Class = errorClass(rec.Stage, err)
+		w.scheduleIn(path, time.Duration(attempt)*w.settle)
+	case err != nil:
+		if fileExists(path) {
+			if quarantines(path, err) {
+				err = quarantineInput(path, err, attempt)
+			} else {
+				err = w.moveFailed(path, err, attempt)
+			}
+		}
+		recordResult(path, err)
+		fmt.Printf(tr("Error processing %s: %v\n"), path, err)
+		events.Error(fmt.Sprintf("Error processing '%s' (operator: %s, run: %s): %v", path, operator, runID, err))
+		w.mu.Lock()
+		w.failed++
+		w.mu.Unlock()
+		rec.Status, rec.Error = "failed", err.Error()
+		rec.Stage = failureStage(err)
+		rec.Class = errorClass(rec.Stage, err)
+	default:
+		recordResult(path, nil)
+		fmt.Println(tr("Classified:"), path)
+		events.Info(fmt.Sprintf("Classified '%s' as %s (operator: %s, run: %s)", path, w.banner.Text, operator, runID))
+	}
+	if w.log == nil {
+		return
+	}
+	line, _ := json.Marshal(rec)
+	w.logMu.Lock()
+	defer w.logMu.Unlock()
+	if _, err := w.log.Write(append(line, '\n')); err != nil {
+		fmt.Println(tr("Warning: failed to write watch log:"), err)
+	}
+}
+
+// moveFailed moves path, which failed its last attempt with cause, to the
+// failed directory beside a quarantine record, and returns the error to
+// report for it.
+func (w *hotFolder) moveFailed(path string, cause error, attempts int) error {
+	dest, err := quarantineFile(path, filepath.Join(w.dirPath, watchFailedDir), cause, attempts)
+	switch {
+	case dest == "":
+		return fmt.Errorf("%w (moving to %s failed: %v)", cause, watchFailedDir, err)
+	case err != nil:
+		return fmt.Errorf("%w (moved to %s, but the record failed: %v)", cause, dest, err)
+	}
+	return fmt.Errorf("%w (moved to %s)", cause, dest)
+}
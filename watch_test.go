@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AmbitiousOkie/goclassifyit/pkg/classify"
+)
+
+// newTestHotFolder returns a stopped hot folder over a new directory holding
+// a truncated PNG, so failed attempts are not rescheduled by timers.
+func newTestHotFolder(t *testing.T, attempts int) (*hotFolder, string) {
+	t.Helper()
+	dir := t.TempDir()
+	drop := filepath.Join(dir, "drop")
+	if err := os.Mkdir(drop, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(drop, "upload.png")
+	if err := os.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := &hotFolder{
+		dirPath: drop, outputDir: filepath.Join(dir, "out"), banner: classify.Presets["secret"], bannerHeight: 20,
+		settle: time.Millisecond, attempts: attempts,
+		pending:  map[string]*pendingFile{},
+		failures: map[string]int{},
+		stopped:  true,
+	}
+	return w, path
+}
+
+func TestWatchRetriesBeforeQuarantine(t *testing.T) {
+	tests := []struct {
+		name       string
+		quarantine bool
+		wantDir    string // Directory the file ends up in, relative to the test directory
+		otherDir   string // Directory that must not be created
+	}{
+		{"failed directory", false, "drop/failed", "quarantine"},
+		{"quarantine directory", true, "quarantine", "drop/failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, path := newTestHotFolder(t, 3)
+			root := filepath.Dir(w.dirPath)
+			old := quarantineDir
+			defer func() { quarantineDir = old }()
+			quarantineDir = ""
+			if tt.quarantine {
+				quarantineDir = filepath.Join(root, "quarantine")
+			}
+
+			for attempt := 1; attempt < w.attempts; attempt++ {
+				w.classify(path)
+				if !fileExists(path) {
+					t.Fatalf("attempt %d moved the file away, want it left for a retry", attempt)
+				}
+				if got := w.failures[path]; got != attempt {
+					t.Fatalf("after attempt %d, failures = %d", attempt, got)
+				}
+			}
+			w.classify(path)
+
+			if fileExists(path) {
+				t.Fatal("file still in the watched directory after the last attempt")
+			}
+			dest := filepath.Join(root, filepath.FromSlash(tt.wantDir), "upload.png")
+			if !fileExists(dest) || !fileExists(dest+quarantineSuffix) {
+				t.Errorf("want %s with its record", dest)
+			}
+			if other := filepath.Join(root, filepath.FromSlash(tt.otherDir)); fileExists(other) {
+				t.Errorf("%s was created, want only %s", tt.otherDir, tt.wantDir)
+			}
+			if w.failed != 1 {
+				t.Errorf("failed = %d, want 1", w.failed)
+			}
+		})
+	}
+}
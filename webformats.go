@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp" // Registers the WebP decoder
+)
+
+// convertFormat is the output format for WebP and AVIF inputs: "png" or
+// "jpeg", or "" to write them back in their own format, which needs the
+// external encoder (cwebp or avifenc).
+var convertFormat string
+
+// isAVIF reports whether path names an AVIF image.
+func isAVIF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".avif")
+}
+
+// parseConvertFormat checks a -convert value.
+func parseConvertFormat(format string) (string, error) {
+	switch format {
+	case "", "png", "jpeg":
+		return format, nil
+	case "jpg":
+		return "jpeg", nil
+	}
+	return "", fmt.Errorf("invalid -convert format '%s' (options: png, jpeg)", format)
+}
+
+// decodeAVIF decodes an AVIF file by converting it to PNG with libavif's
+// avifdec, since there is no Go decoder.
+func decodeAVIF(path string) (image.Image, error) {
+	tool, err := exec.LookPath("avifdec")
+	if err != nil {
+		return nil, fmt.Errorf("AVIF file '%s' requires libavif's avifdec in PATH", path)
+	}
+	tmp, err := os.MkdirTemp("", "goclassifyit-avif-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	out := filepath.Join(tmp, "decoded.png")
+	if err := runImageTool(tool, path, out); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avifdec output: %w", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avifdec output: %w", err)
+	}
+	return img, nil
+}
+
+// externalEncoder returns the path of the encoder for format (cwebp for
+// WebP, avifenc for AVIF), so a missing tool fails before any output is
+// created.
+func externalEncoder(format string) (string, error) {
+	name := "cwebp"
+	if format == "avif" {
+		name = "avifenc"
+	}
+	tool, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("writing %s requires %s in PATH; use -convert png or -convert jpeg to transcode instead", strings.ToUpper(format), name)
+	}
+	return tool, nil
+}
+
+// encodeExternal encodes img as WebP or AVIF with the external encoder,
+// handing it over as a temporary PNG.
+func encodeExternal(w io.Writer, img image.Image, format string) error {
+	tool, err := externalEncoder(format)
+	if err != nil {
+		return err
+	}
+	var args []string
+	if format == "webp" {
+		args = []string{"-quiet", "-q", strconv.Itoa(jpegQuality)}
+	}
+	tmp, err := os.MkdirTemp("", "goclassifyit-"+format+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	in, out := filepath.Join(tmp, "marked.png"), filepath.Join(tmp, "marked."+format)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	if err := os.WriteFile(in, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to stage image: %w", err)
+	}
+	if format == "webp" {
+		args = append(args, in, "-o", out)
+	} else {
+		args = append(args, in, out)
+	}
+	if err := runImageTool(tool, args...); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return fmt.Errorf("failed to read %s output: %w", filepath.Base(tool), err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// runImageTool runs an external image converter, reporting its stderr on
+// failure.
+func runImageTool(tool string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(tool, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("'%s' failed: %w: %s", tool, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}